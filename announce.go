@@ -0,0 +1,229 @@
+package libp2p
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AnnounceAddrs makes the node advertise the given addresses instead of the
+// ones it is bound to.
+func AnnounceAddrs(addrs ...ma.Multiaddr) Option {
+	return func(cfg *Config) error {
+		cfg.AnnounceAddrs = append(cfg.AnnounceAddrs, addrs...)
+		return nil
+	}
+}
+
+// AnnounceAddrStrings is like AnnounceAddrs, parsing the addresses from
+// strings.
+func AnnounceAddrStrings(s ...string) Option {
+	return func(cfg *Config) error {
+		for _, addrstr := range s {
+			a, err := ma.NewMultiaddr(addrstr)
+			if err != nil {
+				return err
+			}
+			cfg.AnnounceAddrs = append(cfg.AnnounceAddrs, a)
+		}
+		return nil
+	}
+}
+
+// AppendAnnounceAddrs makes the node advertise the given addresses in
+// addition to the ones it is bound to.
+func AppendAnnounceAddrs(addrs ...ma.Multiaddr) Option {
+	return func(cfg *Config) error {
+		cfg.AppendAnnounceAddrs = append(cfg.AppendAnnounceAddrs, addrs...)
+		return nil
+	}
+}
+
+// NoAnnounce prevents the node from ever advertising addresses matching any
+// of the given prefixes. A prefix is either a multiaddr, matching all
+// addresses starting with it, or an IP range such as
+// "/ip4/127.0.0.0/ipcidr/8", matching all addresses in that range. The
+// filters apply last, to announced and bound addresses alike.
+func NoAnnounce(prefixes ...string) Option {
+	return func(cfg *Config) error {
+		for _, p := range prefixes {
+			if _, err := parseAddrFilter(p); err != nil {
+				return err
+			}
+		}
+		cfg.NoAnnounce = append(cfg.NoAnnounce, prefixes...)
+		return nil
+	}
+}
+
+// RevalidateAnnounceAddrs makes the node check, every interval, that the
+// addresses given with AnnounceAddrs and AppendAnnounceAddrs still work,
+// e.g. that a manual port forward is still in place. An address is valid
+// when a peer reports having reached the node at it during the interval,
+// or else when a peer running the autonat service (see
+// EnableAutoNATService) dials the node back at it. It requires identify.
+// After threshold consecutive failures the address stops being advertised,
+// until it is valid again. See bhost.BasicHost.ExternalAddrStatus.
+func RevalidateAnnounceAddrs(interval time.Duration, threshold int) Option {
+	return func(cfg *Config) error {
+		if interval <= 0 {
+			return fmt.Errorf("revalidation interval must be positive, got %s", interval)
+		}
+		if threshold <= 0 {
+			return fmt.Errorf("revalidation failure threshold must be positive, got %d", threshold)
+		}
+		cfg.RevalidateAnnounceInterval = interval
+		cfg.RevalidateAnnounceThreshold = threshold
+		return nil
+	}
+}
+
+// OnlyPublicAddrs stops the node from advertising addresses that aren't
+// publicly routable: loopback, link-local, private (RFC 1918) and unique
+// local IPv6 addresses. The node still listens on them, so that peers
+// already knowing them can connect. Relay and DNS addresses are kept. It
+// applies after any other address factory or announce option.
+func OnlyPublicAddrs() Option {
+	return func(cfg *Config) error {
+		cfg.OnlyPublicAddrs = true
+		return nil
+	}
+}
+
+func isRelayAddr(a ma.Multiaddr) bool {
+	for _, p := range a.Protocols() {
+		if p.Code == circuit.P_CIRCUIT {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyPublicAddrsFactory wraps factory, which may be nil, to drop the
+// addresses that aren't publicly routable.
+func onlyPublicAddrsFactory(factory bhost.AddrsFactory) bhost.AddrsFactory {
+	return func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		if factory != nil {
+			addrs = factory(addrs)
+		}
+		out := make([]ma.Multiaddr, 0, len(addrs))
+		for _, a := range addrs {
+			if bhost.IsPublicAddr(a) {
+				out = append(out, a)
+			}
+		}
+		return out
+	}
+}
+
+// addrFilter matches addresses by prefix, or by IP range.
+type addrFilter struct {
+	prefix []byte
+	ipnet  *net.IPNet
+}
+
+func parseAddrFilter(s string) (*addrFilter, error) {
+	parts := strings.SplitN(s, "/ipcidr/", 2)
+	base, err := ma.NewMultiaddr(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid address filter %q: %s", s, err)
+	}
+	if len(parts) == 1 {
+		return &addrFilter{prefix: base.Bytes()}, nil
+	}
+
+	ip, bits := leadingIP(base)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address filter %q: ipcidr must follow an ip4 or ip6 address", s)
+	}
+	ones, err := strconv.Atoi(parts[1])
+	if err != nil || ones < 0 || ones > bits {
+		return nil, fmt.Errorf("invalid address filter %q: bad prefix length %q", s, parts[1])
+	}
+	mask := net.CIDRMask(ones, bits)
+	return &addrFilter{ipnet: &net.IPNet{IP: ip.Mask(mask), Mask: mask}}, nil
+}
+
+func (f *addrFilter) matches(a ma.Multiaddr) bool {
+	if f.ipnet == nil {
+		return bytes.HasPrefix(a.Bytes(), f.prefix)
+	}
+	ip, _ := leadingIP(a)
+	return ip != nil && f.ipnet.Contains(ip)
+}
+
+// leadingIP returns the IP address a starts with, if any, and its size in
+// bits.
+func leadingIP(a ma.Multiaddr) (net.IP, int) {
+	first := ma.Split(a)[0]
+	switch first.Protocols()[0].Code {
+	case ma.P_IP4:
+		ip := net.ParseIP(first.String()[len("/ip4/"):])
+		if ip == nil {
+			return nil, 0
+		}
+		return ip.To4(), 32
+	case ma.P_IP6:
+		return net.ParseIP(first.String()[len("/ip6/"):]), 128
+	}
+	return nil, 0
+}
+
+// announceAddrsFactory builds the AddrsFactory implementing the
+// AnnounceAddrs, AppendAnnounceAddrs and NoAnnounce options, or returns nil
+// if none of them were given.
+func announceAddrsFactory(cfg *Config) (bhost.AddrsFactory, error) {
+	if len(cfg.AnnounceAddrs) == 0 && len(cfg.AppendAnnounceAddrs) == 0 && len(cfg.NoAnnounce) == 0 {
+		return nil, nil
+	}
+
+	filters := make([]*addrFilter, len(cfg.NoAnnounce))
+	for i, s := range cfg.NoAnnounce {
+		f, err := parseAddrFilter(s)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = f
+	}
+
+	announce := cfg.AnnounceAddrs
+	extra := cfg.AppendAnnounceAddrs
+	return func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		if len(announce) > 0 {
+			addrs = announce
+		}
+		addrs = append(addrs[:len(addrs):len(addrs)], extra...)
+
+		out := make([]ma.Multiaddr, 0, len(addrs))
+	outer:
+		for _, a := range addrs {
+			for _, f := range filters {
+				if f.matches(a) {
+					continue outer
+				}
+			}
+			out = append(out, a)
+		}
+		return out
+	}, nil
+}
+
+// AddrChangeHandler registers a function called when the set of addresses
+// the node advertises changes, be it because of new listeners, NAT
+// mappings, observed addresses or relays. It is called asynchronously, so
+// it may be slow, but its calls are in order. The current set is available
+// from bhost.BasicHost.PublishedAddrs.
+func AddrChangeHandler(f func(old, new []ma.Multiaddr)) Option {
+	return func(cfg *Config) error {
+		cfg.AddrsChangeHandlers = append(cfg.AddrsChangeHandlers, f)
+		return nil
+	}
+}
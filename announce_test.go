@@ -0,0 +1,142 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func mustAddrs(t *testing.T, s ...string) []ma.Multiaddr {
+	out := make([]ma.Multiaddr, len(s))
+	for i, a := range s {
+		m, err := ma.NewMultiaddr(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = m
+	}
+	return out
+}
+
+func TestAnnounceAddrsFactory(t *testing.T) {
+	bound := mustAddrs(t,
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip4/10.0.0.5/tcp/4001",
+		"/ip6/::1/tcp/4001",
+		"/ip4/203.0.113.7/tcp/4001",
+	)
+
+	cases := []struct {
+		opts []Option
+		exp  []ma.Multiaddr
+	}{{
+		opts: []Option{AnnounceAddrStrings("/dns4/node1.example.com/tcp/4001")},
+		exp:  mustAddrs(t, "/dns4/node1.example.com/tcp/4001"),
+	}, {
+		opts: []Option{AppendAnnounceAddrs(mustAddrs(t, "/dns4/node1.example.com/tcp/4001")...)},
+		exp:  append(bound[:len(bound):len(bound)], mustAddrs(t, "/dns4/node1.example.com/tcp/4001")...),
+	}, {
+		opts: []Option{NoAnnounce("/ip4/127.0.0.0/ipcidr/8", "/ip4/10.0.0.0/ipcidr/8", "/ip6/::1")},
+		exp:  mustAddrs(t, "/ip4/203.0.113.7/tcp/4001"),
+	}, {
+		// filters apply to announced addresses too.
+		opts: []Option{
+			AnnounceAddrStrings("/ip4/192.168.1.2/tcp/4001", "/ip4/198.51.100.1/tcp/4001"),
+			NoAnnounce("/ip4/192.168.0.0/ipcidr/16"),
+		},
+		exp: mustAddrs(t, "/ip4/198.51.100.1/tcp/4001"),
+	}}
+
+	for i, c := range cases {
+		var cfg Config
+		for _, opt := range c.opts {
+			if err := opt(&cfg); err != nil {
+				t.Fatalf("case %d: %s", i, err)
+			}
+		}
+		factory, err := announceAddrsFactory(&cfg)
+		if err != nil {
+			t.Fatalf("case %d: %s", i, err)
+		}
+
+		got := factory(bound)
+		if len(got) != len(c.exp) {
+			t.Fatalf("case %d: expected %s, got %s", i, c.exp, got)
+		}
+		for j := range got {
+			if !got[j].Equal(c.exp[j]) {
+				t.Fatalf("case %d: expected %s, got %s", i, c.exp, got)
+			}
+		}
+	}
+
+	var cfg Config
+	if err := NoAnnounce("/ip4/127.0.0.0/ipcidr/33")(&cfg); err == nil {
+		t.Fatal("expected an invalid prefix length to be rejected")
+	}
+	if err := NoAnnounce("/tcp/4001/ipcidr/8")(&cfg); err == nil {
+		t.Fatal("expected ipcidr without an IP to be rejected")
+	}
+}
+
+func TestAnnounceAddrsConflict(t *testing.T) {
+	_, err := New(context.Background(),
+		AnnounceAddrStrings("/ip4/203.0.113.7/tcp/4001"),
+		AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr { return addrs }),
+	)
+	if err == nil {
+		t.Fatal("expected announce addresses and an address factory to conflict")
+	}
+}
+
+func TestOnlyPublicAddrs(t *testing.T) {
+	public := []string{
+		"/ip4/1.2.3.4/tcp/4001",
+		"/ip4/1.2.3.4/tcp/4001/ws",
+		"/ip6/2001:db8::1/tcp/4001",
+		"/dns4/example.com/tcp/4001",
+		"/ip4/10.0.0.1/tcp/4001/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit",
+	}
+	private := []string{
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip4/10.0.0.1/tcp/4001",
+		"/ip4/10.0.0.1/tcp/4001/ws",
+		"/ip4/172.16.5.4/tcp/4001",
+		"/ip4/192.168.1.1/tcp/4001",
+		"/ip4/169.254.1.1/tcp/4001",
+		"/ip6/::1/tcp/4001",
+		"/ip6/fe80::1/tcp/4001",
+		"/ip6/fd00::1/tcp/4001",
+	}
+
+	factory := onlyPublicAddrsFactory(nil)
+	got := factory(mustAddrs(t, append(public, private...)...))
+	if len(got) != len(public) {
+		t.Fatalf("expected %s, got %s", public, got)
+	}
+	for i, a := range got {
+		if a.String() != public[i] {
+			t.Fatalf("expected %s, got %s", public[i], a)
+		}
+	}
+}
+
+func TestOnlyPublicAddrsKeepsListeners(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := makeLocalHost(ctx, t, OnlyPublicAddrs())
+	defer h.Close()
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+
+	if addrs := h.Addrs(); len(addrs) != 0 {
+		t.Fatalf("expected no advertised addresses, got %s", addrs)
+	}
+	err := other.Connect(ctx, pstore.PeerInfo{ID: h.ID(), Addrs: h.Network().ListenAddresses()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,85 @@
+package libp2p
+
+import (
+	"fmt"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	autonat "github.com/libp2p/go-libp2p/p2p/protocol/autonat"
+)
+
+// EnableAutoNAT makes the node find out whether it is publicly reachable,
+// by periodically asking its peers running the AutoNAT service to dial it
+// back. The result is available from bhost.BasicHost.Reachability, and
+// decides whether the node advertises relay addresses (see
+// EnableAutoRelay).
+func EnableAutoNAT() Option {
+	return func(cfg *Config) error {
+		cfg.AutoNAT = true
+		return nil
+	}
+}
+
+// EnableAutoNATService makes the node dial back the peers asking whether
+// they are reachable. At most one rate limit may be given; without one,
+// autonat.DefaultRateLimit applies.
+func EnableAutoNATService(rateLimit ...autonat.RateLimit) Option {
+	return func(cfg *Config) error {
+		if cfg.AutoNATService != nil {
+			return fmt.Errorf("cannot enable the AutoNAT service more than once")
+		}
+		switch len(rateLimit) {
+		case 0:
+			limit := autonat.DefaultRateLimit
+			cfg.AutoNATService = &limit
+		case 1:
+			if rateLimit[0].Interval <= 0 {
+				return fmt.Errorf("AutoNAT service rate limit interval must be positive, got %s", rateLimit[0].Interval)
+			}
+			limit := rateLimit[0]
+			cfg.AutoNATService = &limit
+		default:
+			return fmt.Errorf("cannot give the AutoNAT service several rate limits")
+		}
+		return nil
+	}
+}
+
+// ForceReachabilityPublic tells the node it is publicly reachable, without
+// probing it with AutoNAT, so it never advertises relay addresses. The
+// forced state is the one bhost.BasicHost.Reachability returns. It can't be
+// combined with EnableAutoNAT, but can with EnableAutoNATService.
+func ForceReachabilityPublic() Option {
+	return forceReachability(bhost.ReachabilityPublic)
+}
+
+// ForceReachabilityPrivate tells the node it isn't publicly reachable,
+// whatever its addresses, so that it advertises addresses through the
+// relays it is connected to instead of its private addresses. The relays
+// are the ones given to EnableAutoRelay, and the ones named in circuit
+// listen addresses. Like ForceReachabilityPublic, it can't be combined with
+// EnableAutoNAT.
+func ForceReachabilityPrivate() Option {
+	return forceReachability(bhost.ReachabilityPrivate)
+}
+
+func forceReachability(r bhost.Reachability) Option {
+	return func(cfg *Config) error {
+		if cfg.Reachability != bhost.ReachabilityUnknown && cfg.Reachability != r {
+			return fmt.Errorf("cannot force both public and private reachability")
+		}
+		cfg.Reachability = r
+		return nil
+	}
+}
+
+// ReachabilityChangeHandler registers a function called when the node's
+// reachability changes, found by AutoNAT or set with
+// bhost.BasicHost.SetReachability. It is called asynchronously, so it may
+// be slow, but its calls are in order. The current reachability is
+// available from bhost.BasicHost.Reachability.
+func ReachabilityChangeHandler(f func(old, new bhost.Reachability)) Option {
+	return func(cfg *Config) error {
+		cfg.ReachabilityChangeHandlers = append(cfg.ReachabilityChangeHandlers, f)
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	autonat "github.com/libp2p/go-libp2p/p2p/protocol/autonat"
+
+	host "github.com/libp2p/go-libp2p-host"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func waitReachability(ctx context.Context, t *testing.T, h host.Host, exp bhost.Reachability) {
+	for h.(*bhost.BasicHost).Reachability() != exp {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("reachability never became %s", exp)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestAutoNAT(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	oldInterval, oldThreshold := bhost.DefaultAutoNATInterval, autonat.PrivateThreshold
+	bhost.DefaultAutoNATInterval = 100 * time.Millisecond
+	autonat.PrivateThreshold = 1
+	defer func() {
+		bhost.DefaultAutoNATInterval, autonat.PrivateThreshold = oldInterval, oldThreshold
+	}()
+	defer setPublicThreshold(1)()
+
+	service := makeLocalHost(ctx, t, EnableAutoNATService())
+	defer service.Close()
+
+	// the service reaches us on loopback.
+	reachable := makeLocalHost(ctx, t, EnableAutoNAT())
+	defer reachable.Close()
+	connectHosts(ctx, t, reachable, service)
+	waitReachability(ctx, t, reachable, bhost.ReachabilityPublic)
+
+	// but not at an address nothing listens on.
+	closed := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	unreachable := makeLocalHost(ctx, t, EnableAutoNAT(), AddrsFactory(func([]ma.Multiaddr) []ma.Multiaddr {
+		return []ma.Multiaddr{closed}
+	}))
+	defer unreachable.Close()
+	connectHosts(ctx, t, unreachable, service)
+	waitReachability(ctx, t, unreachable, bhost.ReachabilityPrivate)
+}
+
+func setPublicThreshold(n int) (restore func()) {
+	old := autonat.PublicThreshold
+	autonat.PublicThreshold = n
+	return func() { autonat.PublicThreshold = old }
+}
+
+func TestAutoNATPublicThreshold(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer setPublicThreshold(2)()
+
+	h := makeLocalHost(ctx, t)
+	defer h.Close()
+
+	// a single peer dialing us back isn't enough.
+	s1 := makeLocalHost(ctx, t, EnableAutoNATService())
+	defer s1.Close()
+	connectHosts(ctx, t, h, s1)
+	if _, ok := autonat.NewClient(h).Probe(ctx); ok {
+		t.Fatal("expected a probe answered by a single peer to be inconclusive")
+	}
+
+	s2 := makeLocalHost(ctx, t, EnableAutoNATService())
+	defer s2.Close()
+	connectHosts(ctx, t, h, s2)
+	if public, ok := autonat.NewClient(h).Probe(ctx); !public || !ok {
+		t.Fatalf("expected two peers dialing us back to settle the probe, got public %t, conclusive %t", public, ok)
+	}
+}
+
+func TestAutoNATServiceAddrsCap(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer setPublicThreshold(1)()
+	oldThreshold := autonat.PrivateThreshold
+	autonat.PrivateThreshold = 1
+	defer func() { autonat.PrivateThreshold = oldThreshold }()
+
+	service := makeLocalHost(ctx, t, EnableAutoNATService())
+	defer service.Close()
+
+	// the service gives up on the closed addresses before getting to
+	// the one we listen on.
+	var closed []ma.Multiaddr
+	for port := 1; port <= autonat.MaxDialBackAddrs; port++ {
+		closed = append(closed, ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port)))
+	}
+	h := makeLocalHost(ctx, t, AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		return append(append([]ma.Multiaddr{}, closed...), addrs...)
+	}))
+	defer h.Close()
+	connectHosts(ctx, t, h, service)
+
+	if public, ok := autonat.NewClient(h).Probe(ctx); public || !ok {
+		t.Fatalf("expected only the first %d addresses to be dialed back, got public %t, conclusive %t",
+			autonat.MaxDialBackAddrs, public, ok)
+	}
+}
+
+func TestAutoNATServiceRateLimit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	defer setPublicThreshold(1)()
+
+	service := makeLocalHost(ctx, t, EnableAutoNATService(autonat.RateLimit{Global: 1, Interval: time.Hour}))
+	defer service.Close()
+
+	for i, exp := range []bool{true, false} {
+		h := makeLocalHost(ctx, t)
+		connectHosts(ctx, t, h, service)
+		_, ok := autonat.NewClient(h).Probe(ctx)
+		h.Close()
+		if ok != exp {
+			t.Fatalf("probe %d: expected conclusive %t, got %t", i, exp, ok)
+		}
+	}
+
+	if _, err := New(ctx, EnableAutoNATService(autonat.RateLimit{Global: 1})); err == nil {
+		t.Fatal("expected a rate limit without interval to be rejected")
+	}
+}
+
+func TestForceReachability(t *testing.T) {
+	ctx := context.Background()
+
+	h := makeLocalHost(ctx, t, ForceReachabilityPublic(), EnableAutoNATService())
+	defer h.Close()
+	if r := h.(*bhost.BasicHost).Reachability(); r != bhost.ReachabilityPublic {
+		t.Fatalf("expected the forced reachability, got %s", r)
+	}
+
+	for _, opts := range [][]Option{
+		{ForceReachabilityPublic(), ForceReachabilityPrivate()},
+		{ForceReachabilityPrivate(), EnableAutoNAT()},
+		{EnableAutoNAT(), ForceReachabilityPublic()},
+	} {
+		_, err := New(ctx, opts...)
+		if _, ok := err.(*ConfigError); !ok {
+			t.Fatalf("expected a *ConfigError, got %v", err)
+		}
+	}
+}
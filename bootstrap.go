@@ -0,0 +1,110 @@
+package libp2p
+
+import (
+	"fmt"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// BootstrapPeers makes the node connect to the given peers once it
+// listens, as full peer addresses such as
+// "/ip4/1.2.3.4/tcp/4001/p2p/QmPeer" (see ParsePeerAddrs). Their addresses
+// are added to the peerstore permanently, and the connections to them are
+// protected from connection manager trimming. At most
+// bhost.BootstrapConcurrency attempts run at once, and failures don't fail
+// New: they're available from bhost.BasicHost.BootstrapErrors, and retried
+// with KeepBootstrapped.
+func BootstrapPeers(addrs ...string) Option {
+	return func(cfg *Config) error {
+		infos, err := ParsePeerAddrs(addrs...)
+		if err != nil {
+			return err
+		}
+		return BootstrapPeerInfos(infos...)(cfg)
+	}
+}
+
+// BootstrapPeerInfos is BootstrapPeers for peers already parsed.
+func BootstrapPeerInfos(peers ...pstore.PeerInfo) Option {
+	return func(cfg *Config) error {
+		if len(peers) == 0 {
+			return fmt.Errorf("bootstrap needs at least one peer")
+		}
+		for _, pi := range peers {
+			if len(pi.Addrs) == 0 {
+				return fmt.Errorf("bootstrap peer %s has no address", pi.ID.Pretty())
+			}
+		}
+		all := append(cfg.BootstrapPeers[:len(cfg.BootstrapPeers):len(cfg.BootstrapPeers)], peers...)
+		if err := checkPeerInfos(all, nil); err != nil {
+			return err
+		}
+		cfg.BootstrapPeers = mergePeerInfos(all)
+		return nil
+	}
+}
+
+// KeepBootstrapped makes the node retry failed connections to its
+// bootstrap peers, with exponential backoff, and reconnect to the ones it
+// gets disconnected from, for as long as it runs.
+func KeepBootstrapped() Option {
+	return func(cfg *Config) error {
+		cfg.KeepBootstrapped = true
+		return nil
+	}
+}
+
+// BootstrapConfig configures the connection maintenance of Bootstrap.
+type BootstrapConfig struct {
+	// MinPeers is the number of peers the node keeps connected to.
+	MinPeers int
+
+	// Period is how often the connected peers are counted. If 0, it will
+	// use bhost.DefaultBootstrapPeriod.
+	Period time.Duration
+}
+
+// Bootstrap keeps the node connected to at least cfg.MinPeers peers: every
+// cfg.Period, if it is connected to fewer, it dials its bootstrap peers
+// (see BootstrapPeers), then the peers in its peerstore, until it's back
+// to the minimum. Peers the connection gater refuses are never dialed, and
+// failed ones are backed off from exponentially, between
+// bhost.BootstrapBackoff and bhost.BootstrapMaxBackoff. The state of the
+// maintenance is available from bhost.BasicHost.BootstrapState.
+func Bootstrap(cfg BootstrapConfig) Option {
+	return func(c *Config) error {
+		if cfg.MinPeers <= 0 {
+			return fmt.Errorf("bootstrap minimum peers must be positive, got %d", cfg.MinPeers)
+		}
+		if cfg.Period < 0 {
+			return fmt.Errorf("bootstrap period must not be negative, got %s", cfg.Period)
+		}
+		c.BootstrapMinPeers = cfg.MinPeers
+		c.BootstrapPeriod = cfg.Period
+		return nil
+	}
+}
+
+// StaticPeers adds the given peers to the peerstore with
+// pstore.PermanentAddrTTL, and protects them from connection manager
+// trimming, without connecting to them. The node's own ID can't be one of
+// them. The list is available from bhost.BasicHost.StaticPeers.
+func StaticPeers(peers ...pstore.PeerInfo) Option {
+	return func(cfg *Config) error {
+		if len(peers) == 0 {
+			return fmt.Errorf("static peers need at least one peer")
+		}
+		for _, pi := range peers {
+			if len(pi.Addrs) == 0 {
+				return fmt.Errorf("static peer %s has no address", pi.ID.Pretty())
+			}
+		}
+		all := append(cfg.StaticPeers[:len(cfg.StaticPeers):len(cfg.StaticPeers)], peers...)
+		if err := checkPeerInfos(all, nil); err != nil {
+			return err
+		}
+		cfg.StaticPeers = mergePeerInfos(all)
+		return nil
+	}
+}
@@ -0,0 +1,168 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	connmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+func TestBootstrapPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	oldBackoff := bhost.BootstrapBackoff
+	bhost.BootstrapBackoff = 50 * time.Millisecond
+	defer func() { bhost.BootstrapBackoff = oldBackoff }()
+
+	boot := makeLocalHost(ctx, t)
+	defer boot.Close()
+	bootAddr := boot.Addrs()[0].String() + "/p2p/" + boot.ID().Pretty()
+
+	// a peer that isn't running yet.
+	late := makeLocalHost(ctx, t)
+	lateAddr := late.Addrs()[0].String() + "/p2p/" + late.ID().Pretty()
+	late.Close()
+
+	cm := connmgr.NewConnManager(1, 10, 0)
+	h := makeLocalHost(ctx, t, BootstrapPeers(bootAddr, lateAddr), KeepBootstrapped(), ConnectionManager(cm))
+	defer h.Close()
+
+	for h.Network().Connectedness(boot.ID()) != inet.Connected || h.(*bhost.BasicHost).BootstrapErrors()[late.ID()] == nil {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected a connection to the bootstrap peer and a failure, got %v", h.(*bhost.BasicHost).BootstrapErrors())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cm.Protect(boot.ID(), "test")
+	if !cm.Unprotect(boot.ID(), "test") {
+		t.Fatal("expected the bootstrap peer to be protected")
+	}
+	if len(h.Peerstore().Addrs(late.ID())) == 0 {
+		t.Fatal("expected the bootstrap addresses to be kept in the peerstore")
+	}
+
+	if _, err := New(ctx, KeepBootstrapped()); err == nil {
+		t.Fatal("expected KeepBootstrapped without bootstrap peers to be rejected")
+	}
+	if _, err := New(ctx, BootstrapPeers("/ip4/1.2.3.4/tcp/4001")); err == nil {
+		t.Fatal("expected an address without a peer ID to be rejected")
+	}
+}
+
+func TestAddressTTLs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := New(ctx, AddressTTLs(0, 0, 500*time.Millisecond, 0)); err == nil {
+		t.Fatal("expected a TTL below a second to be rejected")
+	}
+
+	// a peer that isn't running.
+	gone := makeLocalHost(ctx, t)
+	goneAddr := gone.Addrs()[0].String() + "/p2p/" + gone.ID().Pretty()
+	gone.Close()
+
+	h := makeLocalHost(ctx, t, BootstrapPeers(goneAddr), AddressTTLs(0, 0, 0, time.Second))
+	defer h.Close()
+	for len(h.Peerstore().Addrs(gone.ID())) != 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the bootstrap addresses to expire")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestStaticPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+	pi := pstore.PeerInfo{ID: other.ID(), Addrs: other.Addrs()}
+
+	cm := connmgr.NewConnManager(1, 10, 0)
+	h := makeLocalHost(ctx, t, StaticPeers(pi), ConnectionManager(cm))
+	defer h.Close()
+
+	if len(h.Peerstore().Addrs(other.ID())) == 0 {
+		t.Fatal("expected the static peer addresses to be in the peerstore")
+	}
+	if h.Network().Connectedness(other.ID()) == inet.Connected {
+		t.Fatal("expected no connection to the static peer")
+	}
+	if static := h.(*bhost.BasicHost).StaticPeers(); len(static) != 1 || static[0].ID != other.ID() {
+		t.Fatalf("expected the static peer to be listed, got %v", static)
+	}
+	cm.Protect(other.ID(), "test")
+	if !cm.Unprotect(other.ID(), "test") {
+		t.Fatal("expected the static peer to be protected")
+	}
+
+	sk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(ctx, Identity(sk), StaticPeers(pstore.PeerInfo{ID: self, Addrs: other.Addrs()}))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError listing the node itself, got %v", err)
+	}
+}
+
+func TestBootstrapMaintenance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := New(ctx, Bootstrap(BootstrapConfig{})); err == nil {
+		t.Fatal("expected a zero minimum to be rejected")
+	}
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+	pi := pstore.PeerInfo{ID: other.ID(), Addrs: other.Addrs()}
+	maintenance := Bootstrap(BootstrapConfig{MinPeers: 1, Period: 50 * time.Millisecond})
+
+	h := makeLocalHost(ctx, t, StaticPeers(pi), maintenance)
+	defer h.Close()
+	for h.Network().Connectedness(other.ID()) != inet.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the host to connect to a peer from its peerstore")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	h.Network().ClosePeer(other.ID())
+	for h.(*bhost.BasicHost).BootstrapState().Peers != 1 || h.Network().Connectedness(other.ID()) != inet.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected the host to reconnect, got %+v", h.(*bhost.BasicHost).BootstrapState())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// peers the gater refuses are never dialed.
+	gated := makeLocalHost(ctx, t, StaticPeers(pi), maintenance, ConnectionGater(&testGater{deny: "PeerDial"}))
+	defer gated.Close()
+	for gated.(*bhost.BasicHost).BootstrapState().ConsecutiveFailures < 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the maintenance to fail")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if gated.Network().Connectedness(other.ID()) == inet.Connected {
+		t.Fatal("expected no connection to a peer the gater refuses")
+	}
+}
@@ -0,0 +1,45 @@
+package libp2p
+
+import (
+	"fmt"
+	"time"
+
+	ifconnmgr "github.com/libp2p/go-libp2p-interface-connmgr"
+)
+
+// ConnectionManager makes the node track its connections with the given
+// connection manager, which is notified of every connection and closed
+// along with the node if it's an io.Closer. Protocols tag peers through
+// the host's ConnManager, and protect them with bhost.BasicHost.Protect.
+func ConnectionManager(cm ifconnmgr.ConnManager) Option {
+	return func(cfg *Config) error {
+		if cfg.ConnManager != nil || cfg.ConnManagerHigh > 0 {
+			return fmt.Errorf("cannot specify multiple connection managers")
+		}
+		cfg.ConnManager = cm
+		return nil
+	}
+}
+
+// ConnectionManagerWatermarks makes the node trim its connections with the
+// built-in connection manager (see connmgr.BasicConnMgr): whenever there
+// are more than high connections open, the least valuable ones are closed
+// until only low are left, none with a zero low. Connections younger than
+// grace are spared; a zero grace uses connmgr.DefaultGracePeriod.
+func ConnectionManagerWatermarks(low, high int, grace time.Duration) Option {
+	return func(cfg *Config) error {
+		if cfg.ConnManager != nil || cfg.ConnManagerHigh > 0 {
+			return fmt.Errorf("cannot specify multiple connection managers")
+		}
+		if low < 0 || high <= 0 || low > high {
+			return fmt.Errorf("invalid connection manager watermarks: low %d, high %d", low, high)
+		}
+		if grace < 0 {
+			return fmt.Errorf("connection manager grace period must not be negative, got %s", grace)
+		}
+		cfg.ConnManagerLow = low
+		cfg.ConnManagerHigh = high
+		cfg.ConnManagerGrace = grace
+		return nil
+	}
+}
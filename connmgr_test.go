@@ -0,0 +1,52 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	connmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+func TestConnectionManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h1 := makeLocalHost(ctx, t, ConnectionManagerWatermarks(1, 10, time.Minute))
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t)
+	defer h2.Close()
+
+	if _, ok := h1.ConnManager().(*connmgr.BasicConnMgr); !ok {
+		t.Fatalf("expected the built-in connection manager, got %T", h1.ConnManager())
+	}
+	connectHosts(ctx, t, h1, h2)
+	h1.ConnManager().TagPeer(h2.ID(), "test", 5)
+	if ti := h1.ConnManager().GetTagInfo(h2.ID()); ti == nil || ti.Value != 5 || len(ti.Conns) != 1 {
+		t.Fatalf("expected the connection tracked and tagged, got %+v", ti)
+	}
+	h1.(*bhost.BasicHost).Protect(h2.ID(), "test")
+	if h1.(*bhost.BasicHost).Unprotect(h2.ID(), "test") {
+		t.Fatal("expected the peer to be unprotected")
+	}
+
+	h4 := makeLocalHost(ctx, t, ConnectionManagerWatermarks(0, 10, time.Minute))
+	h4.Close()
+
+	cm := connmgr.NewConnManager(1, 10, 0)
+	h3 := makeLocalHost(ctx, t, ConnectionManager(cm))
+	if h3.ConnManager() != cm {
+		t.Fatal("expected the given connection manager")
+	}
+	h3.Close()
+
+	_, err := New(ctx, ConnectionManager(cm), ConnectionManagerWatermarks(1, 10, 0))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError for multiple connection managers, got %v", err)
+	}
+	_, err = New(ctx, ConnectionManagerWatermarks(10, 1, 0))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError for inverted watermarks, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ConstructionTimeout bounds how long New may take. Every stage of the
+// construction gives up once the timeout expires, and New then fails with
+// a *ConstructionError naming the stage in progress, after releasing what
+// was already set up. The timeout doesn't apply to the node once built.
+func ConstructionTimeout(d time.Duration) Option {
+	return func(cfg *Config) error {
+		if d <= 0 {
+			return fmt.Errorf("construction timeout must be positive, got %s", d)
+		}
+		cfg.ConstructionTimeout = d
+		return nil
+	}
+}
+
+// ConstructionError is returned by New when construction was given up,
+// because of the ConstructionTimeout or because the context passed to New
+// is done.
+type ConstructionError struct {
+	// Stage is the construction stage that was in progress: "identity",
+	// "resolve", "listen", "host" or "relay".
+	Stage string
+	Err   error
+}
+
+func (e *ConstructionError) Error() string {
+	return fmt.Sprintf("node construction aborted during %s: %s", e.Stage, e.Err)
+}
+
+// ConfigError is returned by New when its options are invalid, alone or in
+// combination, before anything was set up.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func configErrorf(format string, args ...interface{}) error {
+	return &ConfigError{Err: fmt.Errorf(format, args...)}
+}
+
+// runStage runs the construction stage f, giving up when ctx is done. f
+// then keeps running in the background, and abandon, if not nil, is called
+// once it returns, to release what it and the previous stages set up. If
+// ctx is done already, f isn't run, and abandon is called right away.
+func runStage(ctx context.Context, stage string, f func() error, abandon func()) error {
+	if err := ctx.Err(); err != nil {
+		if abandon != nil {
+			abandon()
+		}
+		return &ConstructionError{Stage: stage, Err: err}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if abandon != nil {
+			go func() {
+				<-done
+				abandon()
+			}()
+		}
+		return &ConstructionError{Stage: stage, Err: ctx.Err()}
+	}
+}
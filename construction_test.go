@@ -0,0 +1,65 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+)
+
+func TestConstructionTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// generating such a key takes far longer than the timeout.
+	start := time.Now()
+	_, err := New(ctx,
+		RandomIdentity(crypto.RSA, 8192),
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		ConstructionTimeout(50*time.Millisecond),
+	)
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("New took %s despite the construction timeout", took)
+	}
+	cerr, ok := err.(*ConstructionError)
+	if !ok {
+		t.Fatalf("expected a ConstructionError, got %v", err)
+	}
+	if cerr.Stage != "identity" || cerr.Err != context.DeadlineExceeded {
+		t.Fatalf("unexpected construction error: %s", cerr)
+	}
+}
+
+func TestConstructionTimeoutDoesNotBoundNode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := makeLocalHost(ctx, t, RandomIdentity(crypto.Ed25519, 0), ConstructionTimeout(time.Second))
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t)
+	defer h2.Close()
+
+	time.Sleep(1200 * time.Millisecond)
+	connectHosts(ctx, t, h2, h1)
+}
+
+func TestRunStageAbandonsWhenAlreadyDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ran, abandoned bool
+	err := runStage(ctx, "listen", func() error {
+		ran = true
+		return nil
+	}, func() { abandoned = true })
+	if _, ok := err.(*ConstructionError); !ok {
+		t.Fatalf("expected a ConstructionError, got %v", err)
+	}
+	if ran {
+		t.Fatal("expected the stage not to run")
+	}
+	if !abandoned {
+		t.Fatal("expected what the previous stages set up to be released")
+	}
+}
@@ -0,0 +1,28 @@
+package libp2p
+
+import (
+	"fmt"
+
+	host "github.com/libp2p/go-libp2p-host"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+)
+
+// DiscoveryC constructs the discovery of a node, given its host.
+type DiscoveryC func(host.Host) (bhost.Discovery, error)
+
+// Discovery plugs a rendezvous-style discovery mechanism, constructed
+// while the host is set up, into the node: the node advertises itself in
+// bhost.BootstrapNamespace, and relays with hop enabled in
+// bhost.RelayNamespace too. With EnableRelay, the node finds relays there
+// when it isn't publicly reachable and has no static relay it can reach,
+// and with Bootstrap, peers to connect to. See discovery.MemoryRegistry
+// for an implementation for tests.
+func Discovery(d DiscoveryC) Option {
+	return func(cfg *Config) error {
+		if cfg.Discovery != nil {
+			return fmt.Errorf("cannot specify multiple discovery options")
+		}
+		cfg.Discovery = d
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package libp2p
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	discovery "github.com/libp2p/go-libp2p/p2p/discovery"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+)
+
+func memoryDiscovery(reg *discovery.MemoryRegistry) Option {
+	return Discovery(func(h host.Host) (bhost.Discovery, error) {
+		return reg.Discovery(h), nil
+	})
+}
+
+func TestDiscoveryBootstrap(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reg := discovery.NewMemoryRegistry()
+	a := makeLocalHost(ctx, t, memoryDiscovery(reg))
+	defer a.Close()
+	b := makeLocalHost(ctx, t, memoryDiscovery(reg), Bootstrap(BootstrapConfig{MinPeers: 1, Period: 50 * time.Millisecond}))
+	defer b.Close()
+
+	for b.Network().Connectedness(a.ID()) != inet.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the host to connect to a peer found through discovery")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestDiscoveryAutoRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	oldInterval := bhost.DefaultAutoRelayInterval
+	bhost.DefaultAutoRelayInterval = 50 * time.Millisecond
+	defer func() { bhost.DefaultAutoRelayInterval = oldInterval }()
+
+	reg := discovery.NewMemoryRegistry()
+	relay := makeLocalHost(ctx, t, EnableRelay(circuit.OptHop), memoryDiscovery(reg))
+	defer relay.Close()
+
+	// loopback addresses aren't public, so the node looks for a relay.
+	h := makeLocalHost(ctx, t, EnableRelay(), memoryDiscovery(reg))
+	defer h.Close()
+
+	for {
+		addrs := h.(*bhost.BasicHost).RelayAddrs()
+		if len(addrs) > 0 {
+			if !strings.Contains(addrs[0].String(), relay.ID().Pretty()) {
+				t.Fatalf("expected an address through the discovered relay, got %s", addrs)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected relay addresses through a discovered relay")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
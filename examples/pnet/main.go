@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	host "github.com/libp2p/go-libp2p-host"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// makeHost creates a host listening on a random local port, with the given
+// extra options.
+func makeHost(ctx context.Context, opts ...libp2p.Option) host.Host {
+	opts = append([]libp2p.Option{libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0")}, opts...)
+	h, err := libp2p.New(ctx, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return h
+}
+
+func connect(ctx context.Context, from, to host.Host) error {
+	// an unauthorized peer can't complete the handshake, which would
+	// otherwise only end with the dial timeout.
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return from.Connect(ctx, pstore.PeerInfo{ID: to.ID(), Addrs: to.Addrs()})
+}
+
+func main() {
+	ctx := context.Background()
+
+	// Generate the network key, and store it the way it would be shared
+	// among the members of the network.
+	dir, err := ioutil.TempDir("", "pnet")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "swarm.key")
+
+	key, err := libp2p.NewPSK()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := libp2p.WritePSKFile(keyFile, key); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Wrote the private network key to %s\n", keyFile)
+
+	// Each member reads the key file. ForcePrivateNetwork makes sure a
+	// member never joins the public network, should the file be missing.
+	member := func() host.Host {
+		key, err := libp2p.ReadPSKFile(keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return makeHost(ctx, libp2p.PrivateNetworkPSK(key), libp2p.ForcePrivateNetwork)
+	}
+	h1 := member()
+	defer h1.Close()
+	h2 := member()
+	defer h2.Close()
+
+	if err := connect(ctx, h2, h1); err != nil {
+		log.Fatalf("members failed to connect: %s", err)
+	}
+	fmt.Printf("%s and %s, sharing the key, are connected\n", h1.ID().Pretty(), h2.ID().Pretty())
+
+	// A host without the key can't make sense of the members' traffic,
+	// nor they of its own: the connection fails while it is set up.
+	outsider := makeHost(ctx)
+	defer outsider.Close()
+
+	err = connect(ctx, outsider, h1)
+	if err == nil {
+		log.Fatal("a host without the key connected to the private network")
+	}
+	fmt.Printf("%s, without the key, was rejected:\n\t%s\n", outsider.ID().Pretty(), err)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	inet "github.com/libp2p/go-libp2p-net"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// run starts the three hosts, and returns the message the dialer got from
+// the hidden host.
+func run(ctx context.Context) (string, error) {
+	// The relay: reachable, and relaying circuits for others thanks to
+	// circuit.OptHop.
+	relay, err := libp2p.New(ctx,
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.EnableRelay(circuit.OptHop),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer relay.Close()
+
+	// The hidden host listens on no address of its own, only through the
+	// relay: it connects to the relay, and accepts the circuits the relay
+	// opens to it.
+	circuitAddr, err := ma.NewMultiaddr(fmt.Sprintf("%s/ipfs/%s/p2p-circuit", relay.Addrs()[0], relay.ID().Pretty()))
+	if err != nil {
+		return "", err
+	}
+	hidden, err := libp2p.New(ctx,
+		libp2p.ListenAddrs(circuitAddr),
+		libp2p.EnableRelay(),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer hidden.Close()
+	hidden.SetStreamHandler("/relay-example/1.0.0", func(s inet.Stream) {
+		defer s.Close()
+		fmt.Fprintf(s, "hello from %s, through the relay", hidden.ID().Pretty())
+	})
+	fmt.Printf("hidden host %s is reachable at %s\n", hidden.ID().Pretty(), hidden.Addrs())
+
+	// The dialer only knows the hidden host's circuit address. Dialing it
+	// asks the relay to open a circuit to the hidden host.
+	dialer, err := libp2p.New(ctx,
+		libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"),
+		libp2p.EnableRelay(),
+	)
+	if err != nil {
+		return "", err
+	}
+	defer dialer.Close()
+
+	err = dialer.Connect(ctx, pstore.PeerInfo{ID: hidden.ID(), Addrs: []ma.Multiaddr{circuitAddr}})
+	if err != nil {
+		return "", err
+	}
+	s, err := dialer.NewStream(ctx, hidden.ID(), "/relay-example/1.0.0")
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("dialer is connected to the hidden host over %s\n", s.Conn().RemoteMultiaddr())
+
+	msg, err := ioutil.ReadAll(s)
+	if err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+func main() {
+	msg, err := run(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("dialer received: %s\n", msg)
+}
@@ -0,0 +1,50 @@
+package libp2p
+
+import (
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ConnectionGater makes the node check its connections against the given
+// gater: dials to refused peers or addresses aren't attempted, and
+// connections refused once established are closed before any stream is
+// handled on them. See bhost.ConnectionGater for when each hook runs.
+//
+// The swarm dials the addresses the peerstore has for a peer, so the
+// addresses the gater refuses to dial are left out of the peerstore's
+// Addrs.
+func ConnectionGater(g bhost.ConnectionGater) Option {
+	return func(cfg *Config) error {
+		if cfg.ConnectionGater != nil {
+			return fmt.Errorf("cannot specify multiple connection gaters")
+		}
+		cfg.ConnectionGater = g
+		return nil
+	}
+}
+
+// gatedPeerstore hides the addresses a gater refuses to dial from the
+// swarm.
+type gatedPeerstore struct {
+	pstore.Peerstore
+	gater bhost.ConnectionGater
+}
+
+func (ps *gatedPeerstore) Addrs(p peer.ID) []ma.Multiaddr {
+	addrs := ps.Peerstore.Addrs(p)
+	out := addrs[:0:0]
+	for _, a := range addrs {
+		if ps.gater.InterceptAddrDial(p, a) {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+func (ps *gatedPeerstore) PeerInfo(p peer.ID) pstore.PeerInfo {
+	return pstore.PeerInfo{ID: p, Addrs: ps.Addrs(p)}
+}
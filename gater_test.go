@@ -0,0 +1,121 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type testGater struct {
+	mu    sync.Mutex
+	calls []string
+	deny  string
+}
+
+func (g *testGater) record(call string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls = append(g.calls, call)
+	return call != g.deny
+}
+
+// order returns the hooks called, each at its first call.
+func (g *testGater) order() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out []string
+	seen := make(map[string]bool)
+	for _, c := range g.calls {
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func (g *testGater) InterceptPeerDial(p peer.ID) bool {
+	return g.record("PeerDial")
+}
+
+func (g *testGater) InterceptAddrDial(p peer.ID, a ma.Multiaddr) bool {
+	return g.record("AddrDial")
+}
+
+func (g *testGater) InterceptAccept(c bhost.ConnMultiaddrs) bool {
+	return g.record("Accept")
+}
+
+func (g *testGater) InterceptSecured(dir bhost.Direction, p peer.ID, c bhost.ConnMultiaddrs) bool {
+	return g.record(fmt.Sprintf("Secured/%d", dir))
+}
+
+func (g *testGater) InterceptUpgraded(c inet.Conn) (bool, bhost.DisconnectReason) {
+	return g.record("Upgraded"), 7
+}
+
+func waitOrder(ctx context.Context, t *testing.T, g *testGater, exp ...string) {
+	for {
+		order := g.order()
+		if len(order) >= len(exp) {
+			if fmt.Sprint(order[:len(exp)]) != fmt.Sprint(exp) {
+				t.Fatalf("expected the hooks %v, got %v", exp, order)
+			}
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected the hooks %v, got %v", exp, order)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestConnectionGater(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dialerGater, listenerGater := new(testGater), new(testGater)
+	dialer := makeLocalHost(ctx, t, ConnectionGater(dialerGater))
+	defer dialer.Close()
+	listener := makeLocalHost(ctx, t, ConnectionGater(listenerGater))
+	defer listener.Close()
+
+	connectHosts(ctx, t, dialer, listener)
+	out, in := fmt.Sprintf("Secured/%d", bhost.DirOutbound), fmt.Sprintf("Secured/%d", bhost.DirInbound)
+	waitOrder(ctx, t, dialerGater, "PeerDial", "AddrDial", out, "Upgraded")
+	waitOrder(ctx, t, listenerGater, "Accept", in, "Upgraded")
+
+	denied := &testGater{deny: "PeerDial"}
+	h := makeLocalHost(ctx, t, ConnectionGater(denied))
+	defer h.Close()
+	h.Peerstore().AddAddrs(listener.ID(), listener.Addrs(), time.Minute)
+	if _, err := h.NewStream(ctx, listener.ID(), "/test"); err != bhost.ErrGaterDisallowedConnection {
+		t.Fatalf("expected the dial to be refused, got %v", err)
+	}
+
+	// refused once upgraded, the connection is closed.
+	refusing := makeLocalHost(ctx, t, ConnectionGater(&testGater{deny: "Upgraded"}))
+	defer refusing.Close()
+	// the dial may or may not see the connection closed.
+	dialer.Connect(ctx, pstore.PeerInfo{ID: refusing.ID(), Addrs: refusing.Addrs()})
+	for len(refusing.Network().ConnsToPeer(dialer.ID())) > 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the refused connection to be closed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if err := ConnectionGater(dialerGater)(&Config{ConnectionGater: dialerGater}); err == nil {
+		t.Fatal("expected multiple gaters to be rejected")
+	}
+}
@@ -0,0 +1,47 @@
+package libp2p
+
+import (
+	"net"
+	"time"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	mux "github.com/libp2p/go-stream-muxer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// handshakeMuxer wraps the stream muxer of the swarm, to record the last
+// stages of the upgrade of new connections in the event log: the muxer is
+// handed connections once their security handshake is done, and then
+// negotiates the stream muxer.
+type handshakeMuxer struct {
+	mux.Transport
+	sink events.Sink
+}
+
+func (m handshakeMuxer) NewConn(c net.Conn, isServer bool) (mux.Conn, error) {
+	ev := events.Event{
+		Time:  time.Now(),
+		Type:  events.Handshake,
+		Addr:  c.RemoteAddr().String(),
+		Stage: "secured",
+	}
+	if mc, ok := c.(interface{ RemoteMultiaddr() ma.Multiaddr }); ok {
+		ev.Addr = mc.RemoteMultiaddr().String()
+	}
+	if pc, ok := c.(interface{ RemotePeer() peer.ID }); ok {
+		ev.Peer = pc.RemotePeer().Pretty()
+	}
+	m.sink.Emit(ev)
+
+	mc, err := m.Transport.NewConn(c, isServer)
+	ev.Stage = "muxed"
+	ev.Took = time.Since(ev.Time)
+	ev.Time = time.Now()
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	m.sink.Emit(ev)
+	return mc, err
+}
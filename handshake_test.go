@@ -0,0 +1,80 @@
+package libp2p
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+)
+
+func TestEventLogConnectionRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "event-log-records")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "events.log")
+
+	h1 := makeLocalHost(ctx, t,
+		ProtocolVersion("test/1.0.0"),
+		RequireProtocolVersionMatch(),
+		EventLog(logPath, 1<<20),
+	)
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t, ProtocolVersion("test/1.0.0"))
+	defer h2.Close()
+	other := makeLocalHost(ctx, t, ProtocolVersion("test/2.0.0"))
+	defer other.Close()
+
+	connectHosts(ctx, t, h1, h2)
+	connectHosts(ctx, t, h1, other)
+	for h1.Network().Connectedness(other.ID()) == inet.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the mismatching peer to be disconnected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// let the disconnection notification through.
+	time.Sleep(100 * time.Millisecond)
+
+	h1.Close()
+	evs, err := ReadEventLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stages := make(map[string]int)
+	identified := 0
+	var reason string
+	for _, ev := range evs {
+		switch {
+		case ev.Type == events.Handshake && ev.Peer == h2.ID().Pretty():
+			if ev.Error != "" {
+				t.Fatalf("unexpected handshake failure: %+v", ev)
+			}
+			stages[ev.Stage]++
+		case ev.Type == events.Identified && ev.Peer == h2.ID().Pretty():
+			identified++
+		case ev.Type == events.Disconnected && ev.Peer == other.ID().Pretty():
+			reason = ev.Error
+		}
+	}
+	if stages["secured"] != 1 || stages["muxed"] != 1 {
+		t.Fatalf("expected the secured and muxed stages to be recorded once, got %v", stages)
+	}
+	if identified != 1 {
+		t.Fatalf("expected the dialed connection to be identified once, got %d Identified events", identified)
+	}
+	if reason != "protocol mismatch" {
+		t.Fatalf("expected the disconnection to record its reason, got %q", reason)
+	}
+}
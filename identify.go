@@ -0,0 +1,61 @@
+package libp2p
+
+import (
+	"fmt"
+)
+
+// UserAgent sets the agent version the node reports to its peers through
+// identify, instead of identify.ClientVersion, so that they can tell which
+// application, or which release of it, they are talking to. The agent
+// versions reported by peers are stored in the peerstore, under
+// identify.AgentVersionKey.
+func UserAgent(s string) Option {
+	return func(cfg *Config) error {
+		if s == "" {
+			return fmt.Errorf("user agent must not be empty")
+		}
+		cfg.UserAgent = s
+		return nil
+	}
+}
+
+// ProtocolVersion sets the protocol version the node reports to its peers
+// through identify, instead of identify.LibP2PVersion. Peers reporting
+// another version are only disconnected with RequireProtocolVersionMatch.
+// The protocol versions reported by peers are stored in the peerstore,
+// under identify.ProtocolVersionKey.
+func ProtocolVersion(s string) Option {
+	return func(cfg *Config) error {
+		if s == "" {
+			return fmt.Errorf("protocol version must not be empty")
+		}
+		cfg.ProtocolVersion = s
+		return nil
+	}
+}
+
+// RequireProtocolVersionMatch disconnects the peers whose identify reports
+// a protocol version other than the node's own, once identify completes.
+// Each disconnection emits an events.ProtocolMismatch event, and is
+// counted in identify.IDService.ProtocolMismatches.
+func RequireProtocolVersionMatch() Option {
+	return func(cfg *Config) error {
+		cfg.RequireProtocolVersionMatch = true
+		return nil
+	}
+}
+
+// DisableIdentify keeps the node from running the identify protocol, for
+// deployments exchanging peer metadata their own way: the node neither
+// registers the identify handlers nor identifies new connections. Peer IDs
+// still come from the security handshake, but the node no longer learns
+// the protocols and listen addresses of its peers, nor the addresses they
+// observe it at, and the peerstore's protocol lists stay empty unless
+// filled by the application. It cannot be combined with the other identify
+// options, nor with RevalidateAnnounceAddrs or EnableAutoNAT.
+func DisableIdentify() Option {
+	return func(cfg *Config) error {
+		cfg.DisableIdentify = true
+		return nil
+	}
+}
@@ -0,0 +1,191 @@
+package libp2p
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+	identify "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestUserAgent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := New(ctx, UserAgent("")); err == nil {
+		t.Fatal("expected an empty user agent to be rejected")
+	}
+
+	h1 := makeLocalHost(ctx, t)
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t, UserAgent("test-service/1.2.3"))
+	defer h2.Close()
+	h3 := makeLocalHost(ctx, t)
+	defer h3.Close()
+
+	connectHosts(ctx, t, h1, h2)
+	connectHosts(ctx, t, h1, h3)
+
+	av, err := h1.Peerstore().Get(h2.ID(), identify.AgentVersionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if av != "test-service/1.2.3" {
+		t.Fatalf("expected the configured user agent, got %v", av)
+	}
+	av, err = h1.Peerstore().Get(h3.ID(), identify.AgentVersionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if av != identify.ClientVersion {
+		t.Fatalf("expected the default user agent, got %v", av)
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "protocol-version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "events.log")
+
+	h1 := makeLocalHost(ctx, t,
+		ProtocolVersion("test/1.0.0"),
+		RequireProtocolVersionMatch(),
+		EventLog(logPath, 1<<16),
+	)
+	defer h1.Close()
+	same := makeLocalHost(ctx, t, ProtocolVersion("test/1.0.0"))
+	defer same.Close()
+	other := makeLocalHost(ctx, t, ProtocolVersion("test/2.0.0"))
+	defer other.Close()
+
+	connectHosts(ctx, t, h1, same)
+	pv, err := h1.Peerstore().Get(same.ID(), identify.ProtocolVersionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pv != "test/1.0.0" {
+		t.Fatalf("expected the configured protocol version, got %v", pv)
+	}
+	if h1.Network().Connectedness(same.ID()) != inet.Connected {
+		t.Fatal("expected to stay connected to a peer with the same protocol version")
+	}
+
+	// connecting succeeds, but the connection is closed once identified.
+	connectHosts(ctx, t, h1, other)
+	if h1.Network().Connectedness(other.ID()) == inet.Connected {
+		t.Fatal("expected a peer with another protocol version to be disconnected")
+	}
+	if n := h1.(*bhost.BasicHost).IDService().ProtocolMismatches(); n != 1 {
+		t.Fatalf("expected 1 protocol mismatch, got %d", n)
+	}
+
+	h1.Close()
+	evs, err := ReadEventLog(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, ev := range evs {
+		if ev.Type == events.ProtocolMismatch && ev.Peer == other.ID().Pretty() && ev.Protocol == "test/2.0.0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("expected a ProtocolMismatch event")
+	}
+}
+
+func TestDisableIdentify(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := New(ctx, DisableIdentify(), UserAgent("test/1.0")); err == nil {
+		t.Fatal("expected identify options to be rejected with DisableIdentify")
+	}
+	_, err := New(ctx, DisableIdentify(), AnnounceAddrStrings("/ip4/1.2.3.4/tcp/4001"), RevalidateAnnounceAddrs(time.Minute, 3))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected revalidation to be rejected with DisableIdentify, got %v", err)
+	}
+	_, err = New(ctx, DisableIdentify(), EnableAutoNAT())
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected AutoNAT to be rejected with DisableIdentify, got %v", err)
+	}
+
+	h1 := makeLocalHost(ctx, t, DisableIdentify())
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t)
+	defer h2.Close()
+
+	if h1.(*bhost.BasicHost).IDService() != nil {
+		t.Fatal("expected no identify service")
+	}
+
+	h2.SetStreamHandler("/test", func(s inet.Stream) {
+		s.Close()
+	})
+	connectHosts(ctx, t, h1, h2)
+	s, err := h1.NewStream(ctx, h2.ID(), "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	protos, err := h1.Peerstore().GetProtocols(h2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, proto := range protos {
+		if proto == identify.ID {
+			t.Fatal("expected the peer's protocols not to be learned through identify")
+		}
+	}
+	if _, err := h2.NewStream(ctx, h1.ID(), identify.ID); err == nil {
+		t.Fatal("expected the identify protocol not to be handled")
+	}
+}
+
+func TestIdentifyPushKeepsStaticAddrs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+	permanent := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	pi := pstore.PeerInfo{ID: other.ID(), Addrs: append(other.Addrs(), permanent)}
+
+	h := makeLocalHost(ctx, t, StaticPeers(pi))
+	defer h.Close()
+	connectHosts(ctx, t, h, other)
+
+	// a new handler makes the static peer push its identify.
+	other.SetStreamHandler("/pushed", func(s inet.Stream) { s.Close() })
+	deadline := time.Now().Add(5 * time.Second)
+	for !hasProtocol(t, h, other.ID(), "/pushed") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the push to be received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	found := false
+	for _, a := range h.Peerstore().Addrs(other.ID()) {
+		found = found || a.Equal(permanent)
+	}
+	if !found {
+		t.Fatal("expected the permanent address of the static peer to survive the push")
+	}
+}
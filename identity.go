@@ -0,0 +1,254 @@
+package libp2p
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+)
+
+// IdentityFromFile loads the node's private key from the file at path. If
+// the file doesn't exist, a new Ed25519 key is generated and persisted
+// there first. See LoadOrGenerateIdentity.
+func IdentityFromFile(path string) Option {
+	return func(cfg *Config) error {
+		sk, err := LoadOrGenerateIdentity(path)
+		if err != nil {
+			return err
+		}
+		return Identity(sk)(cfg)
+	}
+}
+
+// LoadOrGenerateIdentity reads a private key, marshalled with
+// crypto.MarshalPrivateKey, from the file at path.
+//
+// If the file does not exist, a new Ed25519 key is generated and atomically
+// written to path (readable only by the current user) before being
+// returned. A file that exists but can't be decoded is an error: silently
+// replacing it would change the node's peer ID.
+func LoadOrGenerateIdentity(path string) (crypto.PrivKey, error) {
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		sk, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("identity file %s is corrupt (refusing to generate a new identity): %s", path, err)
+		}
+		return sk, nil
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("failed to read identity file %s: %s", path, err)
+	}
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = crypto.MarshalPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFileAtomic(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write identity file %s: %s", path, err)
+	}
+
+	return sk, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so path never contains a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// RandomIdentity configures the type of key generated for the node when no
+// identity is given: one of crypto.RSA, crypto.Ed25519, crypto.Secp256k1 or
+// crypto.ECDSA. The size in bits is only used for RSA keys. Without this
+// option, a 2048 bit RSA key is generated.
+func RandomIdentity(typ int, bits int) Option {
+	return func(cfg *Config) error {
+		if cfg.KeyType != 0 || cfg.KeyBits != 0 {
+			return fmt.Errorf("cannot specify multiple random identity key types")
+		}
+
+		switch typ {
+		case crypto.RSA:
+			if bits < 1024 {
+				return fmt.Errorf("RSA identity keys must be at least 1024 bits, got %d", bits)
+			}
+		case crypto.Ed25519, crypto.Secp256k1, crypto.ECDSA:
+			bits = 0
+		default:
+			return fmt.Errorf("unsupported identity key type: %d", typ)
+		}
+
+		cfg.KeyType = typ
+		cfg.KeyBits = bits
+		return nil
+	}
+}
+
+// ExpectedPeerID makes New fail unless the node's identity, however it was
+// configured or generated, yields the given peer ID. This guards against
+// starting a node with the wrong key, e.g. due to a mixed up volume.
+//
+// If no identity is given but a peerstore is, the private key for id is
+// taken from the peerstore.
+func ExpectedPeerID(id peer.ID) Option {
+	return func(cfg *Config) error {
+		if cfg.ExpectedPeerID != "" {
+			return fmt.Errorf("cannot specify multiple expected peer IDs")
+		}
+		if id == "" {
+			return fmt.Errorf("expected peer ID must not be empty")
+		}
+
+		cfg.ExpectedPeerID = id
+		return nil
+	}
+}
+
+// MinSeedLength is the minimum seed length accepted by IdentityFromSeed,
+// unless InsecureShortSeed is passed.
+const MinSeedLength = 32
+
+// SeedFlag modifies how IdentityFromSeed treats its seed.
+type SeedFlag int
+
+const (
+	// InsecureShortSeed allows seeds shorter than MinSeedLength, which are
+	// easy to guess. Only use it for tests.
+	InsecureShortSeed SeedFlag = 1 << iota
+)
+
+// IdentityFromSeed configures the node with an Ed25519 identity derived
+// deterministically from seed, so the same seed always yields the same
+// peer ID.
+//
+// This is meant for tests and simulations that need stable peer IDs across
+// runs. Anyone who knows the seed knows the private key: do not use it to
+// manage production keys.
+func IdentityFromSeed(seed []byte, flags ...SeedFlag) Option {
+	return func(cfg *Config) error {
+		var insecure bool
+		for _, f := range flags {
+			insecure = insecure || f&InsecureShortSeed != 0
+		}
+		if len(seed) < MinSeedLength && !insecure {
+			return fmt.Errorf("identity seed must be at least %d bytes, got %d", MinSeedLength, len(seed))
+		}
+
+		// hash the seed, so all of it is used whatever its length.
+		digest := sha256.Sum256(seed)
+		sk, _, err := crypto.GenerateEd25519Key(bytes.NewReader(digest[:]))
+		if err != nil {
+			return err
+		}
+		return Identity(sk)(cfg)
+	}
+}
+
+// IdentityFromSeedString is like IdentityFromSeed, using the bytes of s as
+// the seed.
+func IdentityFromSeedString(s string, flags ...SeedFlag) Option {
+	return IdentityFromSeed([]byte(s), flags...)
+}
+
+// DisablePrivKeyStorage keeps the node's private key out of the peerstore,
+// so it is never written to a persistent peerstore, nor handed to the
+// components using the node's peerstore: PrivKey returns nil for the node
+// itself. The key is only served, from memory, to the swarm, which needs
+// it to secure connections.
+func DisablePrivKeyStorage() Option {
+	return func(cfg *Config) error {
+		cfg.DisablePrivKeyStorage = true
+		return nil
+	}
+}
+
+// localKeyPeerstore keeps the local private key out of the wrapped
+// peerstore, and from its holders.
+type localKeyPeerstore struct {
+	pstore.Peerstore
+
+	local peer.ID
+}
+
+func (ps *localKeyPeerstore) PrivKey(p peer.ID) crypto.PrivKey {
+	if p == ps.local {
+		return nil
+	}
+	return ps.Peerstore.PrivKey(p)
+}
+
+func (ps *localKeyPeerstore) AddPrivKey(p peer.ID, sk crypto.PrivKey) error {
+	if p == ps.local {
+		return fmt.Errorf("private key storage is disabled for the local peer %s", p.Pretty())
+	}
+	return ps.Peerstore.AddPrivKey(p, sk)
+}
+
+// swarmKeyPeerstore is the swarm's view of a localKeyPeerstore: it serves
+// the local private key from memory, for the swarm to secure connections.
+type swarmKeyPeerstore struct {
+	pstore.Peerstore
+
+	local peer.ID
+	sk    crypto.PrivKey
+}
+
+func (ps *swarmKeyPeerstore) PrivKey(p peer.ID) crypto.PrivKey {
+	if p == ps.local {
+		return ps.sk
+	}
+	return ps.Peerstore.PrivKey(p)
+}
+
+// keylessNetwork is the swarm's network, handing out the peerstore without
+// the local private key instead of the swarm's view of it.
+type keylessNetwork struct {
+	*swarm.Network
+	ps pstore.Peerstore
+}
+
+func (n *keylessNetwork) Peerstore() pstore.Peerstore {
+	return n.ps
+}
+
+// Unwrap returns the swarm's network, which the relay transport needs.
+func (n *keylessNetwork) Unwrap() inet.Network {
+	return n.Network
+}
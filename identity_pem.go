@@ -0,0 +1,200 @@
+package libp2p
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+)
+
+var (
+	oidEd25519        = asn1.ObjectIdentifier{1, 3, 101, 112}
+	oidECPublicKey    = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidCurveSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+)
+
+// curveNames names some well known curves we can't use, for error messages.
+var curveNames = map[string]string{
+	"1.3.132.0.33":          "P-224",
+	"1.2.840.10045.3.1.1":   "P-192",
+	"1.3.36.3.3.2.8.1.1.7":  "brainpoolP256r1",
+	"1.3.36.3.3.2.8.1.1.11": "brainpoolP384r1",
+	"1.3.36.3.3.2.8.1.1.13": "brainpoolP512r1",
+}
+
+// pkcs8 mirrors the PKCS#8 PrivateKeyInfo structure.
+type pkcs8 struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// ecPrivateKey mirrors the SEC1 ECPrivateKey structure.
+type ecPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// IdentityFromPEM configures the node's identity from a PEM encoded private
+// key. See UnmarshalPEMPrivateKey for the supported formats.
+func IdentityFromPEM(pemBytes []byte, passphrase []byte) Option {
+	return func(cfg *Config) error {
+		sk, err := UnmarshalPEMPrivateKey(pemBytes, passphrase)
+		if err != nil {
+			return err
+		}
+		return Identity(sk)(cfg)
+	}
+}
+
+// UnmarshalPEMPrivateKey converts a PEM encoded private key, as issued by
+// most PKIs and tools like openssl, into a libp2p private key.
+//
+// PKCS#1 ("RSA PRIVATE KEY"), SEC1 ("EC PRIVATE KEY") and unencrypted PKCS#8
+// ("PRIVATE KEY") blocks are supported, holding RSA, ECDSA (P-256, P-384,
+// P-521 and secp256k1) or Ed25519 keys. Blocks encrypted with the legacy PEM
+// encryption scheme are decrypted with passphrase.
+func UnmarshalPEMPrivateKey(pemBytes []byte, passphrase []byte) (crypto.PrivKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	der := block.Bytes
+	if x509.IsEncryptedPEMBlock(block) {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("PEM block %q is encrypted, but no passphrase was given", block.Type)
+		}
+		var err error
+		der, err = x509.DecryptPEMBlock(block, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt PEM block %q: %s", block.Type, err)
+		}
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err := x509.ParsePKCS1PrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		return fromStdRSA(k)
+	case "EC PRIVATE KEY":
+		return parseSEC1(der)
+	case "PRIVATE KEY":
+		return parsePKCS8(der)
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, errors.New("encrypted PKCS#8 keys are not supported, decrypt the key first (e.g. with `openssl pkcs8`)")
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+}
+
+func parsePKCS8(der []byte) (crypto.PrivKey, error) {
+	var info pkcs8
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse PKCS#8 private key: %s", err)
+	}
+
+	switch {
+	case info.Algo.Algorithm.Equal(oidEd25519):
+		// the key is an octet string holding the 32 byte seed.
+		var seed []byte
+		if _, err := asn1.Unmarshal(info.PrivateKey, &seed); err != nil {
+			return nil, fmt.Errorf("failed to parse Ed25519 private key: %s", err)
+		}
+		if len(seed) != 32 {
+			return nil, fmt.Errorf("invalid Ed25519 private key length %d", len(seed))
+		}
+		sk, _, err := crypto.GenerateEd25519Key(bytes.NewReader(seed))
+		return sk, err
+	case info.Algo.Algorithm.Equal(oidECPublicKey):
+		var curve asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(info.Algo.Parameters.FullBytes, &curve); err != nil {
+			return nil, fmt.Errorf("failed to parse elliptic curve of PKCS#8 private key: %s", err)
+		}
+		if curve.Equal(oidCurveSecp256k1) {
+			return parseSecp256k1(info.PrivateKey)
+		}
+		if name, ok := curveNames[curve.String()]; ok {
+			return nil, fmt.Errorf("unsupported elliptic curve %s", name)
+		}
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch k := k.(type) {
+	case *rsa.PrivateKey:
+		return fromStdRSA(k)
+	case *ecdsa.PrivateKey:
+		return fromStdECDSA(k)
+	default:
+		return nil, fmt.Errorf("unsupported PKCS#8 private key type %T", k)
+	}
+}
+
+// parseSEC1 parses an EC private key. The standard library doesn't know
+// about secp256k1, so we have to look at the curve ourselves first.
+func parseSEC1(der []byte) (crypto.PrivKey, error) {
+	var ecKey ecPrivateKey
+	if _, err := asn1.Unmarshal(der, &ecKey); err != nil {
+		return nil, fmt.Errorf("failed to parse EC private key: %s", err)
+	}
+
+	curve := ecKey.NamedCurveOID
+	if curve.Equal(oidCurveSecp256k1) {
+		return crypto.UnmarshalSecp256k1PrivateKey(ecKey.PrivateKey)
+	}
+
+	k, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		if name, ok := curveNames[curve.String()]; ok {
+			return nil, fmt.Errorf("unsupported elliptic curve %s", name)
+		}
+		if len(curve) > 0 {
+			return nil, fmt.Errorf("unsupported elliptic curve %s: %s", curve, err)
+		}
+		return nil, err
+	}
+	return fromStdECDSA(k)
+}
+
+// parseSecp256k1 parses a SEC1 EC private key known to be on the secp256k1
+// curve, which may omit the curve.
+func parseSecp256k1(der []byte) (crypto.PrivKey, error) {
+	var ecKey ecPrivateKey
+	if _, err := asn1.Unmarshal(der, &ecKey); err != nil {
+		return nil, fmt.Errorf("failed to parse EC private key: %s", err)
+	}
+	return crypto.UnmarshalSecp256k1PrivateKey(ecKey.PrivateKey)
+}
+
+func fromStdRSA(k *rsa.PrivateKey) (crypto.PrivKey, error) {
+	return crypto.UnmarshalRsaPrivateKey(x509.MarshalPKCS1PrivateKey(k))
+}
+
+func fromStdECDSA(k *ecdsa.PrivateKey) (crypto.PrivKey, error) {
+	switch k.Curve {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+	default:
+		return nil, fmt.Errorf("unsupported elliptic curve %s", k.Curve.Params().Name)
+	}
+
+	der, err := x509.MarshalECPrivateKey(k)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.UnmarshalECDSAPrivateKey(der)
+}
@@ -0,0 +1,104 @@
+package libp2p
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+)
+
+// The keys in testdata/pem were generated with openssl, e.g.:
+//
+//   openssl genrsa -traditional -out rsa-pkcs1.pem 2048
+//   openssl genpkey -algorithm EC -pkeyopt ec_paramgen_curve:P-384 -out ec-p384-pkcs8.pem
+//   openssl ecparam -name secp256k1 -genkey -noout -out ec-secp256k1-sec1.pem
+//   openssl genpkey -algorithm ed25519 -out ed25519-pkcs8.pem
+//   openssl rsa -in rsa-pkcs1.pem -traditional -aes256 -passout pass:libp2p -out rsa-encrypted.pem
+//
+// with their public keys extracted to *.pub.pem using `openssl pkey -pubout`.
+
+func readPEMFixture(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "pem", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestUnmarshalPEMPrivateKey(t *testing.T) {
+	for _, name := range []string{
+		"rsa-pkcs1.pem",
+		"rsa-pkcs8.pem",
+		"rsa-encrypted.pem",
+		"ec-p256-sec1.pem",
+		"ec-p384-pkcs8.pem",
+		"ec-secp256k1-sec1.pem",
+		"ed25519-pkcs8.pem",
+	} {
+		sk, err := UnmarshalPEMPrivateKey(readPEMFixture(t, name), []byte("libp2p"))
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		msg := []byte("hello libp2p")
+		sig, err := sk.Sign(msg)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		ok, err := sk.GetPublic().Verify(msg, sig)
+		if err != nil || !ok {
+			t.Fatalf("%s: signature didn't verify (%v)", name, err)
+		}
+
+		// compare against the public key extracted by openssl, if we have it.
+		pubName := strings.TrimSuffix(name, ".pem") + ".pub.pem"
+		if _, err := os.Stat(filepath.Join("testdata", "pem", pubName)); err != nil {
+			continue
+		}
+		block, _ := pem.Decode(readPEMFixture(t, pubName))
+		stdPub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var pub crypto.PubKey
+		switch {
+		case strings.HasPrefix(name, "rsa"):
+			pub, err = crypto.UnmarshalRsaPublicKey(block.Bytes)
+		case strings.HasPrefix(name, "ec"):
+			pub, err = crypto.UnmarshalECDSAPublicKey(block.Bytes)
+		default:
+			t.Fatalf("%s: unexpected public key type %T", pubName, stdPub)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pub.Equals(sk.GetPublic()) {
+			t.Fatalf("%s: public key doesn't match the one extracted by openssl", name)
+		}
+	}
+}
+
+func TestUnmarshalPEMPrivateKeyErrors(t *testing.T) {
+	_, err := UnmarshalPEMPrivateKey(readPEMFixture(t, "ec-brainpool-sec1.pem"), nil)
+	if err == nil || !strings.Contains(err.Error(), "brainpoolP256r1") {
+		t.Fatalf("expected an error naming the unsupported curve, got: %v", err)
+	}
+
+	if _, err := UnmarshalPEMPrivateKey(readPEMFixture(t, "rsa-encrypted.pem"), nil); err == nil {
+		t.Fatal("expected an error for an encrypted key without passphrase")
+	}
+
+	if _, err := UnmarshalPEMPrivateKey(readPEMFixture(t, "rsa-encrypted.pem"), []byte("wrong")); err == nil {
+		t.Fatal("expected an error for an encrypted key with the wrong passphrase")
+	}
+
+	if _, err := UnmarshalPEMPrivateKey(readPEMFixture(t, "rsa-pkcs1.pub.pem"), nil); err == nil {
+		t.Fatal("expected an error for a public key")
+	}
+}
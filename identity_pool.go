@@ -0,0 +1,91 @@
+package libp2p
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+)
+
+// poolKeyBits is the size of the RSA keys an IdentityPool generates, the
+// default identity size.
+const poolKeyBits = 2048
+
+// IdentityPool generates RSA identity keys in the background, so that
+// constructing a node doesn't have to wait for one. It is safe for
+// concurrent use, hands each key out only once, and refills itself as keys
+// are taken.
+type IdentityPool struct {
+	keys    chan crypto.PrivKey
+	closing chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+// PregeneratedIdentityPool starts generating 2048 bit RSA keys in the
+// background, keeping up to n of them ready. Pass it to nodes with the
+// IdentityPool option, and close it once it's no longer needed.
+func PregeneratedIdentityPool(n int) *IdentityPool {
+	if n < 1 {
+		n = 1
+	}
+	p := &IdentityPool{
+		keys:    make(chan crypto.PrivKey, n),
+		closing: make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.fill()
+	return p
+}
+
+func (p *IdentityPool) fill() {
+	defer p.wg.Done()
+	for {
+		priv, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, poolKeyBits, rand.Reader)
+		if err != nil {
+			// nodes fall back to generating their key inline.
+			return
+		}
+		select {
+		case p.keys <- priv:
+		case <-p.closing:
+			return
+		}
+	}
+}
+
+// Get returns a pregenerated key, if one is ready.
+func (p *IdentityPool) Get() (crypto.PrivKey, bool) {
+	select {
+	case priv := <-p.keys:
+		return priv, true
+	default:
+		return nil, false
+	}
+}
+
+// Ready returns the number of keys ready to be taken.
+func (p *IdentityPool) Ready() int {
+	return len(p.keys)
+}
+
+// Close stops the background generation.
+func (p *IdentityPool) Close() error {
+	p.once.Do(func() { close(p.closing) })
+	p.wg.Wait()
+	return nil
+}
+
+// IdentityFromPool makes the node take its identity from pool, when no
+// identity is given and the default 2048 bit RSA key would otherwise be
+// generated. If the pool is empty, the key is generated inline.
+func IdentityFromPool(pool *IdentityPool) Option {
+	return func(cfg *Config) error {
+		if pool == nil {
+			return fmt.Errorf("identity pool must not be nil")
+		}
+		cfg.IdentityPool = pool
+		return nil
+	}
+}
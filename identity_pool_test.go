@@ -0,0 +1,101 @@
+package libp2p
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func waitPoolReady(t *testing.T, pool *IdentityPool, n int) {
+	deadline := time.Now().Add(30 * time.Second)
+	for pool.Ready() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool only has %d keys ready", pool.Ready())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIdentityPoolWarm(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := PregeneratedIdentityPool(2)
+	defer pool.Close()
+	waitPoolReady(t, pool, 2)
+
+	start := time.Now()
+	h, err := New(ctx, IdentityFromPool(pool))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Fatalf("New with a warm pool took %s", took)
+	}
+
+	if typ := h.Peerstore().PrivKey(h.ID()).GetPublic().Type(); typ != crypto.RSA {
+		t.Fatalf("expected an RSA identity, got key type %d", typ)
+	}
+}
+
+func TestIdentityPoolConcurrentConsumers(t *testing.T) {
+	const n = 6
+
+	pool := PregeneratedIdentityPool(n)
+	defer pool.Close()
+	waitPoolReady(t, pool, n)
+
+	var mu sync.Mutex
+	seen := make(map[peer.ID]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			priv, ok := pool.Get()
+			if !ok {
+				t.Error("expected a key to be ready")
+				return
+			}
+			id, err := peer.IDFromPrivateKey(priv)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[id] {
+				t.Errorf("key for %s handed out twice", id.Pretty())
+			}
+			seen[id] = true
+		}()
+	}
+	wg.Wait()
+
+	// the pool refills itself.
+	waitPoolReady(t, pool, 1)
+}
+
+func TestIdentityPoolNotUsedForOtherKeyTypes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := PregeneratedIdentityPool(1)
+	defer pool.Close()
+	waitPoolReady(t, pool, 1)
+
+	h, err := New(ctx, IdentityFromPool(pool), RandomIdentity(crypto.Ed25519, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if pool.Ready() != 1 {
+		t.Fatal("expected the pool to be left alone")
+	}
+}
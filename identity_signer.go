@@ -0,0 +1,117 @@
+package libp2p
+
+import (
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	pb "github.com/libp2p/go-libp2p-crypto/pb"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ErrKeyNotExportable is returned when trying to marshal a private key held
+// by an external signer.
+var ErrKeyNotExportable = errors.New("private key is held by an external signer and can't be exported")
+
+// IdentityFromSigner configures the node's identity from a crypto.Signer,
+// such as a key held in an HSM or a KMS. The private key never has to be
+// in memory: everything in libp2p that uses it only needs signatures.
+//
+// RSA, ECDSA (P-256, P-384 and P-521) and Ed25519 signers are supported.
+func IdentityFromSigner(signer gocrypto.Signer) Option {
+	return func(cfg *Config) error {
+		sk, err := NewSignerPrivKey(signer)
+		if err != nil {
+			return err
+		}
+		return Identity(sk)(cfg)
+	}
+}
+
+// NewSignerPrivKey returns a crypto.PrivKey delegating signing to signer.
+// Its Bytes and Raw methods return ErrKeyNotExportable.
+func NewSignerPrivKey(signer gocrypto.Signer) (crypto.PrivKey, error) {
+	k := &signerPrivKey{signer: signer}
+
+	var err error
+	switch pub := signer.Public().(type) {
+	case *rsa.PublicKey:
+		k.typ = pb.KeyType_RSA
+		k.pub, err = fromStdPublicKey(pub, crypto.UnmarshalRsaPublicKey)
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %s", pub.Curve.Params().Name)
+		}
+		k.typ = pb.KeyType_ECDSA
+		k.pub, err = fromStdPublicKey(pub, crypto.UnmarshalECDSAPublicKey)
+	case ed25519.PublicKey:
+		k.typ = pb.KeyType_Ed25519
+		k.pub, err = crypto.UnmarshalEd25519PublicKey(pub)
+	default:
+		return nil, fmt.Errorf("unsupported signer public key type %T", pub)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func fromStdPublicKey(pub interface{}, unmarshal func([]byte) (crypto.PubKey, error)) (crypto.PubKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	return unmarshal(der)
+}
+
+// signerPrivKey is a crypto.PrivKey backed by a crypto.Signer.
+type signerPrivKey struct {
+	signer gocrypto.Signer
+	pub    crypto.PubKey
+	typ    pb.KeyType
+}
+
+// Sign produces the same signatures as the corresponding libp2p key types:
+// Ed25519 signs the message itself, RSA (PKCS#1 v1.5) and ECDSA (ASN.1) sign
+// its SHA-256 hash.
+func (k *signerPrivKey) Sign(data []byte) ([]byte, error) {
+	if k.typ == pb.KeyType_Ed25519 {
+		return k.signer.Sign(rand.Reader, data, gocrypto.Hash(0))
+	}
+	hash := sha256.Sum256(data)
+	return k.signer.Sign(rand.Reader, hash[:], gocrypto.SHA256)
+}
+
+func (k *signerPrivKey) GetPublic() crypto.PubKey {
+	return k.pub
+}
+
+func (k *signerPrivKey) Bytes() ([]byte, error) {
+	return nil, ErrKeyNotExportable
+}
+
+func (k *signerPrivKey) Raw() ([]byte, error) {
+	return nil, ErrKeyNotExportable
+}
+
+func (k *signerPrivKey) Type() pb.KeyType {
+	return k.typ
+}
+
+// Equals compares the public halves, as the private ones aren't available.
+func (k *signerPrivKey) Equals(o crypto.Key) bool {
+	sk, ok := o.(crypto.PrivKey)
+	if !ok {
+		return false
+	}
+	return k.pub.Equals(sk.GetPublic())
+}
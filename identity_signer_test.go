@@ -0,0 +1,99 @@
+package libp2p
+
+import (
+	"context"
+	gocrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"testing"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	"golang.org/x/crypto/ed25519"
+)
+
+// opaqueSigner hides the private key behind the crypto.Signer interface, like
+// an HSM would.
+type opaqueSigner struct {
+	s gocrypto.Signer
+}
+
+func (o opaqueSigner) Public() gocrypto.PublicKey {
+	return o.s.Public()
+}
+
+func (o opaqueSigner) Sign(rand io.Reader, digest []byte, opts gocrypto.SignerOpts) ([]byte, error) {
+	return o.s.Sign(rand, digest, opts)
+}
+
+func TestIdentityFromSigner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+
+	for name, s := range map[string]gocrypto.Signer{
+		"rsa":     rsaKey,
+		"ecdsa":   ecKey,
+		"ed25519": edKey,
+	} {
+		signer := opaqueSigner{s}
+
+		sk, err := NewSignerPrivKey(signer)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := sk.Bytes(); err != ErrKeyNotExportable {
+			t.Fatalf("%s: expected the key not to be exportable, got %v", name, err)
+		}
+
+		// signatures verify with the libp2p public key.
+		msg := []byte("hello libp2p")
+		sig, err := sk.Sign(msg)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if ok, err := sk.GetPublic().Verify(msg, sig); err != nil || !ok {
+			t.Fatalf("%s: signature doesn't verify: %v", name, err)
+		}
+
+		// and a node using the signer can complete secio handshakes.
+		h := makeLocalHost(ctx, t, IdentityFromSigner(signer))
+		expected, err := peer.IDFromPublicKey(sk.GetPublic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.ID() != expected {
+			t.Fatalf("%s: expected peer ID %s, got %s", name, expected.Pretty(), h.ID().Pretty())
+		}
+		connectHosts(ctx, t, other, h)
+		connectHosts(ctx, t, h, other)
+		h.Close()
+	}
+}
+
+func TestIdentityFromSignerUnsupported(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSignerPrivKey(opaqueSigner{ecKey}); err == nil {
+		t.Fatal("expected P-224 signer to be rejected")
+	}
+}
@@ -0,0 +1,103 @@
+package libp2p
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestLoadOrGenerateIdentity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "node.key")
+
+	sk1, err := LoadOrGenerateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected identity file to have mode 0600, got %s", fi.Mode())
+	}
+
+	sk2, err := LoadOrGenerateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id1, err := peer.IDFromPrivateKey(sk1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := peer.IDFromPrivateKey(sk2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 != id2 {
+		t.Fatalf("reloaded identity %s differs from generated one %s", id2, id1)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrGenerateIdentity(path); err == nil {
+		t.Fatal("expected a corrupt identity file to be an error")
+	}
+}
+
+func TestIdentityFromSeed(t *testing.T) {
+	idFor := func(opt Option) (peer.ID, error) {
+		var cfg Config
+		if err := opt(&cfg); err != nil {
+			return "", err
+		}
+		return peer.IDFromPrivateKey(cfg.PeerKey)
+	}
+
+	seed := "this seed is only good for tests, never for real keys"
+	id1, err := idFor(IdentityFromSeedString(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := idFor(IdentityFromSeed([]byte(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 != id2 {
+		t.Fatalf("same seed gave different peer IDs: %s and %s", id1, id2)
+	}
+
+	id3, err := idFor(IdentityFromSeedString(seed + "!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id3 == id1 {
+		t.Fatal("different seeds gave the same peer ID")
+	}
+
+	if _, err := idFor(IdentityFromSeedString("node-1")); err == nil {
+		t.Fatal("expected a short seed to be refused")
+	}
+	short1, err := idFor(IdentityFromSeedString("node-1", InsecureShortSeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	short2, err := idFor(IdentityFromSeedString("node-1", InsecureShortSeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if short1 != short2 {
+		t.Fatal("same short seed gave different peer IDs")
+	}
+}
@@ -0,0 +1,261 @@
+package libp2p
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// KeyBookGracePeriod is how long a public key is kept after it was last
+// added or used, whatever the KeyBookLimit, so that keys of peers in the
+// middle of a handshake are never evicted.
+var KeyBookGracePeriod = time.Minute
+
+// KeyBookLimit caps the memory the peerstore spends on public keys of other
+// peers, in bytes. Beyond it, the keys least recently used are forgotten,
+// except for the keys of connected peers, pinned peers (see
+// bhost.BasicHost.PinKey) and keys used within KeyBookGracePeriod. Keys
+// inlined in peer IDs are never stored. See bhost.BasicHost.KeyBookStats
+// for accounting.
+func KeyBookLimit(bytes int) Option {
+	return func(cfg *Config) error {
+		if bytes <= 0 {
+			return fmt.Errorf("key book limit must be positive, got %d", bytes)
+		}
+		cfg.KeyBookLimit = bytes
+		return nil
+	}
+}
+
+type keyEntry struct {
+	id       peer.ID
+	key      crypto.PubKey
+	size     int
+	lastUsed time.Time
+
+	// el is the entry in the LRU, nil while the key is held.
+	el *list.Element
+}
+
+// cappedKeyPeerstore stores public keys itself, in least recently used
+// order, instead of in the wrapped peerstore. The keys of pinned and
+// connected peers are held out of the LRU, so that eviction only walks
+// the keys it may forget.
+type cappedKeyPeerstore struct {
+	pstore.Peerstore
+
+	limit int
+
+	mu        sync.Mutex
+	keys      map[peer.ID]*keyEntry
+	lru       *list.List // of the keys not held, most recently used first
+	pinned    map[peer.ID]bool
+	conns     map[peer.ID]int
+	bytes     int
+	evictions uint64
+}
+
+func newCappedKeyPeerstore(ps pstore.Peerstore, limit int) *cappedKeyPeerstore {
+	return &cappedKeyPeerstore{
+		Peerstore: ps,
+		limit:     limit,
+		keys:      make(map[peer.ID]*keyEntry),
+		lru:       list.New(),
+		pinned:    make(map[peer.ID]bool),
+		conns:     make(map[peer.ID]int),
+	}
+}
+
+// inlinedKey returns the public key p embeds, if any.
+func inlinedKey(p peer.ID) crypto.PubKey {
+	dh, err := mh.Decode([]byte(p))
+	if err != nil || dh.Code != mh.ID {
+		return nil
+	}
+	pk, err := crypto.UnmarshalPublicKey(dh.Digest)
+	if err != nil {
+		return nil
+	}
+	return pk
+}
+
+func (ps *cappedKeyPeerstore) PubKey(p peer.ID) crypto.PubKey {
+	if pk := inlinedKey(p); pk != nil {
+		return pk
+	}
+
+	ps.mu.Lock()
+	if e, ok := ps.keys[p]; ok {
+		ps.touch(e)
+		ps.mu.Unlock()
+		return e.key
+	}
+	ps.mu.Unlock()
+
+	return ps.Peerstore.PubKey(p)
+}
+
+func (ps *cappedKeyPeerstore) AddPubKey(p peer.ID, pk crypto.PubKey) error {
+	// keys inlined in their peer ID needn't be stored.
+	if inlined := inlinedKey(p); inlined != nil {
+		if !inlined.Equals(pk) {
+			return fmt.Errorf("ID does not match PublicKey")
+		}
+		return nil
+	}
+	if !p.MatchesPublicKey(pk) {
+		return fmt.Errorf("ID does not match PublicKey")
+	}
+	b, err := pk.Bytes()
+	if err != nil {
+		return err
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if e, ok := ps.keys[p]; ok {
+		ps.touch(e)
+		return nil
+	}
+	e := &keyEntry{
+		id:       p,
+		key:      pk,
+		size:     len(b),
+		lastUsed: time.Now(),
+	}
+	ps.keys[p] = e
+	if !ps.held(p) {
+		e.el = ps.lru.PushFront(e)
+	}
+	ps.bytes += len(b)
+	ps.evict()
+	return nil
+}
+
+// touch records a use of the key of e.
+func (ps *cappedKeyPeerstore) touch(e *keyEntry) {
+	e.lastUsed = time.Now()
+	if e.el != nil {
+		ps.lru.MoveToFront(e.el)
+	}
+}
+
+func (ps *cappedKeyPeerstore) held(p peer.ID) bool {
+	return ps.pinned[p] || ps.conns[p] > 0
+}
+
+// update moves the key of p out of the LRU, or back into it, after p was
+// pinned or connected to, or no longer is.
+func (ps *cappedKeyPeerstore) update(p peer.ID) {
+	e, ok := ps.keys[p]
+	if !ok {
+		return
+	}
+	switch held := ps.held(p); {
+	case held && e.el != nil:
+		ps.lru.Remove(e.el)
+		e.el = nil
+	case !held && e.el == nil:
+		// back in order of last use, most likely near the front.
+		for el := ps.lru.Front(); el != nil; el = el.Next() {
+			if el.Value.(*keyEntry).lastUsed.Before(e.lastUsed) {
+				e.el = ps.lru.InsertBefore(e, el)
+				break
+			}
+		}
+		if e.el == nil {
+			e.el = ps.lru.PushBack(e)
+		}
+		ps.evict()
+	}
+}
+
+// evict forgets the least recently used keys, until the keys fit under the
+// limit. The LRU is in order of last use, so eviction stops at the first
+// key used within KeyBookGracePeriod.
+func (ps *cappedKeyPeerstore) evict() {
+	now := time.Now()
+	for ps.bytes > ps.limit {
+		el := ps.lru.Back()
+		if el == nil {
+			return
+		}
+		e := el.Value.(*keyEntry)
+		if now.Sub(e.lastUsed) <= KeyBookGracePeriod {
+			return
+		}
+		ps.lru.Remove(el)
+		delete(ps.keys, e.id)
+		ps.bytes -= e.size
+		ps.evictions++
+	}
+}
+
+// Pin exempts the key of p from eviction, or undoes it.
+func (ps *cappedKeyPeerstore) Pin(p peer.ID, pinned bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if pinned {
+		ps.pinned[p] = true
+	} else {
+		delete(ps.pinned, p)
+	}
+	ps.update(p)
+}
+
+// countConn counts a connection to p opened, with delta 1, or closed, with
+// delta -1. The key of a peer is in use until its last connection closes.
+func (ps *cappedKeyPeerstore) countConn(p peer.ID, delta int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	// notifications are asynchronous, a disconnection may be counted
+	// before its connection.
+	n := ps.conns[p] + delta
+	if n == 0 {
+		delete(ps.conns, p)
+		if e, ok := ps.keys[p]; ok {
+			e.lastUsed = time.Now()
+		}
+	} else {
+		ps.conns[p] = n
+	}
+	ps.update(p)
+}
+
+func (ps *cappedKeyPeerstore) Stats() bhost.KeyBookStats {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return bhost.KeyBookStats{
+		Keys:      len(ps.keys),
+		Bytes:     ps.bytes,
+		Limit:     ps.limit,
+		Evictions: ps.evictions,
+	}
+}
+
+// keyBookNotifiee counts the connections of a cappedKeyPeerstore.
+type keyBookNotifiee cappedKeyPeerstore
+
+func (kn *keyBookNotifiee) Connected(_ inet.Network, c inet.Conn) {
+	(*cappedKeyPeerstore)(kn).countConn(c.RemotePeer(), 1)
+}
+
+func (kn *keyBookNotifiee) Disconnected(_ inet.Network, c inet.Conn) {
+	(*cappedKeyPeerstore)(kn).countConn(c.RemotePeer(), -1)
+}
+
+func (kn *keyBookNotifiee) Listen(inet.Network, ma.Multiaddr)      {}
+func (kn *keyBookNotifiee) ListenClose(inet.Network, ma.Multiaddr) {}
+func (kn *keyBookNotifiee) OpenedStream(inet.Network, inet.Stream) {}
+func (kn *keyBookNotifiee) ClosedStream(inet.Network, inet.Stream) {}
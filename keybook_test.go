@@ -0,0 +1,168 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	mh "github.com/multiformats/go-multihash"
+)
+
+type testKey struct {
+	id   peer.ID
+	pk   crypto.PubKey
+	size int
+}
+
+func genTestKeys(t *testing.T, n int) []testKey {
+	keys := make([]testKey, n)
+	for i := range keys {
+		_, pk, err := crypto.GenerateKeyPair(crypto.RSA, 512)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := peer.IDFromPublicKey(pk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := pk.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = testKey{id: id, pk: pk, size: len(b)}
+	}
+	return keys
+}
+
+func TestKeyBookEviction(t *testing.T) {
+	defer func(d time.Duration) { KeyBookGracePeriod = d }(KeyBookGracePeriod)
+	KeyBookGracePeriod = -1
+
+	keys := genTestKeys(t, 8)
+	kb := newCappedKeyPeerstore(pstore.NewPeerstore(), 4*keys[0].size+keys[0].size/2)
+
+	// keys[0] is pinned, and we're connected to keys[2].
+	kb.Pin(keys[0].id, true)
+	kb.countConn(keys[2].id, 1)
+
+	known := func(i int) bool {
+		_, ok := kb.keys[keys[i].id]
+		return ok
+	}
+
+	for i, k := range keys[:6] {
+		if err := kb.AddPubKey(k.id, k.pk); err != nil {
+			t.Fatal(err)
+		}
+		switch i {
+		case 3:
+			// keys[1] is used, and so more recent than keys[3].
+			if kb.PubKey(keys[1].id) == nil {
+				t.Fatal("expected keys[1] to be known")
+			}
+		case 4:
+			if known(3) || !known(1) {
+				t.Fatal("expected the least recently used key to be evicted first")
+			}
+		}
+	}
+
+	kept := map[int]bool{0: true, 2: true, 4: true, 5: true}
+	bytes := 0
+	for i, k := range keys {
+		if known(i) != kept[i] {
+			t.Errorf("keys[%d] kept: %t, expected %t", i, known(i), kept[i])
+		}
+		if known(i) {
+			bytes += k.size
+		}
+	}
+
+	st := kb.Stats()
+	if st.Keys != len(kept) || st.Bytes != bytes || st.Evictions != 2 {
+		t.Fatalf("unexpected stats: %+v (expected %d bytes)", st, bytes)
+	}
+
+	// once unpinned, keys[0] is the oldest.
+	kb.Pin(keys[0].id, false)
+	if err := kb.AddPubKey(keys[6].id, keys[6].pk); err != nil {
+		t.Fatal(err)
+	}
+	if known(0) || !known(6) {
+		t.Fatal("expected the unpinned key to be evicted")
+	}
+
+	// once disconnected, keys[2] was just used.
+	kb.countConn(keys[2].id, -1)
+	if err := kb.AddPubKey(keys[7].id, keys[7].pk); err != nil {
+		t.Fatal(err)
+	}
+	if !known(2) || known(4) {
+		t.Fatal("expected the key of the disconnected peer to be kept over older ones")
+	}
+}
+
+func TestKeyBookGracePeriod(t *testing.T) {
+	keys := genTestKeys(t, 3)
+	kb := newCappedKeyPeerstore(pstore.NewPeerstore(), keys[0].size)
+	for _, k := range keys {
+		if err := kb.AddPubKey(k.id, k.pk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// keys just added may belong to peers being handshaked with.
+	if st := kb.Stats(); st.Keys != 3 || st.Evictions != 0 {
+		t.Fatalf("expected recent keys to be kept: %+v", st)
+	}
+}
+
+func TestKeyBookInlinedKeys(t *testing.T) {
+	_, pk, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := pk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := mh.Sum(b, mh.ID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := peer.ID(hash)
+
+	kb := newCappedKeyPeerstore(pstore.NewPeerstore(), 1024)
+	if err := kb.AddPubKey(id, pk); err != nil {
+		t.Fatal(err)
+	}
+	if st := kb.Stats(); st.Keys != 0 || st.Bytes != 0 {
+		t.Fatalf("expected the inlined key not to be stored: %+v", st)
+	}
+	if got := kb.PubKey(id); got == nil || !got.Equals(pk) {
+		t.Fatal("expected the key to be derived from the peer ID")
+	}
+}
+
+func TestKeyBookLimitOption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := makeLocalHost(ctx, t, KeyBookLimit(1<<20))
+	defer h.Close()
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+	connectHosts(ctx, t, h, other)
+
+	st := h.(*bhost.BasicHost).KeyBookStats()
+	if st.Limit != 1<<20 || st.Keys == 0 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	if h.Peerstore().PubKey(other.ID()) == nil {
+		t.Fatal("expected the key of the connected peer")
+	}
+}
@@ -0,0 +1,60 @@
+package libp2p
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// LatencySmoothing sets the weight, between 0 and 1, the latency
+// measurements of identify exchanges and pings carry in the moving average
+// the peerstore keeps for each peer (see bhost.BasicHost.PeerLatency).
+// Higher values follow changes faster but are noisier. The default is
+// pstore.LatencyEWMASmoothing, shared by all the peerstores of the process.
+func LatencySmoothing(alpha float64) Option {
+	return func(cfg *Config) error {
+		if alpha <= 0 || alpha > 1 {
+			return fmt.Errorf("latency smoothing must be in (0, 1], got %g", alpha)
+		}
+		cfg.LatencySmoothing = alpha
+		return nil
+	}
+}
+
+// latencyPeerstore keeps the latency averages of its own, with its own
+// smoothing.
+type latencyPeerstore struct {
+	pstore.Peerstore
+	alpha float64
+
+	mu   sync.RWMutex
+	ewma map[peer.ID]time.Duration
+}
+
+func newLatencyPeerstore(ps pstore.Peerstore, alpha float64) *latencyPeerstore {
+	return &latencyPeerstore{
+		Peerstore: ps,
+		alpha:     alpha,
+		ewma:      make(map[peer.ID]time.Duration),
+	}
+}
+
+func (ps *latencyPeerstore) RecordLatency(p peer.ID, next time.Duration) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	prev, ok := ps.ewma[p]
+	if !ok {
+		ps.ewma[p] = next
+		return
+	}
+	ps.ewma[p] = time.Duration(ps.alpha*float64(next) + (1-ps.alpha)*float64(prev))
+}
+
+func (ps *latencyPeerstore) LatencyEWMA(p peer.ID) time.Duration {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return ps.ewma[p]
+}
@@ -0,0 +1,60 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	host "github.com/libp2p/go-libp2p-host"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	memory "github.com/libp2p/go-libp2p/p2p/net/memory"
+)
+
+func TestPeerLatency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := New(ctx, LatencySmoothing(0)); err == nil {
+		t.Fatal("expected a zero smoothing to be rejected")
+	}
+
+	const latency = 20 * time.Millisecond
+	newHost := func() host.Host {
+		h, err := New(ctx,
+			ListenAddrStrings("/memory/0"),
+			Transports(&memory.Transport{Latency: latency}),
+			RandomIdentity(crypto.Ed25519, 0),
+			LatencySmoothing(0.5),
+			Ping(true),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	h1 := newHost()
+	defer h1.Close()
+	h2 := newHost()
+	defer h2.Close()
+
+	if err := h1.Connect(ctx, pstore.PeerInfo{ID: h2.ID(), Addrs: h2.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	bh := h1.(*bhost.BasicHost)
+	pings := bh.PingService().Ping(ctx, h2.ID())
+	for i := 0; i < 10; i++ {
+		if res := <-pings; res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	// a round trip takes the latency both ways.
+	if rtt := bh.PeerLatency(h2.ID()); rtt < 2*latency || rtt > 10*latency {
+		t.Fatalf("expected a latency near %s, got %s", 2*latency, rtt)
+	}
+	if peers := bh.PeersByLatency(); len(peers) != 1 || peers[0] != h2.ID() {
+		t.Fatalf("expected the connected peer, got %v", peers)
+	}
+}
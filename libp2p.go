@@ -4,18 +4,32 @@ import (
 	"context"
 	"crypto/rand"
 	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
 
+	circuit "github.com/libp2p/go-libp2p-circuit"
 	crypto "github.com/libp2p/go-libp2p-crypto"
 	host "github.com/libp2p/go-libp2p-host"
+	ifconnmgr "github.com/libp2p/go-libp2p-interface-connmgr"
 	pnet "github.com/libp2p/go-libp2p-interface-pnet"
 	metrics "github.com/libp2p/go-libp2p-metrics"
+	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	swarm "github.com/libp2p/go-libp2p-swarm"
 	transport "github.com/libp2p/go-libp2p-transport"
+	discovery "github.com/libp2p/go-libp2p/p2p/discovery"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+	routed "github.com/libp2p/go-libp2p/p2p/host/routed"
+	connmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	autonat "github.com/libp2p/go-libp2p/p2p/protocol/autonat"
+	filter "github.com/libp2p/go-maddr-filter"
 	mux "github.com/libp2p/go-stream-muxer"
 	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
 	mplex "github.com/whyrusleeping/go-smux-multiplex"
 	msmux "github.com/whyrusleeping/go-smux-multistream"
 	yamux "github.com/whyrusleeping/go-smux-yamux"
@@ -31,10 +45,133 @@ type Config struct {
 	Protector    pnet.Protector
 	Reporter     metrics.Reporter
 	DisableSecio bool
+
+	KeyType               int
+	KeyBits               int
+	ExpectedPeerID        peer.ID
+	DisablePrivKeyStorage bool
+	IdentityPool          *IdentityPool
+
+	ConstructionTimeout time.Duration
+	StrictListenAddrs   bool
+
+	// DefaultListenAddrs are the listen addresses added by Defaults rather
+	// than by the user. Those the host can't support are skipped.
+	DefaultListenAddrs []ma.Multiaddr
+
+	EventLogPath        string
+	EventLogMaxBytes    int64
+	FailureDedupWindow  time.Duration
+	DisableFailureDedup bool
+
+	ConnSelectionPolicy  bhost.ConnSelectionPolicy
+	StreamObserver       bhost.StreamObserver
+	OrderedNotifications bool
+	AddrsUpdateInterval  time.Duration
+	AddrsFactory         bhost.AddrsFactory
+
+	AnnounceAddrs               []ma.Multiaddr
+	AppendAnnounceAddrs         []ma.Multiaddr
+	NoAnnounce                  []string
+	RevalidateAnnounceInterval  time.Duration
+	RevalidateAnnounceThreshold int
+	OnlyPublicAddrs             bool
+
+	KeyBookLimit int
+
+	LatencySmoothing float64
+	Ping             bool
+	UserAgent        string
+
+	ProtocolVersion             string
+	RequireProtocolVersionMatch bool
+	DisableIdentify             bool
+	NegotiationTimeout          *time.Duration
+
+	Routing                RoutingC
+	DisableRoutingFallback bool
+
+	MDNS            bool
+	MDNSTag         string
+	MDNSInterval    time.Duration
+	MDNSAutoConnect bool
+	MDNSNotifees    []discovery.Notifee
+
+	ConnBudget      int
+	DialTimeout     time.Duration
+	DialBackoff     *bhost.DialBackoff
+	MaxInboundConns int
+
+	MaxConnsPerPeer  int
+	PruneGracePeriod time.Duration
+
+	BootstrapPeers   []pstore.PeerInfo
+	KeepBootstrapped bool
+	StaticPeers      []pstore.PeerInfo
+
+	BootstrapMinPeers int
+	BootstrapPeriod   time.Duration
+
+	Discovery DiscoveryC
+
+	AddrTTLs bhost.AddrTTLs
+
+	PeerstoreSnapshot         string
+	PeerstoreSnapshotInterval time.Duration
+
+	ConnectionGater bhost.ConnectionGater
+
+	ConnManager      ifconnmgr.ConnManager
+	ConnManagerLow   int
+	ConnManagerHigh  int
+	ConnManagerGrace time.Duration
+
+	Filters       *filter.Filters
+	FilterSubnets []*net.IPNet
+
+	NATPortMap           bool
+	NATManager           func(inet.Network) bhost.NATManager
+	DisableObservedAddrs bool
+	AdvertiseUnixAddrs   bool
+
+	MultiaddrResolver *madns.Resolver
+
+	Relay     bool
+	RelayOpts []circuit.RelayOpt
+	// DisableRelay records that relay was explicitly disabled, so that
+	// defaults don't enable it.
+	DisableRelay bool
+	AutoRelays   []pstore.PeerInfo
+	RelayLimits  *bhost.RelayLimits
+	Reachability bhost.Reachability
+
+	AutoNAT        bool
+	AutoNATService *autonat.RateLimit
+
+	AddrsChangeHandlers        []bhost.AddrsChangeHandler
+	ReachabilityChangeHandlers []bhost.ReachabilityChangeHandler
+
+	MuxerPreference []string
+	Yamux           *yamux.Transport
+	MaxStreamBuffer uint32
+
+	ResourceLimits *bhost.ResourceLimits
+
+	ForcePrivateNetwork bool
 }
 
 type Option func(cfg *Config) error
 
+// Transports adds transports to the node. The swarm always has its built-in
+// transports, TCP and websockets over TCP, which it can't run without, and
+// prefers them for the addresses they handle, so the given transports
+// extend that set rather than replace it: they serve the addresses, dialed
+// or listened on, that the built-in transports don't, such as /unix ones.
+// New fails if a transport would be shadowed, by a built-in transport or
+// by another one given, for some of the addresses it handles. As the
+// built-in transports can neither be left out nor given again, there is no
+// option naming them: New(ctx, Transports(myTransport)) already extends
+// the default set.
 func Transports(tpts ...transport.Transport) Option {
 	return func(cfg *Config) error {
 		cfg.Transports = append(cfg.Transports, tpts...)
@@ -42,15 +179,24 @@ func Transports(tpts ...transport.Transport) Option {
 	}
 }
 
+// ListenAddrStrings configures the node to listen on the given addresses.
+// All the strings are checked, and the error lists every invalid one.
 func ListenAddrStrings(s ...string) Option {
 	return func(cfg *Config) error {
-		for _, addrstr := range s {
+		var errs []string
+		addrs := make([]ma.Multiaddr, 0, len(s))
+		for i, addrstr := range s {
 			a, err := ma.NewMultiaddr(addrstr)
 			if err != nil {
-				return err
+				errs = append(errs, fmt.Sprintf("#%d %q: %s", i, addrstr, err))
+				continue
 			}
-			cfg.ListenAddrs = append(cfg.ListenAddrs, a)
+			addrs = append(addrs, a)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("invalid listen addresses: %s", strings.Join(errs, "; "))
 		}
+		cfg.ListenAddrs = append(cfg.ListenAddrs, addrs...)
 		return nil
 	}
 }
@@ -147,11 +293,370 @@ func Identity(sk crypto.PrivKey) Option {
 	}
 }
 
+// EventLog records connection events (dials, the handshake stages of new
+// connections, connects, disconnects with the reason the node closed them,
+// and listen address changes) to a bounded on-disk log at path, taking up
+// at most maxBytes. The log can be read back with ReadEventLog. Writes are
+// asynchronous; events are dropped rather than slowing down the network
+// when the disk can't keep up.
+func EventLog(path string, maxBytes int64) Option {
+	return func(cfg *Config) error {
+		if cfg.EventLogPath != "" {
+			return fmt.Errorf("cannot specify multiple event logs")
+		}
+		if maxBytes <= 0 {
+			return fmt.Errorf("event log size must be positive, got %d", maxBytes)
+		}
+
+		cfg.EventLogPath = path
+		cfg.EventLogMaxBytes = maxBytes
+		return nil
+	}
+}
+
+// FailureDedup sets the window over which repeated identical failures are
+// coalesced in the event log (see events.Dedup). Defaults to
+// events.DefaultDedupWindow.
+func FailureDedup(window time.Duration) Option {
+	return func(cfg *Config) error {
+		if window <= 0 {
+			return fmt.Errorf("failure dedup window must be positive, got %s", window)
+		}
+		if cfg.DisableFailureDedup {
+			return fmt.Errorf("cannot both configure and disable failure dedup")
+		}
+
+		cfg.FailureDedupWindow = window
+		return nil
+	}
+}
+
+// DisableFailureDedup records every failure in the event log, even when the
+// same failure keeps repeating.
+func DisableFailureDedup() Option {
+	return func(cfg *Config) error {
+		if cfg.FailureDedupWindow != 0 {
+			return fmt.Errorf("cannot both configure and disable failure dedup")
+		}
+
+		cfg.DisableFailureDedup = true
+		return nil
+	}
+}
+
+// ReadEventLog reads back the events recorded by the EventLog option,
+// oldest first.
+func ReadEventLog(path string) ([]events.Event, error) {
+	return events.ReadLog(path)
+}
+
+// ConnSelectionPolicy configures how the host picks a connection for new
+// streams when it has several connections to the same peer. See
+// bhost.DefaultConnSelectionPolicy for the default behavior.
+func ConnSelectionPolicy(cmp func(a, b bhost.ConnStat) int) Option {
+	return func(cfg *Config) error {
+		if cfg.ConnSelectionPolicy != nil {
+			return fmt.Errorf("cannot specify multiple connection selection policies")
+		}
+
+		cfg.ConnSelectionPolicy = cmp
+		return nil
+	}
+}
+
+// StreamObserver configures an observer told about every stream the node
+// opens or accepts, e.g. to count streams and bytes by protocol. See
+// bhost.StreamObserver.
+func StreamObserver(obs bhost.StreamObserver) Option {
+	return func(cfg *Config) error {
+		if cfg.StreamObserver != nil {
+			return fmt.Errorf("cannot specify multiple stream observers")
+		}
+		cfg.StreamObserver = obs
+		return nil
+	}
+}
+
+// OrderedNotifications makes the node deliver the Connected and Disconnected
+// notifications of each peer one at a time and in the order they happened,
+// both to network notifiees and to the event log. Notifications for
+// different peers are still delivered concurrently.
+func OrderedNotifications() Option {
+	return func(cfg *Config) error {
+		cfg.OrderedNotifications = true
+		return nil
+	}
+}
+
+// AddrsFactory configures a function transforming the addresses the node
+// advertises, in Addrs and through identify. It is given all the node's
+// addresses, including observed ones; returning an empty slice advertises
+// nothing.
+func AddrsFactory(factory bhost.AddrsFactory) Option {
+	return func(cfg *Config) error {
+		if cfg.AddrsFactory != nil {
+			return fmt.Errorf("cannot specify multiple address factories")
+		}
+
+		cfg.AddrsFactory = factory
+		return nil
+	}
+}
+
+// Filters configures the address filters of the node: it neither dials nor
+// accepts connections from addresses blocked by f. Inbound connections are
+// dropped before the security handshake. f may be changed at runtime,
+// unless combined with FilterSubnets: the node then uses its own filters,
+// holding those of f when the node is constructed, and f is left as is.
+func Filters(f *filter.Filters) Option {
+	return func(cfg *Config) error {
+		if cfg.Filters != nil {
+			return fmt.Errorf("cannot specify multiple address filters")
+		}
+
+		cfg.Filters = f
+		return nil
+	}
+}
+
+// FilterSubnets blocks connections to and from the given subnets, in CIDR
+// notation (e.g. "10.0.0.0/8"), in addition to any address filters given
+// with Filters.
+func FilterSubnets(cidrs ...string) Option {
+	return func(cfg *Config) error {
+		for _, cidr := range cidrs {
+			_, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("invalid subnet %q: %s", cidr, err)
+			}
+			cfg.FilterSubnets = append(cfg.FilterSubnets, ipnet)
+		}
+		return nil
+	}
+}
+
+// addrFilters returns the address filters the swarm should use, or nil for
+// none. Filters given with the Filters option are used as is, unless there
+// are subnets to add to them, which go to a new set of filters.
+func addrFilters(cfg *Config) *filter.Filters {
+	if len(cfg.FilterSubnets) == 0 {
+		return cfg.Filters
+	}
+	f := filter.NewFilters()
+	if cfg.Filters != nil {
+		for _, ipnet := range cfg.Filters.Filters() {
+			f.AddDialFilter(ipnet)
+		}
+	}
+	for _, ipnet := range cfg.FilterSubnets {
+		f.AddDialFilter(ipnet)
+	}
+	return f
+}
+
+// NATPortMap makes the node try to open ports for its listen addresses on
+// the local NAT device, using UPnP or NAT-PMP. Established mappings are
+// refreshed periodically, advertised as external addresses (subject to any
+// address factory or announce filters), and released when the node is
+// closed. Failures don't prevent the node from starting; see
+// bhost.BasicHost.NATMappingErrors.
+func NATPortMap() Option {
+	return func(cfg *Config) error {
+		cfg.NATPortMap = true
+		return nil
+	}
+}
+
+// NATManager makes the node manage its NAT device with the NAT manager
+// built by constructor, rather than the default one of NATPortMap. The
+// constructor is given the network once it listens, and the manager is
+// closed along with the node. See bhost.BasicHost.NATMappings to monitor
+// the mappings.
+func NATManager(constructor func(inet.Network) bhost.NATManager) Option {
+	return func(cfg *Config) error {
+		if cfg.NATManager != nil {
+			return fmt.Errorf("cannot specify multiple NAT managers")
+		}
+		cfg.NATManager = constructor
+		return nil
+	}
+}
+
+// DisableObservedAddrs stops the node from advertising the addresses its
+// peers report observing it at. Use it for nodes with static public
+// addresses, where observed addresses only add noise.
+func DisableObservedAddrs() Option {
+	return func(cfg *Config) error {
+		cfg.DisableObservedAddrs = true
+		return nil
+	}
+}
+
+// ConnEstablishmentBudget caps the number of outgoing connection attempts,
+// dials and handshakes, the node runs at once. Attempts beyond the budget
+// wait, in the order given by their bhost.DialPriority (see
+// bhost.WithDialPriority), and part of the budget is reserved for
+// connections explicitly requested by the application.
+func ConnEstablishmentBudget(n int) Option {
+	return func(cfg *Config) error {
+		if n <= 0 {
+			return fmt.Errorf("connection establishment budget must be positive, got %d", n)
+		}
+		cfg.ConnBudget = n
+		return nil
+	}
+}
+
+// DialBackoff sets how long the node refuses to dial a peer again after
+// dials to it failed, replacing the swarm's fixed backoff: base after the
+// first failure, doubling with each further one up to max. Backed off
+// dials, by Connect or NewStream, fail right away with
+// bhost.ErrDialBackoff, even if their context would allow waiting the
+// backoff out. See bhost.BasicHost.ClearBackoff to retry a peer at once.
+func DialBackoff(base, max time.Duration) Option {
+	return func(cfg *Config) error {
+		if cfg.DialBackoff != nil {
+			return fmt.Errorf("cannot set the dial backoff more than once")
+		}
+		if base <= 0 || max < base {
+			return fmt.Errorf("invalid dial backoff: base %s, max %s", base, max)
+		}
+		cfg.DialBackoff = &bhost.DialBackoff{Base: base, Max: max}
+		return nil
+	}
+}
+
+// DisableDialBackoff lets the node dial peers again right after failed
+// dials. It is meant for tests.
+func DisableDialBackoff() Option {
+	return func(cfg *Config) error {
+		if cfg.DialBackoff != nil {
+			return fmt.Errorf("cannot set the dial backoff more than once")
+		}
+		cfg.DialBackoff = &bhost.DialBackoff{}
+		return nil
+	}
+}
+
+// MaxInboundConns caps the number of inbound connections the node keeps.
+// Beyond the cap, new inbound connections are closed as soon as the swarm
+// has set them up, before any stream is handled on them; outbound
+// connections don't count. The count, the cap and the number of rejected
+// connections are available from bhost.BasicHost.InboundStats, and each
+// rejection is emitted as an events.ConnectionGated event.
+func MaxInboundConns(n int) Option {
+	return func(cfg *Config) error {
+		if n <= 0 {
+			return fmt.Errorf("inbound connection limit must be positive, got %d", n)
+		}
+		cfg.MaxInboundConns = n
+		return nil
+	}
+}
+
+// MaxConnsPerPeer caps the number of connections the node keeps to each
+// peer, which is unlimited by default. Once the security handshake has
+// identified the peer of a new connection, the connections to it beyond
+// the cap, normally the newest ones, stop being used for new streams, and
+// are closed once their streams are done, or after the grace period set by
+// PruneGracePeriod. The connections of a simultaneous dial are ordered by
+// their addresses, so that both peers keep the same one.
+func MaxConnsPerPeer(n int) Option {
+	return func(cfg *Config) error {
+		if n <= 0 {
+			return fmt.Errorf("connection limit per peer must be positive, got %d", n)
+		}
+		cfg.MaxConnsPerPeer = n
+		return nil
+	}
+}
+
+// PruneGracePeriod sets how long connections exceeding MaxConnsPerPeer are
+// kept for their streams to finish, bhost.DefaultPruneGracePeriod by
+// default.
+func PruneGracePeriod(d time.Duration) Option {
+	return func(cfg *Config) error {
+		if d <= 0 {
+			return fmt.Errorf("prune grace period must be positive, got %s", d)
+		}
+		cfg.PruneGracePeriod = d
+		return nil
+	}
+}
+
+// DialTimeout bounds how long Connect and NewStream spend dialing a peer
+// the node isn't connected to, across all the peer's addresses. A shorter
+// context deadline still wins, and so does the swarm's own dial timeout,
+// swarm.DialTimeout, which is a minute by default.
+func DialTimeout(d time.Duration) Option {
+	return func(cfg *Config) error {
+		if d <= 0 {
+			return fmt.Errorf("dial timeout must be positive, got %s", d)
+		}
+		cfg.DialTimeout = d
+		return nil
+	}
+}
+
+// NegotiationTimeout bounds how long peers have to negotiate the protocol
+// of the streams they open to the node, and identify exchanges, after
+// which the streams are reset. Zero disables the timeout. The default is
+// bhost.DefaultNegotiationTimeout. See bhost.BasicHost.SetNegotiationTimeout
+// to change it while the node runs.
+func NegotiationTimeout(d time.Duration) Option {
+	return func(cfg *Config) error {
+		if cfg.NegotiationTimeout != nil {
+			return fmt.Errorf("cannot set the negotiation timeout more than once")
+		}
+		if d < 0 {
+			return fmt.Errorf("negotiation timeout must not be negative, got %s", d)
+		}
+		cfg.NegotiationTimeout = &d
+		return nil
+	}
+}
+
+// AddressTTLs sets how long the addresses the node adds to its peerstore
+// are kept: those peers report through identify while connected to the
+// node, once disconnected, those peers observe the node at, and those of
+// its bootstrap peers. A zero TTL keeps the default (see bhost.AddrTTLs);
+// others must be at least a second.
+func AddressTTLs(connected, recentlyConnected, observed, bootstrap time.Duration) Option {
+	return func(cfg *Config) error {
+		ttls := bhost.AddrTTLs{
+			Connected:         connected,
+			RecentlyConnected: recentlyConnected,
+			Observed:          observed,
+			Bootstrap:         bootstrap,
+		}
+		for _, ttl := range []time.Duration{connected, recentlyConnected, observed, bootstrap} {
+			if ttl != 0 && ttl < time.Second {
+				return fmt.Errorf("address TTL must be at least a second, got %s", ttl)
+			}
+		}
+		cfg.AddrTTLs = ttls
+		return nil
+	}
+}
+
+// AddrsUpdateInterval sets the minimum time between two recomputations of
+// the addresses the node advertises, so that flapping NAT mappings or
+// observed addresses don't cause a flurry of updates.
+func AddrsUpdateInterval(d time.Duration) Option {
+	return func(cfg *Config) error {
+		if d <= 0 {
+			return fmt.Errorf("address update interval must be positive, got %s", d)
+		}
+		cfg.AddrsUpdateInterval = d
+		return nil
+	}
+}
+
 func New(ctx context.Context, opts ...Option) (host.Host, error) {
 	var cfg Config
 	for _, opt := range opts {
 		if err := opt(&cfg); err != nil {
-			return nil, err
+			return nil, &ConfigError{Err: err}
 		}
 	}
 
@@ -159,13 +664,44 @@ func New(ctx context.Context, opts ...Option) (host.Host, error) {
 }
 
 func newWithCfg(ctx context.Context, cfg *Config) (host.Host, error) {
-	// If no key was given, generate a random 2048 bit RSA key
+	// cctx bounds the construction, while ctx is the lifetime of the node.
+	cctx := ctx
+	if cfg.ConstructionTimeout > 0 {
+		var cancel context.CancelFunc
+		cctx, cancel = context.WithTimeout(ctx, cfg.ConstructionTimeout)
+		defer cancel()
+	}
+
+	// If no key was given, but we know who we should be, the given peerstore
+	// may hold our key
+	if cfg.PeerKey == nil && cfg.ExpectedPeerID != "" && cfg.Peerstore != nil {
+		cfg.PeerKey = cfg.Peerstore.PrivKey(cfg.ExpectedPeerID)
+	}
+
+	// If no key was given, generate a random one, by default a 2048 bit RSA
+	// key, taken from the identity pool if there's one ready.
 	if cfg.PeerKey == nil {
-		priv, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
-		if err != nil {
-			return nil, err
+		typ, bits := cfg.KeyType, cfg.KeyBits
+		if typ == crypto.RSA && bits == 0 {
+			bits = poolKeyBits
+		}
+		if cfg.IdentityPool != nil && typ == crypto.RSA && bits == poolKeyBits {
+			cfg.PeerKey, _ = cfg.IdentityPool.Get()
+		}
+		if cfg.PeerKey == nil {
+			var priv crypto.PrivKey
+			err := runStage(cctx, "identity", func() error {
+				var err error
+				priv, _, err = crypto.GenerateKeyPairWithReader(typ, bits, rand.Reader)
+				return err
+			}, nil)
+			if err != nil {
+				return nil, err
+			}
+			cfg.PeerKey = priv
 		}
-		cfg.PeerKey = priv
+	} else if cfg.KeyType != 0 || cfg.KeyBits != 0 {
+		return nil, configErrorf("cannot specify both an identity and a random identity key type")
 	}
 
 	// Obtain Peer ID from public key
@@ -174,54 +710,458 @@ func newWithCfg(ctx context.Context, cfg *Config) (host.Host, error) {
 		return nil, err
 	}
 
+	if cfg.ExpectedPeerID != "" && pid != cfg.ExpectedPeerID {
+		return nil, configErrorf("configured identity %s does not match expected peer ID %s", pid.Pretty(), cfg.ExpectedPeerID.Pretty())
+	}
+
 	// Create a new blank peerstore if none was passed in
 	ps := cfg.Peerstore
 	if ps == nil {
 		ps = pstore.NewPeerstore()
 	}
 
+	ymux := cfg.Yamux
+	if cfg.MaxStreamBuffer != 0 {
+		if cfg.Muxer != nil {
+			return nil, configErrorf("cannot combine a muxer with a stream buffer limit")
+		}
+		tpt := *yamux.DefaultTransport
+		if ymux != nil {
+			if ymux.MaxStreamWindowSize != tpt.MaxStreamWindowSize {
+				return nil, configErrorf("cannot combine a yamux stream window with a stream buffer limit")
+			}
+			tpt = *ymux
+		}
+		tpt.MaxStreamWindowSize = cfg.MaxStreamBuffer
+		ymux = &tpt
+	}
+
 	// Set default muxer if none was passed in
 	muxer := cfg.Muxer
 	if muxer == nil {
 		muxer = DefaultMuxer()
+		if ymux != nil {
+			muxer = defaultMuxer(ymux)
+		}
+	} else if cfg.Yamux != nil {
+		return nil, configErrorf("cannot combine a muxer with the yamux configuration")
+	}
+	if cfg.MuxerPreference != nil {
+		mst, ok := muxer.(*msmux.Transport)
+		if !ok {
+			return nil, configErrorf("cannot set a muxer preference on a %T muxer", muxer)
+		}
+		if err := preferMuxers(mst, cfg.MuxerPreference); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+	}
+
+	addrsFactory, err := announceAddrsFactory(cfg)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	if addrsFactory == nil {
+		addrsFactory = cfg.AddrsFactory
+	} else if cfg.AddrsFactory != nil {
+		return nil, configErrorf("cannot combine an address factory with the announce address options")
+	}
+	if cfg.OnlyPublicAddrs {
+		addrsFactory = onlyPublicAddrsFactory(addrsFactory)
 	}
 
 	// If secio is disabled, don't add our private key to the peerstore
 	if !cfg.DisableSecio {
-		ps.AddPrivKey(pid, cfg.PeerKey)
+		if cfg.DisablePrivKeyStorage {
+			ps = &localKeyPeerstore{Peerstore: ps, local: pid}
+		} else if err := ps.AddPrivKey(pid, cfg.PeerKey); err != nil {
+			return nil, fmt.Errorf("failed to add private key to peerstore: %s", err)
+		}
 		ps.AddPubKey(pid, cfg.PeerKey.GetPublic())
 	}
 
-	swrm, err := swarm.NewSwarmWithProtector(ctx, cfg.ListenAddrs, pid, ps, cfg.Protector, muxer, cfg.Reporter)
+	if cfg.LatencySmoothing > 0 {
+		ps = newLatencyPeerstore(ps, cfg.LatencySmoothing)
+	}
+
+	if cfg.ConnectionGater != nil {
+		ps = &gatedPeerstore{Peerstore: ps, gater: cfg.ConnectionGater}
+	}
+
+	var keybook *cappedKeyPeerstore
+	if cfg.KeyBookLimit > 0 {
+		keybook = newCappedKeyPeerstore(ps, cfg.KeyBookLimit)
+		keybook.Pin(pid, true)
+		ps = keybook
+	}
+
+	listenAddrs, warnings := skipUnsupportedDefaults(cfg.ListenAddrs, cfg.DefaultListenAddrs)
+	// circuit listen addresses are served by the relay transport, which
+	// the host registers; the swarm doesn't listen on them.
+	listenAddrs, circuits, err := splitCircuitListenAddrs(listenAddrs)
 	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	if len(circuits) > 0 {
+		if !cfg.Relay {
+			return nil, configErrorf("cannot listen on circuit addresses without EnableRelay")
+		}
+		addrsFactory = circuitAddrsFactory(addrsFactory, circuits, ps)
+	}
+	if cfg.RelayLimits != nil && !hasRelayOpt(cfg.RelayOpts, circuit.OptHop) {
+		return nil, configErrorf("cannot set relay limits without relay hop")
+	}
+	if len(cfg.AutoRelays) > 0 && !cfg.Relay {
+		return nil, configErrorf("cannot enable autorelay without EnableRelay")
+	}
+	if cfg.NATPortMap && cfg.NATManager != nil {
+		return nil, configErrorf("cannot combine NATPortMap with a NAT manager")
+	}
+	if cfg.KeepBootstrapped && len(cfg.BootstrapPeers) == 0 {
+		return nil, configErrorf("cannot keep bootstrapped without bootstrap peers")
+	}
+	for _, pi := range cfg.StaticPeers {
+		if pi.ID == pid {
+			return nil, configErrorf("cannot list the node itself as a static peer")
+		}
+	}
+	if (cfg.MDNSAutoConnect || len(cfg.MDNSNotifees) > 0) && !cfg.MDNS {
+		return nil, configErrorf("cannot handle mDNS peers without EnableMDNS")
+	}
+	if cfg.DisableIdentify && (cfg.UserAgent != "" || cfg.ProtocolVersion != "" || cfg.RequireProtocolVersionMatch) {
+		return nil, configErrorf("cannot set identify options with DisableIdentify")
+	}
+	if cfg.DisableIdentify && cfg.RevalidateAnnounceInterval > 0 {
+		return nil, configErrorf("cannot revalidate announced addresses with DisableIdentify")
+	}
+	// AutoNAT only asks the peers identify tells us run the service.
+	if cfg.DisableIdentify && cfg.AutoNAT {
+		return nil, configErrorf("cannot enable AutoNAT with DisableIdentify")
+	}
+	if cfg.DisableRoutingFallback && cfg.Routing == nil {
+		return nil, configErrorf("cannot disable the routing fallback without Routing")
+	}
+	if cfg.PruneGracePeriod > 0 && cfg.MaxConnsPerPeer == 0 {
+		return nil, configErrorf("cannot set a prune grace period without MaxConnsPerPeer")
+	}
+	if cfg.Reachability != bhost.ReachabilityUnknown && cfg.AutoNAT {
+		return nil, configErrorf("cannot both force the reachability and probe it with AutoNAT")
+	}
+	if err := checkTransports(cfg.Transports); err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	listenAddrs, err = checkListenAddrs(listenAddrs, cfg.Transports, cfg.StrictListenAddrs)
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+	if err := checkUnixSockets(listenAddrs); err != nil {
 		return nil, err
 	}
+	if cfg.ForcePrivateNetwork && cfg.Protector == nil {
+		return nil, configErrorf("a private network was required, but none was configured")
+	}
+
+	// The event log comes first, to record the handshakes of the swarm's
+	// connections too.
+	var evlog *events.Log
+	var sink events.Sink
+	if cfg.EventLogPath != "" {
+		evlog, err = events.NewLog(cfg.EventLogPath, cfg.EventLogMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		sink = evlog
+		if !cfg.DisableFailureDedup {
+			sink = events.NewDedup(evlog, cfg.FailureDedupWindow)
+		}
+		muxer = handshakeMuxer{Transport: muxer, sink: sink}
+	}
+
+	// Without storage, the swarm still gets the key, for secio.
+	swarmPS := ps
+	if cfg.DisablePrivKeyStorage && !cfg.DisableSecio {
+		swarmPS = &swarmKeyPeerstore{Peerstore: ps, local: pid, sk: cfg.PeerKey}
+	}
+
+	// Listen only once the filters are in place, so they apply to our
+	// listeners too.
+	swrm, err := swarm.NewSwarmWithProtector(ctx, nil, pid, swarmPS, cfg.Protector, muxer, cfg.Reporter)
+	if err != nil {
+		if evlog != nil {
+			evlog.Close()
+		}
+		return nil, err
+	}
+	// closeNet releases the swarm, and the event log, if construction
+	// fails before the host is built.
+	closeNet := func() {
+		swrm.Close()
+		if evlog != nil {
+			evlog.Close()
+		}
+	}
+	if f := addrFilters(cfg); f != nil {
+		swrm.Filters = f
+	}
+	for _, t := range cfg.Transports {
+		swrm.AddTransport(t)
+	}
+	resolver := cfg.MultiaddrResolver
+	if resolver == nil {
+		resolver = madns.DefaultResolver
+	}
 
 	netw := (*swarm.Network)(swrm)
+	if keybook != nil {
+		// the keys of connected peers are kept.
+		netw.Notify((*keyBookNotifiee)(keybook))
+	}
+	var listenErrs bhost.ListenErrors
+	if len(listenAddrs) > 0 {
+		err := runStage(cctx, "resolve", func() error {
+			var err error
+			listenAddrs, err = resolveListenAddrs(cctx, resolver, listenAddrs)
+			return err
+		}, nil)
+		if err != nil {
+			closeNet()
+			return nil, err
+		}
+		err = runStage(cctx, "listen", func() error {
+			listenErrs = listenEach(netw, listenAddrs)
+			if len(listenErrs) == len(listenAddrs) || (cfg.StrictListenAddrs && len(listenErrs) > 0) {
+				return listenErrs
+			}
+			return nil
+		}, closeNet)
+		if err != nil {
+			if _, ok := err.(*ConstructionError); !ok {
+				closeNet()
+			}
+			return nil, err
+		}
+	}
+
+	for _, e := range listenErrs {
+		warnings = append(warnings, e.Error())
+	}
+
+	hostOpts := &bhost.HostOpts{
+		ConnSelectionPolicy:  cfg.ConnSelectionPolicy,
+		OrderedNotifications: cfg.OrderedNotifications,
+		AddrsUpdateInterval:  cfg.AddrsUpdateInterval,
+		AddrsFactory:         addrsFactory,
+		ConnBudget:           cfg.ConnBudget,
+		DialTimeout:          cfg.DialTimeout,
+		DialBackoff:          cfg.DialBackoff,
+		ClearNetworkBackoff:  func(p peer.ID) { swrm.Backoff().Clear(p) },
+		MaxInboundConns:      cfg.MaxInboundConns,
+		MaxConnsPerPeer:      cfg.MaxConnsPerPeer,
+		PruneGracePeriod:     cfg.PruneGracePeriod,
+		BootstrapPeers:       cfg.BootstrapPeers,
+		ResourceLimits:       cfg.ResourceLimits,
+		KeepBootstrapped:     cfg.KeepBootstrapped,
+		BootstrapMinPeers:    cfg.BootstrapMinPeers,
+		BootstrapPeriod:      cfg.BootstrapPeriod,
+		Discovery:            cfg.Discovery,
+		StaticPeers:          cfg.StaticPeers,
+		AddrTTLs:             cfg.AddrTTLs,
+		EnablePing:           cfg.Ping,
+		UserAgent:            cfg.UserAgent,
+		BandwidthReporter:    cfg.Reporter,
+		StreamObserver:       cfg.StreamObserver,
+		DisableObservedAddrs: cfg.DisableObservedAddrs,
+		MultiaddrResolver:    resolver,
+		StartupWarnings:      warnings,
+		ListenErrors:         listenErrs,
+		AdvertiseUnixAddrs:   cfg.AdvertiseUnixAddrs,
+		EnableRelay:          cfg.Relay,
+		RelayOpts:            cfg.RelayOpts,
+		AutoRelays:           cfg.AutoRelays,
+		Reachability:         cfg.Reachability,
+		EnableAutoNAT:        cfg.AutoNAT,
+		AutoNATService:       cfg.AutoNATService,
+		RelayLimits:          cfg.RelayLimits,
+		ConnManager:          cfg.ConnManager,
+		ConnectionGater:      cfg.ConnectionGater,
+
+		AddrsChangeHandlers:        cfg.AddrsChangeHandlers,
+		ReachabilityChangeHandlers: cfg.ReachabilityChangeHandlers,
+		PeerstoreSnapshot:          cfg.PeerstoreSnapshot,
+		PeerstoreSnapshotInterval:  cfg.PeerstoreSnapshotInterval,
+
+		ProtocolVersion:             cfg.ProtocolVersion,
+		RequireProtocolVersionMatch: cfg.RequireProtocolVersionMatch,
+		DisableIdentify:             cfg.DisableIdentify,
+	}
+
+	if keybook != nil {
+		hostOpts.KeyBook = keybook
+	}
+
+	if cfg.NegotiationTimeout != nil {
+		hostOpts.NegotiationTimeout = *cfg.NegotiationTimeout
+		if hostOpts.NegotiationTimeout == 0 {
+			// the basic host takes negative timeouts to mean none.
+			hostOpts.NegotiationTimeout = -1
+		}
+	}
+
+	if cfg.Reachability == bhost.ReachabilityPrivate {
+		// the relays we listen through are relays to advertise too.
+		hostOpts.AutoRelays = append(cfg.AutoRelays[:len(cfg.AutoRelays):len(cfg.AutoRelays)], circuitRelays(circuits)...)
+	}
+
+	if cfg.RevalidateAnnounceInterval > 0 {
+		hostOpts.ValidateExternalAddrs = append(cfg.AnnounceAddrs[:len(cfg.AnnounceAddrs):len(cfg.AnnounceAddrs)], cfg.AppendAnnounceAddrs...)
+		hostOpts.ExternalAddrCheckInterval = cfg.RevalidateAnnounceInterval
+		hostOpts.ExternalAddrFailureThreshold = cfg.RevalidateAnnounceThreshold
+	}
+
+	hostOpts.EventSink = sink
+
+	if cfg.NATPortMap {
+		hostOpts.NATManager = bhost.NewNATManager(netw)
+	}
+	if cfg.NATManager != nil {
+		hostOpts.NATManager = cfg.NATManager(netw)
+	}
 
-	return bhost.New(netw), nil
+	// closeUnhosted releases what was set up for a host that wasn't built.
+	closeUnhosted := func() {
+		closeNet()
+		if hostOpts.NATManager != nil {
+			hostOpts.NATManager.Close()
+		}
+	}
+
+	var h *bhost.BasicHost
+	err = runStage(cctx, "host", func() error {
+		var err error
+		if cfg.ConnManagerHigh > 0 {
+			cm := connmgr.NewConnManager(cfg.ConnManagerLow, cfg.ConnManagerHigh, cfg.ConnManagerGrace)
+			hostOpts.ConnManager = cm
+			defer func() {
+				if err != nil {
+					cm.Close()
+				}
+			}()
+		}
+		var hnet inet.Network = netw
+		if swarmPS != ps {
+			hnet = &keylessNetwork{Network: netw, ps: ps}
+		}
+		h, err = bhost.NewHost(ctx, hnet, hostOpts)
+		return err
+	}, func() {
+		if h != nil {
+			h.Close()
+		} else {
+			closeUnhosted()
+		}
+	})
+	if err != nil {
+		if _, ok := err.(*ConstructionError); !ok {
+			closeUnhosted()
+		}
+		return nil, err
+	}
+
+	if cfg.MDNS {
+		svc, err := discovery.NewMdnsService(ctx, h, cfg.MDNSInterval, cfg.MDNSTag)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("failed to start mDNS: %s", err)
+		}
+		h.OnClose(svc)
+		if cfg.MDNSAutoConnect {
+			svc.RegisterNotifee(&mdnsConnector{h: h})
+		}
+		for _, n := range cfg.MDNSNotifees {
+			svc.RegisterNotifee(n)
+		}
+	}
+
+	var router routed.Routing
+	if cfg.Routing != nil {
+		err = runStage(cctx, "routing", func() error {
+			var err error
+			router, err = cfg.Routing(h)
+			return err
+		}, func() {
+			h.Close()
+			closeRouting(router)
+		})
+		if err != nil {
+			if _, ok := err.(*ConstructionError); !ok {
+				h.Close()
+				closeRouting(router)
+			}
+			return nil, err
+		}
+	}
+
+	if len(circuits) > 0 {
+		err = runStage(cctx, "relay", func() error {
+			return connectRelays(cctx, h, circuits)
+		}, func() {
+			h.Close()
+			closeRouting(router)
+		})
+		if err != nil {
+			if _, ok := err.(*ConstructionError); !ok {
+				h.Close()
+				closeRouting(router)
+			}
+			return nil, err
+		}
+	}
+
+	if router != nil {
+		if cfg.DisableRoutingFallback {
+			if c, ok := router.(io.Closer); ok {
+				h.OnClose(c)
+			}
+			return h, nil
+		}
+		h.SetPeerRouting(router)
+		return &routedHost{RoutedHost: routed.Wrap(h, router), routing: router}, nil
+	}
+	return h, nil
 }
 
 func DefaultMuxer() mux.Transport {
+	return defaultMuxer(yamux.DefaultTransport)
+}
+
+// defaultMuxer is DefaultMuxer with the given yamux transport.
+func defaultMuxer(ymux *yamux.Transport) mux.Transport {
 	// Set up stream multiplexer
 	tpt := msmux.NewBlankTransport()
 
 	// By default, support yamux and multiplex
-	tpt.AddTransport("/yamux/1.0.0", yamux.DefaultTransport)
+	tpt.AddTransport("/yamux/1.0.0", ymux)
 	tpt.AddTransport("/mplex/6.3.0", mplex.DefaultTransport)
 
 	return tpt
 }
 
 func Defaults(cfg *Config) error {
-	// Create a multiaddress that listens on a random port on all interfaces
-	addr, err := ma.NewMultiaddr("/ip4/0.0.0.0/tcp/0")
-	if err != nil {
-		return err
+	// Listen on a random port on all interfaces, for both IPv4 and IPv6,
+	// in addition to the addresses already configured.
+	for _, s := range defaultListenAddrs {
+		addr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return err
+		}
+		if containsAddr(cfg.ListenAddrs, addr) {
+			continue
+		}
+		cfg.ListenAddrs = append(cfg.ListenAddrs, addr)
+		cfg.DefaultListenAddrs = append(cfg.DefaultListenAddrs, addr)
 	}
 
-	cfg.ListenAddrs = []ma.Multiaddr{addr}
+	// The muxer is left unset, for New to use DefaultMuxer, tuned by
+	// YamuxConfig and ResourceLimits, unless another option sets one.
 	cfg.Peerstore = pstore.NewPeerstore()
-	cfg.Muxer = DefaultMuxer()
 	return nil
 }
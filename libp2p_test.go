@@ -0,0 +1,506 @@
+package libp2p
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	routedhost "github.com/libp2p/go-libp2p/p2p/host/routed"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	host "github.com/libp2p/go-libp2p-host"
+	inat "github.com/libp2p/go-libp2p-nat"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	filter "github.com/libp2p/go-maddr-filter"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func makeLocalHost(ctx context.Context, t *testing.T, opts ...Option) host.Host {
+	opts = append([]Option{ListenAddrStrings("/ip4/127.0.0.1/tcp/0")}, opts...)
+	h, err := New(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func connectHosts(ctx context.Context, t *testing.T, a, b host.Host) {
+	err := a.Connect(ctx, pstore.PeerInfo{ID: b.ID(), Addrs: b.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyTypesInterop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	var hosts []host.Host
+	for _, typ := range []int{crypto.RSA, crypto.Ed25519, crypto.Secp256k1, crypto.ECDSA} {
+		h := makeLocalHost(ctx, t, RandomIdentity(typ, 2048))
+		defer h.Close()
+		hosts = append(hosts, h)
+	}
+
+	for i, a := range hosts {
+		for _, b := range hosts[i+1:] {
+			connectHosts(ctx, t, a, b)
+
+			conns := a.Network().ConnsToPeer(b.ID())
+			if len(conns) == 0 {
+				t.Fatalf("%s not connected to %s", a.ID(), b.ID())
+			}
+			if conns[0].RemotePeer() != b.ID() {
+				t.Fatalf("expected remote peer %s, got %s", b.ID(), conns[0].RemotePeer())
+			}
+
+			// the public key learned through the handshake must yield the
+			// peer ID we dialed.
+			id, err := peer.IDFromPublicKey(a.Peerstore().PubKey(b.ID()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != b.ID() {
+				t.Fatalf("public key of %s yields a different peer ID: %s", b.ID(), id)
+			}
+		}
+	}
+}
+
+func TestRandomIdentityConflicts(t *testing.T) {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(context.Background(), Identity(sk), RandomIdentity(crypto.Ed25519, 0))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected combining Identity and RandomIdentity to fail with a *ConfigError, got %v", err)
+	}
+}
+
+func TestExpectedPeerID(t *testing.T) {
+	ctx := context.Background()
+
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := New(ctx, Identity(sk), ExpectedPeerID(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Close()
+
+	if _, err := New(ctx, Identity(other), ExpectedPeerID(id)); err == nil {
+		t.Fatal("expected a mismatching identity to fail")
+	}
+
+	// the identity may also come from the peerstore
+	ps := pstore.NewPeerstore()
+	ps.AddPrivKey(id, sk)
+	h, err = New(ctx, Peerstore(ps), ExpectedPeerID(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ID() != id {
+		t.Fatalf("expected host to use the identity from the peerstore, got %s", h.ID())
+	}
+	h.Close()
+}
+
+func TestDisablePrivKeyStorage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ps := pstore.NewPeerstore()
+	h := makeLocalHost(ctx, t, Peerstore(ps), DisablePrivKeyStorage(), EnableRelay())
+	defer h.Close()
+
+	if ps.PrivKey(h.ID()) != nil {
+		t.Fatal("expected the private key not to be stored in the peerstore")
+	}
+	if h.Peerstore().PrivKey(h.ID()) != nil || h.Network().Peerstore().PrivKey(h.ID()) != nil {
+		t.Fatal("expected the private key not to be handed out with the host's peerstore")
+	}
+	if ps.PubKey(h.ID()) == nil {
+		t.Fatal("expected the public key to be stored in the peerstore")
+	}
+
+	// secio still has the key, or the handshakes would fail.
+	dialed := makeLocalHost(ctx, t)
+	defer dialed.Close()
+	connectHosts(ctx, t, h, dialed)
+
+	dialer := makeLocalHost(ctx, t)
+	defer dialer.Close()
+	connectHosts(ctx, t, dialer, h)
+}
+
+func TestAddrsFactory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	announced, err := ma.NewMultiaddr("/ip4/203.0.113.7/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := makeLocalHost(ctx, t, AddrsFactory(func([]ma.Multiaddr) []ma.Multiaddr {
+		return []ma.Multiaddr{announced}
+	}))
+	defer h.Close()
+
+	addrs := h.Addrs()
+	if len(addrs) != 1 || !addrs[0].Equal(announced) {
+		t.Fatalf("expected host to advertise only %s, got %s", announced, addrs)
+	}
+
+	// identify tells peers about the announced address.
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+	err = other.Connect(ctx, pstore.PeerInfo{ID: h.ID(), Addrs: h.Network().ListenAddresses()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, a := range other.Peerstore().Addrs(h.ID()) {
+		found = found || a.Equal(announced)
+	}
+	if !found {
+		t.Fatalf("expected peer to learn the announced address, got %s", other.Peerstore().Addrs(h.ID()))
+	}
+
+	silent := makeLocalHost(ctx, t, AddrsFactory(func([]ma.Multiaddr) []ma.Multiaddr {
+		return nil
+	}))
+	defer silent.Close()
+	if addrs := silent.Addrs(); len(addrs) != 0 {
+		t.Fatalf("expected host to advertise nothing, got %s", addrs)
+	}
+}
+
+type nullRouting struct{}
+
+func (nullRouting) FindPeer(context.Context, peer.ID) (pstore.PeerInfo, error) {
+	return pstore.PeerInfo{}, fmt.Errorf("no routing")
+}
+
+func TestStreamHandlersThroughWrappedHost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const proto = protocol.ID("/test/handlers")
+
+	h := makeLocalHost(ctx, t)
+	defer h.Close()
+	rh := routedhost.Wrap(h, nullRouting{})
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+	connectHosts(ctx, t, other, h)
+
+	hits := make(chan string, 10)
+	handler := func(name string) inet.StreamHandler {
+		return func(s inet.Stream) {
+			hits <- name
+			s.Close()
+		}
+	}
+	roundTrip := func() error {
+		s, err := other.NewStream(ctx, h.ID(), proto)
+		if err != nil {
+			return err
+		}
+		// force protocol negotiation to complete.
+		_, err = s.Read(make([]byte, 1))
+		if err == io.EOF {
+			err = nil
+		}
+		return err
+	}
+	expectHits := func(exp ...string) {
+		for _, name := range exp {
+			select {
+			case got := <-hits:
+				if got != name {
+					t.Fatalf("expected stream to reach the %s handler, got %s", name, got)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("stream never reached the %s handler", name)
+			}
+		}
+		select {
+		case got := <-hits:
+			t.Fatalf("unexpected extra handler invocation: %s", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// registrations on either layer end up in the same place, the last one
+	// wins.
+	h.SetStreamHandler(proto, handler("inner"))
+	if err := roundTrip(); err != nil {
+		t.Fatal(err)
+	}
+	expectHits("inner")
+
+	rh.SetStreamHandler(proto, handler("wrapper"))
+	if err := roundTrip(); err != nil {
+		t.Fatal(err)
+	}
+	expectHits("wrapper")
+
+	if rh.Mux() != h.Mux() {
+		t.Fatal("expected the wrapping host to share the inner host's muxer")
+	}
+
+	rh.RemoveStreamHandler(proto)
+	if err := roundTrip(); err == nil {
+		t.Fatal("expected stream to be refused after removing the handler")
+	}
+	expectHits()
+}
+
+func TestFilterSubnets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filtered := makeLocalHost(ctx, t, FilterSubnets("127.0.0.0/8"))
+	defer filtered.Close()
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+
+	// dialing a filtered address fails.
+	err := filtered.Connect(ctx, pstore.PeerInfo{ID: other.ID(), Addrs: other.Addrs()})
+	if err == nil {
+		t.Fatal("expected dial to a filtered address to fail")
+	}
+
+	// and connections from filtered addresses are refused.
+	cctx, ccancel := context.WithTimeout(ctx, 5*time.Second)
+	defer ccancel()
+	err = other.Connect(cctx, pstore.PeerInfo{ID: filtered.ID(), Addrs: filtered.Network().ListenAddresses()})
+	if err == nil {
+		t.Fatal("expected connection from a filtered address to be refused")
+	}
+	if len(filtered.Network().Conns()) != 0 {
+		t.Fatal("expected no connections on the filtering host")
+	}
+}
+
+func TestFiltersWithFilterSubnets(t *testing.T) {
+	_, blocked, _ := net.ParseCIDR("192.168.0.0/16")
+	blockedAddr := ma.StringCast("/ip4/192.168.1.1/tcp/4001")
+	subnetAddr := ma.StringCast("/ip4/10.1.1.1/tcp/4001")
+
+	for _, order := range []string{"filters first", "subnets first"} {
+		f := filter.NewFilters()
+		f.AddDialFilter(blocked)
+
+		opts := []Option{Filters(f), FilterSubnets("10.0.0.0/8")}
+		if order == "subnets first" {
+			opts[0], opts[1] = opts[1], opts[0]
+		}
+		var cfg Config
+		for _, opt := range opts {
+			if err := opt(&cfg); err != nil {
+				t.Fatalf("%s: %s", order, err)
+			}
+		}
+
+		fs := addrFilters(&cfg)
+		if !fs.AddrBlocked(blockedAddr) || !fs.AddrBlocked(subnetAddr) {
+			t.Errorf("%s: expected both the filters and the subnets to be blocked", order)
+		}
+		if f.AddrBlocked(subnetAddr) || len(f.Filters()) != 1 {
+			t.Errorf("%s: the given filters were modified", order)
+		}
+	}
+}
+
+func TestWildcardListenAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h, err := New(ctx, ListenAddrStrings("/ip4/0.0.0.0/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	bound, err := h.(*bhost.BasicHost).ListenAddresses()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bound) != 1 {
+		t.Fatalf("expected a single bound address, got %s", bound)
+	}
+	port, err := bound[0].ValueForProtocol(ma.P_TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port == "0" {
+		t.Fatalf("expected the actual port, got %s", bound[0])
+	}
+
+	loopback := ma.StringCast("/ip4/127.0.0.1/tcp/" + port)
+	found := false
+	for _, a := range h.Addrs() {
+		if ip, _ := a.ValueForProtocol(ma.P_IP4); ip == "0.0.0.0" {
+			t.Fatalf("advertised the unspecified address %s", a)
+		}
+		if a.Equal(loopback) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %s among %s", loopback, h.Addrs())
+	}
+}
+
+type testNATManager struct {
+	listening int
+	closed    bool
+}
+
+func (m *testNATManager) NAT() *inat.NAT         { return nil }
+func (m *testNATManager) Ready() <-chan struct{} { return nil }
+func (m *testNATManager) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestNATManager(t *testing.T) {
+	ctx := context.Background()
+
+	m := &testNATManager{}
+	h := makeLocalHost(ctx, t, NATManager(func(n inet.Network) bhost.NATManager {
+		m.listening = len(n.ListenAddresses())
+		return m
+	}))
+	if m.listening == 0 {
+		t.Fatal("expected the NAT manager to be built once the network listens")
+	}
+	h.Close()
+	if !m.closed {
+		t.Fatal("expected the NAT manager to be closed with the host")
+	}
+
+	_, err := New(ctx, NATPortMap(), NATManager(bhost.NewNATManager))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError combining NATPortMap and NATManager, got %v", err)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	ctx := context.Background()
+	if _, err := New(ctx, DialTimeout(0)); err == nil {
+		t.Fatal("expected a zero dial timeout to be rejected")
+	}
+
+	// a peer that accepts connections but never completes a handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	sk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi := pstore.PeerInfo{
+		ID:    pid,
+		Addrs: []ma.Multiaddr{ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", l.Addr().(*net.TCPAddr).Port))},
+	}
+
+	for _, c := range []struct {
+		timeout, deadline time.Duration
+	}{
+		{timeout: 200 * time.Millisecond, deadline: time.Hour},
+		{timeout: time.Hour, deadline: 200 * time.Millisecond},
+	} {
+		h := makeLocalHost(ctx, t, DialTimeout(c.timeout))
+		cctx, cancel := context.WithTimeout(ctx, c.deadline)
+		before := time.Now()
+		if err := h.Connect(cctx, pi); err == nil {
+			t.Fatal("expected the dial to time out")
+		}
+		if took := time.Since(before); took > 5*time.Second {
+			t.Fatalf("expected the dial to give up quickly, took %s", took)
+		}
+		cancel()
+		h.Close()
+	}
+}
+
+func TestNegotiationTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := New(ctx, NegotiationTimeout(-time.Second)); err == nil {
+		t.Fatal("expected a negative negotiation timeout to be rejected")
+	}
+
+	h1 := makeLocalHost(ctx, t, NegotiationTimeout(200*time.Millisecond))
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t)
+	defer h2.Close()
+	connectHosts(ctx, t, h2, h1)
+
+	// stalled reports whether a stream opened to h1 without negotiating a
+	// protocol is still open after wait.
+	stalled := func(wait time.Duration) bool {
+		s, err := h2.Network().NewStream(ctx, h1.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Reset()
+		// announce a multistream message that never comes.
+		if _, err := s.Write([]byte{16}); err != nil {
+			t.Fatal(err)
+		}
+		s.SetReadDeadline(time.Now().Add(wait))
+		_, err = s.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return true
+		}
+		return false
+	}
+
+	if stalled(2 * time.Second) {
+		t.Fatal("expected the stream to be reset after the negotiation timeout")
+	}
+	h1.(*bhost.BasicHost).SetNegotiationTimeout(0)
+	if !stalled(time.Second) {
+		t.Fatal("expected the stream to stay open without a negotiation timeout")
+	}
+}
@@ -0,0 +1,197 @@
+package libp2p
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	logging "github.com/ipfs/go-log"
+	inet "github.com/libp2p/go-libp2p-net"
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+var log = logging.Logger("libp2p")
+
+// defaultListenAddrs are the addresses Defaults makes the node listen on.
+var defaultListenAddrs = []string{
+	"/ip4/0.0.0.0/tcp/0",
+	"/ip6/::/tcp/0",
+}
+
+// hasIPv6 reports whether the host has a working IPv6 stack.
+var hasIPv6 = func() bool {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// StrictListenAddrs makes New fail, rather than warn, when a listen address
+// can't be served by any of the node's transports, such as a UDP address,
+// or when listening on any of the addresses fails. Otherwise New only fails
+// if listening fails on all of them.
+func StrictListenAddrs() Option {
+	return func(cfg *Config) error {
+		cfg.StrictListenAddrs = true
+		return nil
+	}
+}
+
+// checkListenAddrs reports the listen addresses no transport can serve,
+// neither the swarm's own nor the extra tpts, with an error if strict is
+// set, and with warnings otherwise. It returns the addresses to listen on.
+func checkListenAddrs(addrs []ma.Multiaddr, tpts []transport.Transport, strict bool) ([]ma.Multiaddr, error) {
+	var bad []string
+	ok := make([]ma.Multiaddr, 0, len(addrs))
+	for i, a := range addrs {
+		if !canListen(a) && !matchesTransport(a, tpts) {
+			bad = append(bad, fmt.Sprintf("#%d %s", i, a))
+			continue
+		}
+		ok = append(ok, a)
+	}
+	if len(bad) == 0 {
+		return addrs, nil
+	}
+	if strict {
+		return nil, fmt.Errorf("no transport can listen on: %s", strings.Join(bad, "; "))
+	}
+	for _, b := range bad {
+		log.Warningf("no transport can listen on listen address %s, skipping it", b)
+	}
+	return ok, nil
+}
+
+// canListen reports whether the swarm's transports (TCP, and websockets
+// over TCP) can listen on a.
+func canListen(a ma.Multiaddr) bool {
+	protos := a.Protocols()
+	if len(protos) < 2 {
+		return false
+	}
+	switch protos[0].Code {
+	case ma.P_IP4, ma.P_IP6, madns.P_DNS4, madns.P_DNS6:
+	default:
+		return false
+	}
+	if protos[1].Code != ma.P_TCP {
+		return false
+	}
+	switch len(protos) {
+	case 2:
+		return true
+	case 3:
+		return protos[2].Name == "ws"
+	}
+	return false
+}
+
+// listenEach listens on every address, rather than stopping at the first
+// failure, and returns the failures.
+func listenEach(netw inet.Network, addrs []ma.Multiaddr) bhost.ListenErrors {
+	var errs bhost.ListenErrors
+	for _, a := range addrs {
+		if err := netw.Listen(a); err != nil {
+			log.Warningf("failed to listen on %s: %s", a, err)
+			errs = append(errs, &bhost.ListenError{Addr: a, Err: err})
+		}
+	}
+	return errs
+}
+
+func matchesTransport(a ma.Multiaddr, tpts []transport.Transport) bool {
+	for _, t := range tpts {
+		if t.Matches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// AdvertiseUnixAddrs makes the node advertise its unix domain socket listen
+// addresses, which it otherwise only listens on. They are only reachable
+// from the same machine.
+func AdvertiseUnixAddrs() Option {
+	return func(cfg *Config) error {
+		cfg.AdvertiseUnixAddrs = true
+		return nil
+	}
+}
+
+func isUnixAddr(a ma.Multiaddr) bool {
+	return a.Protocols()[0].Name == "unix"
+}
+
+// checkUnixSockets makes sure the node can bind the unix domain sockets it
+// listens on: a socket file left behind by a node that is gone is removed,
+// while one still accepting connections is an error.
+func checkUnixSockets(addrs []ma.Multiaddr) error {
+	for _, a := range addrs {
+		if !isUnixAddr(a) {
+			continue
+		}
+		path := strings.TrimPrefix(a.String(), "/unix")
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("cannot listen on %s: %s exists and is not a socket", a, path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return fmt.Errorf("cannot listen on %s: socket is in use", a)
+		}
+		log.Infof("removing stale unix socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipUnsupportedDefaults removes duplicates from addrs, as well as the
+// default listen addresses the host can't listen on, currently IPv6 ones on
+// hosts without IPv6, returning warnings about those. Explicitly configured
+// addresses are kept.
+func skipUnsupportedDefaults(addrs, defaults []ma.Multiaddr) ([]ma.Multiaddr, []string) {
+	v6 := len(defaults) == 0 || hasIPv6()
+	var warnings []string
+	out := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if containsAddr(out, a) {
+			continue
+		}
+		if !v6 && containsAddr(defaults, a) && isIP6(a) {
+			w := fmt.Sprintf("not listening on default address %s: no IPv6 support", a)
+			log.Warning(w)
+			warnings = append(warnings, w)
+			continue
+		}
+		out = append(out, a)
+	}
+	return out, warnings
+}
+
+func isIP6(a ma.Multiaddr) bool {
+	return a.Protocols()[0].Code == ma.P_IP6
+}
+
+func containsAddr(addrs []ma.Multiaddr, a ma.Multiaddr) bool {
+	for _, b := range addrs {
+		if a.Equal(b) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestListenAddrStringsErrors(t *testing.T) {
+	var cfg Config
+	err := ListenAddrStrings(
+		"/ip4/0.0.0.0/tcp/4001",
+		"/ip4/0.0.0.0/tcp/abc",
+		"/ip4/0.0.0.0/tcp/4002",
+		"/ip9/1.2.3.4",
+	)(&cfg)
+	if err == nil {
+		t.Fatal("expected invalid listen addresses to be rejected")
+	}
+	for _, s := range []string{`#1 "/ip4/0.0.0.0/tcp/abc"`, `#3 "/ip9/1.2.3.4"`} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("expected error to mention %s, got: %s", s, err)
+		}
+	}
+	if len(cfg.ListenAddrs) != 0 {
+		t.Fatal("expected no listen addresses to be set")
+	}
+}
+
+func TestStrictListenAddrs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := New(ctx, ListenAddrStrings("/ip4/127.0.0.1/udp/0"), StrictListenAddrs())
+	if err == nil || !strings.Contains(err.Error(), "/ip4/127.0.0.1/udp/0") {
+		t.Fatalf("expected an unsupported listen address to be rejected, got %v", err)
+	}
+
+	for _, a := range []string{"/ip4/127.0.0.1/tcp/0", "/ip6/::1/tcp/0/ws", "/dns4/example.com/tcp/4001"} {
+		if _, err := checkListenAddrs([]ma.Multiaddr{ma.StringCast(a)}, nil, true); err != nil {
+			t.Fatalf("expected %s to be accepted: %s", a, err)
+		}
+	}
+}
+
+func TestDefaultListenAddrsDualStack(t *testing.T) {
+	var cfg Config
+	if err := ListenAddrStrings("/ip4/0.0.0.0/tcp/0")(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Defaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.ListenAddrs) != 2 {
+		t.Fatalf("expected the IPv4 and IPv6 wildcard addresses, got %s", cfg.ListenAddrs)
+	}
+	if !containsAddr(cfg.ListenAddrs, ma.StringCast("/ip6/::/tcp/0")) {
+		t.Fatalf("expected an IPv6 listen address, got %s", cfg.ListenAddrs)
+	}
+	if len(cfg.DefaultListenAddrs) != 1 {
+		t.Fatalf("expected the user's address not to count as a default, got %s", cfg.DefaultListenAddrs)
+	}
+}
+
+func TestDefaultListenAddrsWithoutIPv6(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defer func(f func() bool) { hasIPv6 = f }(hasIPv6)
+	hasIPv6 = func() bool { return false }
+
+	h, err := New(ctx, Defaults, StrictListenAddrs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for _, a := range h.Network().ListenAddresses() {
+		if isIP6(a) {
+			t.Fatalf("expected no IPv6 listener, got %s", a)
+		}
+	}
+	if len(h.(*bhost.BasicHost).StartupWarnings()) != 1 {
+		t.Fatal("expected a warning about the skipped IPv6 address")
+	}
+}
+
+func TestCheckUnixSockets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libp2p-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	live := filepath.Join(dir, "live.sock")
+	l, err := net.Listen("unix", live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := checkUnixSockets([]ma.Multiaddr{ma.StringCast("/unix" + live)}); err == nil {
+		t.Fatal("expected a socket in use to be rejected")
+	}
+
+	stale := filepath.Join(dir, "stale.sock")
+	sl, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.(*net.UnixListener).SetUnlinkOnClose(false)
+	sl.Close()
+	if err := checkUnixSockets([]ma.Multiaddr{ma.StringCast("/unix" + stale)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(stale); !os.IsNotExist(err) {
+		t.Fatal("expected the stale socket to be removed")
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkUnixSockets([]ma.Multiaddr{ma.StringCast("/unix" + file)}); err == nil {
+		t.Fatal("expected a regular file to be rejected")
+	}
+}
+
+func TestUnixListenAddrsNeedTransport(t *testing.T) {
+	a := ma.StringCast("/unix/tmp/p2p.sock")
+	if _, err := checkListenAddrs([]ma.Multiaddr{a}, nil, true); err == nil {
+		t.Fatal("expected a unix address to be rejected without a unix transport")
+	}
+	addrs, err := checkListenAddrs([]ma.Multiaddr{a}, []transport.Transport{unixTransport{}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 {
+		t.Fatalf("expected the unix address to be kept, got %s", addrs)
+	}
+}
+
+// unixTransport only claims unix addresses, for checkListenAddrs.
+type unixTransport struct {
+	transport.Transport
+}
+
+func (unixTransport) Matches(a ma.Multiaddr) bool {
+	return isUnixAddr(a)
+}
+
+func TestListenErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	taken := fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", l.Addr().(*net.TCPAddr).Port)
+
+	h, err := New(ctx, ListenAddrStrings("/ip4/127.0.0.1/tcp/0", taken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	errs := h.(*bhost.BasicHost).ListenErrors()
+	if len(errs) != 1 || errs[0].Addr.String() != taken {
+		t.Fatalf("expected a listen error for %s, got %v", taken, errs)
+	}
+
+	for _, opts := range [][]Option{
+		{ListenAddrStrings(taken)},
+		{ListenAddrStrings("/ip4/127.0.0.1/tcp/0", taken), StrictListenAddrs()},
+	} {
+		_, err := New(ctx, opts...)
+		errs, ok := err.(bhost.ListenErrors)
+		if !ok {
+			t.Fatalf("expected bhost.ListenErrors, got %T: %v", err, err)
+		}
+		if len(errs) != 1 || errs[0].Addr.String() != taken {
+			t.Fatalf("expected a listen error for %s, got %v", taken, errs)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	discovery "github.com/libp2p/go-libp2p/p2p/discovery"
+)
+
+// EnableMDNS makes the node announce itself on the local network with
+// mDNS, under serviceTag (discovery.ServiceTag if empty), and look for
+// other nodes announced under the same tag every interval. The addresses
+// of the peers found are added to the peerstore; MDNSAutoConnect connects
+// to them, and MDNSNotifee reports them. The service is closed with the
+// node, and several nodes of a process can run it.
+func EnableMDNS(serviceTag string, interval time.Duration) Option {
+	return func(cfg *Config) error {
+		if interval <= 0 {
+			return fmt.Errorf("mDNS interval must be positive, got %s", interval)
+		}
+		cfg.MDNS = true
+		cfg.MDNSTag = serviceTag
+		cfg.MDNSInterval = interval
+		return nil
+	}
+}
+
+// MDNSAutoConnect makes the node connect to the peers it finds with mDNS.
+func MDNSAutoConnect() Option {
+	return func(cfg *Config) error {
+		cfg.MDNSAutoConnect = true
+		return nil
+	}
+}
+
+// MDNSNotifee registers n to be told about the peers the node finds with
+// mDNS.
+func MDNSNotifee(n discovery.Notifee) Option {
+	return func(cfg *Config) error {
+		cfg.MDNSNotifees = append(cfg.MDNSNotifees, n)
+		return nil
+	}
+}
+
+// mdnsConnector connects to the peers found with mDNS.
+type mdnsConnector struct {
+	h host.Host
+}
+
+func (n *mdnsConnector) HandlePeerFound(pi pstore.PeerInfo) {
+	if n.h.Network().Connectedness(pi.ID) == inet.Connected {
+		return
+	}
+	if err := n.h.Connect(context.Background(), pi); err != nil {
+		log.Debugf("failed to connect to %s, found with mDNS: %s", pi.ID.Pretty(), err)
+	}
+}
@@ -0,0 +1,61 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+type chanNotifee chan pstore.PeerInfo
+
+func (n chanNotifee) HandlePeerFound(pi pstore.PeerInfo) {
+	select {
+	case n <- pi:
+	default:
+	}
+}
+
+func TestMDNS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	if _, err := New(ctx, MDNSAutoConnect()); err == nil {
+		t.Fatal("expected MDNSAutoConnect without EnableMDNS to be rejected")
+	}
+
+	tag := fmt.Sprintf("_libp2p-test-%d._udp", time.Now().UnixNano())
+	found := make(chanNotifee, 1)
+	h1, err := New(ctx, ListenAddrStrings("/ip4/0.0.0.0/tcp/0"), EnableMDNS(tag, time.Second), MDNSNotifee(found))
+	if err != nil {
+		t.Skipf("mDNS unavailable: %s", err)
+	}
+	defer h1.Close()
+	h2, err := New(ctx, ListenAddrStrings("/ip4/0.0.0.0/tcp/0"), EnableMDNS(tag, time.Second), MDNSAutoConnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	select {
+	case pi := <-found:
+		if pi.ID != h2.ID() {
+			t.Fatalf("expected to find %s, found %s", h2.ID(), pi.ID)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected the hosts to find each other")
+	}
+	if len(h1.Peerstore().Addrs(h2.ID())) == 0 {
+		t.Fatal("expected the addresses of the peer found to be in the peerstore")
+	}
+	for h2.Network().Connectedness(h1.ID()) != inet.Connected {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the host with MDNSAutoConnect to connect")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package libp2p
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	newHost := func(addr string) host.Host {
+		h, err := New(ctx, ListenAddrStrings(addr), MemoryTransport(), RandomIdentity(crypto.Ed25519, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+	h1 := newHost("/memory/1")
+	defer h1.Close()
+	h2 := newHost("/memory/2")
+	defer h2.Close()
+
+	if !containsAddr(h1.Addrs(), ma.StringCast("/memory/1")) {
+		t.Fatalf("expected /memory/1 in the addresses, got %s", h1.Addrs())
+	}
+
+	h1.SetStreamHandler("/test/memory", func(s inet.Stream) {
+		defer s.Close()
+		b, err := ioutil.ReadAll(s)
+		if err != nil {
+			return
+		}
+		s.Write(b)
+	})
+
+	err := h2.Connect(ctx, pstore.PeerInfo{ID: h1.ID(), Addrs: h1.Addrs()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := h2.NewStream(ctx, h1.ID(), "/test/memory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	b, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "ping" {
+		t.Fatalf("expected the data echoed back, got %q", b)
+	}
+
+	if _, err := New(ctx, ListenAddrStrings("/memory/1"), MemoryTransport(), RandomIdentity(crypto.Ed25519, 0)); err == nil {
+		t.Fatal("expected listening on an address in use to fail")
+	}
+}
@@ -0,0 +1,148 @@
+package libp2p
+
+import (
+	"fmt"
+	"time"
+
+	mux "github.com/libp2p/go-stream-muxer"
+	msmux "github.com/whyrusleeping/go-smux-multistream"
+	yamux "github.com/whyrusleeping/go-smux-yamux"
+)
+
+// MuxerSpec is a stream muxer and the multistream protocol ID it's
+// negotiated with.
+type MuxerSpec struct {
+	ID        string
+	Transport mux.Transport
+}
+
+// Muxers replaces the stream muxers of the node, yamux and mplex by
+// default, with the given ones, proposed in that order. Use Muxer to give
+// a complete muxer instead.
+func Muxers(muxers ...MuxerSpec) Option {
+	return func(cfg *Config) error {
+		if len(muxers) == 0 {
+			return fmt.Errorf("must specify at least one muxer")
+		}
+		tpt := msmux.NewBlankTransport()
+		seen := make(map[string]bool, len(muxers))
+		for _, m := range muxers {
+			if seen[m.ID] {
+				return fmt.Errorf("muxer %q specified twice", m.ID)
+			}
+			if m.Transport == nil {
+				return fmt.Errorf("muxer %q has no transport", m.ID)
+			}
+			seen[m.ID] = true
+			tpt.AddTransport(m.ID, m.Transport)
+		}
+		cfg.Muxer = tpt
+		return nil
+	}
+}
+
+// PreferMuxers sets the order in which the node proposes stream muxers
+// when it dials, e.g. PreferMuxers("/mplex/6.3.0") to try mplex before
+// yamux. Muxers not listed keep their order, after the listed ones. It
+// applies to the default muxer and to multistream muxers given with the
+// Muxer option, and New fails if an ID isn't one of theirs.
+//
+// Under multistream-select, the dialer proposes muxers in order of its
+// preference and the listener accepts the first one it supports, so the
+// dialer's preference wins.
+func PreferMuxers(ids ...string) Option {
+	return func(cfg *Config) error {
+		if cfg.MuxerPreference != nil {
+			return fmt.Errorf("cannot specify multiple muxer preferences")
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("muxer preference must list at least one muxer")
+		}
+		cfg.MuxerPreference = ids
+		return nil
+	}
+}
+
+// preferMuxers moves the muxers ids first in the order of preference of t.
+func preferMuxers(t *msmux.Transport, ids []string) error {
+	known := make(map[string]bool, len(t.OrderPreference))
+	for _, id := range t.OrderPreference {
+		known[id] = true
+	}
+
+	order := make([]string, 0, len(t.OrderPreference))
+	listed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !known[id] {
+			return fmt.Errorf("unknown muxer %q in muxer preference", id)
+		}
+		if listed[id] {
+			return fmt.Errorf("muxer %q listed twice in muxer preference", id)
+		}
+		listed[id] = true
+		order = append(order, id)
+	}
+	for _, id := range t.OrderPreference {
+		if !listed[id] {
+			order = append(order, id)
+		}
+	}
+	t.OrderPreference = order
+	return nil
+}
+
+// yamuxMinStreamWindow is the initial stream window of the yamux protocol,
+// below which the window can't go.
+const yamuxMinStreamWindow = 256 * 1024
+
+// YamuxOpts tunes the yamux muxer. Zero fields keep the defaults of
+// yamux.DefaultTransport.
+type YamuxOpts struct {
+	// MaxStreamWindowSize is the receive window of each stream, which
+	// bounds the throughput of a stream to the window over the round
+	// trip time. It can't be below 256KiB.
+	MaxStreamWindowSize uint32
+
+	// KeepAliveInterval is the interval between keepalive pings.
+	KeepAliveInterval time.Duration
+
+	// AcceptBacklog is the number of inbound streams waiting to be
+	// accepted, beyond which new streams are refused.
+	AcceptBacklog int
+
+	// ConnectionWriteTimeout bounds how long a write may block before the
+	// connection is closed.
+	ConnectionWriteTimeout time.Duration
+}
+
+// YamuxConfig tunes the yamux muxer of the default muxer. It can't be
+// combined with the Muxer option.
+func YamuxConfig(opts YamuxOpts) Option {
+	return func(cfg *Config) error {
+		if cfg.Yamux != nil {
+			return fmt.Errorf("cannot specify multiple yamux configurations")
+		}
+		if opts.MaxStreamWindowSize != 0 && opts.MaxStreamWindowSize < yamuxMinStreamWindow {
+			return fmt.Errorf("yamux stream window must be at least %d bytes, got %d", yamuxMinStreamWindow, opts.MaxStreamWindowSize)
+		}
+		if opts.KeepAliveInterval < 0 || opts.AcceptBacklog < 0 || opts.ConnectionWriteTimeout < 0 {
+			return fmt.Errorf("yamux settings must not be negative")
+		}
+
+		tpt := *yamux.DefaultTransport
+		if opts.MaxStreamWindowSize != 0 {
+			tpt.MaxStreamWindowSize = opts.MaxStreamWindowSize
+		}
+		if opts.KeepAliveInterval != 0 {
+			tpt.KeepAliveInterval = opts.KeepAliveInterval
+		}
+		if opts.AcceptBacklog != 0 {
+			tpt.AcceptBacklog = opts.AcceptBacklog
+		}
+		if opts.ConnectionWriteTimeout != 0 {
+			tpt.ConnectionWriteTimeout = opts.ConnectionWriteTimeout
+		}
+		cfg.Yamux = &tpt
+		return nil
+	}
+}
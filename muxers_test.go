@@ -0,0 +1,255 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mux "github.com/libp2p/go-stream-muxer"
+	mplex "github.com/whyrusleeping/go-smux-multiplex"
+	msmux "github.com/whyrusleeping/go-smux-multistream"
+	yamux "github.com/whyrusleeping/go-smux-yamux"
+)
+
+func TestPreferMuxers(t *testing.T) {
+	yamuxFirst := DefaultMuxer().(*msmux.Transport)
+	mplexFirst := DefaultMuxer().(*msmux.Transport)
+	if err := preferMuxers(mplexFirst, []string{"/mplex/6.3.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if mplexFirst.OrderPreference[0] != "/mplex/6.3.0" || mplexFirst.OrderPreference[1] != "/yamux/1.0.0" {
+		t.Fatalf("unexpected order: %v", mplexFirst.OrderPreference)
+	}
+
+	if err := preferMuxers(DefaultMuxer().(*msmux.Transport), []string{"/spdy/3.1.0"}); err == nil {
+		t.Fatal("expected an unknown muxer to be rejected")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the dialer's preference wins.
+	for _, c := range []struct {
+		dialer, listener *msmux.Transport
+		exp              string
+	}{
+		{dialer: mplexFirst, listener: yamuxFirst, exp: "multiplex"},
+		{dialer: yamuxFirst, listener: mplexFirst, exp: "yamux"},
+	} {
+		done := make(chan string, 1)
+		go func() {
+			nc, err := l.Accept()
+			if err != nil {
+				done <- err.Error()
+				return
+			}
+			sc, err := c.listener.NewConn(nc, true)
+			if err != nil {
+				done <- err.Error()
+				return
+			}
+			done <- fmt.Sprintf("%T", sc)
+			sc.Close()
+		}()
+
+		nc, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dc, err := c.dialer.NewConn(nc, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dialed := fmt.Sprintf("%T", dc)
+		dc.Close()
+
+		for _, got := range []string{dialed, <-done} {
+			if !strings.Contains(got, c.exp) {
+				t.Fatalf("expected %s to be negotiated, got %s", c.exp, got)
+			}
+		}
+	}
+}
+
+func TestYamuxConfig(t *testing.T) {
+	if err := YamuxConfig(YamuxOpts{MaxStreamWindowSize: 1024})(&Config{}); err == nil {
+		t.Fatal("expected a stream window below the protocol minimum to be rejected")
+	}
+
+	var cfg Config
+	err := YamuxConfig(YamuxOpts{MaxStreamWindowSize: 16 << 20, AcceptBacklog: 64})(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Yamux.MaxStreamWindowSize != 16<<20 || cfg.Yamux.AcceptBacklog != 64 {
+		t.Fatalf("settings not applied: %+v", cfg.Yamux)
+	}
+	if cfg.Yamux.KeepAliveInterval != yamux.DefaultTransport.KeepAliveInterval {
+		t.Fatal("expected unset settings to keep their defaults")
+	}
+	if cfg.Yamux == yamux.DefaultTransport {
+		t.Fatal("expected the default transport to be left alone")
+	}
+
+	_, err = New(context.Background(), YamuxConfig(YamuxOpts{AcceptBacklog: 64}), Muxer(DefaultMuxer()))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError combining Muxer and YamuxConfig, got %v", err)
+	}
+}
+
+func TestTunedMuxerWithDefaults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, opt := range []Option{
+		YamuxConfig(YamuxOpts{MaxStreamWindowSize: 16 << 20}),
+		ResourceLimits(ResourceLimitOpts{MaxStreamBuffer: 1 << 20}),
+	} {
+		h, err := New(ctx, Defaults, opt)
+		if err != nil {
+			t.Fatalf("expected the yamux settings to apply on top of Defaults, got %v", err)
+		}
+		h.Close()
+	}
+}
+
+// latencyPipe returns the two ends of an in-memory connection, delivering
+// what is written to either end after delay.
+func latencyPipe(delay time.Duration) (net.Conn, net.Conn) {
+	a, ra := net.Pipe()
+	b, rb := net.Pipe()
+	go delayCopy(rb, ra, delay)
+	go delayCopy(ra, rb, delay)
+	return a, b
+}
+
+// delayCopy copies src to dst, holding each chunk back for delay, but
+// without limiting how many are in flight.
+func delayCopy(dst, src net.Conn, delay time.Duration) {
+	type chunk struct {
+		b  []byte
+		at time.Time
+	}
+	chunks := make(chan chunk, 4096)
+	go func() {
+		defer close(chunks)
+		for {
+			b := make([]byte, 32<<10)
+			n, err := src.Read(b)
+			if n > 0 {
+				chunks <- chunk{b: b[:n], at: time.Now().Add(delay)}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	defer func() {
+		dst.Close()
+		src.Close()
+		for range chunks {
+		}
+	}()
+	for c := range chunks {
+		time.Sleep(time.Until(c.at))
+		if _, err := dst.Write(c.b); err != nil {
+			return
+		}
+	}
+}
+
+// transferTime returns how long sending size bytes on a single stream of
+// muxer takes, over a connection with the given one way delay.
+func transferTime(t *testing.T, muxer mux.Transport, size int, delay time.Duration) time.Duration {
+	c1, c2 := latencyPipe(delay)
+	defer c1.Close()
+	defer c2.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		sc, err := muxer.NewConn(c2, true)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer sc.Close()
+		s, err := sc.AcceptStream()
+		if err != nil {
+			done <- err
+			return
+		}
+		_, err = io.ReadFull(s, make([]byte, size))
+		done <- err
+	}()
+
+	dc, err := muxer.NewConn(c1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+	s, err := dc.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	go s.Write(make([]byte, size))
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+func TestYamuxWindowThroughput(t *testing.T) {
+	tuned := func(window uint32) mux.Transport {
+		var cfg Config
+		if err := YamuxConfig(YamuxOpts{MaxStreamWindowSize: window})(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		// the muxer New builds from the configuration.
+		return defaultMuxer(cfg.Yamux)
+	}
+
+	// a stream moves a window per round trip: 256KiB per 100ms is about
+	// 2.5MiB/s, so 4MiB take well over a second, while a 16MiB window is
+	// only limited by the initial window for the first round trip.
+	const size = 4 << 20
+	const delay = 50 * time.Millisecond
+	small := transferTime(t, tuned(yamuxMinStreamWindow), size, delay)
+	large := transferTime(t, tuned(16<<20), size, delay)
+	if large*3 > small {
+		t.Fatalf("expected the larger window to take effect: %s with 256KiB, %s with 16MiB", small, large)
+	}
+}
+
+func TestMuxersReplaceDefaults(t *testing.T) {
+	var cfg Config
+	if err := Defaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Muxers(MuxerSpec{ID: "/mplex/6.3.0", Transport: mplex.DefaultTransport})(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	order := cfg.Muxer.(*msmux.Transport).OrderPreference
+	if len(order) != 1 || order[0] != "/mplex/6.3.0" {
+		t.Fatalf("expected only mplex, got %v", order)
+	}
+
+	if err := Muxers()(&cfg); err == nil {
+		t.Fatal("expected an empty muxer list to be rejected")
+	}
+	err := Muxers(
+		MuxerSpec{ID: "/mplex/6.3.0", Transport: mplex.DefaultTransport},
+		MuxerSpec{ID: "/mplex/6.3.0", Transport: mplex.DefaultTransport},
+	)(&cfg)
+	if err == nil {
+		t.Fatal("expected a duplicate muxer to be rejected")
+	}
+}
@@ -0,0 +1,439 @@
+package libp2p
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	discovery "github.com/libp2p/go-libp2p/p2p/discovery"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	routed "github.com/libp2p/go-libp2p/p2p/host/routed"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	host "github.com/libp2p/go-libp2p-host"
+	metrics "github.com/libp2p/go-libp2p-metrics"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// The option combination harness runs a bounded corpus by default. For
+// longer runs, e.g.:
+//
+//	go test -run TestOptionCombinations -options.iterations 10000 -options.seed 0
+var (
+	optionIterations = flag.Int("options.iterations", 60, "number of option combinations tried by TestOptionCombinations")
+	optionSeed       = flag.Int64("options.seed", 1, "seed of TestOptionCombinations, 0 for a random one")
+)
+
+// optionEnv holds what catalog entries may need to build their option.
+type optionEnv struct {
+	dir string
+	sk  crypto.PrivKey
+	id  peer.ID
+}
+
+func newOptionEnv(t *testing.T, dir string) *optionEnv {
+	sk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &optionEnv{dir: dir, sk: sk, id: id}
+}
+
+// catalogOption describes an option and its constraints. A combination is
+// invalid, and New must fail with a *ConfigError, if it holds an invalid
+// option, two conflicting options, or an option without one it requires.
+// Any other combination must build a node.
+type catalogOption struct {
+	name      string
+	opt       func(env *optionEnv) Option
+	conflicts []string
+	requires  []string
+	invalid   bool
+}
+
+// optionCatalog lists the options exercised by the harness. NATPortMap and
+// EnableMDNS, with MDNSAutoConnect which requires it, are left out, as they
+// probe the local network.
+var optionCatalog = []catalogOption{
+	{name: "ListenLoopback", opt: func(*optionEnv) Option { return ListenAddrStrings("/ip4/127.0.0.1/tcp/0") }},
+	{name: "ListenLoopbackWS", opt: func(*optionEnv) Option { return ListenAddrStrings("/ip4/127.0.0.1/tcp/0/ws") }},
+	{
+		name:      "ListenUDP",
+		opt:       func(*optionEnv) Option { return ListenAddrStrings("/ip4/127.0.0.1/udp/0") },
+		conflicts: []string{"StrictListenAddrs"},
+	},
+	{name: "StrictListenAddrs", opt: func(*optionEnv) Option { return StrictListenAddrs() }},
+	{
+		name:     "ListenCircuit",
+		opt:      func(*optionEnv) Option { return ListenAddrStrings("/p2p-circuit") },
+		requires: []string{"EnableRelay"},
+	},
+	{name: "EnableRelay", opt: func(*optionEnv) Option { return EnableRelay() }},
+	{name: "EnableAutoNAT", opt: func(*optionEnv) Option { return EnableAutoNAT() }},
+	{name: "EnableAutoNATService", opt: func(*optionEnv) Option { return EnableAutoNATService() }},
+	{
+		name:      "ForceReachabilityPublic",
+		opt:       func(*optionEnv) Option { return ForceReachabilityPublic() },
+		conflicts: []string{"ForceReachabilityPrivate", "EnableAutoNAT"},
+	},
+	{
+		name:      "ForceReachabilityPrivate",
+		opt:       func(*optionEnv) Option { return ForceReachabilityPrivate() },
+		conflicts: []string{"EnableAutoNAT"},
+	},
+	{
+		name:      "DisableRelay",
+		opt:       func(*optionEnv) Option { return DisableRelay() },
+		conflicts: []string{"EnableRelay"},
+	},
+	{
+		name:    "ListenInvalid",
+		opt:     func(*optionEnv) Option { return ListenAddrStrings("/ip4/256.0.0.1/tcp/0") },
+		invalid: true,
+	},
+	{
+		name:      "Identity",
+		opt:       func(env *optionEnv) Option { return Identity(env.sk) },
+		conflicts: []string{"RandomIdentity"},
+	},
+	{name: "RandomIdentity", opt: func(*optionEnv) Option { return RandomIdentity(crypto.Ed25519, 0) }},
+	{
+		name:     "ExpectedPeerID",
+		opt:      func(env *optionEnv) Option { return ExpectedPeerID(env.id) },
+		requires: []string{"Identity"},
+	},
+	{name: "DisablePrivKeyStorage", opt: func(*optionEnv) Option { return DisablePrivKeyStorage() }},
+	{name: "NoEncryption", opt: func(*optionEnv) Option { return NoEncryption() }},
+	{name: "PrivateNetworkPSK", opt: func(*optionEnv) Option { return PrivateNetworkPSK(make([]byte, 32)) }},
+	{
+		name:    "PrivateNetworkPSKShort",
+		opt:     func(*optionEnv) Option { return PrivateNetworkPSK(make([]byte, 16)) },
+		invalid: true,
+	},
+	{
+		name:     "ForcePrivateNetwork",
+		opt:      func(*optionEnv) Option { return ForcePrivateNetwork },
+		requires: []string{"PrivateNetworkPSK"},
+	},
+	{name: "ConnectionManagerWatermarks", opt: func(*optionEnv) Option { return ConnectionManagerWatermarks(10, 20, time.Second) }},
+	{
+		name:    "ConnectionManagerInvalid",
+		opt:     func(*optionEnv) Option { return ConnectionManagerWatermarks(20, 10, 0) },
+		invalid: true,
+	},
+	{name: "DialTimeout", opt: func(*optionEnv) Option { return DialTimeout(time.Second) }},
+	{name: "NegotiationTimeout", opt: func(*optionEnv) Option { return NegotiationTimeout(time.Minute) }},
+	{name: "MaxInboundConns", opt: func(*optionEnv) Option { return MaxInboundConns(100) }},
+	{name: "MaxConnsPerPeer", opt: func(*optionEnv) Option { return MaxConnsPerPeer(1) }},
+	{
+		name:     "PruneGracePeriod",
+		opt:      func(*optionEnv) Option { return PruneGracePeriod(time.Second) },
+		requires: []string{"MaxConnsPerPeer"},
+	},
+	{
+		name: "BootstrapPeers",
+		opt: func(*optionEnv) Option {
+			return BootstrapPeers("/ip4/127.0.0.1/tcp/1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+		},
+	},
+	{
+		name: "StaticPeers",
+		opt: func(*optionEnv) Option {
+			infos, _ := ParsePeerAddrs("/ip4/127.0.0.1/tcp/2/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ")
+			return StaticPeers(infos...)
+		},
+	},
+	{
+		name:     "KeepBootstrapped",
+		opt:      func(*optionEnv) Option { return KeepBootstrapped() },
+		requires: []string{"BootstrapPeers"},
+	},
+	{
+		name:      "DialBackoff",
+		opt:       func(*optionEnv) Option { return DialBackoff(time.Second, time.Minute) },
+		conflicts: []string{"DisableDialBackoff"},
+	},
+	{name: "DisableDialBackoff", opt: func(*optionEnv) Option { return DisableDialBackoff() }},
+	{
+		name: "ResourceLimits",
+		opt: func(*optionEnv) Option {
+			return ResourceLimits(ResourceLimitOpts{MaxStreams: 100, MaxStreamBuffer: 1 << 20})
+		},
+		conflicts: []string{"Muxer"},
+	},
+	{name: "AddressTTLs", opt: func(*optionEnv) Option { return AddressTTLs(0, 0, time.Minute, 0) }},
+	{
+		name: "PeerstoreSnapshot",
+		opt: func(env *optionEnv) Option {
+			return PeerstoreSnapshot(filepath.Join(env.dir, "peers"), time.Minute)
+		},
+	},
+	{name: "LatencySmoothing", opt: func(*optionEnv) Option { return LatencySmoothing(0.5) }},
+	{name: "Ping", opt: func(*optionEnv) Option { return Ping(true) }},
+	{name: "UserAgent", opt: func(*optionEnv) Option { return UserAgent("test/1.0") }},
+	{name: "ProtocolVersion", opt: func(*optionEnv) Option { return ProtocolVersion("test/1.0.0") }},
+	{name: "RequireProtocolVersionMatch", opt: func(*optionEnv) Option { return RequireProtocolVersionMatch() }},
+	{
+		name:      "DisableIdentify",
+		opt:       func(*optionEnv) Option { return DisableIdentify() },
+		conflicts: []string{"UserAgent", "ProtocolVersion", "RequireProtocolVersionMatch", "RevalidateAnnounceAddrs", "EnableAutoNAT"},
+	},
+	{
+		name: "Routing",
+		opt: func(*optionEnv) Option {
+			return Routing(func(host.Host) (routed.Routing, error) { return nullRouting{}, nil })
+		},
+	},
+	{
+		name:     "DisableRoutingFallback",
+		opt:      func(*optionEnv) Option { return DisableRoutingFallback() },
+		requires: []string{"Routing"},
+	},
+	{
+		name: "Bootstrap",
+		opt: func(*optionEnv) Option {
+			return Bootstrap(BootstrapConfig{MinPeers: 4, Period: time.Minute})
+		},
+	},
+	{name: "Discovery", opt: func(*optionEnv) Option { return memoryDiscovery(discovery.NewMemoryRegistry()) }},
+	{name: "KeyBookLimit", opt: func(*optionEnv) Option { return KeyBookLimit(1 << 20) }},
+	{name: "Peerstore", opt: func(*optionEnv) Option { return Peerstore(pstore.NewPeerstore()) }},
+	{name: "Muxer", opt: func(*optionEnv) Option { return Muxer(DefaultMuxer()) }},
+	{name: "BandwidthReporter", opt: func(*optionEnv) Option { return BandwidthReporter(metrics.NewBandwidthCounter()) }},
+	{
+		name:      "AnnounceAddrs",
+		opt:       func(*optionEnv) Option { return AnnounceAddrStrings("/ip4/1.2.3.4/tcp/4001") },
+		conflicts: []string{"AddrsFactory"},
+	},
+	{
+		name:      "AppendAnnounceAddrs",
+		opt:       func(*optionEnv) Option { return AppendAnnounceAddrs(ma.StringCast("/ip4/1.2.3.4/tcp/4002")) },
+		conflicts: []string{"AddrsFactory"},
+	},
+	{
+		name:      "NoAnnounce",
+		opt:       func(*optionEnv) Option { return NoAnnounce("/ip4/10.0.0.0/ipcidr/8") },
+		conflicts: []string{"AddrsFactory"},
+	},
+	{
+		name:    "NoAnnounceInvalid",
+		opt:     func(*optionEnv) Option { return NoAnnounce("/ip4/10.0.0.0/ipcidr/33") },
+		invalid: true,
+	},
+	{name: "RevalidateAnnounceAddrs", opt: func(*optionEnv) Option { return RevalidateAnnounceAddrs(time.Minute, 3) }},
+	{name: "OnlyPublicAddrs", opt: func(*optionEnv) Option { return OnlyPublicAddrs() }},
+	{
+		name: "AddrsFactory",
+		opt: func(*optionEnv) Option {
+			return AddrsFactory(func(addrs []ma.Multiaddr) []ma.Multiaddr { return addrs })
+		},
+	},
+	{name: "AddrsUpdateInterval", opt: func(*optionEnv) Option { return AddrsUpdateInterval(time.Second) }},
+	{name: "DisableObservedAddrs", opt: func(*optionEnv) Option { return DisableObservedAddrs() }},
+	{name: "OrderedNotifications", opt: func(*optionEnv) Option { return OrderedNotifications() }},
+	{name: "ConnSelectionPolicy", opt: func(*optionEnv) Option { return ConnSelectionPolicy(bhost.DefaultConnSelectionPolicy) }},
+	{name: "ConnEstablishmentBudget", opt: func(*optionEnv) Option { return ConnEstablishmentBudget(4) }},
+	{name: "FilterSubnets", opt: func(*optionEnv) Option { return FilterSubnets("10.0.0.0/8") }},
+	{name: "MultiaddrResolver", opt: func(*optionEnv) Option { return MultiaddrResolver(madns.DefaultResolver) }},
+	{name: "ConstructionTimeout", opt: func(*optionEnv) Option { return ConstructionTimeout(time.Minute) }},
+	{
+		name: "EventLog",
+		opt: func(env *optionEnv) Option {
+			return EventLog(filepath.Join(env.dir, "events.log"), 1<<16)
+		},
+	},
+	{
+		name:      "FailureDedup",
+		opt:       func(*optionEnv) Option { return FailureDedup(time.Second) },
+		conflicts: []string{"DisableFailureDedup"},
+	},
+	{name: "DisableFailureDedup", opt: func(*optionEnv) Option { return DisableFailureDedup() }},
+}
+
+// expectInvalid reports why the combination is invalid, if it is.
+func expectInvalid(combo []catalogOption) string {
+	has := make(map[string]bool)
+	for _, o := range combo {
+		has[o.name] = true
+	}
+	for _, o := range combo {
+		if o.invalid {
+			return o.name + " is invalid"
+		}
+		for _, c := range o.conflicts {
+			if has[c] {
+				return o.name + " conflicts with " + c
+			}
+		}
+		for _, r := range o.requires {
+			if !has[r] {
+				return o.name + " requires " + r
+			}
+		}
+	}
+	return ""
+}
+
+func comboNames(combo []catalogOption) string {
+	names := make([]string, len(combo))
+	for i, o := range combo {
+		names[i] = o.name
+	}
+	return strings.Join(names, " + ")
+}
+
+// randomCombo picks up to max distinct catalog options, in random order.
+func randomCombo(r *rand.Rand, max int) []catalogOption {
+	n := r.Intn(max + 1)
+	combo := make([]catalogOption, 0, n)
+	for _, i := range r.Perm(len(optionCatalog))[:n] {
+		combo = append(combo, optionCatalog[i])
+	}
+	return combo
+}
+
+// runCombo builds a node from combo and closes it, failing t if New panics,
+// hangs, fails with an unexpected error or succeeds unexpectedly.
+func runCombo(t *testing.T, env *optionEnv, combo []catalogOption) {
+	opts := make([]Option, 0, len(combo)+1)
+	keyed := false
+	for _, o := range combo {
+		opts = append(opts, o.opt(env))
+		keyed = keyed || o.name == "Identity" || o.name == "RandomIdentity"
+	}
+	if !keyed {
+		// avoid generating an RSA key for every combination.
+		opts = append(opts, RandomIdentity(crypto.Ed25519, 0))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		h, err := New(context.Background(), opts...)
+		if err == nil {
+			err = h.Close()
+			if err != nil {
+				err = fmt.Errorf("close: %s", err)
+			}
+		} else if _, ok := err.(*ConfigError); !ok {
+			err = fmt.Errorf("expected a *ConfigError, got %T: %s", err, err)
+		} else {
+			err = errExpectedInvalid{err}
+		}
+		done <- err
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s: New or Close hung", comboNames(combo))
+	}
+
+	reason := expectInvalid(combo)
+	switch e := err.(type) {
+	case nil:
+		if reason != "" {
+			t.Fatalf("%s: expected a *ConfigError, as %s", comboNames(combo), reason)
+		}
+	case errExpectedInvalid:
+		if reason == "" {
+			t.Fatalf("%s: unexpected error: %s", comboNames(combo), e.err)
+		}
+	default:
+		t.Fatalf("%s: %s", comboNames(combo), err)
+	}
+}
+
+type errExpectedInvalid struct {
+	err error
+}
+
+func (e errExpectedInvalid) Error() string {
+	return e.err.Error()
+}
+
+func TestOptionCombinations(t *testing.T) {
+	seed := *optionSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	t.Logf("seed %d", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	dir, err := ioutil.TempDir("", "libp2p-options")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	env := newOptionEnv(t, dir)
+
+	// warm up, so that lazily started background goroutines don't count
+	// as leaks.
+	runCombo(t, env, []catalogOption{optionCatalog[0]})
+	baseline := runtime.NumGoroutine()
+
+	for i := 0; i < *optionIterations; i++ {
+		runCombo(t, env, randomCombo(r, 6))
+	}
+
+	// every node was closed, their goroutines must go away.
+	deadline := time.Now().Add(10 * time.Second)
+	for runtime.NumGoroutine() > baseline+10 {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("goroutines leaked: %d, up from %d\n%s", runtime.NumGoroutine(), baseline,
+				buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestKnownInvalidOptionCombinations(t *testing.T) {
+	env := newOptionEnv(t, os.TempDir())
+
+	byName := make(map[string]catalogOption)
+	for _, o := range optionCatalog {
+		byName[o.name] = o
+	}
+	for _, names := range [][]string{
+		{"ListenUDP", "StrictListenAddrs"},
+		{"ListenCircuit"},
+		{"ListenInvalid"},
+		{"Identity", "RandomIdentity"},
+		{"ExpectedPeerID", "RandomIdentity"},
+		{"AnnounceAddrs", "AddrsFactory"},
+		{"NoAnnounce", "AddrsFactory"},
+		{"NoAnnounceInvalid"},
+		{"FailureDedup", "DisableFailureDedup"},
+		{"DisableFailureDedup", "FailureDedup"},
+	} {
+		combo := make([]catalogOption, len(names))
+		for i, n := range names {
+			combo[i] = byName[n]
+		}
+		if expectInvalid(combo) == "" {
+			t.Fatalf("%s: catalog doesn't describe the combination as invalid", comboNames(combo))
+		}
+		runCombo(t, env, combo)
+	}
+}
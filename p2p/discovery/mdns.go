@@ -23,6 +23,10 @@ var log = logging.Logger("mdns")
 
 const ServiceTag = "_ipfs-discovery._udp"
 
+// AddrTTL is the TTL of the addresses of discovered peers, added to the
+// peerstore of the host.
+var AddrTTL = pstore.RecentlyConnectedAddrTTL
+
 type Service interface {
 	io.Closer
 	RegisterNotifee(Notifee)
@@ -42,20 +46,34 @@ type mdnsService struct {
 	lk       sync.Mutex
 	notifees []Notifee
 	interval time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
+// getDialableListenAddrs returns the TCP addresses of the host, leaving
+// loopback ones out unless there are no others, as on machines without a
+// network.
 func getDialableListenAddrs(ph host.Host) ([]*net.TCPAddr, error) {
-	var out []*net.TCPAddr
+	var out, loopback []*net.TCPAddr
 	for _, addr := range ph.Addrs() {
 		na, err := manet.ToNetAddr(addr)
 		if err != nil {
 			continue
 		}
 		tcp, ok := na.(*net.TCPAddr)
-		if ok {
+		if !ok {
+			continue
+		}
+		if manet.IsIPLoopback(addr) {
+			loopback = append(loopback, tcp)
+		} else {
 			out = append(out, tcp)
 		}
 	}
+	if len(out) == 0 {
+		out = loopback
+	}
 	if len(out) == 0 {
 		return nil, errors.New("failed to find good external addr from peerhost")
 	}
@@ -97,12 +115,15 @@ func NewMdnsService(ctx context.Context, peerhost host.Host, interval time.Durat
 		return nil, err
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
 	s := &mdnsService{
 		server:   server,
 		service:  service,
 		host:     peerhost,
 		interval: interval,
 		tag:      serviceTag,
+		cancel:   cancel,
+		done:     make(chan struct{}),
 	}
 
 	go s.pollForEntries(ctx)
@@ -110,13 +131,18 @@ func NewMdnsService(ctx context.Context, peerhost host.Host, interval time.Durat
 	return s, nil
 }
 
+// Close stops querying for peers and answering queries.
 func (m *mdnsService) Close() error {
+	m.cancel()
+	<-m.done
 	return m.server.Shutdown()
 }
 
 func (m *mdnsService) pollForEntries(ctx context.Context) {
+	defer close(m.done)
 
 	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
 	for {
 		//execute mdns query right away at method call and then with every tick
 		entriesCh := make(chan *mdns.ServiceEntry, 16)
@@ -177,6 +203,7 @@ func (m *mdnsService) handleEntry(e *mdns.ServiceEntry) {
 		ID:    mpeer,
 		Addrs: []ma.Multiaddr{maddr},
 	}
+	m.host.Peerstore().AddAddrs(pi.ID, pi.Addrs, AddrTTL)
 
 	m.lk.Lock()
 	for _, n := range m.notifees {
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// MemoryAdTTL is the TTL of the advertisements made in a MemoryRegistry.
+var MemoryAdTTL = time.Hour
+
+// MemoryRegistry is a rendezvous point held in memory, shared by the hosts
+// of a process, for tests. Each host advertises itself and finds its peers
+// through the MemoryDiscovery returned by Discovery.
+type MemoryRegistry struct {
+	mu  sync.Mutex
+	ads map[string]map[peer.ID]memoryAd
+}
+
+type memoryAd struct {
+	h       host.Host
+	expires time.Time
+}
+
+// NewMemoryRegistry returns an empty registry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{ads: make(map[string]map[peer.ID]memoryAd)}
+}
+
+// Discovery returns the discovery h uses to advertise itself in r.
+func (r *MemoryRegistry) Discovery(h host.Host) *MemoryDiscovery {
+	return &MemoryDiscovery{r: r, h: h}
+}
+
+// MemoryDiscovery is the view of a MemoryRegistry from a host. It
+// implements basichost.Discovery.
+type MemoryDiscovery struct {
+	r *MemoryRegistry
+	h host.Host
+}
+
+// Advertise advertises the host in ns for MemoryAdTTL.
+func (d *MemoryDiscovery) Advertise(ctx context.Context, ns string) (time.Duration, error) {
+	d.r.mu.Lock()
+	defer d.r.mu.Unlock()
+	ads, ok := d.r.ads[ns]
+	if !ok {
+		ads = make(map[peer.ID]memoryAd)
+		d.r.ads[ns] = ads
+	}
+	ads[d.h.ID()] = memoryAd{h: d.h, expires: time.Now().Add(MemoryAdTTL)}
+	return MemoryAdTTL, nil
+}
+
+// FindPeers returns the hosts advertised in ns, with their current
+// addresses, the host itself included.
+func (d *MemoryDiscovery) FindPeers(ctx context.Context, ns string) (<-chan pstore.PeerInfo, error) {
+	d.r.mu.Lock()
+	now := time.Now()
+	var found []host.Host
+	for p, ad := range d.r.ads[ns] {
+		if now.After(ad.expires) {
+			delete(d.r.ads[ns], p)
+			continue
+		}
+		found = append(found, ad.h)
+	}
+	d.r.mu.Unlock()
+
+	ch := make(chan pstore.PeerInfo, len(found))
+	for _, h := range found {
+		ch <- pstore.PeerInfo{ID: h.ID(), Addrs: h.Addrs()}
+	}
+	close(ch)
+	return ch, nil
+}
@@ -0,0 +1,60 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	netutil "github.com/libp2p/go-libp2p-netutil"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := bhost.New(netutil.GenSwarmNetwork(t, ctx))
+	defer a.Close()
+	b := bhost.New(netutil.GenSwarmNetwork(t, ctx))
+	defer b.Close()
+
+	reg := NewMemoryRegistry()
+	ttl, err := reg.Discovery(a).Advertise(ctx, "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != MemoryAdTTL {
+		t.Fatalf("expected a TTL of %s, got %s", MemoryAdTTL, ttl)
+	}
+
+	ch, err := reg.Discovery(b).FindPeers(ctx, "ns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []string
+	for pi := range ch {
+		if len(pi.Addrs) == 0 {
+			t.Fatal("expected the addresses of the advertised host")
+		}
+		found = append(found, pi.ID.Pretty())
+	}
+	if len(found) != 1 || found[0] != a.ID().Pretty() {
+		t.Fatalf("expected to find %s, got %v", a.ID().Pretty(), found)
+	}
+
+	ch, _ = reg.Discovery(b).FindPeers(ctx, "other")
+	if _, ok := <-ch; ok {
+		t.Fatal("expected nothing in another namespace")
+	}
+
+	oldTTL := MemoryAdTTL
+	MemoryAdTTL = time.Millisecond
+	defer func() { MemoryAdTTL = oldTTL }()
+	reg.Discovery(b).Advertise(ctx, "short")
+	time.Sleep(10 * time.Millisecond)
+	ch, _ = reg.Discovery(a).FindPeers(ctx, "short")
+	if _, ok := <-ch; ok {
+		t.Fatal("expected expired advertisements to be dropped")
+	}
+}
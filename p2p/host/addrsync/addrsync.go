@@ -0,0 +1,388 @@
+// Package addrsync keeps a versioned account of the addresses learned about
+// peers, so that nodes of a cluster can share them incrementally.
+//
+// A Book records the addresses added to a peerstore through it, either with
+// Book.AddAddrs or through the peerstore returned by Book.Peerstore, which
+// the host can use to feed it everything it learns. DiffSince produces the
+// records that changed after a given sequence number, which Marshal turns
+// into a compact, versioned wire format. On the other end, ApplyDiff merges
+// the records into the local book and peerstore: certified addresses beat
+// hearsay, and otherwise the most recently seen record wins. Exchanging the
+// diffs is up to the application.
+//
+// Addresses removed from the peerstore through the book, or whose TTL ran
+// out, leave a tombstone record behind, so that diffs carry the removal.
+// Tombstones are dropped after TombstoneTTL: a node that doesn't catch up
+// within that time should start over from DiffSince(0).
+package addrsync
+
+import (
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// TombstoneTTL is how long the records of removed addresses are kept.
+var TombstoneTTL = time.Hour
+
+// TTLClass is a coarse version of a peerstore address TTL, meaningful to
+// other nodes.
+type TTLClass uint8
+
+const (
+	// TTLTemp is for addresses of unknown quality.
+	TTLTemp TTLClass = iota
+
+	// TTLProvider is for addresses learned from content routing.
+	TTLProvider
+
+	// TTLRecentlyConnected is for addresses a connection was made on.
+	TTLRecentlyConnected
+
+	// TTLPermanent is for addresses which are known to be good.
+	TTLPermanent
+
+	maxTTLClass = TTLPermanent
+)
+
+// ClassOf returns the class of a peerstore address TTL.
+func ClassOf(ttl time.Duration) TTLClass {
+	switch {
+	case ttl == pstore.ConnectedAddrTTL:
+		// the connection is ours, not the receiver's.
+		return TTLRecentlyConnected
+	case ttl >= pstore.PermanentAddrTTL:
+		return TTLPermanent
+	case ttl >= pstore.RecentlyConnectedAddrTTL:
+		return TTLRecentlyConnected
+	case ttl >= pstore.ProviderAddrTTL:
+		return TTLProvider
+	default:
+		return TTLTemp
+	}
+}
+
+// TTL returns the peerstore TTL for addresses of class c.
+func (c TTLClass) TTL() time.Duration {
+	switch c {
+	case TTLPermanent:
+		return pstore.PermanentAddrTTL
+	case TTLRecentlyConnected:
+		return pstore.RecentlyConnectedAddrTTL
+	case TTLProvider:
+		return pstore.ProviderAddrTTL
+	default:
+		return pstore.TempAddrTTL
+	}
+}
+
+// AddrRecord is what is known about an address of a peer. Removed records
+// are tombstones: the address was removed, or expired, at LastSeen.
+type AddrRecord struct {
+	Addr      ma.Multiaddr
+	TTL       TTLClass
+	Certified bool
+	Removed   bool
+	LastSeen  time.Time
+}
+
+// supersedes reports whether r should replace old.
+func (r AddrRecord) supersedes(old AddrRecord) bool {
+	if r.Certified != old.Certified {
+		return r.Certified
+	}
+	return r.LastSeen.After(old.LastSeen)
+}
+
+// PeerRecord holds the address records of a peer.
+type PeerRecord struct {
+	ID    peer.ID
+	Addrs []AddrRecord
+}
+
+// Diff is an incremental update of a Book. Seq is the sequence number of
+// the book it was taken from, to pass to the next DiffSince call.
+type Diff struct {
+	Seq   uint64
+	Peers []PeerRecord
+}
+
+type addrState struct {
+	AddrRecord
+	seq uint64
+
+	// ttl is the peerstore TTL the address was stored with, and expires
+	// when it runs out, zero for permanent addresses.
+	ttl     time.Duration
+	expires time.Time
+}
+
+// expiry returns when an address seen at t with the given TTL expires, or
+// the zero time if it doesn't.
+func expiry(t time.Time, ttl time.Duration) time.Time {
+	if ttl >= pstore.PermanentAddrTTL {
+		return time.Time{}
+	}
+	return t.Add(ttl)
+}
+
+func (st *addrState) expired(now time.Time) bool {
+	return !st.expires.IsZero() && now.After(st.expires)
+}
+
+// Book records the addresses of peers, along with the sequence number of
+// their last change. It is safe for concurrent use.
+type Book struct {
+	mu    sync.Mutex
+	ps    pstore.Peerstore
+	seq   uint64
+	peers map[peer.ID]map[string]*addrState
+}
+
+// NewBook returns a Book storing the addresses it learns in ps.
+func NewBook(ps pstore.Peerstore) *Book {
+	return &Book{
+		ps:    ps,
+		peers: make(map[peer.ID]map[string]*addrState),
+	}
+}
+
+// Seq returns the book's current sequence number.
+func (b *Book) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// AddAddrs adds addresses of p to the peerstore, and records them.
+// Certified is true when the addresses come from p itself, e.g. through
+// identify, rather than from a third party.
+func (b *Book) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, certified bool) {
+	b.ps.AddAddrs(p, addrs, ttl)
+	b.record(p, addrs, ttl, certified)
+}
+
+// record records addresses of p, just stored in the peerstore with ttl.
+func (b *Book) record(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration, certified bool) {
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	class := ClassOf(ttl)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, a := range addrs {
+		b.update(p, AddrRecord{Addr: a, TTL: class, Certified: certified, LastSeen: now}, ttl, true)
+	}
+}
+
+// update stores r, of an address stored in the peerstore with ttl, unless a
+// record superseding it is already known, and reports whether it did.
+// Local records, of addresses just stored in our own peerstore, always
+// replace tombstones.
+func (b *Book) update(p peer.ID, r AddrRecord, ttl time.Duration, local bool) bool {
+	addrs, ok := b.peers[p]
+	if !ok {
+		addrs = make(map[string]*addrState)
+		b.peers[p] = addrs
+	}
+
+	key := string(r.Addr.Bytes())
+	expires := expiry(r.LastSeen, ttl)
+	if r.Removed {
+		expires = r.LastSeen.Add(TombstoneTTL)
+	}
+	old, ok := addrs[key]
+	if ok && !(local && old.Removed) && !r.supersedes(old.AddrRecord) {
+		return false
+	}
+	// like the peerstore, keep the longest TTL.
+	if ok && !old.Removed && !r.Removed &&
+		(old.expires.IsZero() || !expires.IsZero() && old.expires.After(expires)) {
+		r.TTL, ttl, expires = old.TTL, old.ttl, old.expires
+	}
+	b.seq++
+	addrs[key] = &addrState{AddrRecord: r, seq: b.seq, ttl: ttl, expires: expires}
+	return true
+}
+
+// bury replaces the record of st with a tombstone, a change of the book.
+func (b *Book) bury(st *addrState, now time.Time) {
+	b.seq++
+	st.seq = b.seq
+	st.Removed = true
+	st.LastSeen = now
+	st.expires = now.Add(TombstoneTTL)
+}
+
+// expire buries the records of addresses whose TTL ran out, and drops the
+// tombstones older than TombstoneTTL.
+func (b *Book) expire(now time.Time) {
+	for p, addrs := range b.peers {
+		for key, st := range addrs {
+			if !st.expired(now) {
+				continue
+			}
+			if st.Removed {
+				delete(addrs, key)
+			} else {
+				b.bury(st, now)
+			}
+		}
+		if len(addrs) == 0 {
+			delete(b.peers, p)
+		}
+	}
+}
+
+// remove buries the records of the given addresses of p, or all of them if
+// addrs is nil.
+func (b *Book) remove(p peer.ID, addrs []ma.Multiaddr) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for key, st := range b.peers[p] {
+		if st.Removed {
+			continue
+		}
+		if addrs != nil && !containsKey(addrs, key) {
+			continue
+		}
+		b.bury(st, now)
+	}
+}
+
+func containsKey(addrs []ma.Multiaddr, key string) bool {
+	for _, a := range addrs {
+		if string(a.Bytes()) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// updateTTL mirrors pstore.Peerstore.UpdateAddrs: the addresses of p stored
+// with oldTTL now have newTTL.
+func (b *Book) updateTTL(p peer.ID, oldTTL, newTTL time.Duration) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, st := range b.peers[p] {
+		if st.Removed || st.ttl != oldTTL {
+			continue
+		}
+		if newTTL <= 0 {
+			b.bury(st, now)
+			continue
+		}
+		st.ttl = newTTL
+		st.expires = expiry(now, newTTL)
+		if class := ClassOf(newTTL); class != st.TTL {
+			b.seq++
+			st.TTL = class
+			st.seq = b.seq
+		}
+	}
+}
+
+// DiffSince returns the address records that changed after seq, tombstones
+// included. Pass 0 to get all of them.
+func (b *Book) DiffSince(seq uint64) *Diff {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire(time.Now())
+	d := &Diff{Seq: b.seq}
+	for p, addrs := range b.peers {
+		var pr PeerRecord
+		for _, st := range addrs {
+			if st.seq > seq {
+				pr.Addrs = append(pr.Addrs, st.AddrRecord)
+			}
+		}
+		if len(pr.Addrs) > 0 {
+			pr.ID = p
+			d.Peers = append(d.Peers, pr)
+		}
+	}
+	return d
+}
+
+// ApplyDiff merges the records of d, typically from another node, into the
+// book and its peerstore. Records superseded by what the book already knows
+// are ignored; the others are changes of the book in turn. Tombstones
+// remove their address from the peerstore.
+func (b *Book) ApplyDiff(d *Diff) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	for _, pr := range d.Peers {
+		for _, r := range pr.Addrs {
+			ttl := r.TTL.TTL()
+			if r.Removed {
+				if now.Sub(r.LastSeen) <= TombstoneTTL && b.update(pr.ID, r, ttl, false) {
+					b.ps.SetAddr(pr.ID, r.Addr, 0)
+				}
+				continue
+			}
+			if exp := expiry(r.LastSeen, ttl); !exp.IsZero() && now.After(exp) {
+				continue
+			}
+			if b.update(pr.ID, r, ttl, false) {
+				b.ps.AddAddr(pr.ID, r.Addr, ttl)
+			}
+		}
+	}
+}
+
+// Peerstore returns the book's peerstore, wrapped so that the addresses
+// added or set through it are recorded in the book, as hearsay, and the
+// ones cleared, or updated to a zero TTL, are buried in it. Addresses
+// a peer certified must still be added with Book.AddAddrs.
+func (b *Book) Peerstore() pstore.Peerstore {
+	return &bookPeerstore{Peerstore: b.ps, book: b}
+}
+
+type bookPeerstore struct {
+	pstore.Peerstore
+	book *Book
+}
+
+func (ps *bookPeerstore) AddAddr(p peer.ID, a ma.Multiaddr, ttl time.Duration) {
+	ps.AddAddrs(p, []ma.Multiaddr{a}, ttl)
+}
+
+func (ps *bookPeerstore) AddAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.AddAddrs(p, addrs, ttl)
+	ps.book.record(p, addrs, ttl, false)
+}
+
+func (ps *bookPeerstore) SetAddr(p peer.ID, a ma.Multiaddr, ttl time.Duration) {
+	ps.SetAddrs(p, []ma.Multiaddr{a}, ttl)
+}
+
+func (ps *bookPeerstore) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duration) {
+	ps.Peerstore.SetAddrs(p, addrs, ttl)
+	if ttl <= 0 {
+		ps.book.remove(p, addrs)
+		return
+	}
+	ps.book.record(p, addrs, ttl, false)
+}
+
+func (ps *bookPeerstore) UpdateAddrs(p peer.ID, oldTTL, newTTL time.Duration) {
+	ps.Peerstore.UpdateAddrs(p, oldTTL, newTTL)
+	ps.book.updateTTL(p, oldTTL, newTTL)
+}
+
+func (ps *bookPeerstore) ClearAddrs(p peer.ID) {
+	ps.Peerstore.ClearAddrs(p)
+	ps.book.remove(p, nil)
+}
@@ -0,0 +1,279 @@
+package addrsync
+
+import (
+	"testing"
+	"time"
+
+	testutil "github.com/libp2p/go-libp2p-netutil"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func randPeer(t *testing.T) peer.ID {
+	p, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func findRecord(d *Diff, p peer.ID, a ma.Multiaddr) (AddrRecord, bool) {
+	for _, pr := range d.Peers {
+		if pr.ID != p {
+			continue
+		}
+		for _, r := range pr.Addrs {
+			if r.Addr.Equal(a) {
+				return r, true
+			}
+		}
+	}
+	return AddrRecord{}, false
+}
+
+func TestRoundTrip(t *testing.T) {
+	p := randPeer(t)
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	a2 := ma.StringCast("/ip6/::1/tcp/4001")
+	a3 := ma.StringCast("/ip4/5.6.7.8/udp/4001")
+
+	b := NewBook(pstore.NewPeerstore())
+	b.AddAddrs(p, []ma.Multiaddr{a1}, pstore.PermanentAddrTTL, true)
+	b.AddAddrs(p, []ma.Multiaddr{a2, a3}, pstore.TempAddrTTL, false)
+	b.Peerstore().SetAddr(p, a3, 0)
+
+	d := b.DiffSince(0)
+	out, err := Unmarshal(d.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Seq != d.Seq {
+		t.Fatalf("expected seq %d, got %d", d.Seq, out.Seq)
+	}
+
+	for _, a := range []ma.Multiaddr{a1, a2, a3} {
+		want, _ := findRecord(d, p, a)
+		got, ok := findRecord(out, p, a)
+		if !ok {
+			t.Fatalf("record for %s lost", a)
+		}
+		if got.TTL != want.TTL || got.Certified != want.Certified || got.Removed != want.Removed ||
+			!got.LastSeen.Equal(want.LastSeen) {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+	}
+
+	if r, _ := findRecord(out, p, a1); r.TTL != TTLPermanent || !r.Certified {
+		t.Fatalf("unexpected record for %s: %+v", a1, r)
+	}
+	if r, _ := findRecord(out, p, a3); !r.Removed {
+		t.Fatalf("expected a tombstone for %s, got %+v", a3, r)
+	}
+
+	if _, err := Unmarshal(append([]byte{Version + 1}, d.Marshal()[1:]...)); err != ErrUnknownVersion {
+		t.Fatalf("expected ErrUnknownVersion, got %v", err)
+	}
+	enc := d.Marshal()
+	if _, err := Unmarshal(enc[:len(enc)-1]); err == nil {
+		t.Fatal("expected a truncated diff to be rejected")
+	}
+}
+
+func TestIncrementalDiffs(t *testing.T) {
+	p1, p2 := randPeer(t), randPeer(t)
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	a2 := ma.StringCast("/ip4/5.6.7.8/tcp/4001")
+
+	b := NewBook(pstore.NewPeerstore())
+	b.AddAddrs(p1, []ma.Multiaddr{a1}, pstore.TempAddrTTL, false)
+	seq := b.Seq()
+
+	if d := b.DiffSince(seq); len(d.Peers) != 0 {
+		t.Fatalf("expected an empty diff, got %+v", d)
+	}
+
+	b.AddAddrs(p2, []ma.Multiaddr{a2}, pstore.TempAddrTTL, false)
+	d := b.DiffSince(seq)
+	if len(d.Peers) != 1 || d.Peers[0].ID != p2 || len(d.Peers[0].Addrs) != 1 {
+		t.Fatalf("expected only the new address, got %+v", d)
+	}
+	if d.Seq != b.Seq() {
+		t.Fatalf("expected diff seq %d, got %d", b.Seq(), d.Seq)
+	}
+
+	// seeing an address again is a change.
+	time.Sleep(time.Millisecond)
+	b.AddAddrs(p1, []ma.Multiaddr{a1}, pstore.TempAddrTTL, false)
+	d = b.DiffSince(d.Seq)
+	if _, ok := findRecord(d, p1, a1); !ok || len(d.Peers) != 1 {
+		t.Fatalf("expected the refreshed address, got %+v", d)
+	}
+}
+
+func TestApplyDiffPrecedence(t *testing.T) {
+	p := randPeer(t)
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	now := time.Now()
+
+	ps := pstore.NewPeerstore()
+	b := NewBook(ps)
+
+	apply := func(r AddrRecord) {
+		r.Addr = a
+		b.ApplyDiff(&Diff{Peers: []PeerRecord{{ID: p, Addrs: []AddrRecord{r}}}})
+	}
+	current := func() AddrRecord {
+		r, ok := findRecord(b.DiffSince(0), p, a)
+		if !ok {
+			t.Fatal("record missing")
+		}
+		return r
+	}
+
+	apply(AddrRecord{TTL: TTLTemp, LastSeen: now})
+	if len(ps.Addrs(p)) != 1 {
+		t.Fatal("expected the address to be added to the peerstore")
+	}
+
+	// newer beats older.
+	apply(AddrRecord{TTL: TTLProvider, LastSeen: now.Add(time.Second)})
+	if r := current(); r.TTL != TTLProvider {
+		t.Fatalf("expected the newer record to win, got %+v", r)
+	}
+	seq := b.Seq()
+	apply(AddrRecord{TTL: TTLTemp, LastSeen: now})
+	if r := current(); r.TTL != TTLProvider || b.Seq() != seq {
+		t.Fatalf("expected the older record to be ignored, got %+v", r)
+	}
+
+	// certified beats hearsay, even older.
+	apply(AddrRecord{TTL: TTLPermanent, Certified: true, LastSeen: now.Add(-time.Hour)})
+	if r := current(); !r.Certified || r.TTL != TTLPermanent {
+		t.Fatalf("expected the certified record to win, got %+v", r)
+	}
+	apply(AddrRecord{TTL: TTLTemp, LastSeen: now.Add(time.Hour)})
+	if r := current(); !r.Certified {
+		t.Fatalf("expected hearsay not to override a certified record, got %+v", r)
+	}
+}
+
+func TestPeerstoreFeedsBook(t *testing.T) {
+	p := randPeer(t)
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	a2 := ma.StringCast("/ip4/5.6.7.8/tcp/4001")
+
+	b := NewBook(pstore.NewPeerstore())
+	ps := b.Peerstore()
+
+	ps.AddAddrs(p, []ma.Multiaddr{a1}, pstore.PermanentAddrTTL)
+	ps.SetAddr(p, a2, pstore.ConnectedAddrTTL)
+	d := b.DiffSince(0)
+	if r, ok := findRecord(d, p, a1); !ok || r.TTL != TTLPermanent || r.Certified {
+		t.Fatalf("expected a hearsay record for %s, got %+v", a1, d)
+	}
+	if r, ok := findRecord(d, p, a2); !ok || r.TTL != TTLRecentlyConnected {
+		t.Fatalf("expected a record for %s, got %+v", a2, d)
+	}
+
+	// addresses set to a zero TTL are buried.
+	seq := b.Seq()
+	ps.UpdateAddrs(p, pstore.ConnectedAddrTTL, 0)
+	if r, ok := findRecord(b.DiffSince(seq), p, a2); !ok || !r.Removed {
+		t.Fatalf("expected a tombstone for %s, got %+v", a2, r)
+	}
+
+	seq = b.Seq()
+	ps.ClearAddrs(p)
+	d = b.DiffSince(seq)
+	if r, ok := findRecord(d, p, a1); !ok || !r.Removed {
+		t.Fatalf("expected a tombstone for %s, got %+v", a1, r)
+	}
+	if _, ok := findRecord(d, p, a2); ok {
+		t.Fatalf("expected %s not to be buried twice", a2)
+	}
+
+	// adding an address back replaces its tombstone.
+	ps.AddAddr(p, a1, pstore.TempAddrTTL)
+	if r, _ := findRecord(b.DiffSince(0), p, a1); r.Removed {
+		t.Fatalf("expected %s to be live again", a1)
+	}
+}
+
+func TestRemovalsPropagate(t *testing.T) {
+	p := randPeer(t)
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	a2 := ma.StringCast("/ip4/5.6.7.8/tcp/4001")
+
+	src := NewBook(pstore.NewPeerstore())
+	src.AddAddrs(p, []ma.Multiaddr{a1, a2}, pstore.PermanentAddrTTL, false)
+
+	dst := NewBook(pstore.NewPeerstore())
+	dst.ApplyDiff(src.DiffSince(0))
+	if n := len(dst.ps.Addrs(p)); n != 2 {
+		t.Fatalf("expected 2 addresses, got %d", n)
+	}
+
+	seq := src.Seq()
+	src.Peerstore().SetAddr(p, a1, 0)
+	d, err := Unmarshal(src.DiffSince(seq).Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst.ApplyDiff(d)
+
+	addrs := dst.ps.Addrs(p)
+	if len(addrs) != 1 || !addrs[0].Equal(a2) {
+		t.Fatalf("expected only %s to be left, got %v", a2, addrs)
+	}
+	if r, ok := findRecord(dst.DiffSince(0), p, a1); !ok || !r.Removed {
+		t.Fatalf("expected the tombstone to be kept for further diffs, got %+v", r)
+	}
+
+	// a stale record doesn't resurrect the address.
+	dst.ApplyDiff(&Diff{Peers: []PeerRecord{{ID: p, Addrs: []AddrRecord{
+		{Addr: a1, TTL: TTLPermanent, LastSeen: time.Now().Add(-time.Minute)},
+	}}}})
+	if n := len(dst.ps.Addrs(p)); n != 1 {
+		t.Fatalf("expected a stale record not to be applied, got %d addresses", n)
+	}
+}
+
+func TestExpiredAddrs(t *testing.T) {
+	p := randPeer(t)
+	a1 := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	a2 := ma.StringCast("/ip4/5.6.7.8/tcp/4001")
+
+	b := NewBook(pstore.NewPeerstore())
+	b.AddAddrs(p, []ma.Multiaddr{a1}, 10*time.Millisecond, false)
+	b.AddAddrs(p, []ma.Multiaddr{a2}, pstore.PermanentAddrTTL, false)
+	time.Sleep(20 * time.Millisecond)
+
+	d := b.DiffSince(0)
+	if r, ok := findRecord(d, p, a1); !ok || !r.Removed {
+		t.Fatalf("expected %s to have expired, got %+v", a1, r)
+	}
+	if r, ok := findRecord(d, p, a2); !ok || r.Removed {
+		t.Fatalf("expected %s to be kept, got %+v", a2, r)
+	}
+
+	// tombstones go away in turn.
+	defer func(ttl time.Duration) { TombstoneTTL = ttl }(TombstoneTTL)
+	TombstoneTTL = 10 * time.Millisecond
+	b.AddAddrs(p, []ma.Multiaddr{a1}, 10*time.Millisecond, false)
+	time.Sleep(20 * time.Millisecond)
+	b.DiffSince(0)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := findRecord(b.DiffSince(0), p, a1); ok {
+		t.Fatalf("expected the tombstone of %s to be dropped", a1)
+	}
+
+	// and expired records from other nodes aren't taken in.
+	q := randPeer(t)
+	b.ApplyDiff(&Diff{Peers: []PeerRecord{{ID: q, Addrs: []AddrRecord{
+		{Addr: a1, TTL: TTLTemp, LastSeen: time.Now().Add(-time.Hour)},
+	}}}})
+	if _, ok := findRecord(b.DiffSince(0), q, a1); ok {
+		t.Fatal("expected an expired record not to be applied")
+	}
+}
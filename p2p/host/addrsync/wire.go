@@ -0,0 +1,187 @@
+package addrsync
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Version is the version of the wire format produced by Marshal.
+const Version = 1
+
+// maxFieldLen bounds the length of peer IDs and addresses read by
+// Unmarshal.
+const maxFieldLen = 1 << 12
+
+const (
+	flagCertified = 1 << iota
+	flagRemoved
+)
+
+// ErrUnknownVersion is returned by Unmarshal for diffs encoded with a
+// version of the format it doesn't know.
+var ErrUnknownVersion = errors.New("unknown address diff version")
+
+// Marshal encodes d. The encoding starts with the version byte, followed by
+// varints and length-prefixed byte strings:
+//
+//	version seq npeers (id naddrs (addr ttlclass flags lastseen)*)*
+//
+// where lastseen is in Unix nanoseconds, and flags has bit 0 set for
+// certified records, and bit 1 for tombstones.
+func (d *Diff) Marshal() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(Version)
+	putUvarint(&buf, d.Seq)
+	putUvarint(&buf, uint64(len(d.Peers)))
+	for _, pr := range d.Peers {
+		putBytes(&buf, []byte(pr.ID))
+		putUvarint(&buf, uint64(len(pr.Addrs)))
+		for _, r := range pr.Addrs {
+			putBytes(&buf, r.Addr.Bytes())
+			buf.WriteByte(byte(r.TTL))
+			var flags byte
+			if r.Certified {
+				flags |= flagCertified
+			}
+			if r.Removed {
+				flags |= flagRemoved
+			}
+			buf.WriteByte(flags)
+			putVarint(&buf, r.LastSeen.UnixNano())
+		}
+	}
+	return buf.Bytes()
+}
+
+// Unmarshal decodes a diff encoded with Marshal.
+func Unmarshal(b []byte) (*Diff, error) {
+	r := bytes.NewReader(b)
+	v, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if v != Version {
+		return nil, ErrUnknownVersion
+	}
+
+	d := new(Diff)
+	if d.Seq, err = binary.ReadUvarint(r); err != nil {
+		return nil, err
+	}
+	npeers, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < npeers; i++ {
+		id, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		pr := PeerRecord{ID: peer.ID(id)}
+		if _, err := mh.Cast(id); err != nil {
+			return nil, fmt.Errorf("invalid peer ID in address diff: %s", err)
+		}
+
+		naddrs, err := readCount(r)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < naddrs; j++ {
+			rec, err := readAddrRecord(r)
+			if err != nil {
+				return nil, err
+			}
+			pr.Addrs = append(pr.Addrs, rec)
+		}
+		d.Peers = append(d.Peers, pr)
+	}
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after address diff", r.Len())
+	}
+	return d, nil
+}
+
+func readAddrRecord(r *bytes.Reader) (AddrRecord, error) {
+	var rec AddrRecord
+	ab, err := readBytes(r)
+	if err != nil {
+		return rec, err
+	}
+	if rec.Addr, err = ma.NewMultiaddrBytes(ab); err != nil {
+		return rec, fmt.Errorf("invalid address in address diff: %s", err)
+	}
+
+	class, err := r.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	if TTLClass(class) > maxTTLClass {
+		return rec, fmt.Errorf("invalid TTL class %d in address diff", class)
+	}
+	rec.TTL = TTLClass(class)
+
+	flags, err := r.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	rec.Certified = flags&flagCertified != 0
+	rec.Removed = flags&flagRemoved != 0
+
+	ns, err := binary.ReadVarint(r)
+	if err != nil {
+		return rec, err
+	}
+	rec.LastSeen = time.Unix(0, ns)
+	return rec, nil
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func putBytes(buf *bytes.Buffer, b []byte) {
+	putUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+// readCount reads a number of items, each taking at least a byte.
+func readCount(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFieldLen {
+		return nil, fmt.Errorf("field of %d bytes too long in address diff", n)
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
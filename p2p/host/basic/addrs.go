@@ -0,0 +1,183 @@
+package basichost
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	goprocess "github.com/jbenet/goprocess"
+	addrutil "github.com/libp2p/go-addr-util"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// DefaultAddrsUpdateInterval is the default value for
+// HostOpts.AddrsUpdateInterval.
+var DefaultAddrsUpdateInterval = 5 * time.Second
+
+// ListenAddresses returns the addresses the host is bound to, as opposed to
+// the ones it advertises (see Addrs). Ports are the actual ones, including
+// for addresses listened on with port 0, and unspecified IPs such as 0.0.0.0
+// are left as is.
+func (h *BasicHost) ListenAddresses() ([]ma.Multiaddr, error) {
+	if h.isClosed() {
+		return nil, ErrHostClosed
+	}
+	return h.Network().ListenAddresses(), nil
+}
+
+// ListenError is a listen address the host failed to listen on.
+type ListenError struct {
+	Addr ma.Multiaddr
+	Err  error
+}
+
+func (e *ListenError) Error() string {
+	return fmt.Sprintf("failed to listen on %s: %s", e.Addr, e.Err)
+}
+
+// ListenErrors are the failures to listen on some addresses.
+type ListenErrors []*ListenError
+
+func (errs ListenErrors) Error() string {
+	strs := make([]string, len(errs))
+	for i, e := range errs {
+		strs[i] = e.Error()
+	}
+	return strings.Join(strs, "; ")
+}
+
+// ListenErrors returns the listen addresses the host failed to listen on
+// when it was set up (see HostOpts.ListenErrors).
+func (h *BasicHost) ListenErrors() ListenErrors {
+	return append(ListenErrors(nil), h.listenErrors...)
+}
+
+// interfaceListenAddrs returns the host's listen addresses, with the
+// unspecified ones expanded into the addresses of the network interfaces
+// they cover. Interfaces are looked up on every call, so that changes are
+// picked up. Should the lookup fail, unspecified addresses are dropped
+// rather than advertised. Unix domain socket addresses are kept as is.
+func (h *BasicHost) interfaceListenAddrs() []ma.Multiaddr {
+	var listen, unix []ma.Multiaddr
+	for _, a := range h.Network().ListenAddresses() {
+		if isUnixAddr(a) {
+			unix = append(unix, a)
+		} else {
+			listen = append(listen, a)
+		}
+	}
+
+	addrs, err := addrutil.ResolveUnspecifiedAddresses(listen, nil)
+	if err != nil {
+		log.Debugf("error resolving unspecified listen addrs: %s", err)
+		addrs = make([]ma.Multiaddr, 0, len(listen))
+		for _, a := range listen {
+			if !manet.IsIPUnspecified(a) {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	return append(addrs, unix...)
+}
+
+// PublishedAddrs returns the set of addresses the host last published, and
+// how many times that set has changed.
+//
+// Address sources (listeners, NAT mappings, observed addresses) can change
+// in quick succession. Rather than reacting to every change, the host
+// recomputes its addresses at most once per AddrsUpdateInterval, and only
+// publishes the result, with an AddrsUpdated event, when it differs from the
+// previous one.
+func (h *BasicHost) PublishedAddrs() ([]ma.Multiaddr, uint64) {
+	h.addrsMu.Lock()
+	defer h.addrsMu.Unlock()
+	return append([]ma.Multiaddr(nil), h.publishedAddrs...), h.addrsChanges
+}
+
+// signalAddrsChanged tells the host its addresses may have changed.
+func (h *BasicHost) signalAddrsChanged() {
+	select {
+	case h.addrsChanged <- struct{}{}:
+	default:
+	}
+}
+
+// updateAddrsLoop recomputes the host's addresses whenever they may have
+// changed, and periodically for sources we aren't told about, but never more
+// than once per interval.
+func (h *BasicHost) updateAddrsLoop(p goprocess.Process) {
+	ticker := time.NewTicker(h.addrsInterval)
+	defer ticker.Stop()
+
+	h.updateAddrs()
+	last := time.Now()
+
+	for {
+		select {
+		case <-h.addrsChanged:
+		case <-ticker.C:
+		case <-p.Closing():
+			return
+		}
+
+		// more changes may come in while we wait, they are all covered
+		// by the next update.
+		if wait := h.addrsInterval - time.Since(last); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-p.Closing():
+				return
+			}
+		}
+
+		h.updateAddrs()
+		last = time.Now()
+	}
+}
+
+// updateAddrs publishes the host's current addresses, if they differ from
+// the ones last published, and pushes them to our peers.
+func (h *BasicHost) updateAddrs() {
+	addrs := h.Addrs()
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].String() < addrs[j].String()
+	})
+
+	h.addrsMu.Lock()
+	if sameAddrs(addrs, h.publishedAddrs) {
+		h.addrsMu.Unlock()
+		return
+	}
+	old := h.publishedAddrs
+	h.publishedAddrs = addrs
+	h.addrsChanges++
+	h.addrsMu.Unlock()
+
+	strs := make([]string, len(addrs))
+	for i, a := range addrs {
+		strs[i] = a.String()
+	}
+	h.emit(events.Event{
+		Type:  events.AddrsUpdated,
+		Addrs: strs,
+	})
+	h.changes.addrsChanged(old, addrs)
+	h.requestPush()
+}
+
+// sameAddrs compares two sorted address lists.
+func sameAddrs(a, b []ma.Multiaddr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
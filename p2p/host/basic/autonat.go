@@ -0,0 +1,74 @@
+package basichost
+
+import (
+	"context"
+	"time"
+
+	autonat "github.com/libp2p/go-libp2p/p2p/protocol/autonat"
+
+	goprocess "github.com/jbenet/goprocess"
+)
+
+var (
+	// DefaultAutoNATInterval is the default value for
+	// HostOpts.AutoNATInterval.
+	DefaultAutoNATInterval = 15 * time.Minute
+
+	// AutoNATRetryInterval is how soon an inconclusive probe, for lack of
+	// peers to ask, is retried.
+	AutoNATRetryInterval = 10 * time.Second
+)
+
+// autoNATLoop probes the reachability of the host, and updates it with the
+// conclusive results.
+func (h *BasicHost) autoNATLoop(p goprocess.Process) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.Closing()
+		cancel()
+	}()
+
+	client := autonat.NewClient(h)
+	retry := AutoNATRetryInterval
+	if retry > h.autoNATInterval {
+		retry = h.autoNATInterval
+	}
+	wait := retry
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-p.Closing():
+			timer.Stop()
+			return
+		}
+
+		public, ok := client.Probe(ctx)
+		if !ok {
+			wait = retry
+			continue
+		}
+		wait = h.autoNATInterval
+
+		reach := ReachabilityPrivate
+		if public {
+			reach = ReachabilityPublic
+		}
+		if reach != h.Reachability() {
+			log.Infof("autonat: reachability is now %s", reach)
+			h.SetReachability(reach)
+		}
+	}
+}
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachabilityPublic:
+		return "public"
+	case ReachabilityPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,240 @@
+package basichost
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	goprocess "github.com/jbenet/goprocess"
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DefaultAutoRelayInterval is the default value for
+// HostOpts.AutoRelayInterval.
+var DefaultAutoRelayInterval = time.Minute
+
+// Reachability is whether the host can be dialed directly from the public
+// internet.
+type Reachability int
+
+const (
+	// ReachabilityUnknown lets the host guess: it considers itself
+	// publicly reachable if it listens on a public address of one of its
+	// interfaces. Observed and NAT mapped addresses don't count, as they
+	// are public for hosts behind a NAT too.
+	ReachabilityUnknown Reachability = iota
+	// ReachabilityPublic means the host can be dialed directly.
+	ReachabilityPublic
+	// ReachabilityPrivate means the host is behind a NAT or a firewall.
+	ReachabilityPrivate
+)
+
+// autoRelay keeps the host connected to its static relays, or to relays
+// found with HostOpts.Discovery, while it isn't publicly reachable, and
+// holds the circuit addresses it advertises through them.
+type autoRelay struct {
+	relays   []pstore.PeerInfo
+	interval time.Duration
+	check    chan struct{}
+
+	mu    sync.Mutex
+	addrs []ma.Multiaddr
+	found []pstore.PeerInfo
+}
+
+func newAutoRelay(relays []pstore.PeerInfo, interval time.Duration) *autoRelay {
+	return &autoRelay{
+		relays:   relays,
+		interval: interval,
+		check:    make(chan struct{}, 1),
+	}
+}
+
+func (ar *autoRelay) signal() {
+	select {
+	case ar.check <- struct{}{}:
+	default:
+	}
+}
+
+func (ar *autoRelay) isRelay(p peer.ID) bool {
+	for _, pi := range ar.relays {
+		if pi.ID == p {
+			return true
+		}
+	}
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	for _, pi := range ar.found {
+		if pi.ID == p {
+			return true
+		}
+	}
+	return false
+}
+
+// SetReachability tells the host whether it is publicly reachable, as
+// found by the application or a NAT detection service. With static relays
+// (see HostOpts.AutoRelays), the host advertises relay addresses, instead
+// of its private ones, while it is not.
+func (h *BasicHost) SetReachability(r Reachability) {
+	h.reachMu.Lock()
+	old := h.reach
+	h.reach = r
+	h.reachMu.Unlock()
+	if old == r {
+		return
+	}
+
+	h.emit(events.Event{
+		Type:         events.ReachabilityChanged,
+		Reachability: r.String(),
+	})
+	h.changes.reachabilityChanged(old, r)
+	if h.autoRelay != nil {
+		h.autoRelay.signal()
+	}
+}
+
+// Reachability returns the reachability of the host, as last set with
+// SetReachability or found by AutoNAT (see HostOpts.EnableAutoNAT).
+func (h *BasicHost) Reachability() Reachability {
+	h.reachMu.Lock()
+	defer h.reachMu.Unlock()
+	return h.reach
+}
+
+// RelayAddrs returns the circuit addresses the host advertises through its
+// static or discovered relays.
+func (h *BasicHost) RelayAddrs() []ma.Multiaddr {
+	if h.autoRelay == nil {
+		return nil
+	}
+	h.autoRelay.mu.Lock()
+	defer h.autoRelay.mu.Unlock()
+	return append([]ma.Multiaddr(nil), h.autoRelay.addrs...)
+}
+
+// publiclyReachable reports whether the host needs no relay.
+func (h *BasicHost) publiclyReachable() bool {
+	switch h.Reachability() {
+	case ReachabilityPublic:
+		return true
+	case ReachabilityPrivate:
+		return false
+	}
+	for _, a := range h.interfaceListenAddrs() {
+		if IsPublicAddr(a) && !isCircuitAddr(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// autoRelayLoop updates the relay addresses periodically, and whenever the
+// reachability changes or a relay disconnects.
+func (h *BasicHost) autoRelayLoop(p goprocess.Process) {
+	ticker := time.NewTicker(h.autoRelay.interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.Closing()
+		cancel()
+	}()
+
+	for {
+		h.updateRelayAddrs(ctx)
+		select {
+		case <-ticker.C:
+		case <-h.autoRelay.check:
+		case <-p.Closing():
+			return
+		}
+	}
+}
+
+func (h *BasicHost) updateRelayAddrs(ctx context.Context) {
+	var addrs []ma.Multiaddr
+	var found []pstore.PeerInfo
+	if !h.publiclyReachable() {
+		for _, pi := range h.autoRelay.relays {
+			addrs = append(addrs, h.connectRelay(ctx, pi)...)
+		}
+		// discovered relays stand in for unreachable static ones.
+		if len(addrs) == 0 && h.discovery != nil {
+			fctx, cancel := context.WithTimeout(ctx, h.autoRelay.interval)
+			found = h.findPeers(fctx, RelayNamespace, DiscoveredRelays)
+			cancel()
+			for _, pi := range found {
+				addrs = append(addrs, h.connectRelay(ctx, pi)...)
+			}
+		}
+	}
+
+	ar := h.autoRelay
+	ar.mu.Lock()
+	changed := !sameAddrs(ar.addrs, addrs)
+	ar.addrs = addrs
+	ar.found = found
+	ar.mu.Unlock()
+	if changed {
+		log.Infof("advertising relay addresses %s", addrs)
+		h.signalAddrsChanged()
+	}
+}
+
+// connectRelay makes sure we're connected to the relay, and returns the
+// circuit addresses we're reachable at through it.
+func (h *BasicHost) connectRelay(ctx context.Context, pi pstore.PeerInfo) []ma.Multiaddr {
+	if h.Network().Connectedness(pi.ID) != inet.Connected {
+		ctx, cancel := context.WithTimeout(ctx, h.autoRelay.interval)
+		defer cancel()
+		if err := h.Connect(ctx, pi); err != nil {
+			log.Debugf("failed to connect to relay %s: %s", pi.ID.Pretty(), err)
+			return nil
+		}
+		h.ConnManager().TagPeer(pi.ID, "autorelay", 100)
+	}
+
+	suffix, err := ma.NewMultiaddr("/ipfs/" + pi.ID.Pretty() + "/p2p-circuit")
+	if err != nil {
+		return nil
+	}
+	var addrs []ma.Multiaddr
+	for _, c := range h.Network().ConnsToPeer(pi.ID) {
+		if isCircuitAddr(c.RemoteMultiaddr()) {
+			continue
+		}
+		a := c.RemoteMultiaddr().Encapsulate(suffix)
+		if !containsAddr(addrs, a) {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+func isCircuitAddr(a ma.Multiaddr) bool {
+	for _, p := range a.Protocols() {
+		if p.Code == circuit.P_CIRCUIT {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddr(addrs []ma.Multiaddr, a ma.Multiaddr) bool {
+	for _, b := range addrs {
+		if b.Equal(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package basichost
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ErrDialBackoff is returned when dialing a peer the host is backing off
+// from, after failed dials. See HostOpts.DialBackoff.
+var ErrDialBackoff = errors.New("dial backoff")
+
+// DialBackoff configures how long the host refuses to dial a peer again
+// after dials to it failed. The first failure backs off for Base, and each
+// further one doubles the delay, up to Max. The zero value disables
+// backoff.
+type DialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// dialBackoff tracks the peers the host backs off from, replacing the
+// network's own backoff.
+type dialBackoff struct {
+	DialBackoff
+	clearNetwork func(peer.ID)
+
+	mu      sync.Mutex
+	entries map[peer.ID]*backoffEntry
+}
+
+type backoffEntry struct {
+	delay time.Duration
+	until time.Time
+}
+
+func newDialBackoff(cfg DialBackoff, clearNetwork func(peer.ID)) *dialBackoff {
+	return &dialBackoff{
+		DialBackoff:  cfg,
+		clearNetwork: clearNetwork,
+		entries:      make(map[peer.ID]*backoffEntry),
+	}
+}
+
+// check returns ErrDialBackoff if dials to p are backed off.
+func (b *dialBackoff) check(p peer.ID) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if e, ok := b.entries[p]; ok && time.Now().Before(e.until) {
+		return ErrDialBackoff
+	}
+	return nil
+}
+
+// done records the outcome of a dial to p.
+func (b *dialBackoff) done(p peer.ID, err error) {
+	if b == nil {
+		return
+	}
+	if err == nil {
+		b.clear(p)
+		return
+	}
+	// the network backs off on its own terms; ours replace them.
+	if b.clearNetwork != nil {
+		b.clearNetwork(p)
+	}
+	if b.Base <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[p]
+	if !ok {
+		e = &backoffEntry{delay: b.Base}
+		b.entries[p] = e
+	} else {
+		e.delay *= 2
+		if e.delay > b.Max {
+			e.delay = b.Max
+		}
+	}
+	e.until = time.Now().Add(e.delay)
+}
+
+func (b *dialBackoff) clear(p peer.ID) {
+	b.mu.Lock()
+	delete(b.entries, p)
+	b.mu.Unlock()
+}
+
+// ClearBackoff lets the host dial p again right away, after failed dials.
+// Applications call it when they learn fresh addresses for p.
+func (h *BasicHost) ClearBackoff(p peer.ID) {
+	if h.backoff != nil {
+		h.backoff.clear(p)
+	}
+	if h.clearNetworkBackoff != nil {
+		h.clearNetworkBackoff(p)
+	}
+}
@@ -0,0 +1,66 @@
+package basichost
+
+import (
+	"sync"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	metrics "github.com/libp2p/go-libp2p-metrics"
+	inet "github.com/libp2p/go-libp2p-net"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// transportBandwidth counts stream traffic by transport.
+type transportBandwidth struct {
+	mu       sync.Mutex
+	counters map[string]*metrics.BandwidthCounter
+}
+
+func (tb *transportBandwidth) counter(c inet.Conn) *metrics.BandwidthCounter {
+	name := transportName(c.LocalMultiaddr(), c.RemoteMultiaddr())
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.counters == nil {
+		tb.counters = make(map[string]*metrics.BandwidthCounter)
+	}
+	bc, ok := tb.counters[name]
+	if !ok {
+		bc = metrics.NewBandwidthCounter()
+		tb.counters[name] = bc
+	}
+	return bc
+}
+
+// transportName names the transport of a connection: "p2p-circuit" for
+// relayed connections, otherwise the outermost protocol of its addresses,
+// such as "tcp", "ws" or "unix".
+func transportName(local, remote ma.Multiaddr) string {
+	for _, a := range []ma.Multiaddr{local, remote} {
+		for _, p := range a.Protocols() {
+			if p.Code == circuit.P_CIRCUIT {
+				return "p2p-circuit"
+			}
+		}
+	}
+	protos := local.Protocols()
+	if len(protos) == 0 {
+		return "unknown"
+	}
+	if protos[0].Name == "unix" {
+		return "unix"
+	}
+	return protos[len(protos)-1].Name
+}
+
+// GetBandwidthByTransport returns the stream traffic of the host by
+// transport (see transportName), if it has a bandwidth reporter. Only
+// stream payloads are counted, not the security and muxer overhead.
+func (h *BasicHost) GetBandwidthByTransport() map[string]metrics.Stats {
+	h.transportBW.mu.Lock()
+	defer h.transportBW.mu.Unlock()
+	out := make(map[string]metrics.Stats, len(h.transportBW.counters))
+	for name, bc := range h.transportBW.counters {
+		out[name] = bc.GetBandwidthTotals()
+	}
+	return out
+}
@@ -2,17 +2,26 @@ package basichost
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+	snapshot "github.com/libp2p/go-libp2p/p2p/host/snapshot"
+	guard "github.com/libp2p/go-libp2p/p2p/net/guard"
+	autonat "github.com/libp2p/go-libp2p/p2p/protocol/autonat"
 	identify "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ping "github.com/libp2p/go-libp2p/p2p/protocol/ping"
 
 	logging "github.com/ipfs/go-log"
 	goprocess "github.com/jbenet/goprocess"
 	circuit "github.com/libp2p/go-libp2p-circuit"
+	host "github.com/libp2p/go-libp2p-host"
 	ifconnmgr "github.com/libp2p/go-libp2p-interface-connmgr"
 	metrics "github.com/libp2p/go-libp2p-metrics"
-	mstream "github.com/libp2p/go-libp2p-metrics/stream"
 	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
@@ -30,12 +39,29 @@ var (
 
 	// DefaultAddrsFactory is the default value for HostOpts.AddrsFactory.
 	DefaultAddrsFactory = func(addrs []ma.Multiaddr) []ma.Multiaddr { return addrs }
+
+	// DefaultPeerstoreSnapshotInterval is the default value for
+	// HostOpts.PeerstoreSnapshotInterval.
+	DefaultPeerstoreSnapshotInterval = 10 * time.Minute
 )
 
 // AddrsFactory functions can be passed to New in order to override
 // addresses returned by Addrs.
 type AddrsFactory func([]ma.Multiaddr) []ma.Multiaddr
 
+// AddrTTLs are the TTLs the host gives the addresses it adds to the
+// peerstore. A zero field keeps the default: pstore.ConnectedAddrTTL and
+// pstore.RecentlyConnectedAddrTTL for the addresses peers report while
+// we're connected to them and once we aren't anymore,
+// pstore.OwnObservedAddrTTL for the addresses peers observe us at, and
+// pstore.PermanentAddrTTL for the addresses of BootstrapPeers.
+type AddrTTLs struct {
+	Connected         time.Duration
+	RecentlyConnected time.Duration
+	Observed          time.Duration
+	Bootstrap         time.Duration
+}
+
 // Option is a type used to pass in options to the host.
 //
 // Deprecated in favor of HostOpts and NewHost.
@@ -59,16 +85,71 @@ type BasicHost struct {
 	network    inet.Network
 	mux        *msmux.MultistreamMuxer
 	ids        *identify.IDService
+	ping       *ping.PingService
 	natmgr     NATManager
 	addrs      AddrsFactory
 	maResolver *madns.Resolver
 	cmgr       ifconnmgr.ConnManager
+	connPolicy ConnSelectionPolicy
+
+	noObservedAddrs bool
+	advertiseUnix   bool
 
 	negtimeout time.Duration
+	negLimits  guard.Limits
 
 	proc goprocess.Process
 
-	bwc metrics.Reporter
+	bwc         metrics.Reporter
+	transportBW transportBandwidth
+	observer    StreamObserver
+	streams     openStreams
+
+	eventSink events.Sink
+
+	addrsInterval  time.Duration
+	addrsChanged   chan struct{}
+	addrsMu        sync.Mutex
+	publishedAddrs []ma.Multiaddr
+	addrsChanges   uint64
+	pushRequests   chan struct{}
+
+	budget       *connBudget
+	bootstrapper *bootstrapper
+	maintainer   *maintainer
+	staticPeers  []pstore.PeerInfo
+	backoff      *dialBackoff
+	dialTimeout  time.Duration
+	gate         *gate
+	streamLimits *streamLimiter
+
+	// clearNetworkBackoff is HostOpts.ClearNetworkBackoff.
+	clearNetworkBackoff func(peer.ID)
+
+	conns connTracker
+
+	extAddrs  *extAddrValidator
+	autoRelay *autoRelay
+	discovery Discovery
+	routing   peerRouting
+
+	reachMu         sync.Mutex
+	reach           Reachability
+	autoNATInterval time.Duration
+
+	changes *changeNotifier
+
+	relayLimiter *relayLimiter
+
+	keyBook KeyBook
+
+	startupWarnings []string
+	listenErrors    ListenErrors
+
+	closeMu      sync.RWMutex
+	closed       bool
+	handlers     sync.WaitGroup
+	closeTimeout time.Duration
 }
 
 // HostOpts holds options that can be passed to NewHost in order to
@@ -81,12 +162,38 @@ type HostOpts struct {
 	// NegotiationTimeout determines the read and write timeouts on streams.
 	// If 0 or omitted, it will use DefaultNegotiationTimeout.
 	// If below 0, timeouts on streams will be deactivated.
+	// It also bounds identify exchanges. See SetNegotiationTimeout.
 	NegotiationTimeout time.Duration
 
 	// IdentifyService holds an implementation of the /ipfs/id/ protocol.
 	// If omitted, a new *identify.IDService will be used.
 	IdentifyService *identify.IDService
 
+	// UserAgent, if set, is the agent version the host's identify service
+	// reports to peers, instead of identify.ClientVersion.
+	UserAgent string
+
+	// ProtocolVersion, if set, is the protocol version the host's identify
+	// service reports to peers, instead of identify.LibP2PVersion. With
+	// RequireProtocolVersionMatch, peers reporting another version are
+	// disconnected once identified, and a ProtocolMismatch event is
+	// emitted. See identify.IDService.ProtocolVersion.
+	ProtocolVersion             string
+	RequireProtocolVersionMatch bool
+
+	// DisableIdentify keeps the host from running the identify protocol:
+	// it neither answers identify requests nor identifies new connections,
+	// and IdentifyService is ignored. Peers are still authenticated by the
+	// security transports, but the host learns neither their protocols nor
+	// their listen addresses, and doesn't learn its observed addresses, so
+	// it can't be combined with ValidateExternalAddrs. No Identified events
+	// are emitted.
+	DisableIdentify bool
+
+	// EnablePing makes the host answer pings, on the ping.ID protocol. See
+	// BasicHost.PingService.
+	EnablePing bool
+
 	// AddrsFactory holds a function which can be used to override or filter the result of Addrs.
 	// If omitted, there's no override or filtering, and the results of Addrs and AllAddrs are the same.
 	AddrsFactory AddrsFactory
@@ -103,7 +210,8 @@ type HostOpts struct {
 	// bandwidth used by various protocols.
 	BandwidthReporter metrics.Reporter
 
-	// ConnManager is a libp2p connection manager
+	// ConnManager is a libp2p connection manager. It's notified of every
+	// connection, and closed along with the host if it's an io.Closer.
 	ConnManager ifconnmgr.ConnManager
 
 	// Relay indicates whether the host should use circuit relay transport
@@ -111,41 +219,274 @@ type HostOpts struct {
 
 	// RelayOpts are options for the relay transport; only meaningful when Relay=true
 	RelayOpts []circuit.RelayOpt
+
+	// RelayLimits, if set, caps the circuits the host serves, and keeps
+	// the counters returned by RelayStats.
+	RelayLimits *RelayLimits
+
+	// KeyBook, if set, is the peerstore's capped store of public keys,
+	// whose accounting and pins the host exposes (see KeyBookStats).
+	KeyBook KeyBook
+
+	// EventSink receives structured events about dials, connections and
+	// listen addresses. If it implements io.Closer, it is closed along with
+	// the host. If omitted, no events are emitted.
+	EventSink events.Sink
+
+	// ConnSelectionPolicy decides which connection new streams are opened on
+	// when there are several connections to a peer. If omitted,
+	// DefaultConnSelectionPolicy is used.
+	ConnSelectionPolicy ConnSelectionPolicy
+
+	// OrderedNotifications makes the host deliver the Connected and
+	// Disconnected notifications of each peer serially and in order, to all
+	// notifiees registered with its Network and to the EventSink.
+	OrderedNotifications bool
+
+	// AddrsUpdateInterval is the minimum time between two recomputations
+	// of the addresses the host publishes. If 0 or omitted, it will use
+	// DefaultAddrsUpdateInterval.
+	AddrsUpdateInterval time.Duration
+
+	// ConnBudget caps the number of outgoing connection attempts (dials
+	// and their security handshakes) in flight at once. Attempts beyond it
+	// wait, served by DialPriority. If 0 or omitted, there is no cap.
+	ConnBudget int
+
+	// DialBackoff, if set, replaces the network's backoff after failed
+	// dials with the given one, for the dials made by the host. Backed off
+	// dials fail right away with ErrDialBackoff, whatever the deadline of
+	// their context. The network's backoff is cleared with
+	// ClearNetworkBackoff.
+	DialBackoff *DialBackoff
+
+	// ClearNetworkBackoff clears the network's own backoff for a peer. It
+	// is used by ClearBackoff, and to replace the network's backoff with
+	// DialBackoff.
+	ClearNetworkBackoff func(peer.ID)
+
+	// DialTimeout bounds how long Connect and NewStream spend dialing a
+	// peer, on top of any deadline of their context. If 0 or omitted, only
+	// the swarm's own timeout applies.
+	DialTimeout time.Duration
+
+	// ConnectionGater, if set, decides which connections the host dials
+	// and keeps; see ConnectionGater.
+	ConnectionGater ConnectionGater
+
+	// MaxInboundConns caps the number of inbound connections. Inbound
+	// connections beyond it are closed as soon as they are set up, before
+	// any stream is handled on them, and counted in InboundStats. If 0 or
+	// omitted, there is no cap.
+	MaxInboundConns int
+
+	// MaxConnsPerPeer caps the number of connections to each peer. Once
+	// the security handshake has identified the peer of a new connection,
+	// the connections to it beyond the cap, normally the newest ones, stop
+	// being used for new streams, and are closed once their streams are
+	// done, or after PruneGracePeriod. If 0 or omitted, there is no cap.
+	MaxConnsPerPeer int
+
+	// ResourceLimits, if set, caps the streams the host keeps open; see
+	// ResourceLimits.
+	ResourceLimits *ResourceLimits
+
+	// BootstrapPeers are connected to once the host is set up, with at
+	// most BootstrapConcurrency attempts at once. Their addresses are
+	// added to the peerstore permanently, and they are protected from
+	// connection manager trimming. Failures are available from
+	// BootstrapErrors.
+	BootstrapPeers []pstore.PeerInfo
+
+	// KeepBootstrapped retries failed connections to the BootstrapPeers,
+	// with exponential backoff, and reconnects to the ones we get
+	// disconnected from, until the host is closed.
+	KeepBootstrapped bool
+
+	// BootstrapMinPeers, if set, makes the host check the number of peers
+	// it's connected to every BootstrapPeriod, and dial more while it's
+	// below the minimum: BootstrapPeers first, then the peers in the
+	// peerstore, skipping the ones the ConnectionGater refuses and backing
+	// off from failed ones. See BootstrapState. If BootstrapPeriod is 0 or
+	// omitted, it will use DefaultBootstrapPeriod.
+	BootstrapMinPeers int
+	BootstrapPeriod   time.Duration
+
+	// Discovery, if set, constructs the discovery the host advertises
+	// itself in, in BootstrapNamespace, and in RelayNamespace if it's a
+	// relay with hop enabled. The host finds relays there when its
+	// AutoRelays can't be reached, or without AutoRelays if it has
+	// EnableRelay, and peers to connect to when below BootstrapMinPeers.
+	Discovery func(host.Host) (Discovery, error)
+
+	// StaticPeers are peers whose addresses are added to the peerstore
+	// permanently, and which are protected from connection manager
+	// trimming, without connecting to them. See BasicHost.StaticPeers.
+	StaticPeers []pstore.PeerInfo
+
+	// AddrTTLs overrides the TTLs of the addresses the host adds to the
+	// peerstore.
+	AddrTTLs AddrTTLs
+
+	// PeerstoreSnapshot, if set, is the path of a peerstore snapshot (see
+	// package snapshot) loaded when the host is set up, and saved every
+	// PeerstoreSnapshotInterval and when the host is closed. Failing to
+	// load it is reported in StartupWarnings. If the interval is 0 or
+	// omitted, it will use DefaultPeerstoreSnapshotInterval.
+	PeerstoreSnapshot         string
+	PeerstoreSnapshotInterval time.Duration
+
+	// PruneGracePeriod bounds how long connections exceeding
+	// MaxConnsPerPeer are kept for their streams to finish. If 0 or
+	// omitted, it will use DefaultPruneGracePeriod. If below 0, they are
+	// closed at once.
+	PruneGracePeriod time.Duration
+
+	// CloseTimeout bounds how long Close waits for running stream handlers
+	// to return, after resetting their streams. If 0 or omitted, it will use
+	// DefaultCloseTimeout.
+	CloseTimeout time.Duration
+
+	// DisableObservedAddrs stops the host from advertising the addresses
+	// its peers report observing it at. Nodes with static public addresses
+	// don't need them.
+	DisableObservedAddrs bool
+
+	// ValidateExternalAddrs lists externally declared addresses, such as
+	// ones behind manual port forwards, to revalidate every
+	// ExternalAddrCheckInterval. An address no peer reached us at, nor
+	// could dial us back at through the autonat service, during
+	// ExternalAddrFailureThreshold consecutive intervals stops being
+	// advertised until one does again. If 0 or omitted, the interval and
+	// threshold use DefaultExternalAddrCheckInterval and
+	// DefaultExternalAddrFailureThreshold.
+	ValidateExternalAddrs        []ma.Multiaddr
+	ExternalAddrCheckInterval    time.Duration
+	ExternalAddrFailureThreshold int
+
+	// NegotiationLimits is the minimum read progress protocol negotiation
+	// on inbound streams, and identify exchanges, must make. Slower
+	// exchanges are aborted. If omitted, guard.DefaultLimits is used.
+	NegotiationLimits *guard.Limits
+
+	// StartupWarnings are problems found while setting up the network that
+	// didn't prevent the host from starting, such as default listen
+	// addresses that had to be skipped. See BasicHost.StartupWarnings.
+	StartupWarnings []string
+
+	// ListenErrors are the listen addresses the network failed to listen
+	// on before the host was set up. See BasicHost.ListenErrors.
+	ListenErrors ListenErrors
+
+	// StreamObserver, if set, is told about every stream of the host. See
+	// StreamObserver.
+	StreamObserver StreamObserver
+
+	// AdvertiseUnixAddrs includes the host's unix domain socket listen
+	// addresses in Addrs, and so in identify. They are only meaningful to
+	// processes on the same machine, and left out by default.
+	AdvertiseUnixAddrs bool
+
+	// AutoRelays are relays the host connects to, and advertises circuit
+	// addresses through, while it isn't publicly reachable (see
+	// SetReachability). The relays must have hop enabled, and the host
+	// EnableRelay. Reachability and relay connections are checked every
+	// AutoRelayInterval, and when a relay disconnects. If 0 or omitted,
+	// the interval is DefaultAutoRelayInterval.
+	AutoRelays        []pstore.PeerInfo
+	AutoRelayInterval time.Duration
+
+	// Reachability is the host's initial reachability, see SetReachability.
+	Reachability Reachability
+
+	// EnableAutoNAT makes the host find out its reachability by asking
+	// its peers to dial it back, every AutoNATInterval. If 0 or omitted,
+	// the interval is DefaultAutoNATInterval. It requires identify.
+	EnableAutoNAT   bool
+	AutoNATInterval time.Duration
+
+	// AutoNATService, if set, makes the host dial back the peers that ask
+	// it to, within the given rate limit.
+	AutoNATService *autonat.RateLimit
+
+	// AddrsChangeHandlers and ReachabilityChangeHandlers are called when
+	// the advertised addresses, or the reachability, of the host change.
+	// They are called in order, but asynchronously, from a goroutine of
+	// the host.
+	AddrsChangeHandlers        []AddrsChangeHandler
+	ReachabilityChangeHandlers []ReachabilityChangeHandler
 }
 
 // NewHost constructs a new *BasicHost and activates it by attaching its stream and connection handlers to the given inet.Network.
 func NewHost(ctx context.Context, net inet.Network, opts *HostOpts) (*BasicHost, error) {
 	ctx, cancel := context.WithCancel(ctx)
+
+	// wrap the network before anything registers notifiees on it.
+	if opts.OrderedNotifications {
+		net = newOrderedNetwork(net)
+	}
+
 	h := &BasicHost{
 		network:    net,
 		mux:        msmux.NewMultistreamMuxer(),
 		negtimeout: DefaultNegotiationTimeout,
+		negLimits:  guard.DefaultLimits,
 		addrs:      DefaultAddrsFactory,
 		maResolver: madns.DefaultResolver,
+		connPolicy: DefaultConnSelectionPolicy,
+
+		addrsInterval: DefaultAddrsUpdateInterval,
+		addrsChanged:  make(chan struct{}, 1),
+		pushRequests:  make(chan struct{}, 1),
+
+		closeTimeout: DefaultCloseTimeout,
 	}
 
 	h.proc = goprocess.WithTeardown(func() error {
+		h.shutdown()
 		if h.natmgr != nil {
 			h.natmgr.Close()
 		}
+		if c, ok := h.cmgr.(io.Closer); ok {
+			c.Close()
+		}
 		cancel()
-		return h.Network().Close()
+		listen := h.Network().ListenAddresses()
+		err := h.Network().Close()
+		removeUnixSockets(listen)
+		if c, ok := h.eventSink.(io.Closer); ok {
+			c.Close()
+		}
+		return err
 	})
 
 	if opts.MultistreamMuxer != nil {
 		h.mux = opts.MultistreamMuxer
 	}
 
-	if opts.IdentifyService != nil {
+	switch {
+	case opts.DisableIdentify:
+	case opts.IdentifyService != nil:
 		h.ids = opts.IdentifyService
-	} else {
+	default:
 		// we can't set this as a default above because it depends on the *BasicHost.
 		h.ids = identify.NewIDService(h)
 	}
+	if h.ids != nil {
+		h.configureIDService(opts)
+		h.proc.Go(h.pushLoop)
+	}
+
+	if opts.EnablePing {
+		h.ping = ping.NewPingService(h)
+	}
 
 	if uint64(opts.NegotiationTimeout) != 0 {
 		h.negtimeout = opts.NegotiationTimeout
 	}
+	if h.ids != nil {
+		h.ids.SetTimeout(h.negtimeout)
+	}
 
 	if opts.AddrsFactory != nil {
 		h.addrs = opts.AddrsFactory
@@ -159,9 +500,56 @@ func NewHost(ctx context.Context, net inet.Network, opts *HostOpts) (*BasicHost,
 		h.maResolver = opts.MultiaddrResolver
 	}
 
+	if opts.ConnSelectionPolicy != nil {
+		h.connPolicy = opts.ConnSelectionPolicy
+	}
+
+	h.noObservedAddrs = opts.DisableObservedAddrs
+	h.advertiseUnix = opts.AdvertiseUnixAddrs
+	h.startupWarnings = opts.StartupWarnings
+	h.observer = opts.StreamObserver
+	h.listenErrors = opts.ListenErrors
+	h.reach = opts.Reachability
+	if len(opts.AddrsChangeHandlers) > 0 || len(opts.ReachabilityChangeHandlers) > 0 {
+		h.changes = newChangeNotifier(opts.AddrsChangeHandlers, opts.ReachabilityChangeHandlers)
+		h.proc.Go(h.changes.run)
+	}
+
+	if opts.CloseTimeout > 0 {
+		h.closeTimeout = opts.CloseTimeout
+	}
+
+	if opts.ConnBudget > 0 {
+		h.budget = newConnBudget(opts.ConnBudget)
+	}
+
+	h.dialTimeout = opts.DialTimeout
+	if opts.ResourceLimits != nil {
+		h.streamLimits = newStreamLimiter(*opts.ResourceLimits)
+	}
+	h.clearNetworkBackoff = opts.ClearNetworkBackoff
+	if opts.DialBackoff != nil {
+		h.backoff = newDialBackoff(*opts.DialBackoff, opts.ClearNetworkBackoff)
+	}
+
+	if opts.ConnectionGater != nil || opts.MaxInboundConns > 0 || opts.MaxConnsPerPeer > 0 {
+		pruneGrace := DefaultPruneGracePeriod
+		if opts.PruneGracePeriod != 0 {
+			pruneGrace = opts.PruneGracePeriod
+		}
+		h.gate = newGate(opts.ConnectionGater, opts.MaxInboundConns, opts.MaxConnsPerPeer, pruneGrace)
+	}
+
+	if opts.AddrsUpdateInterval > 0 {
+		h.addrsInterval = opts.AddrsUpdateInterval
+	}
+
+	if opts.NegotiationLimits != nil {
+		h.negLimits = *opts.NegotiationLimits
+	}
+
 	if opts.BandwidthReporter != nil {
 		h.bwc = opts.BandwidthReporter
-		h.ids.Reporter = opts.BandwidthReporter
 	}
 
 	if opts.ConnManager == nil {
@@ -171,17 +559,144 @@ func NewHost(ctx context.Context, net inet.Network, opts *HostOpts) (*BasicHost,
 		net.Notify(h.cmgr.Notifee())
 	}
 
+	h.eventSink = opts.EventSink
+	net.Notify((*hostNotifiee)(h))
+
 	net.SetConnHandler(h.newConnHandler)
 	net.SetStreamHandler(h.newStreamHandler)
 
+	if opts.RelayLimits != nil {
+		h.relayLimiter = newRelayLimiter(*opts.RelayLimits)
+	}
+	h.keyBook = opts.KeyBook
+
 	if opts.EnableRelay {
-		err := circuit.AddRelayTransport(ctx, h, opts.RelayOpts...)
+		// the relay transport needs to get at the swarm itself.
+		var rh host.Host = h
+		if raw := rawNetwork(net); raw != net {
+			rh = &rawNetworkHost{BasicHost: h, net: raw}
+		}
+		err := circuit.AddRelayTransport(ctx, rh, opts.RelayOpts...)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+	}
+
+	if len(opts.ValidateExternalAddrs) > 0 {
+		// without identify, nothing tells us where peers reach us, nor
+		// which peers would dial us back.
+		if h.ids == nil {
+			h.Close()
+			return nil, errors.New("validating external addresses requires identify")
+		}
+		interval := DefaultExternalAddrCheckInterval
+		if opts.ExternalAddrCheckInterval > 0 {
+			interval = opts.ExternalAddrCheckInterval
+		}
+		threshold := DefaultExternalAddrFailureThreshold
+		if opts.ExternalAddrFailureThreshold > 0 {
+			threshold = opts.ExternalAddrFailureThreshold
+		}
+		h.extAddrs = newExtAddrValidator(opts.ValidateExternalAddrs, interval, threshold)
+		h.extAddrs.lastSeen = h.ids.OwnObservedAddrLastSeen
+		h.extAddrs.dialBack = h.dialBackExtAddr
+		h.proc.Go(h.validateExtAddrsLoop)
+	}
+
+	if opts.Discovery != nil {
+		d, err := opts.Discovery(h)
 		if err != nil {
 			h.Close()
 			return nil, err
 		}
+		h.discovery = d
+		h.proc.Go(func(p goprocess.Process) { h.advertiseLoop(p, BootstrapNamespace) })
+		if opts.EnableRelay && hasHop(opts.RelayOpts) {
+			h.proc.Go(func(p goprocess.Process) { h.advertiseLoop(p, RelayNamespace) })
+		}
+	}
+
+	if len(opts.AutoRelays) > 0 || (opts.Discovery != nil && opts.EnableRelay) {
+		if !opts.EnableRelay {
+			h.Close()
+			return nil, errors.New("static relays require the relay transport")
+		}
+		interval := DefaultAutoRelayInterval
+		if opts.AutoRelayInterval > 0 {
+			interval = opts.AutoRelayInterval
+		}
+		h.autoRelay = newAutoRelay(opts.AutoRelays, interval)
+		h.proc.Go(h.autoRelayLoop)
+	}
+
+	if opts.AutoNATService != nil {
+		autonat.NewService(h, *opts.AutoNATService)
+	}
+
+	if opts.EnableAutoNAT {
+		// without identify, we don't know which peers run the service.
+		if h.ids == nil {
+			h.Close()
+			return nil, errors.New("AutoNAT requires identify")
+		}
+		h.autoNATInterval = DefaultAutoNATInterval
+		if opts.AutoNATInterval > 0 {
+			h.autoNATInterval = opts.AutoNATInterval
+		}
+		h.proc.Go(h.autoNATLoop)
+	}
+
+	if opts.PeerstoreSnapshot != "" {
+		if err := snapshot.Load(opts.PeerstoreSnapshot, h.Peerstore()); err != nil {
+			h.startupWarnings = append(h.startupWarnings, fmt.Sprintf("failed to load peerstore snapshot: %s", err))
+		}
+		interval := opts.PeerstoreSnapshotInterval
+		if interval <= 0 {
+			interval = DefaultPeerstoreSnapshotInterval
+		}
+		h.proc.Go(func(p goprocess.Process) {
+			snapshot.Loop(opts.PeerstoreSnapshot, h.Peerstore(), interval, p.Closing())
+		})
+	}
+
+	h.staticPeers = opts.StaticPeers
+	for _, pi := range opts.StaticPeers {
+		h.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.PermanentAddrTTL)
+		h.Protect(pi.ID, staticTag)
+	}
+
+	if len(opts.BootstrapPeers) > 0 {
+		h.bootstrapper = &bootstrapper{
+			peers: opts.BootstrapPeers,
+			keep:  opts.KeepBootstrapped,
+			errs:  make(map[peer.ID]error),
+		}
+		ttl := pstore.PermanentAddrTTL
+		if opts.AddrTTLs.Bootstrap > 0 {
+			ttl = opts.AddrTTLs.Bootstrap
+		}
+		for _, pi := range opts.BootstrapPeers {
+			h.Peerstore().AddAddrs(pi.ID, pi.Addrs, ttl)
+			h.Protect(pi.ID, bootstrapTag)
+		}
+		h.proc.Go(h.bootstrapLoop)
 	}
 
+	if opts.BootstrapMinPeers > 0 {
+		h.maintainer = &maintainer{
+			min:     opts.BootstrapMinPeers,
+			period:  DefaultBootstrapPeriod,
+			backoff: make(map[peer.ID]*backoffEntry),
+		}
+		if opts.BootstrapPeriod > 0 {
+			h.maintainer.period = opts.BootstrapPeriod
+		}
+		h.proc.Go(h.maintainLoop)
+	}
+
+	h.proc.Go(h.updateAddrsLoop)
+
 	return h, nil
 }
 
@@ -219,28 +734,89 @@ func New(net inet.Network, opts ...interface{}) *BasicHost {
 	return h
 }
 
+// SetNegotiationTimeout changes the timeout of protocol negotiation on
+// inbound streams, and of identify exchanges, for the ones started from now
+// on. A zero or negative d disables it.
+func (h *BasicHost) SetNegotiationTimeout(d time.Duration) {
+	atomic.StoreInt64((*int64)(&h.negtimeout), int64(d))
+	if h.ids != nil {
+		h.ids.SetTimeout(d)
+	}
+}
+
+func (h *BasicHost) negotiationTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64((*int64)(&h.negtimeout)))
+}
+
 // newConnHandler is the remote-opened conn handler for inet.Network
 func (h *BasicHost) newConnHandler(c inet.Conn) {
+	if !h.gateConn(c) {
+		return
+	}
 	// Clear protocols on connecting to new peer to avoid issues caused
 	// by misremembering protocols between reconnects
 	h.Peerstore().SetProtocols(c.RemotePeer())
+	if h.ids == nil {
+		return
+	}
+	// the network calls us for the connections we dial too, so this is
+	// where all of them are identified.
+	before := time.Now()
 	h.ids.IdentifyConn(c)
+	took := time.Since(before)
+	h.conns.measured(h.Network(), c, took)
+	h.emit(events.Event{
+		Type: events.Identified,
+		Peer: c.RemotePeer().Pretty(),
+		Addr: c.RemoteMultiaddr().String(),
+		Took: took,
+	})
+}
+
+// closeConn closes c, with the reason reported by its Disconnected event.
+func (h *BasicHost) closeConn(c inet.Conn, reason string) {
+	h.conns.closing(h.Network(), c, reason)
+	c.Close()
 }
 
 // newStreamHandler is the remote-opened stream handler for inet.Network
 // TODO: this feels a bit wonky
 func (h *BasicHost) newStreamHandler(s inet.Stream) {
+	if !h.gateConn(s.Conn()) {
+		s.Reset()
+		return
+	}
+	release, ok := h.acceptStream(s)
+	if !ok {
+		return
+	}
+	// until the stream is wrapped, which takes over.
+	defer func() {
+		if release != nil {
+			release()
+		}
+	}()
 	before := time.Now()
 
-	if h.negtimeout > 0 {
-		if err := s.SetDeadline(time.Now().Add(h.negtimeout)); err != nil {
+	negtimeout := h.negotiationTimeout()
+	if negtimeout > 0 {
+		if err := s.SetDeadline(time.Now().Add(negtimeout)); err != nil {
 			log.Error("setting stream deadline: ", err)
 			s.Reset()
 			return
 		}
 	}
 
-	lzc, protoID, handle, err := h.Mux().NegotiateLazy(s)
+	gs := guard.Watch(s, h.negLimits, func() {
+		h.emit(events.Event{
+			Type:  events.SlowTransfer,
+			Peer:  s.Conn().RemotePeer().Pretty(),
+			Addr:  s.Conn().RemoteMultiaddr().String(),
+			Error: guard.ErrSlowTransfer.Error(),
+		})
+	})
+	lzc, protoID, handle, err := h.Mux().NegotiateLazy(gs)
+	gs.Done()
 	took := time.Now().Sub(before)
 	if err != nil {
 		if err == io.EOF {
@@ -261,7 +837,7 @@ func (h *BasicHost) newStreamHandler(s inet.Stream) {
 		rw:     lzc,
 	}
 
-	if h.negtimeout > 0 {
+	if negtimeout > 0 {
 		if err := s.SetDeadline(time.Time{}); err != nil {
 			log.Error("resetting stream deadline: ", err)
 			s.Reset()
@@ -270,13 +846,19 @@ func (h *BasicHost) newStreamHandler(s inet.Stream) {
 	}
 
 	s.SetProtocol(protocol.ID(protoID))
+	s = h.wrapStream(s, DirInbound, release)
+	release = nil
 
-	if h.bwc != nil {
-		s = mstream.WrapStream(s, h.bwc)
-	}
 	log.Debugf("protocol negotiation took %s", took)
 
-	go handle(protoID, s)
+	if !h.startHandler() {
+		s.Reset()
+		return
+	}
+	go func() {
+		defer h.handlers.Done()
+		handle(protoID, s)
+	}()
 }
 
 // ID returns the (local) peer.ID associated with this Host
@@ -299,22 +881,77 @@ func (h *BasicHost) Mux() *msmux.MultistreamMuxer {
 	return h.mux
 }
 
-// IDService returns
+// IDService returns the host's identify service, or nil with
+// HostOpts.DisableIdentify.
 func (h *BasicHost) IDService() *identify.IDService {
 	return h.ids
 }
 
+// configureIDService applies opts to the host's identify service.
+func (h *BasicHost) configureIDService(opts *HostOpts) {
+	if opts.NegotiationLimits != nil {
+		h.ids.MinThroughput = *opts.NegotiationLimits
+	}
+	if opts.BandwidthReporter != nil {
+		h.ids.Reporter = opts.BandwidthReporter
+	}
+	if opts.AddrTTLs.Connected > 0 {
+		h.ids.ConnectedAddrTTL = opts.AddrTTLs.Connected
+	}
+	if opts.AddrTTLs.RecentlyConnected > 0 {
+		h.ids.RecentlyConnectedAddrTTL = opts.AddrTTLs.RecentlyConnected
+	}
+	if opts.AddrTTLs.Observed > 0 {
+		h.ids.SetObservedAddrTTL(opts.AddrTTLs.Observed)
+	}
+	if opts.UserAgent != "" {
+		h.ids.UserAgent = opts.UserAgent
+	}
+	if opts.ProtocolVersion != "" {
+		h.ids.ProtocolVersion = opts.ProtocolVersion
+	}
+	if opts.RequireProtocolVersionMatch {
+		h.ids.RequireProtocolVersionMatch = true
+	}
+	if h.ids.OnProtocolMismatch == nil {
+		h.ids.OnProtocolMismatch = func(c inet.Conn, pv, av string) {
+			// identify closes the connection.
+			h.conns.closing(h.Network(), c, "protocol mismatch")
+			h.emit(events.Event{
+				Type:     events.ProtocolMismatch,
+				Peer:     c.RemotePeer().Pretty(),
+				Addr:     c.RemoteMultiaddr().String(),
+				Protocol: pv,
+			})
+		}
+	}
+}
+
+// PingService returns the host's ping service, which answers pings with
+// HostOpts.EnablePing. Without it, the service returned can only send
+// pings.
+func (h *BasicHost) PingService() *ping.PingService {
+	if h.ping == nil {
+		return &ping.PingService{Host: h}
+	}
+	return h.ping
+}
+
 // SetStreamHandler sets the protocol handler on the Host's Mux.
 // This is equivalent to:
 //   host.Mux().SetHandler(proto, handler)
 // (Threadsafe)
 func (h *BasicHost) SetStreamHandler(pid protocol.ID, handler inet.StreamHandler) {
+	if pid == circuit.ProtoID && h.relayLimiter != nil {
+		handler = h.relayLimiter.wrap(handler)
+	}
 	h.Mux().AddHandler(string(pid), func(p string, rwc io.ReadWriteCloser) error {
 		is := rwc.(inet.Stream)
 		is.SetProtocol(protocol.ID(p))
 		handler(is)
 		return nil
 	})
+	h.requestPush()
 }
 
 // SetStreamHandlerMatch sets the protocol handler on the Host's Mux
@@ -326,11 +963,13 @@ func (h *BasicHost) SetStreamHandlerMatch(pid protocol.ID, m func(string) bool,
 		handler(is)
 		return nil
 	})
+	h.requestPush()
 }
 
 // RemoveStreamHandler returns ..
 func (h *BasicHost) RemoveStreamHandler(pid protocol.ID) {
 	h.Mux().RemoveHandler(string(pid))
+	h.requestPush()
 }
 
 // NewStream opens a new stream to given peer p, and writes a p2p/protocol
@@ -338,6 +977,10 @@ func (h *BasicHost) RemoveStreamHandler(pid protocol.ID) {
 // to create one. If ProtocolID is "", writes no header.
 // (Threadsafe)
 func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.ID) (inet.Stream, error) {
+	if h.isClosed() {
+		return nil, ErrHostClosed
+	}
+
 	pref, err := h.preferredProtocol(p, pids)
 	if err != nil {
 		return nil, err
@@ -352,7 +995,7 @@ func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.I
 		protoStrs = append(protoStrs, string(pid))
 	}
 
-	s, err := h.Network().NewStream(ctx, p)
+	s, err := h.openStream(ctx, p)
 	if err != nil {
 		return nil, err
 	}
@@ -366,11 +1009,7 @@ func (h *BasicHost) NewStream(ctx context.Context, p peer.ID, pids ...protocol.I
 	s.SetProtocol(selpid)
 	h.Peerstore().AddProtocols(p, selected)
 
-	if h.bwc != nil {
-		s = mstream.WrapStream(s, h.bwc)
-	}
-
-	return s, nil
+	return h.wrapStream(s, DirOutbound, nil), nil
 }
 
 func pidsToStrings(pids []protocol.ID) []string {
@@ -395,31 +1034,77 @@ func (h *BasicHost) preferredProtocol(p peer.ID, pids []protocol.ID) (protocol.I
 	return out, nil
 }
 
+// openStream opens a new stream to p on the connection preferred by the
+// host's ConnSelectionPolicy. The chosen connection is available through the
+// stream's Conn method. If there is no connection to p yet, the network
+// dials one.
+func (h *BasicHost) openStream(ctx context.Context, p peer.ID) (inet.Stream, error) {
+	c := h.bestConn(p)
+	if c != nil {
+		return c.NewStream()
+	}
+
+	dialDone, err := h.gate.startDial(p)
+	if err != nil {
+		return nil, err
+	}
+	defer dialDone()
+	if err := h.backoff.check(p); err != nil {
+		return nil, err
+	}
+	if h.budget != nil {
+		if err := h.budget.acquire(ctx, dialPriority(ctx)); err != nil {
+			return nil, err
+		}
+		defer h.budget.release()
+	}
+	if err := h.addResolvedAddrs(ctx, p); err != nil {
+		return nil, err
+	}
+	if len(h.Peerstore().Addrs(p)) == 0 {
+		h.findPeerAddrs(ctx, p)
+	}
+	dctx, cancel := h.dialContext(ctx)
+	s, err := h.Network().NewStream(dctx, p)
+	cancel()
+	h.backoff.done(p, err)
+	if err != nil {
+		return nil, err
+	}
+	if !h.gateConn(s.Conn()) {
+		s.Reset()
+		return nil, ErrGaterDisallowedConnection
+	}
+	h.conns.dialed(h.Network(), s.Conn())
+	return s, nil
+}
+
 func (h *BasicHost) newStream(ctx context.Context, p peer.ID, pid protocol.ID) (inet.Stream, error) {
-	s, err := h.Network().NewStream(ctx, p)
+	s, err := h.openStream(ctx, p)
 	if err != nil {
 		return nil, err
 	}
 
 	s.SetProtocol(pid)
 
-	if h.bwc != nil {
-		s = mstream.WrapStream(s, h.bwc)
-	}
-
 	lzcon := msmux.NewMSSelect(s, string(pid))
-	return &streamWrapper{
+	return h.wrapStream(&streamWrapper{
 		Stream: s,
 		rw:     lzcon,
-	}, nil
+	}, DirOutbound, nil), nil
 }
 
 // Connect ensures there is a connection between this host and the peer with
 // given peer.ID. If there is not an active connection, Connect will issue a
 // h.Network.Dial, and block until a connection is open, or an error is returned.
 // Connect will absorb the addresses in pi into its internal peerstore.
-// It will also resolve any /dns4, /dns6, and /dnsaddr addresses.
+// It will also resolve any /dns4, /dns6, and /dnsaddr addresses, and, with
+// SetPeerRouting, look the peer up when it has no addresses or they all fail.
 func (h *BasicHost) Connect(ctx context.Context, pi pstore.PeerInfo) error {
+	if h.isClosed() {
+		return ErrHostClosed
+	}
+
 	// absorb addresses into peerstore
 	h.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.TempAddrTTL)
 
@@ -428,15 +1113,44 @@ func (h *BasicHost) Connect(ctx context.Context, pi pstore.PeerInfo) error {
 		return nil
 	}
 
-	resolved, err := h.resolveAddrs(ctx, h.Peerstore().PeerInfo(pi.ID))
-	if err != nil {
+	if err := h.addResolvedAddrs(ctx, pi.ID); err != nil {
 		return err
 	}
-	h.Peerstore().AddAddrs(pi.ID, resolved, pstore.TempAddrTTL)
 
+	// with peer routing, look up peers we know no addresses for, or whose
+	// addresses all failed, once.
+	lookedUp := false
+	if len(h.Peerstore().Addrs(pi.ID)) == 0 {
+		h.findPeerAddrs(ctx, pi.ID)
+		lookedUp = true
+	}
+
+	err := h.dialPeer(ctx, pi.ID)
+	if err == nil || lookedUp || err == ErrGaterDisallowedConnection || ctx.Err() != nil {
+		return err
+	}
+	if !h.findPeerAddrs(ctx, pi.ID) {
+		return err
+	}
+	h.ClearBackoff(pi.ID)
 	return h.dialPeer(ctx, pi.ID)
 }
 
+// addResolvedAddrs resolves the /dns4, /dns6 and /dnsaddr addresses the
+// peerstore has for p, and adds the results to the peerstore, so that they
+// can be dialed.
+func (h *BasicHost) addResolvedAddrs(ctx context.Context, p peer.ID) error {
+	resolved, err := h.resolveAddrs(ctx, h.Peerstore().PeerInfo(p))
+	if err != nil {
+		return err
+	}
+	h.Peerstore().AddAddrs(p, resolved, pstore.TempAddrTTL)
+	return nil
+}
+
+// resolveAddrs returns the addresses of pi along with the ones its DNS
+// addresses resolve to. An address failing to resolve is reported with a
+// ResolveFailed event, and doesn't prevent using the others.
 func (h *BasicHost) resolveAddrs(ctx context.Context, pi pstore.PeerInfo) ([]ma.Multiaddr, error) {
 	proto := ma.ProtocolWithCode(ma.P_IPFS).Name
 	p2paddr, err := ma.NewMultiaddr("/" + proto + "/" + pi.ID.Pretty())
@@ -455,11 +1169,19 @@ func (h *BasicHost) resolveAddrs(ctx context.Context, pi pstore.PeerInfo) ([]ma.
 		resaddrs, err := h.maResolver.Resolve(ctx, reqaddr)
 		if err != nil {
 			log.Infof("error resolving %s: %s", reqaddr, err)
+			h.emit(events.Event{
+				Type:  events.ResolveFailed,
+				Peer:  pi.ID.Pretty(),
+				Addr:  addr.String(),
+				Error: err.Error(),
+			})
+			continue
 		}
 		for _, res := range resaddrs {
 			pi, err := pstore.InfoFromP2pAddr(res)
 			if err != nil {
 				log.Infof("error parsing %s: %s", res, err)
+				continue
 			}
 			addrs = append(addrs, pi.Addrs...)
 		}
@@ -468,18 +1190,68 @@ func (h *BasicHost) resolveAddrs(ctx context.Context, pi pstore.PeerInfo) ([]ma.
 	return addrs, nil
 }
 
+// dialContext bounds ctx by the host's dial timeout, if it has one. The
+// earlier deadline wins.
+func (h *BasicHost) dialContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if h.dialTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, h.dialTimeout)
+}
+
 // dialPeer opens a connection to peer, and makes sure to identify
 // the connection once it has been opened.
 func (h *BasicHost) dialPeer(ctx context.Context, p peer.ID) error {
 	log.Debugf("host %s dialing %s", h.ID, p)
-	c, err := h.Network().DialPeer(ctx, p)
+	dialDone, err := h.gate.startDial(p)
+	if err != nil {
+		return err
+	}
+	defer dialDone()
+	if err := h.backoff.check(p); err != nil {
+		return err
+	}
+	if h.budget != nil {
+		if err := h.budget.acquire(ctx, dialPriority(ctx)); err != nil {
+			return err
+		}
+	}
+
+	before := time.Now()
+	dctx, cancel := h.dialContext(ctx)
+	c, err := h.Network().DialPeer(dctx, p)
+	cancel()
+	h.backoff.done(p, err)
+	if h.budget != nil {
+		h.budget.release()
+	}
 	if err != nil {
+		h.emit(events.Event{
+			Type:  events.DialAttempt,
+			Peer:  p.Pretty(),
+			Error: err.Error(),
+			Took:  time.Since(before),
+		})
 		return err
 	}
+	h.emit(events.Event{
+		Type: events.DialAttempt,
+		Peer: p.Pretty(),
+		Addr: c.RemoteMultiaddr().String(),
+		Took: time.Since(before),
+	})
+	if !h.gateConn(c) {
+		return ErrGaterDisallowedConnection
+	}
+	h.conns.dialed(h.Network(), c)
 
 	// Clear protocols on connecting to new peer to avoid issues caused
 	// by misremembering protocols between reconnects
 	h.Peerstore().SetProtocols(p)
+	if h.ids == nil {
+		log.Debugf("host %s finished dialing %s", h.ID(), p)
+		return nil
+	}
 
 	// identify the connection before returning.
 	done := make(chan struct{})
@@ -503,21 +1275,78 @@ func (h *BasicHost) ConnManager() ifconnmgr.ConnManager {
 	return h.cmgr
 }
 
+// Protect keeps the connection manager from trimming the connections to the
+// given peer, for connection managers that support it, until Unprotect is
+// called with the same tag. Protocols use it for peers they can't lose, and
+// TagPeer on ConnManager for peers they merely value.
+func (h *BasicHost) Protect(p peer.ID, tag string) {
+	if cm, ok := h.cmgr.(interface {
+		Protect(peer.ID, string)
+	}); ok {
+		cm.Protect(p, tag)
+	}
+}
+
+// Unprotect removes a protection set with Protect, and reports whether the
+// peer is still protected under other tags.
+func (h *BasicHost) Unprotect(p peer.ID, tag string) bool {
+	if cm, ok := h.cmgr.(interface {
+		Unprotect(peer.ID, string) bool
+	}); ok {
+		return cm.Unprotect(p, tag)
+	}
+	return false
+}
+
 // Addrs returns listening addresses that are safe to announce to the network.
-// The output is the same as AllAddrs, but processed by AddrsFactory.
+// The output is the same as AllAddrs, but processed by AddrsFactory, and
+// without the external addresses demoted by their validation. While the host
+// advertises relay addresses (see HostOpts.AutoRelays), they replace its
+// private addresses.
 func (h *BasicHost) Addrs() []ma.Multiaddr {
-	return h.addrs(h.AllAddrs())
+	addrs := h.addrs(h.AllAddrs())
+	if h.extAddrs != nil {
+		addrs = h.extAddrs.filter(addrs)
+	}
+	if relayAddrs := h.RelayAddrs(); len(relayAddrs) > 0 {
+		// we aren't publicly reachable, peers should dial us through
+		// the relays rather than at our private addresses.
+		out := addrs[:0:0]
+		for _, a := range addrs {
+			if IsPublicAddr(a) {
+				out = append(out, a)
+			}
+		}
+		for _, a := range relayAddrs {
+			if !containsAddr(out, a) {
+				out = append(out, a)
+			}
+		}
+		addrs = out
+	}
+	return addrs
 }
 
 // AllAddrs returns all the addresses of BasicHost at this moment in time.
 // It's ok to not include addresses if they're not available to be used now.
+// Unspecified listen addresses, such as 0.0.0.0, are replaced with the
+// addresses of the current network interfaces. Unix domain socket
+// addresses are left out unless HostOpts.AdvertiseUnixAddrs is set.
 func (h *BasicHost) AllAddrs() []ma.Multiaddr {
-	addrs, err := h.Network().InterfaceListenAddresses()
-	if err != nil {
-		log.Debug("error retrieving network interface addrs")
+	addrs := h.interfaceListenAddrs()
+	if !h.advertiseUnix {
+		out := addrs[:0]
+		for _, a := range addrs {
+			if !isUnixAddr(a) {
+				out = append(out, a)
+			}
+		}
+		addrs = out
 	}
 
-	if h.ids != nil { // add external observed addresses
+	// add external observed addresses. They are only included once
+	// observed by several distinct peers, and expire when not seen again.
+	if h.ids != nil && !h.noObservedAddrs {
 		addrs = append(addrs, h.ids.OwnObservedAddrs()...)
 	}
 
@@ -531,11 +1360,70 @@ func (h *BasicHost) AllAddrs() []ma.Multiaddr {
 	return addrs
 }
 
+// StartupWarnings returns the problems found while setting up the host
+// that didn't prevent it from starting.
+func (h *BasicHost) StartupWarnings() []string {
+	return append([]string(nil), h.startupWarnings...)
+}
+
+// StaticPeers returns the peers given in HostOpts.StaticPeers.
+func (h *BasicHost) StaticPeers() []pstore.PeerInfo {
+	return append([]pstore.PeerInfo(nil), h.staticPeers...)
+}
+
 // Close shuts down the Host's services (network, etc).
+//
+// All open streams are reset first, and Close waits for running stream
+// handlers to return, for at most HostOpts.CloseTimeout. From the moment
+// Close is called, Connect and NewStream return ErrHostClosed and new
+// inbound streams are refused.
 func (h *BasicHost) Close() error {
 	return h.proc.Close()
 }
 
+// OnClose registers c, a service built on top of the host, to be closed
+// along with the host, before its network is.
+func (h *BasicHost) OnClose(c io.Closer) {
+	h.proc.AddChild(goprocess.WithTeardown(c.Close))
+}
+
+// emit sends an event to the host's event sink, if it has one.
+func (h *BasicHost) emit(e events.Event) {
+	if h.eventSink == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.eventSink.Emit(e)
+}
+
+// NATMappingErrors returns the port mappings the host's NAT manager
+// currently fails to establish, all of them with ErrNoNATDevice if it found
+// no NAT device. Mapping failures don't stop the host, they just leave it
+// without the corresponding external addresses.
+func (h *BasicHost) NATMappingErrors() []*NATMappingError {
+	nm, ok := h.natmgr.(interface {
+		MappingErrors() []*NATMappingError
+	})
+	if !ok {
+		return nil
+	}
+	return nm.MappingErrors()
+}
+
+// NATMappings returns the port mappings the host's NAT manager currently
+// holds, for NAT managers that can list them.
+func (h *BasicHost) NATMappings() []NATMapping {
+	nm, ok := h.natmgr.(interface {
+		Mappings() []NATMapping
+	})
+	if !ok {
+		return nil
+	}
+	return nm.Mappings()
+}
+
 // GetBandwidthReporter exposes the Host's bandiwth metrics reporter
 func (h *BasicHost) GetBandwidthReporter() metrics.Reporter {
 	return h.bwc
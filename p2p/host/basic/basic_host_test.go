@@ -3,14 +3,27 @@ package basichost
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+	guard "github.com/libp2p/go-libp2p/p2p/net/guard"
+	autonat "github.com/libp2p/go-libp2p/p2p/protocol/autonat"
+	identify "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+
 	host "github.com/libp2p/go-libp2p-host"
+	metrics "github.com/libp2p/go-libp2p-metrics"
+	inat "github.com/libp2p/go-libp2p-nat"
 	inet "github.com/libp2p/go-libp2p-net"
 	testutil "github.com/libp2p/go-libp2p-netutil"
+	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	protocol "github.com/libp2p/go-libp2p-protocol"
 	ma "github.com/multiformats/go-multiaddr"
@@ -385,3 +398,1631 @@ func (sma sortedMultiaddrs) Swap(i, j int) { sma[i], sma[j] = sma[j], sma[i] }
 func (sma sortedMultiaddrs) Less(i, j int) bool {
 	return bytes.Compare(sma[i].Bytes(), sma[j].Bytes()) == 1
 }
+
+func TestConnSelectionPolicy(t *testing.T) {
+	direct := ConnStat{Latency: time.Millisecond * 50, NumStreams: 3}
+	relayed := ConnStat{Transient: true, Latency: time.Millisecond * 10}
+	fast := ConnStat{Latency: time.Millisecond * 10, NumStreams: 5}
+	idle := ConnStat{Latency: time.Millisecond * 10}
+	unmeasured := ConnStat{NumStreams: 1}
+
+	cases := []struct {
+		stats []ConnStat
+		exp   int
+	}{
+		{[]ConnStat{relayed, direct}, 1},
+		{[]ConnStat{direct, fast}, 1},
+		{[]ConnStat{fast, idle}, 1},
+		{[]ConnStat{idle, fast, relayed, direct}, 0},
+		// an unmeasured latency isn't a low one.
+		{[]ConnStat{unmeasured, direct}, 0},
+	}
+	for i, c := range cases {
+		if got := selectConn(c.stats, DefaultConnSelectionPolicy); got != c.exp {
+			t.Errorf("case %d: expected connection %d to be selected, got %d", i, c.exp, got)
+		}
+	}
+
+	// a custom policy that always prefers relayed connections.
+	preferRelay := func(a, b ConnStat) int {
+		if a.Transient && !b.Transient {
+			return -1
+		}
+		if b.Transient && !a.Transient {
+			return 1
+		}
+		return 0
+	}
+	if got := selectConn([]ConnStat{direct, relayed, idle}, preferRelay); got != 1 {
+		t.Fatalf("expected the custom policy to select the relayed connection, got %d", got)
+	}
+
+	// the latency is the connection's own, not the peer's.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+	bh := h1.(*BasicHost)
+	conns := bh.Network().ConnsToPeer(h2.ID())
+	if len(conns) != 1 {
+		t.Fatalf("expected a connection, got %d", len(conns))
+	}
+	bh.Peerstore().RecordLatency(h2.ID(), time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for bh.connStat(conns[0]).Latency == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l := bh.connStat(conns[0]).Latency; l <= 0 || l >= time.Hour {
+		t.Fatalf("expected the identify round trip of the connection, got %s", l)
+	}
+}
+
+// lifecycleRecorder records the Connected/Disconnected sequence it sees per
+// peer, both as a notifiee and as an event sink.
+type lifecycleRecorder struct {
+	mu  sync.Mutex
+	seq map[string][]bool
+}
+
+func newLifecycleRecorder() *lifecycleRecorder {
+	return &lifecycleRecorder{seq: make(map[string][]bool)}
+}
+
+func (r *lifecycleRecorder) record(p string, connected bool) {
+	r.mu.Lock()
+	r.seq[p] = append(r.seq[p], connected)
+	r.mu.Unlock()
+}
+
+func (r *lifecycleRecorder) sequence(p string) []bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]bool(nil), r.seq[p]...)
+}
+
+func (r *lifecycleRecorder) Emit(ev events.Event) {
+	switch ev.Type {
+	case events.Connected:
+		r.record(ev.Peer, true)
+	case events.Disconnected:
+		r.record(ev.Peer, false)
+	}
+}
+
+func (r *lifecycleRecorder) Connected(_ inet.Network, c inet.Conn) {
+	r.record(c.RemotePeer().Pretty(), true)
+}
+
+func (r *lifecycleRecorder) Disconnected(_ inet.Network, c inet.Conn) {
+	r.record(c.RemotePeer().Pretty(), false)
+}
+
+func (r *lifecycleRecorder) Listen(inet.Network, ma.Multiaddr)      {}
+func (r *lifecycleRecorder) ListenClose(inet.Network, ma.Multiaddr) {}
+func (r *lifecycleRecorder) OpenedStream(inet.Network, inet.Stream) {}
+func (r *lifecycleRecorder) ClosedStream(inet.Network, inet.Stream) {}
+
+func TestOrderedNotifications(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const rounds = 200
+
+	var hosts [2]*BasicHost
+	var recorders []*lifecycleRecorder
+	for i := range hosts {
+		sink := newLifecycleRecorder()
+		h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+			EventSink:            sink,
+			OrderedNotifications: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+
+		notifiee := newLifecycleRecorder()
+		h.Network().Notify(notifiee)
+
+		hosts[i] = h
+		recorders = append(recorders, sink, notifiee)
+	}
+	h1, h2 := hosts[0], hosts[1]
+	h2pi := h2.Peerstore().PeerInfo(h2.ID())
+
+	for i := 0; i < rounds; i++ {
+		if err := h1.Connect(ctx, h2pi); err != nil {
+			t.Fatal(err)
+		}
+
+		// alternate which side hangs up.
+		closer, other := h1, h2
+		if i%2 == 1 {
+			closer, other = h2, h1
+		}
+		if err := closer.Network().ClosePeer(other.ID()); err != nil {
+			t.Fatal(err)
+		}
+		for h1.Network().Connectedness(h2.ID()) == inet.Connected ||
+			h2.Network().Connectedness(h1.ID()) == inet.Connected {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i, r := range recorders {
+		remote := hosts[1-i/2].ID().Pretty()
+
+		var seq []bool
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			seq = r.sequence(remote)
+			if len(seq) >= 2*rounds || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if len(seq) != 2*rounds {
+			t.Fatalf("observer %d: expected %d notifications, got %d", i, 2*rounds, len(seq))
+		}
+		for j, connected := range seq {
+			if connected != (j%2 == 0) {
+				t.Fatalf("observer %d: notification %d out of order: %v", i, j, seq[:j+1])
+			}
+		}
+	}
+}
+
+// eventCollector is an event sink recording every event.
+type eventCollector struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (c *eventCollector) Emit(ev events.Event) {
+	c.mu.Lock()
+	c.events = append(c.events, ev)
+	c.mu.Unlock()
+}
+
+func (c *eventCollector) ofType(typ events.Type) []events.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var out []events.Event
+	for _, ev := range c.events {
+		if ev.Type == typ {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+func TestStreamStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		proto   = protocol.ID("/test/stats")
+		request = 4096
+		reply   = 10000
+	)
+
+	var hosts [2]*BasicHost
+	var sinks [2]*eventCollector
+	var bwcs [2]*metrics.BandwidthCounter
+	for i := range hosts {
+		sinks[i] = &eventCollector{}
+		bwcs[i] = metrics.NewBandwidthCounter()
+		h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+			EventSink:         sinks[i],
+			BandwidthReporter: bwcs[i],
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Close()
+		hosts[i] = h
+	}
+	h1, h2 := hosts[0], hosts[1]
+
+	remoteStat := make(chan StreamStat, 1)
+	h2.SetStreamHandler(proto, func(s inet.Stream) {
+		if _, err := io.ReadFull(s, make([]byte, request)); err != nil {
+			t.Error(err)
+		}
+		if _, err := s.Write(make([]byte, reply)); err != nil {
+			t.Error(err)
+		}
+		s.Close()
+		io.Copy(ioutil.Discard, s)
+		remoteStat <- s.(StatStream).Stat()
+	})
+
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	s, err := h1.NewStream(ctx, h2.ID(), proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write(make([]byte, request)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(s, make([]byte, reply)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := io.Copy(ioutil.Discard, s); err != nil {
+		t.Fatal(err)
+	}
+
+	local := s.(StatStream).Stat()
+	var remote StreamStat
+	select {
+	case remote = <-remoteStat:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't finish")
+	}
+
+	check := func(name string, st StreamStat, peer host.Host, read, written uint64) {
+		if st.Protocol != proto || st.Peer != peer.ID() {
+			t.Errorf("%s: stats for wrong stream: %s with %s", name, st.Protocol, st.Peer)
+		}
+		if st.BytesRead != read || st.BytesWritten != written {
+			t.Errorf("%s: expected %d/%d bytes read/written, got %d/%d", name, read, written, st.BytesRead, st.BytesWritten)
+		}
+		if st.Duration <= 0 {
+			t.Errorf("%s: expected a positive duration", name)
+		}
+	}
+	check("dialer", local, h2, reply, request)
+	check("listener", remote, h1, request, reply)
+
+	bw := h1.GetBandwidthByTransport()
+	if len(bw) != 1 || bw["tcp"].TotalOut != request || bw["tcp"].TotalIn != reply {
+		t.Errorf("expected %d/%d bytes in/out over tcp, got %v", reply, request, bw)
+	}
+
+	// the StreamClosed events agree with Stat.
+	for i, st := range []StreamStat{local, remote} {
+		var found bool
+		for _, ev := range sinks[i].ofType(events.StreamClosed) {
+			if ev.Protocol != string(proto) {
+				continue
+			}
+			found = true
+			if ev.Peer != st.Peer.Pretty() || ev.BytesRead != st.BytesRead ||
+				ev.BytesWritten != st.BytesWritten || ev.Took != st.Duration {
+				t.Errorf("host %d: StreamClosed event %+v doesn't match stream stats %+v", i, ev, st)
+			}
+		}
+		if !found {
+			t.Errorf("host %d: no StreamClosed event", i)
+		}
+	}
+
+	// and so does the bandwidth reporter, which updates periodically.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out := bwcs[0].GetBandwidthForProtocol(proto)
+		in := bwcs[1].GetBandwidthForProtocol(proto)
+		if out.TotalOut == request && out.TotalIn == reply &&
+			in.TotalOut == reply && in.TotalIn == request {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("reporter totals don't match: dialer %+v, listener %+v", out, in)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStreamClosedOnRemoteReset(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const proto = protocol.ID("/test/reset")
+
+	sink := &eventCollector{}
+	h1 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h1.Close()
+	h2, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{EventSink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	// the handler neither closes nor resets the stream.
+	handled := make(chan struct{})
+	h2.SetStreamHandler(proto, func(s inet.Stream) {
+		close(handled)
+	})
+
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	s, err := h1.NewStream(ctx, h2.ID(), proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler wasn't called")
+	}
+	s.Reset()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.ofType(events.StreamClosed)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a StreamClosed event once the remote reset the stream")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ev := sink.ofType(events.StreamClosed)[0]; ev.Protocol != string(proto) || ev.BytesRead != 0 {
+		t.Fatalf("unexpected StreamClosed event: %+v", ev)
+	}
+}
+
+func TestStreamClosedOnHostClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const proto = protocol.ID("/test/shutdown")
+
+	sink := &eventCollector{}
+	h1 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h1.Close()
+	h2, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{EventSink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h1.SetStreamHandler(proto, func(s inet.Stream) {})
+
+	if err := h2.Connect(ctx, h1.Peerstore().PeerInfo(h1.ID())); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h2.NewStream(ctx, h1.ID(), proto); err != nil {
+		t.Fatal(err)
+	}
+	h2.Close()
+
+	evs := sink.ofType(events.StreamClosed)
+	if len(evs) != 1 || evs[0].Protocol != string(proto) {
+		t.Fatalf("expected a StreamClosed event for the stream reset on close, got %+v", evs)
+	}
+}
+
+func TestAddrsUpdateDebounce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const interval = 100 * time.Millisecond
+
+	// simulate a NAT whose external mapping keeps flapping.
+	mapped1, _ := ma.NewMultiaddr("/ip4/203.0.113.7/tcp/4001")
+	mapped2, _ := ma.NewMultiaddr("/ip4/203.0.113.7/tcp/4002")
+	mapped := []ma.Multiaddr{mapped1, mapped2}
+	var mapping int32
+	factory := func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		return append(addrs, mapped[atomic.LoadInt32(&mapping)])
+	}
+
+	sink := &eventCollector{}
+	h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		AddrsFactory:        factory,
+		AddrsUpdateInterval: interval,
+		EventSink:           sink,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	const flapping = time.Second
+	start := time.Now()
+	for time.Since(start) < flapping {
+		atomic.StoreInt32(&mapping, 1-atomic.LoadInt32(&mapping))
+		h.signalAddrsChanged()
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt32(&mapping, 1)
+	h.signalAddrsChanged()
+	time.Sleep(3 * interval)
+
+	addrs, changes := h.PublishedAddrs()
+	max := uint64(flapping/interval) + 3
+	if changes > max {
+		t.Fatalf("expected at most %d address updates, got %d", max, changes)
+	}
+	if n := len(sink.ofType(events.AddrsUpdated)); uint64(n) != changes {
+		t.Fatalf("expected %d AddrsUpdated events, got %d", changes, n)
+	}
+
+	var found bool
+	for _, a := range addrs {
+		if a.Equal(mapped[0]) {
+			t.Fatalf("stale mapping %s still published", a)
+		}
+		found = found || a.Equal(mapped[1])
+	}
+	if !found {
+		t.Fatalf("expected the final mapping to be published, got %v", addrs)
+	}
+}
+
+func TestConnBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const budget = 8
+
+	// a listener that accepts connections but never completes a handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	tarpit, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", l.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{ConnBudget: budget})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h2.Close()
+
+	// a large bootstrap list of peers that will never answer.
+	bctx, bcancel := context.WithTimeout(WithDialPriority(ctx, PriorityBootstrap), 3*time.Second)
+	defer bcancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		p, err := testutil.RandPeerID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h1.Connect(bctx, pstore.PeerInfo{ID: p, Addrs: []ma.Multiaddr{tarpit}})
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	st := h1.ConnBudgetStats()
+	if st.Waiting[PriorityBootstrap] == 0 {
+		t.Fatalf("expected bootstrap dials to be waiting for the budget: %+v", st)
+	}
+
+	// an explicit connection doesn't wait for the bootstrap dials.
+	start := time.Now()
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Fatalf("explicit connect took %s", took)
+	}
+
+	wg.Wait()
+	if st := h1.ConnBudgetStats(); st.MaxInFlight > budget || st.InFlight != 0 {
+		t.Fatalf("budget not respected: %+v", st)
+	}
+}
+
+func TestCloseWithActiveStreams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const closeTimeout = 500 * time.Millisecond
+
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{CloseTimeout: closeTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h2.Close()
+
+	// a well behaved handler blocks on its stream, and returns once it is
+	// reset.
+	started := make(chan struct{}, 2)
+	returned := make(chan error, 1)
+	h1.SetStreamHandler("/test/blocking", func(s inet.Stream) {
+		started <- struct{}{}
+		_, err := s.Read(make([]byte, 1))
+		returned <- err
+	})
+	// a misbehaving one ignores its stream entirely.
+	stuck := make(chan struct{})
+	defer close(stuck)
+	h1.SetStreamHandler("/test/stuck", func(s inet.Stream) {
+		started <- struct{}{}
+		<-stuck
+	})
+
+	h2pi := h2.Peerstore().PeerInfo(h2.ID())
+	if err := h1.Connect(ctx, h2pi); err != nil {
+		t.Fatal(err)
+	}
+	for _, proto := range []protocol.ID{"/test/blocking", "/test/stuck"} {
+		s, err := h2.NewStream(ctx, h1.ID(), proto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the handler only runs once the peer speaks.
+		if _, err := s.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handlers didn't start")
+		}
+	}
+
+	start := time.Now()
+	if err := h1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	took := time.Since(start)
+	if took < closeTimeout || took > closeTimeout+2*time.Second {
+		t.Fatalf("expected Close to wait for the stuck handler for about %s, took %s", closeTimeout, took)
+	}
+
+	select {
+	case err := <-returned:
+		if err == nil {
+			t.Fatal("expected the handler's read to fail")
+		}
+	default:
+		t.Fatal("expected the blocking handler to have returned")
+	}
+
+	if _, err := h1.NewStream(ctx, h2.ID(), "/test/blocking"); err != ErrHostClosed {
+		t.Fatalf("expected ErrHostClosed from NewStream, got %v", err)
+	}
+	if err := h1.Connect(ctx, h2pi); err != ErrHostClosed {
+		t.Fatalf("expected ErrHostClosed from Connect, got %v", err)
+	}
+}
+
+func TestSamplePeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h.Close()
+
+	const n = 10
+	var peers []host.Host
+	for i := 0; i < n; i++ {
+		p := New(testutil.GenSwarmNetwork(t, ctx))
+		defer p.Close()
+		peers = append(peers, p)
+
+		// half the connections are dialed by h, half by the peers.
+		var err error
+		if i%2 == 0 {
+			err = h.Connect(ctx, p.Peerstore().PeerInfo(p.ID()))
+		} else {
+			err = p.Connect(ctx, h.Peerstore().PeerInfo(h.ID()))
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 4 {
+			h.Peerstore().AddProtocols(p.ID(), "/sample/1.0.0")
+		}
+	}
+	// let the connection notifications through.
+	time.Sleep(100 * time.Millisecond)
+
+	check := func(name string, opts SampleOpts, want func(i int) bool) {
+		got := SamplePeers(h, n, opts)
+		picked := make(map[string]bool)
+		for _, p := range got {
+			picked[string(p)] = true
+		}
+		for i, p := range peers {
+			if picked[string(p.ID())] != want(i) {
+				t.Errorf("%s: peer %d picked: %t, expected %t", name, i, picked[string(p.ID())], want(i))
+			}
+		}
+	}
+
+	check("all", SampleOpts{}, func(int) bool { return true })
+	check("protocol", SampleOpts{Protocols: []string{"/sample/1.0.0"}}, func(i int) bool { return i < 4 })
+	check("outbound", SampleOpts{Direction: DirOutbound}, func(i int) bool { return i%2 == 0 })
+	check("inbound", SampleOpts{Direction: DirInbound}, func(i int) bool { return i%2 == 1 })
+	check("direct", SampleOpts{NoTransient: true}, func(int) bool { return true })
+	check("young", SampleOpts{MinConnAge: time.Hour}, func(int) bool { return false })
+	check("old enough", SampleOpts{MinConnAge: 50 * time.Millisecond}, func(int) bool { return true })
+	check("exclude", SampleOpts{
+		Exclude: map[peer.ID]struct{}{peers[0].ID(): {}, peers[1].ID(): {}},
+	}, func(i int) bool { return i > 1 })
+
+	// every peer is picked about as often.
+	const k, draws = 3, 10000
+	counts := make(map[peer.ID]int)
+	for i := 0; i < draws; i++ {
+		got := SamplePeers(h, k, SampleOpts{})
+		if len(got) != k {
+			t.Fatalf("expected %d peers, got %d", k, len(got))
+		}
+		for _, p := range got {
+			counts[p]++
+		}
+	}
+	expected := float64(draws * k / n)
+	for _, p := range peers {
+		if c := float64(counts[p.ID()]); c < expected*0.9 || c > expected*1.1 {
+			t.Errorf("peer picked %.0f times, expected about %.0f", c, expected)
+		}
+	}
+}
+
+func TestExternalAddrRevalidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ext := ma.StringCast("/ip4/203.0.113.7/tcp/4001")
+	sink := &eventCollector{}
+	h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		AddrsFactory: func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			return append(addrs, ext)
+		},
+		EventSink:                    sink,
+		ValidateExternalAddrs:        []ma.Multiaddr{ext},
+		ExternalAddrCheckInterval:    50 * time.Millisecond,
+		ExternalAddrFailureThreshold: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	// simulate the port forward: the address works while peers reach us
+	// at it.
+	var forwarding int32 = 1
+	h.extAddrs.mu.Lock()
+	h.extAddrs.lastSeen = func(ma.Multiaddr) time.Time {
+		if atomic.LoadInt32(&forwarding) == 1 {
+			return time.Now()
+		}
+		return time.Time{}
+	}
+	h.extAddrs.mu.Unlock()
+
+	advertised := func() bool {
+		for _, a := range h.Addrs() {
+			if a.Equal(ext) {
+				return true
+			}
+		}
+		return false
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if !advertised() {
+		t.Fatal("expected the working external address to be advertised")
+	}
+
+	// break the forward.
+	atomic.StoreInt32(&forwarding, 0)
+	time.Sleep(200 * time.Millisecond)
+	if advertised() {
+		t.Fatal("expected the broken external address to be demoted")
+	}
+	st := h.ExternalAddrStatus()
+	if len(st) != 1 || !st[0].Demoted || st[0].Failures < 2 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if len(sink.ofType(events.ExternalAddrDemoted)) != 1 {
+		t.Fatal("expected an ExternalAddrDemoted event")
+	}
+
+	// and fix it.
+	atomic.StoreInt32(&forwarding, 1)
+	time.Sleep(200 * time.Millisecond)
+	if !advertised() {
+		t.Fatal("expected the external address to be restored")
+	}
+	if len(sink.ofType(events.ExternalAddrRestored)) != 1 {
+		t.Fatal("expected an ExternalAddrRestored event")
+	}
+}
+
+func TestExternalAddrDialBack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the forward: a local port, where nothing listens yet.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	ext := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port))
+
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		AddrsFactory: func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			return append(addrs, ext)
+		},
+		ValidateExternalAddrs:        []ma.Multiaddr{ext},
+		ExternalAddrCheckInterval:    50 * time.Millisecond,
+		ExternalAddrFailureThreshold: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+
+	// no peer connects to us through the forward, only dial backs tell.
+	h1.extAddrs.mu.Lock()
+	h1.extAddrs.lastSeen = func(ma.Multiaddr) time.Time { return time.Time{} }
+	h1.extAddrs.mu.Unlock()
+
+	h2 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h2.Close()
+	autonat.NewService(h2, autonat.RateLimit{})
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	demoted := func() bool {
+		st := h1.ExternalAddrStatus()
+		return len(st) == 1 && st[0].Demoted
+	}
+	waitFor := func(cond func() bool, msg string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(demoted, "expected the broken forward to be demoted")
+
+	// fix the forward.
+	l, err = net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Skipf("port %d was taken meanwhile: %s", port, err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	waitFor(func() bool { return !demoted() }, "expected a dial back to restore the external address")
+	if st := h1.ExternalAddrStatus(); st[0].Failures != 0 || st[0].LastValidated.IsZero() {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+}
+
+func TestSlowNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sink := &eventCollector{}
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		EventSink: sink,
+		NegotiationLimits: &guard.Limits{
+			MinBytes: 4,
+			Window:   200 * time.Millisecond,
+			Loopback: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h2.Close()
+
+	handled := make(chan struct{}, 1)
+	h1.SetStreamHandler("/test/slow", func(s inet.Stream) {
+		handled <- struct{}{}
+		s.Close()
+	})
+	if err := h2.Connect(ctx, h1.Peerstore().PeerInfo(h1.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	handshake := []byte("\x13/multistream/1.0.0\n\x0b/test/slow\n")
+
+	// dribble writes the handshake a byte at a time, and returns how long
+	// it took, and the error that stopped it if any.
+	dribble := func(every time.Duration) (time.Duration, error) {
+		s, err := h2.Network().NewStream(ctx, h1.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		start := time.Now()
+		for _, b := range handshake {
+			if _, err := s.Write([]byte{b}); err != nil {
+				return time.Since(start), err
+			}
+			time.Sleep(every)
+		}
+		return time.Since(start), nil
+	}
+
+	// slow but steady: 10 bytes per window.
+	if _, err := dribble(20 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the slow but steady negotiation to succeed")
+	}
+
+	// dribbling: about a byte per window.
+	took, err := dribble(150 * time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the dribbling stream to be reset")
+	}
+	if took > time.Second {
+		t.Fatalf("dribbling stream reset after %s", took)
+	}
+	select {
+	case <-handled:
+		t.Fatal("handler called for the dribbling stream")
+	default:
+	}
+	if len(sink.ofType(events.SlowTransfer)) != 1 {
+		t.Fatal("expected a SlowTransfer event")
+	}
+}
+
+func TestIdleStreamNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		NegotiationLimits: &guard.Limits{
+			MinBytes: 4,
+			Window:   100 * time.Millisecond,
+			Loopback: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h2.Close()
+
+	h1.SetStreamHandler("/test/lazy", func(s inet.Stream) {
+		s.Write([]byte("hello"))
+		s.Close()
+	})
+	if err := h2.Connect(ctx, h1.Peerstore().PeerInfo(h1.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	// a lazily negotiated stream, to a protocol known to be supported,
+	// sends nothing until it's first used.
+	h2.Peerstore().AddProtocols(h1.ID(), "/test/lazy")
+	s, err := h2.NewStream(ctx, h1.ID(), "/test/lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if _, err := s.Write(nil); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected the idle stream to be negotiated, got %q", b)
+	}
+}
+
+// protocolStats is a sample StreamObserver, aggregating stream counts and
+// traffic by protocol.
+type protocolStats struct {
+	mu      sync.Mutex
+	opened  map[protocol.ID]int
+	closed  map[protocol.ID]int
+	inbound map[protocol.ID]int
+	read    map[protocol.ID]uint64
+	written map[protocol.ID]uint64
+
+	// closedRead sums the final read counters of the closed streams.
+	closedRead map[protocol.ID]uint64
+}
+
+func newProtocolStats() *protocolStats {
+	return &protocolStats{
+		opened:     make(map[protocol.ID]int),
+		closed:     make(map[protocol.ID]int),
+		inbound:    make(map[protocol.ID]int),
+		read:       make(map[protocol.ID]uint64),
+		written:    make(map[protocol.ID]uint64),
+		closedRead: make(map[protocol.ID]uint64),
+	}
+}
+
+func (ps *protocolStats) OnOpen(s inet.Stream, dir Direction) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.opened[s.Protocol()]++
+	if dir == DirInbound {
+		ps.inbound[s.Protocol()]++
+	}
+}
+
+func (ps *protocolStats) OnRead(s inet.Stream, n int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.read[s.Protocol()] += uint64(n)
+}
+
+func (ps *protocolStats) OnWrite(s inet.Stream, n int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.written[s.Protocol()] += uint64(n)
+}
+
+func (ps *protocolStats) OnClose(s inet.Stream, st StreamStat) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.closed[st.Protocol]++
+	ps.closedRead[st.Protocol] += st.BytesRead
+}
+
+func TestStreamObserver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const proto = protocol.ID("/test/observed")
+
+	obs1, obs2 := newProtocolStats(), newProtocolStats()
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{StreamObserver: obs1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{StreamObserver: obs2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h2.Close()
+
+	handled := make(chan struct{}, 3)
+	h2.SetStreamHandler(proto, func(s inet.Stream) {
+		io.Copy(s, s)
+		s.Close()
+		handled <- struct{}{}
+	})
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		s, err := h1.NewStream(ctx, h2.ID(), proto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		// the stream is done once read to EOF, not when closed.
+		obs1.mu.Lock()
+		closed := obs1.closed[proto]
+		obs1.mu.Unlock()
+		if closed != i {
+			t.Fatal("expected a stream closed only for writing not to be reported closed")
+		}
+		b, err := ioutil.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "hello" {
+			t.Fatalf("expected the echo, got %q", b)
+		}
+		select {
+		case <-handled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler didn't finish")
+		}
+	}
+
+	obs1.mu.Lock()
+	defer obs1.mu.Unlock()
+	if obs1.opened[proto] != 3 || obs1.inbound[proto] != 0 || obs1.closed[proto] != 3 {
+		t.Fatalf("dialer: expected 3 outbound streams opened and closed, got %d (%d inbound), %d closed",
+			obs1.opened[proto], obs1.inbound[proto], obs1.closed[proto])
+	}
+	if obs1.written[proto] != 15 || obs1.read[proto] != 15 {
+		t.Fatalf("dialer: expected 15 bytes each way, got %d written, %d read", obs1.written[proto], obs1.read[proto])
+	}
+	if obs1.closedRead[proto] != 15 {
+		t.Fatalf("dialer: expected the closed streams to have read 15 bytes, got %d", obs1.closedRead[proto])
+	}
+
+	obs2.mu.Lock()
+	defer obs2.mu.Unlock()
+	if obs2.inbound[proto] != 3 || obs2.closed[proto] != 3 {
+		t.Fatalf("listener: expected 3 inbound streams closed, got %d, %d closed", obs2.inbound[proto], obs2.closed[proto])
+	}
+	if obs2.closedRead[proto] != 15 {
+		t.Fatalf("listener: expected the closed streams to have read 15 bytes, got %d", obs2.closedRead[proto])
+	}
+}
+
+func TestChangeHandlers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	extra, _ := ma.NewMultiaddr("/ip4/203.0.113.7/tcp/4001")
+	var withExtra int32
+	factory := func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		if atomic.LoadInt32(&withExtra) == 1 {
+			addrs = append(addrs, extra)
+		}
+		return addrs
+	}
+
+	// the handlers block, which must not hold up the host.
+	unblock := make(chan struct{})
+	addrChanges := make(chan []ma.Multiaddr, 10)
+	reachChanges := make(chan Reachability, 10)
+	sink := &eventCollector{}
+	h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		AddrsFactory:        factory,
+		AddrsUpdateInterval: 10 * time.Millisecond,
+		EventSink:           sink,
+		AddrsChangeHandlers: []AddrsChangeHandler{func(old, new []ma.Multiaddr) {
+			<-unblock
+			addrChanges <- new
+		}},
+		ReachabilityChangeHandlers: []ReachabilityChangeHandler{func(old, new Reachability) {
+			<-unblock
+			reachChanges <- new
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	atomic.StoreInt32(&withExtra, 1)
+	h.signalAddrsChanged()
+	h.SetReachability(ReachabilityPrivate)
+	h.SetReachability(ReachabilityPrivate)
+	if h.Reachability() != ReachabilityPrivate {
+		t.Fatal("expected the reachability to be set right away")
+	}
+	for {
+		addrs, _ := h.PublishedAddrs()
+		if containsAddr(addrs, extra) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(unblock)
+
+	for delivered := false; !delivered; {
+		select {
+		case addrs := <-addrChanges:
+			delivered = containsAddr(addrs, extra)
+		case <-time.After(5 * time.Second):
+			t.Fatal("address change not delivered")
+		}
+	}
+	select {
+	case r := <-reachChanges:
+		if r != ReachabilityPrivate {
+			t.Fatalf("expected private reachability, got %s", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reachability change not delivered")
+	}
+	select {
+	case r := <-reachChanges:
+		t.Fatalf("unexpected reachability change to %s", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if n := len(sink.ofType(events.ReachabilityChanged)); n != 1 {
+		t.Fatalf("expected one ReachabilityChanged event, got %d", n)
+	}
+}
+
+func TestMaxInboundConns(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sink := new(eventCollector)
+	h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{MaxInboundConns: 1, EventSink: sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	waitConns := func(p peer.ID, n int) {
+		for len(h.Network().ConnsToPeer(p)) != n {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("expected %d connections to %s", n, p)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	first := New(testutil.GenSwarmNetwork(t, ctx))
+	defer first.Close()
+	if err := first.Connect(ctx, h.Peerstore().PeerInfo(h.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	second := New(testutil.GenSwarmNetwork(t, ctx))
+	defer second.Close()
+	second.Connect(ctx, h.Peerstore().PeerInfo(h.ID()))
+	waitConns(second.ID(), 0)
+
+	st := h.InboundStats()
+	if st.Open != 1 || st.Limit != 1 || st.Rejected != 1 {
+		t.Fatalf("unexpected inbound stats: %+v", st)
+	}
+	if len(h.Network().ConnsToPeer(first.ID())) != 1 {
+		t.Fatal("expected the first connection to be kept")
+	}
+	if evs := sink.ofType(events.ConnectionGated); len(evs) != 1 || evs[0].Error != "MaxInboundConns" {
+		t.Fatalf("expected the rejection to be emitted, got %+v", evs)
+	}
+
+	// outbound connections don't count.
+	if err := h.Connect(ctx, second.Peerstore().PeerInfo(second.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	// room is made again once the first connection closes.
+	first.Network().ClosePeer(h.ID())
+	waitConns(first.ID(), 0)
+	third := New(testutil.GenSwarmNetwork(t, ctx))
+	defer third.Close()
+	if err := third.Connect(ctx, h.Peerstore().PeerInfo(h.ID())); err != nil {
+		t.Fatal(err)
+	}
+	if st := h.InboundStats(); st.Open != 1 || st.Rejected != 1 {
+		t.Fatalf("unexpected inbound stats: %+v", st)
+	}
+}
+
+func TestInboundConnWhileDialing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{MaxInboundConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	first := New(testutil.GenSwarmNetwork(t, ctx))
+	defer first.Close()
+	if err := first.Connect(ctx, h.Peerstore().PeerInfo(h.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	// while h dials second, a connection from second is still inbound,
+	// and over the limit.
+	second := New(testutil.GenSwarmNetwork(t, ctx))
+	defer second.Close()
+	dialDone, err := h.gate.startDial(second.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dialDone()
+	second.Connect(ctx, h.Peerstore().PeerInfo(h.ID()))
+	for len(h.Network().ConnsToPeer(second.ID())) != 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the inbound connection to be refused")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if st := h.InboundStats(); st.Open != 1 || st.Rejected != 1 {
+		t.Fatalf("unexpected inbound stats: %+v", st)
+	}
+}
+
+type addrConn struct {
+	inet.Conn
+	local, remote         peer.ID
+	localAddr, remoteAddr ma.Multiaddr
+}
+
+func (c *addrConn) LocalPeer() peer.ID            { return c.local }
+func (c *addrConn) RemotePeer() peer.ID           { return c.remote }
+func (c *addrConn) LocalMultiaddr() ma.Multiaddr  { return c.localAddr }
+func (c *addrConn) RemoteMultiaddr() ma.Multiaddr { return c.remoteAddr }
+
+func TestMaxConnsPerPeer(t *testing.T) {
+	a, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrA, addrB := ma.StringCast("/ip4/1.2.3.4/tcp/1000"), ma.StringCast("/ip4/5.6.7.8/tcp/2000")
+	addrA2, addrB2 := ma.StringCast("/ip4/1.2.3.4/tcp/1001"), ma.StringCast("/ip4/5.6.7.8/tcp/2001")
+
+	// a simultaneous dial: each connection, seen from both ends, admitted
+	// in opposite orders.
+	onA := []inet.Conn{
+		&addrConn{local: a, remote: b, localAddr: addrA, remoteAddr: addrB},
+		&addrConn{local: a, remote: b, localAddr: addrA2, remoteAddr: addrB2},
+	}
+	onB := []inet.Conn{
+		&addrConn{local: b, remote: a, localAddr: addrB2, remoteAddr: addrA2},
+		&addrConn{local: b, remote: a, localAddr: addrB, remoteAddr: addrA},
+	}
+	survivor := func(conns []inet.Conn) inet.Conn {
+		g := newGate(nil, 0, 1, 0)
+		now := time.Now()
+		var pruned []inet.Conn
+		for _, c := range conns {
+			d := &gateDecision{conn: c, opened: now}
+			g.decisions[c] = d
+			pruned = append(pruned, g.prune(d)...)
+		}
+		if len(pruned) != 1 {
+			t.Fatalf("expected one connection pruned, got %d", len(pruned))
+		}
+		for _, c := range conns {
+			if !g.isPruned(c) {
+				return c
+			}
+		}
+		return nil
+	}
+	sa, sb := survivor(onA), survivor(onB)
+	if sa.LocalMultiaddr() != sb.RemoteMultiaddr() || sa.RemoteMultiaddr() != sb.LocalMultiaddr() {
+		t.Fatalf("expected both ends to keep the same connection, got %s-%s and %s-%s",
+			sa.LocalMultiaddr(), sa.RemoteMultiaddr(), sb.LocalMultiaddr(), sb.RemoteMultiaddr())
+	}
+
+	// otherwise, the newest connections go.
+	g := newGate(nil, 0, 1, 0)
+	old := &gateDecision{conn: onA[1], opened: time.Now().Add(-time.Minute)}
+	g.decisions[onA[1]] = old
+	g.prune(old)
+	d := &gateDecision{conn: onA[0], opened: time.Now()}
+	g.decisions[onA[0]] = d
+	if pruned := g.prune(d); len(pruned) != 1 || pruned[0] != onA[0] {
+		t.Fatalf("expected the newest connection to be pruned, got %v", pruned)
+	}
+}
+
+func TestMaxConnsPerPeerWindows(t *testing.T) {
+	a, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := ma.StringCast("/ip4/5.6.7.8/tcp/2000")
+	conn := func(port int) inet.Conn {
+		local := ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", port))
+		return &addrConn{local: a, remote: b, localAddr: local, remoteAddr: remote}
+	}
+
+	// the first two are close enough to be ordered by address, the second
+	// and third too, but not the first and third: the third comes last,
+	// even though its address orders first.
+	start := time.Now()
+	conns := []inet.Conn{conn(1002), conn(1001), conn(1000)}
+	opened := []time.Duration{0, SimultaneousConnWindow * 6 / 10, SimultaneousConnWindow * 12 / 10}
+
+	for _, order := range [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}} {
+		g := newGate(nil, 0, 2, 0)
+		var pruned []inet.Conn
+		for _, i := range order {
+			d := &gateDecision{conn: conns[i], opened: start.Add(opened[i])}
+			g.decisions[conns[i]] = d
+			pruned = append(pruned, g.prune(d)...)
+		}
+		if len(pruned) != 1 || pruned[0] != conns[2] {
+			t.Fatalf("admitting in order %v: expected the newest connection to be pruned, got %v", order, pruned)
+		}
+	}
+}
+
+func TestPruneConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{MaxConnsPerPeer: 1, PruneGracePeriod: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h2.Close()
+
+	done := make(chan struct{})
+	h2.SetStreamHandler("/test", func(s inet.Stream) {
+		<-done
+		s.Close()
+	})
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	s, err := h1.NewStream(ctx, h2.ID(), "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := s.Conn()
+
+	h1.pruneConn(c)
+	time.Sleep(3 * pruneCheckInterval)
+	if len(h1.Network().ConnsToPeer(h2.ID())) != 1 {
+		t.Fatal("expected the connection to be kept while its stream is open")
+	}
+
+	close(done)
+	s.Close()
+	for len(h1.Network().ConnsToPeer(h2.ID())) > 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the connection to be closed once its stream is done")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var cleared int32
+	h, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		DialBackoff:         &DialBackoff{Base: time.Hour, Max: time.Hour},
+		ClearNetworkBackoff: func(peer.ID) { atomic.AddInt32(&cleared, 1) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	p, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi := pstore.PeerInfo{ID: p, Addrs: []ma.Multiaddr{ma.StringCast("/ip4/127.0.0.1/tcp/1")}}
+	if err := h.Connect(ctx, pi); err == nil || err == ErrDialBackoff {
+		t.Fatalf("expected the dial to fail, got %v", err)
+	}
+	if atomic.LoadInt32(&cleared) != 1 {
+		t.Fatal("expected the network backoff to be replaced")
+	}
+	if err := h.Connect(ctx, pi); err != ErrDialBackoff {
+		t.Fatalf("expected the peer to be backed off, got %v", err)
+	}
+	if _, err := h.NewStream(ctx, p, "/test"); err != ErrDialBackoff {
+		t.Fatalf("expected the peer to be backed off, got %v", err)
+	}
+
+	h.ClearBackoff(p)
+	if err := h.Connect(ctx, pi); err == nil || err == ErrDialBackoff {
+		t.Fatalf("expected the peer to be dialed again, got %v", err)
+	}
+
+	// a zero backoff only replaces the network's.
+	b := newDialBackoff(DialBackoff{}, nil)
+	b.done(p, ErrDialBackoff)
+	if err := b.check(p); err != nil {
+		t.Fatalf("expected no backoff, got %v", err)
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sink := new(eventCollector)
+	h1, err := NewHost(ctx, testutil.GenSwarmNetwork(t, ctx), &HostOpts{
+		ResourceLimits: &ResourceLimits{MaxInboundStreamsPerConn: 2},
+		EventSink:      sink,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h2 := New(testutil.GenSwarmNetwork(t, ctx))
+	defer h2.Close()
+
+	h1.SetStreamHandler("/echo", func(s inet.Stream) {
+		defer s.Close()
+		io.Copy(s, s)
+	})
+	if err := h2.Connect(ctx, h1.Peerstore().PeerInfo(h1.ID())); err != nil {
+		t.Fatal(err)
+	}
+
+	// echo checks that a stream is served.
+	echo := func(s inet.Stream) error {
+		if _, err := s.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(s, buf)
+		return err
+	}
+	var open []inet.Stream
+	for i := 0; i < 2; i++ {
+		s, err := h2.NewStream(ctx, h1.ID(), "/echo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := echo(s); err != nil {
+			t.Fatal(err)
+		}
+		open = append(open, s)
+	}
+
+	s, err := h2.NewStream(ctx, h1.ID(), "/echo")
+	if err == nil {
+		err = echo(s)
+	}
+	if err == nil {
+		t.Fatal("expected the stream beyond the limit to be refused")
+	}
+	st := h1.ResourceStats()
+	if st.RejectedPerConn != 1 || st.Streams != 2 {
+		t.Fatalf("unexpected resource stats: %+v", st)
+	}
+	if evs := sink.ofType(events.StreamRefused); len(evs) != 1 || evs[0].Error != "MaxInboundStreamsPerConn" {
+		t.Fatalf("expected the refusal to be emitted, got %+v", evs)
+	}
+	if len(h2.Network().ConnsToPeer(h1.ID())) != 1 {
+		t.Fatal("expected the connection to be kept")
+	}
+
+	// room is made once a stream is closed.
+	open[0].Close()
+	for h1.ResourceStats().Streams != 1 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected the closed stream to be uncounted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	s, err = h2.NewStream(ctx, h1.ID(), "/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := echo(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdentifyPush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1, h2 := getHostPair(ctx, t)
+	defer h1.Close()
+	defer h2.Close()
+
+	supports := func(proto string) bool {
+		protos, err := h2.Peerstore().SupportsProtocols(h1.ID(), proto)
+		return err == nil && len(protos) > 0
+	}
+	waitFor := func(cond func() bool, msg string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal(msg)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	h1.SetStreamHandler("/new", func(s inet.Stream) { s.Close() })
+	waitFor(func() bool { return supports("/new") }, "expected the new protocol to be pushed")
+	h1.RemoveStreamHandler("/new")
+	waitFor(func() bool { return !supports("/new") }, "expected the removed protocol to be pushed")
+
+	// count the pushes h2 receives, instead of consuming them.
+	var pushes int32
+	h2.SetStreamHandler(identify.IDPush, func(s inet.Stream) {
+		atomic.AddInt32(&pushes, 1)
+		s.Close()
+	})
+	time.Sleep(2 * IdentifyPushDelay)
+	atomic.StoreInt32(&pushes, 0)
+	for i := 0; i < 10; i++ {
+		h1.SetStreamHandler(protocol.ID(fmt.Sprintf("/burst/%d", i)), func(s inet.Stream) { s.Close() })
+	}
+	time.Sleep(5 * IdentifyPushDelay)
+	if n := atomic.LoadInt32(&pushes); n != 1 {
+		t.Fatalf("expected a burst of handlers to be pushed once, got %d pushes", n)
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	for a, public := range map[string]bool{
+		"/ip4/1.2.3.4/tcp/4001":              true,
+		"/ip4/127.0.0.1/tcp/4001":            false,
+		"/ip4/192.168.1.2/tcp/4001":          false,
+		"/ip4/169.254.1.2/tcp/4001":          false,
+		"/ip6/fd00::1/tcp/4001":              false,
+		"/ip6/2001:db8::1/tcp/4001":          true,
+		"/dns4/example.com/tcp/4001":         true,
+		"/ip4/10.0.0.1/tcp/4001/p2p-circuit": true,
+	} {
+		if IsPublicAddr(ma.StringCast(a)) != public {
+			t.Errorf("expected IsPublicAddr(%s) to be %t", a, public)
+		}
+	}
+}
+
+func TestNATDiscoveryFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	defer func(discover func() *inat.NAT) { discoverNATDevice = discover }(discoverNATDevice)
+	discoverNATDevice = func() *inat.NAT { return nil }
+
+	net := testutil.GenSwarmNetwork(t, ctx)
+	h, err := NewHost(ctx, net, &HostOpts{NATManager: newNatManager(net)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	listening := len(net.ListenAddresses())
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.NATMappingErrors()) != listening {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a mapping error for each of the %d listen addresses, got %v", listening, h.NATMappingErrors())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, e := range h.NATMappingErrors() {
+		if e.Err != ErrNoNATDevice {
+			t.Fatalf("expected the failed discovery to be reported, got %s", e)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package basichost
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	goprocess "github.com/jbenet/goprocess"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// BootstrapConcurrency bounds the number of connection attempts to
+// bootstrap peers in flight at once.
+var BootstrapConcurrency = 8
+
+// BootstrapTimeout bounds each connection attempt to a bootstrap peer.
+var BootstrapTimeout = 30 * time.Second
+
+// BootstrapBackoff and BootstrapMaxBackoff bound the delay before
+// connection attempts to bootstrap peers are retried, with
+// HostOpts.KeepBootstrapped. The delay doubles after each failed round,
+// and is back to BootstrapBackoff once all the peers are connected. While
+// they are, they're checked every BootstrapMaxBackoff.
+var (
+	BootstrapBackoff    = time.Second
+	BootstrapMaxBackoff = 5 * time.Minute
+)
+
+// bootstrapTag and staticTag are the tags bootstrap and static peers are
+// protected with in the connection manager.
+const (
+	bootstrapTag = "bootstrap"
+	staticTag    = "static"
+)
+
+type bootstrapper struct {
+	peers []pstore.PeerInfo
+	keep  bool
+
+	mu   sync.Mutex
+	errs map[peer.ID]error
+}
+
+// bootstrapLoop connects to the bootstrap peers, once or, with
+// HostOpts.KeepBootstrapped, until the host is closed.
+func (h *BasicHost) bootstrapLoop(p goprocess.Process) {
+	ctx, cancel := context.WithCancel(WithDialPriority(context.Background(), PriorityBootstrap))
+	defer cancel()
+	go func() {
+		<-p.Closing()
+		cancel()
+	}()
+
+	backoff := BootstrapBackoff
+	for {
+		ok := h.bootstrap(ctx)
+		if !h.bootstrapper.keep {
+			return
+		}
+
+		wait := BootstrapMaxBackoff
+		if ok {
+			backoff = BootstrapBackoff
+		} else {
+			wait = backoff
+			if backoff *= 2; backoff > BootstrapMaxBackoff {
+				backoff = BootstrapMaxBackoff
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-p.Closing():
+			return
+		}
+	}
+}
+
+// bootstrap connects to the bootstrap peers we aren't connected to, and
+// reports whether all the attempts succeeded.
+func (h *BasicHost) bootstrap(ctx context.Context) bool {
+	b := h.bootstrapper
+	sem := make(chan struct{}, BootstrapConcurrency)
+	var wg sync.WaitGroup
+	for _, pi := range b.peers {
+		if h.Network().Connectedness(pi.ID) == inet.Connected {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return false
+		}
+		wg.Add(1)
+		go func(pi pstore.PeerInfo) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			cctx, cancel := context.WithTimeout(ctx, BootstrapTimeout)
+			defer cancel()
+			err := h.Connect(cctx, pi)
+			if err != nil {
+				log.Debugf("failed to connect to bootstrap peer %s: %s", pi.ID.Pretty(), err)
+			}
+			b.mu.Lock()
+			if err != nil {
+				b.errs[pi.ID] = err
+			} else {
+				delete(b.errs, pi.ID)
+			}
+			b.mu.Unlock()
+		}(pi)
+	}
+	wg.Wait()
+	return h.bootstrapErrors() == nil
+}
+
+// BootstrapErrors returns the errors of the last connection attempts to
+// the bootstrap peers which failed, by peer, or nil if none did. Failing
+// to connect to bootstrap peers doesn't stop the host.
+func (h *BasicHost) BootstrapErrors() map[peer.ID]error {
+	if h.bootstrapper == nil {
+		return nil
+	}
+	return h.bootstrapErrors()
+}
+
+func (h *BasicHost) bootstrapErrors() map[peer.ID]error {
+	b := h.bootstrapper
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.errs) == 0 {
+		return nil
+	}
+	errs := make(map[peer.ID]error, len(b.errs))
+	for p, err := range b.errs {
+		errs[p] = err
+	}
+	return errs
+}
+
+// DefaultBootstrapPeriod is the default value for HostOpts.BootstrapPeriod.
+var DefaultBootstrapPeriod = 30 * time.Second
+
+// BootstrapState describes the connection maintenance of a host with
+// HostOpts.BootstrapMinPeers, for health checks.
+type BootstrapState struct {
+	// LastRun is when the connected peers were last counted.
+	LastRun time.Time
+
+	// Peers is the number of connected peers, as of LastRun.
+	Peers int
+
+	// ConsecutiveFailures counts the checks in a row that left the host
+	// below BootstrapMinPeers.
+	ConsecutiveFailures int
+}
+
+// maintainer keeps the host connected to a minimum number of peers.
+type maintainer struct {
+	min    int
+	period time.Duration
+
+	mu      sync.Mutex
+	state   BootstrapState
+	backoff map[peer.ID]*backoffEntry
+}
+
+// maintainLoop checks the number of connected peers every period, until
+// the host is closed, and dials more when it's below the minimum.
+func (h *BasicHost) maintainLoop(p goprocess.Process) {
+	ctx, cancel := context.WithCancel(WithDialPriority(context.Background(), PriorityBootstrap))
+	defer cancel()
+	go func() {
+		<-p.Closing()
+		cancel()
+	}()
+
+	ticker := time.NewTicker(h.maintainer.period)
+	defer ticker.Stop()
+	for {
+		h.maintain(ctx)
+		select {
+		case <-ticker.C:
+		case <-p.Closing():
+			return
+		}
+	}
+}
+
+// maintain dials peers, bootstrap peers first, then the ones in the
+// peerstore, until the host is connected to the minimum number of peers
+// or it runs out of candidates. With HostOpts.Discovery, the peers
+// advertised in BootstrapNamespace are added to the peerstore first.
+func (h *BasicHost) maintain(ctx context.Context) {
+	m := h.maintainer
+	if h.discovery != nil && len(h.Network().Peers()) < m.min {
+		fctx, cancel := context.WithTimeout(ctx, BootstrapTimeout)
+		h.findPeers(fctx, BootstrapNamespace, 0)
+		cancel()
+	}
+	sem := make(chan struct{}, BootstrapConcurrency)
+	var wg sync.WaitGroup
+	for _, p := range h.maintenanceCandidates() {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil || len(h.Network().Peers()) >= m.min {
+			break
+		}
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			cctx, cancel := context.WithTimeout(ctx, BootstrapTimeout)
+			defer cancel()
+			err := h.Connect(cctx, h.Peerstore().PeerInfo(p))
+			if err != nil {
+				log.Debugf("failed to connect to %s to keep peers: %s", p.Pretty(), err)
+			}
+			m.done(p, err)
+		}(p)
+	}
+	wg.Wait()
+
+	peers := len(h.Network().Peers())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.LastRun = time.Now()
+	m.state.Peers = peers
+	if peers < m.min {
+		m.state.ConsecutiveFailures++
+	} else {
+		m.state.ConsecutiveFailures = 0
+	}
+}
+
+// maintenanceCandidates returns the peers to dial to get back to the
+// minimum, or none if the host has enough already. Connected peers, peers
+// backed off from, and peers the gater refuses are left out.
+func (h *BasicHost) maintenanceCandidates() []peer.ID {
+	m := h.maintainer
+	if len(h.Network().Peers()) >= m.min {
+		return nil
+	}
+
+	var all []peer.ID
+	if h.bootstrapper != nil {
+		for _, pi := range h.bootstrapper.peers {
+			all = append(all, pi.ID)
+		}
+	}
+	all = append(all, h.Peerstore().PeersWithAddrs()...)
+
+	now := time.Now()
+	seen := make(map[peer.ID]bool, len(all))
+	var out []peer.ID
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range all {
+		if seen[p] || p == h.ID() || h.Network().Connectedness(p) == inet.Connected {
+			continue
+		}
+		seen[p] = true
+		if e, ok := m.backoff[p]; ok && now.Before(e.until) {
+			continue
+		}
+		if h.gate != nil && h.gate.gater != nil && !h.gate.gater.InterceptPeerDial(p) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// done records the outcome of a dial to p, backing off from p for
+// BootstrapBackoff, doubling with each failure up to BootstrapMaxBackoff.
+func (m *maintainer) done(p peer.ID, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.backoff, p)
+		return
+	}
+	e, ok := m.backoff[p]
+	if !ok {
+		e = &backoffEntry{delay: BootstrapBackoff}
+		m.backoff[p] = e
+	} else if e.delay *= 2; e.delay > BootstrapMaxBackoff {
+		e.delay = BootstrapMaxBackoff
+	}
+	e.until = time.Now().Add(e.delay)
+}
+
+// BootstrapState returns the state of the connection maintenance of a
+// host with HostOpts.BootstrapMinPeers.
+func (h *BasicHost) BootstrapState() BootstrapState {
+	m := h.maintainer
+	if m == nil {
+		return BootstrapState{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
@@ -0,0 +1,190 @@
+package basichost
+
+import (
+	"context"
+	"sync"
+)
+
+// DialPriority classifies outgoing connection attempts, so that interactive
+// ones aren't starved by background ones when the connection establishment
+// budget is exhausted.
+type DialPriority int
+
+const (
+	// PriorityDiscovery is for automatic connections to discovered peers.
+	PriorityDiscovery DialPriority = iota
+
+	// PriorityBootstrap is for connections to bootstrap peers and for
+	// prewarming connections.
+	PriorityBootstrap
+
+	// PriorityApplication is for connections explicitly requested by the
+	// application. It is the default.
+	PriorityApplication
+)
+
+type dialPriorityKey struct{}
+
+// WithDialPriority returns a context making the host's Connect (and the
+// dials NewStream triggers) use the given priority.
+func WithDialPriority(ctx context.Context, prio DialPriority) context.Context {
+	return context.WithValue(ctx, dialPriorityKey{}, prio)
+}
+
+func dialPriority(ctx context.Context) DialPriority {
+	if prio, ok := ctx.Value(dialPriorityKey{}).(DialPriority); ok {
+		return prio
+	}
+	return PriorityApplication
+}
+
+// ConnBudgetStats describes the use of the connection establishment budget.
+type ConnBudgetStats struct {
+	// Size is the budget, zero meaning unlimited.
+	Size int
+
+	// InFlight is the number of connection attempts in progress, and
+	// MaxInFlight the highest it has ever been.
+	InFlight    int
+	MaxInFlight int
+
+	// Waiting is the number of connection attempts waiting for the budget,
+	// by priority.
+	Waiting map[DialPriority]int
+}
+
+// connBudget is a semaphore bounding the number of connection attempts in
+// flight. Waiters are served by priority, and the last quarter of the budget
+// is reserved for PriorityApplication so that explicit connections always
+// make progress.
+type connBudget struct {
+	mu      sync.Mutex
+	size    int
+	reserve int
+	inUse   int
+	maxUse  int
+	waiting map[DialPriority][]chan struct{}
+}
+
+func newConnBudget(size int) *connBudget {
+	reserve := size / 4
+	if reserve == 0 && size > 1 {
+		reserve = 1
+	}
+	return &connBudget{
+		size:    size,
+		reserve: reserve,
+		waiting: make(map[DialPriority][]chan struct{}),
+	}
+}
+
+func (b *connBudget) limit(prio DialPriority) int {
+	if prio >= PriorityApplication {
+		return b.size
+	}
+	return b.size - b.reserve
+}
+
+// canTake reports whether a connection attempt of priority prio may start
+// now, without jumping the queue.
+func (b *connBudget) canTake(prio DialPriority) bool {
+	if b.inUse >= b.limit(prio) {
+		return false
+	}
+	for p, q := range b.waiting {
+		if p >= prio && len(q) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *connBudget) take() {
+	b.inUse++
+	if b.inUse > b.maxUse {
+		b.maxUse = b.inUse
+	}
+}
+
+// acquire waits until a connection attempt of priority prio may start.
+func (b *connBudget) acquire(ctx context.Context, prio DialPriority) error {
+	b.mu.Lock()
+	if b.canTake(prio) {
+		b.take()
+		b.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	b.waiting[prio] = append(b.waiting[prio], ch)
+	b.mu.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q := b.waiting[prio]
+	for i, w := range q {
+		if w == ch {
+			b.waiting[prio] = append(q[:i], q[i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// we were granted a slot while giving up on it.
+	b.inUse--
+	b.grant()
+	return ctx.Err()
+}
+
+// release ends a connection attempt.
+func (b *connBudget) release() {
+	b.mu.Lock()
+	b.inUse--
+	b.grant()
+	b.mu.Unlock()
+}
+
+// grant hands free slots to waiters, highest priority first.
+func (b *connBudget) grant() {
+	for prio := PriorityApplication; prio >= PriorityDiscovery; prio-- {
+		for len(b.waiting[prio]) > 0 && b.inUse < b.limit(prio) {
+			ch := b.waiting[prio][0]
+			b.waiting[prio] = b.waiting[prio][1:]
+			b.take()
+			close(ch)
+		}
+		if len(b.waiting[prio]) > 0 {
+			// don't let lower priorities overtake.
+			return
+		}
+	}
+}
+
+func (b *connBudget) stats() ConnBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := ConnBudgetStats{
+		Size:        b.size,
+		InFlight:    b.inUse,
+		MaxInFlight: b.maxUse,
+		Waiting:     make(map[DialPriority]int),
+	}
+	for prio, q := range b.waiting {
+		if len(q) > 0 {
+			st.Waiting[prio] = len(q)
+		}
+	}
+	return st
+}
+
+// ConnBudgetStats returns the use of the host's connection establishment
+// budget.
+func (h *BasicHost) ConnBudgetStats() ConnBudgetStats {
+	if h.budget == nil {
+		return ConnBudgetStats{}
+	}
+	return h.budget.stats()
+}
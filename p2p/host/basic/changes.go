@@ -0,0 +1,85 @@
+package basichost
+
+import (
+	"sync"
+
+	goprocess "github.com/jbenet/goprocess"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrsChangeHandler is told about changes of the addresses the host
+// advertises, see PublishedAddrs.
+type AddrsChangeHandler func(old, new []ma.Multiaddr)
+
+// ReachabilityChangeHandler is told about changes of the host's
+// reachability, see Reachability.
+type ReachabilityChangeHandler func(old, new Reachability)
+
+// changeNotifier calls the change handlers from its own goroutine, in the
+// order of the changes, so that a slow handler doesn't hold up the host.
+type changeNotifier struct {
+	addrsHandlers []AddrsChangeHandler
+	reachHandlers []ReachabilityChangeHandler
+
+	mu      sync.Mutex
+	pending []func()
+	wake    chan struct{}
+}
+
+func newChangeNotifier(addrs []AddrsChangeHandler, reach []ReachabilityChangeHandler) *changeNotifier {
+	return &changeNotifier{
+		addrsHandlers: addrs,
+		reachHandlers: reach,
+		wake:          make(chan struct{}, 1),
+	}
+}
+
+func (n *changeNotifier) queue(f func()) {
+	n.mu.Lock()
+	n.pending = append(n.pending, f)
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *changeNotifier) addrsChanged(old, new []ma.Multiaddr) {
+	if n == nil || len(n.addrsHandlers) == 0 {
+		return
+	}
+	n.queue(func() {
+		for _, f := range n.addrsHandlers {
+			f(old, new)
+		}
+	})
+}
+
+func (n *changeNotifier) reachabilityChanged(old, new Reachability) {
+	if n == nil || len(n.reachHandlers) == 0 {
+		return
+	}
+	n.queue(func() {
+		for _, f := range n.reachHandlers {
+			f(old, new)
+		}
+	})
+}
+
+func (n *changeNotifier) run(p goprocess.Process) {
+	for {
+		select {
+		case <-n.wake:
+		case <-p.Closing():
+			return
+		}
+
+		n.mu.Lock()
+		pending := n.pending
+		n.pending = nil
+		n.mu.Unlock()
+		for _, f := range pending {
+			f()
+		}
+	}
+}
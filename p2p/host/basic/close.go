@@ -0,0 +1,59 @@
+package basichost
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrHostClosed is returned by the host's methods once it has been closed.
+var ErrHostClosed = errors.New("host is closed")
+
+// DefaultCloseTimeout is the default value for HostOpts.CloseTimeout.
+var DefaultCloseTimeout = 10 * time.Second
+
+// isClosed reports whether the host has been closed, or is closing.
+func (h *BasicHost) isClosed() bool {
+	h.closeMu.RLock()
+	defer h.closeMu.RUnlock()
+	return h.closed
+}
+
+// startHandler registers a stream handler goroutine about to be started,
+// unless the host is closing.
+func (h *BasicHost) startHandler() bool {
+	h.closeMu.RLock()
+	defer h.closeMu.RUnlock()
+	if h.closed {
+		return false
+	}
+	h.handlers.Add(1)
+	return true
+}
+
+// shutdown stops accepting new work, resets all open streams, and waits for
+// running stream handlers to return, for at most the close timeout.
+func (h *BasicHost) shutdown() {
+	h.closeMu.Lock()
+	h.closed = true
+	h.closeMu.Unlock()
+
+	for _, c := range h.Network().Conns() {
+		h.conns.closing(h.Network(), c, ErrHostClosed.Error())
+		for _, s := range c.GetStreams() {
+			s.Reset()
+			h.streamClosed(s, ErrHostClosed)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(h.closeTimeout):
+		log.Warningf("stream handlers still running %s after closing the host", h.closeTimeout)
+	}
+}
@@ -0,0 +1,131 @@
+package basichost
+
+import (
+	"sort"
+	"time"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	goprocess "github.com/jbenet/goprocess"
+	inet "github.com/libp2p/go-libp2p-net"
+)
+
+// DefaultPruneGracePeriod is the default value for
+// HostOpts.PruneGracePeriod.
+var DefaultPruneGracePeriod = 10 * time.Second
+
+// SimultaneousConnWindow is how close in time two connections to the same
+// peer must be opened to be told apart by their addresses rather than by
+// their age, when enforcing HostOpts.MaxConnsPerPeer.
+var SimultaneousConnWindow = time.Second
+
+// pruneCheckInterval is how often a pruned connection is checked for
+// remaining streams.
+var pruneCheckInterval = 100 * time.Millisecond
+
+// prune admits the connection of d, and returns the connections to its
+// peer now exceeding the per peer limit, which weren't pruned already.
+//
+// The oldest connections are kept. Connections opened at about the same
+// time, such as the two of a simultaneous dial, are ordered by their
+// addresses instead, taking the address of the peer with the smaller ID
+// first, so that both peers keep the same one. About the same time means
+// within SimultaneousConnWindow of the oldest connection of the group.
+func (g *gate) prune(d *gateDecision) []inet.Conn {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	d.admitted = true
+	if g.maxPerPeer <= 0 {
+		return nil
+	}
+
+	p := d.conn.RemotePeer()
+	var conns []*gateDecision
+	for c, cd := range g.decisions {
+		if c.RemotePeer() == p && cd.admitted && !cd.pruned {
+			conns = append(conns, cd)
+		}
+	}
+	if len(conns) <= g.maxPerPeer {
+		return nil
+	}
+
+	// group the connections into windows, each starting with the oldest
+	// connection not in a previous one, then order them by window, and by
+	// address within a window.
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].opened.Before(conns[j].opened)
+	})
+	window := make(map[*gateDecision]int64, len(conns))
+	start := conns[0].opened
+	for _, cd := range conns {
+		if cd.opened.Sub(start) >= SimultaneousConnWindow {
+			start = cd.opened
+		}
+		window[cd] = start.UnixNano()
+	}
+	sort.SliceStable(conns, func(i, j int) bool {
+		a, b := conns[i], conns[j]
+		if window[a] != window[b] {
+			return window[a] < window[b]
+		}
+		return connKey(a.conn) < connKey(b.conn)
+	})
+
+	var pruned []inet.Conn
+	for _, cd := range conns[g.maxPerPeer:] {
+		cd.pruned = true
+		pruned = append(pruned, cd.conn)
+	}
+	return pruned
+}
+
+// connKey identifies c the same way on both of its ends: by the address of
+// the peer with the smaller ID, then the other one.
+func connKey(c inet.Conn) string {
+	local, remote := c.LocalMultiaddr().String(), c.RemoteMultiaddr().String()
+	if c.LocalPeer() < c.RemotePeer() {
+		return local + " " + remote
+	}
+	return remote + " " + local
+}
+
+// isPruned reports whether c is waiting to be closed for exceeding the per
+// peer limit, so that no new streams should be opened on it.
+func (g *gate) isPruned(c inet.Conn) bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	d, ok := g.decisions[c]
+	return ok && d.pruned
+}
+
+// pruneConn closes c once its streams are done, or after the prune grace
+// period.
+func (h *BasicHost) pruneConn(c inet.Conn) {
+	log.Debugf("pruning connection to %s at %s", c.RemotePeer(), c.RemoteMultiaddr())
+	h.emit(events.Event{
+		Type: events.ConnectionPruned,
+		Peer: c.RemotePeer().Pretty(),
+		Addr: c.RemoteMultiaddr().String(),
+	})
+	h.proc.Go(func(proc goprocess.Process) {
+		defer h.closeConn(c, "pruned")
+
+		deadline := time.After(h.gate.pruneGrace)
+		ticker := time.NewTicker(pruneCheckInterval)
+		defer ticker.Stop()
+		for len(c.GetStreams()) > 0 {
+			select {
+			case <-ticker.C:
+			case <-deadline:
+				return
+			case <-proc.Closing():
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package basichost
+
+import (
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ConnStat describes a connection to a peer, as seen by a
+// ConnSelectionPolicy.
+type ConnStat struct {
+	Conn inet.Conn
+
+	// Transient is true for connections which are not direct, like relayed
+	// connections.
+	Transient bool
+
+	// Latency is the round trip of the identify exchange on the
+	// connection, or zero if it hasn't been measured, e.g. with identify
+	// disabled.
+	Latency time.Duration
+
+	// NumStreams is the number of streams currently open on the connection.
+	NumStreams int
+}
+
+// ConnSelectionPolicy decides which of two connections to the same peer new
+// streams should be opened on. It returns a negative number if a is
+// preferred, a positive number if b is preferred, and zero if either will do.
+type ConnSelectionPolicy func(a, b ConnStat) int
+
+// DefaultConnSelectionPolicy prefers direct connections over transient
+// ones, then connections with lower latency, if both were measured, and
+// then the connection with the fewest open streams.
+func DefaultConnSelectionPolicy(a, b ConnStat) int {
+	if a.Transient != b.Transient {
+		if b.Transient {
+			return -1
+		}
+		return 1
+	}
+	if a.Latency != 0 && b.Latency != 0 && a.Latency != b.Latency {
+		if a.Latency < b.Latency {
+			return -1
+		}
+		return 1
+	}
+	return a.NumStreams - b.NumStreams
+}
+
+// connStat gathers the ConnStat for c.
+func (h *BasicHost) connStat(c inet.Conn) ConnStat {
+	return ConnStat{
+		Conn:       c,
+		Transient:  isCircuitAddr(c.RemoteMultiaddr()),
+		Latency:    h.conns.latency(c),
+		NumStreams: len(c.GetStreams()),
+	}
+}
+
+// bestConn returns the connection to p new streams should be opened on, or
+// nil if we aren't connected to p. Connections being pruned are avoided.
+func (h *BasicHost) bestConn(p peer.ID) inet.Conn {
+	conns := h.Network().ConnsToPeer(p)
+	switch len(conns) {
+	case 0:
+		return nil
+	case 1:
+		return conns[0]
+	}
+
+	stats := make([]ConnStat, 0, len(conns))
+	for _, c := range conns {
+		if !h.gate.isPruned(c) {
+			stats = append(stats, h.connStat(c))
+		}
+	}
+	if len(stats) == 0 {
+		return conns[0]
+	}
+	return stats[selectConn(stats, h.connPolicy)].Conn
+}
+
+// selectConn returns the index of the preferred connection in stats.
+func selectConn(stats []ConnStat, policy ConnSelectionPolicy) int {
+	best := 0
+	for i := 1; i < len(stats); i++ {
+		if policy(stats[i], stats[best]) < 0 {
+			best = i
+		}
+	}
+	return best
+}
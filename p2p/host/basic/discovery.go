@@ -0,0 +1,99 @@
+package basichost
+
+import (
+	"context"
+	"time"
+
+	goprocess "github.com/jbenet/goprocess"
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// RelayNamespace and BootstrapNamespace are the namespaces relays, and
+// peers to bootstrap from, are advertised in with HostOpts.Discovery.
+const (
+	RelayNamespace     = "/libp2p/relay"
+	BootstrapNamespace = "/libp2p/bootstrap"
+)
+
+// DiscoveryRetryInterval is how long the host waits before advertising
+// itself again after a failure, or when the discovery gives no TTL.
+var DiscoveryRetryInterval = time.Minute
+
+// DiscoveredRelays bounds the number of relays found with HostOpts.Discovery
+// the host uses at once.
+var DiscoveredRelays = 2
+
+// Discovery is a rendezvous-style peer discovery mechanism, such as the
+// in-memory one of package discovery.
+type Discovery interface {
+	// Advertise advertises the local peer in the namespace ns, until the
+	// returned TTL expires.
+	Advertise(ctx context.Context, ns string) (time.Duration, error)
+
+	// FindPeers returns the peers advertised in the namespace ns. The
+	// channel is closed once they were all sent, or ctx is done.
+	FindPeers(ctx context.Context, ns string) (<-chan pstore.PeerInfo, error)
+}
+
+// advertiseLoop advertises the host in ns until it's closed, renewing the
+// advertisement halfway through its TTL.
+func (h *BasicHost) advertiseLoop(p goprocess.Process, ns string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.Closing()
+		cancel()
+	}()
+
+	for {
+		wait := DiscoveryRetryInterval
+		ttl, err := h.discovery.Advertise(ctx, ns)
+		switch {
+		case err != nil:
+			log.Debugf("failed to advertise in %s: %s", ns, err)
+		case ttl > 0:
+			wait = ttl / 2
+		}
+		select {
+		case <-time.After(wait):
+		case <-p.Closing():
+			return
+		}
+	}
+}
+
+// findPeers returns at most limit peers other than the host advertised in
+// ns, or all of them if limit is 0, and adds their addresses to the
+// peerstore.
+func (h *BasicHost) findPeers(ctx context.Context, ns string, limit int) []pstore.PeerInfo {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := h.discovery.FindPeers(ctx, ns)
+	if err != nil {
+		log.Debugf("failed to find peers in %s: %s", ns, err)
+		return nil
+	}
+
+	var found []pstore.PeerInfo
+	for pi := range ch {
+		if pi.ID == h.ID() || len(pi.Addrs) == 0 {
+			continue
+		}
+		h.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.TempAddrTTL)
+		found = append(found, pi)
+		if len(found) == limit {
+			break
+		}
+	}
+	return found
+}
+
+func hasHop(opts []circuit.RelayOpt) bool {
+	for _, o := range opts {
+		if o == circuit.OptHop {
+			return true
+		}
+	}
+	return false
+}
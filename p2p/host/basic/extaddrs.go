@@ -0,0 +1,186 @@
+package basichost
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+	autonat "github.com/libp2p/go-libp2p/p2p/protocol/autonat"
+
+	goprocess "github.com/jbenet/goprocess"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var (
+	// DefaultExternalAddrCheckInterval is the default value for
+	// HostOpts.ExternalAddrCheckInterval.
+	DefaultExternalAddrCheckInterval = 5 * time.Minute
+
+	// DefaultExternalAddrFailureThreshold is the default value for
+	// HostOpts.ExternalAddrFailureThreshold.
+	DefaultExternalAddrFailureThreshold = 3
+)
+
+// ExternalAddrStatus describes the validation state of an externally
+// declared address.
+type ExternalAddrStatus struct {
+	Addr ma.Multiaddr
+
+	// Demoted is true while the address is not advertised, because it
+	// failed validation too many times in a row.
+	Demoted bool
+
+	// Failures is the number of consecutive failed validations.
+	Failures int
+
+	// LastValidated is when the address was last seen working.
+	LastValidated time.Time
+}
+
+// extAddrValidator checks that externally declared addresses, typically
+// behind manual port forwards, still work. An address is validated by a
+// peer reporting, through identify, that it reached us at that address
+// during the last interval. Failing that, peers running the autonat
+// service are asked to dial us back at it, so that a fixed forward is
+// noticed even while nobody connects to us.
+type extAddrValidator struct {
+	interval  time.Duration
+	threshold int
+
+	// lastSeen returns when a peer last reached us at an address, and
+	// dialBack, if set, whether a peer could dial us back at it.
+	lastSeen func(ma.Multiaddr) time.Time
+	dialBack func(context.Context, ma.Multiaddr) bool
+
+	mu    sync.Mutex
+	addrs []*ExternalAddrStatus
+}
+
+func newExtAddrValidator(addrs []ma.Multiaddr, interval time.Duration, threshold int) *extAddrValidator {
+	v := &extAddrValidator{
+		interval:  interval,
+		threshold: threshold,
+	}
+	for _, a := range addrs {
+		v.addrs = append(v.addrs, &ExternalAddrStatus{Addr: a})
+	}
+	return v
+}
+
+// check runs a round of validation, and returns the addresses demoted and
+// restored by it.
+func (v *extAddrValidator) check(ctx context.Context, now time.Time) (demoted, restored []ma.Multiaddr) {
+	v.mu.Lock()
+	lastSeen, dialBack := v.lastSeen, v.dialBack
+	v.mu.Unlock()
+
+	// dialing back takes a while, don't hold the lock meanwhile.
+	validated := make([]time.Time, len(v.addrs))
+	for i, st := range v.addrs {
+		if seen := lastSeen(st.Addr); !seen.IsZero() && now.Sub(seen) <= v.interval {
+			validated[i] = seen
+		} else if dialBack != nil && dialBack(ctx, st.Addr) {
+			validated[i] = now
+		}
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for i, st := range v.addrs {
+		if !validated[i].IsZero() {
+			st.LastValidated = validated[i]
+			st.Failures = 0
+			if st.Demoted {
+				st.Demoted = false
+				restored = append(restored, st.Addr)
+			}
+			continue
+		}
+
+		st.Failures++
+		if st.Failures >= v.threshold && !st.Demoted {
+			st.Demoted = true
+			demoted = append(demoted, st.Addr)
+		}
+	}
+	return demoted, restored
+}
+
+// filter removes the demoted addresses from addrs.
+func (v *extAddrValidator) filter(addrs []ma.Multiaddr) []ma.Multiaddr {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	out := addrs[:0:0]
+outer:
+	for _, a := range addrs {
+		for _, st := range v.addrs {
+			if st.Demoted && st.Addr.Equal(a) {
+				continue outer
+			}
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+func (v *extAddrValidator) status() []ExternalAddrStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	out := make([]ExternalAddrStatus, len(v.addrs))
+	for i, st := range v.addrs {
+		out[i] = *st
+	}
+	return out
+}
+
+// ExternalAddrStatus returns the validation state of the addresses set in
+// HostOpts.ValidateExternalAddrs.
+func (h *BasicHost) ExternalAddrStatus() []ExternalAddrStatus {
+	if h.extAddrs == nil {
+		return nil
+	}
+	return h.extAddrs.status()
+}
+
+// dialBackExtAddr asks the peers running the autonat service to dial us
+// back at a.
+func (h *BasicHost) dialBackExtAddr(ctx context.Context, a ma.Multiaddr) bool {
+	reached, _ := autonat.NewClient(h).DialBack(ctx, a)
+	return reached
+}
+
+// validateExtAddrsLoop periodically revalidates the external addresses,
+// and updates the host's addresses when some are demoted or restored.
+func (h *BasicHost) validateExtAddrsLoop(p goprocess.Process) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.Closing()
+		cancel()
+	}()
+
+	ticker := time.NewTicker(h.extAddrs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			demoted, restored := h.extAddrs.check(ctx, now)
+			for _, a := range demoted {
+				log.Warningf("external address %s failed validation, no longer advertising it", a)
+				h.emit(events.Event{Type: events.ExternalAddrDemoted, Addr: a.String()})
+			}
+			for _, a := range restored {
+				log.Infof("external address %s works again, advertising it", a)
+				h.emit(events.Event{Type: events.ExternalAddrRestored, Addr: a.String()})
+			}
+			if len(demoted) > 0 || len(restored) > 0 {
+				h.signalAddrsChanged()
+			}
+		case <-p.Closing():
+			return
+		}
+	}
+}
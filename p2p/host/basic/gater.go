@@ -0,0 +1,251 @@
+package basichost
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// ErrGaterDisallowedConnection is returned when dialing a peer, or opening
+// a stream to it, is refused by the host's ConnectionGater.
+var ErrGaterDisallowedConnection = errors.New("gater disallows connection to peer")
+
+// DisconnectReason is a code the ConnectionGater gives for closing an
+// upgraded connection. Its meaning is up to the gater.
+type DisconnectReason int
+
+// ConnMultiaddrs is the part of a connection the gater sees before the
+// connection is upgraded.
+type ConnMultiaddrs interface {
+	LocalMultiaddr() ma.Multiaddr
+	RemoteMultiaddr() ma.Multiaddr
+}
+
+// ConnectionGater decides which connections the host keeps. Its hooks are
+// called, in order:
+//
+//   - for outbound connections, InterceptPeerDial when the host dials a peer,
+//     InterceptAddrDial for each address that may be dialed, then
+//     InterceptSecured and InterceptUpgraded;
+//   - for inbound connections, InterceptAccept, then InterceptSecured and
+//     InterceptUpgraded.
+//
+// The swarm accepts connections and secures them internally, so all the
+// hooks for an established connection run once it is fully set up, but
+// before the host handles any stream on it. A connection refused by a hook
+// is closed, and the host emits an events.ConnectionGated event. Only dials
+// made by the host are gated with InterceptPeerDial; dials the swarm makes
+// on its own are caught by the later hooks.
+//
+// The swarm doesn't say which side opened a connection, so the host takes
+// a connection to be outbound if it's dialing the peer and the connection
+// goes to one of the peer's known addresses. An inbound connection from a
+// peer being dialed usually comes from another port, and is still treated
+// as inbound; one coming from a known address, as when the peer reuses its
+// listen port to dial, is taken to be outbound, skipping InterceptAccept
+// and the inbound connection limit.
+type ConnectionGater interface {
+	InterceptPeerDial(p peer.ID) (allow bool)
+	InterceptAddrDial(p peer.ID, a ma.Multiaddr) (allow bool)
+	InterceptAccept(c ConnMultiaddrs) (allow bool)
+	InterceptSecured(dir Direction, p peer.ID, c ConnMultiaddrs) (allow bool)
+	InterceptUpgraded(c inet.Conn) (allow bool, reason DisconnectReason)
+}
+
+// InboundStats describes the inbound connections of a host limited with
+// HostOpts.MaxInboundConns.
+type InboundStats struct {
+	// Open counts the inbound connections currently admitted.
+	Open int
+
+	// Limit is the configured cap.
+	Limit int
+
+	// Rejected counts the inbound connections closed for exceeding the
+	// cap.
+	Rejected uint64
+}
+
+// gate applies the host's ConnectionGater and inbound connection limit to
+// its connections, deciding each connection once, whichever of its conn
+// handler or first stream comes first.
+type gate struct {
+	gater      ConnectionGater
+	maxInbound int
+	maxPerPeer int
+	pruneGrace time.Duration
+
+	mu        sync.Mutex
+	dialing   map[peer.ID]int
+	decisions map[inet.Conn]*gateDecision
+	inbound   int
+	rejected  uint64
+}
+
+type gateDecision struct {
+	once  sync.Once
+	allow bool
+
+	conn   inet.Conn
+	opened time.Time
+
+	// admitted and counted are set, under the gate's lock, on allowed
+	// connections and on inbound ones counted against the limit.
+	admitted bool
+	counted  bool
+	// pruned is set on connections closed for exceeding the per peer
+	// limit, once idle.
+	pruned bool
+}
+
+func newGate(g ConnectionGater, maxInbound, maxPerPeer int, pruneGrace time.Duration) *gate {
+	return &gate{
+		gater:      g,
+		maxInbound: maxInbound,
+		maxPerPeer: maxPerPeer,
+		pruneGrace: pruneGrace,
+		dialing:    make(map[peer.ID]int),
+		decisions:  make(map[inet.Conn]*gateDecision),
+	}
+}
+
+// startDial gates a dial to p, and, if it's allowed, counts the
+// connections to p as outbound until the returned function is called.
+func (g *gate) startDial(p peer.ID) (func(), error) {
+	if g == nil {
+		return func() {}, nil
+	}
+	if g.gater != nil && !g.gater.InterceptPeerDial(p) {
+		return nil, ErrGaterDisallowedConnection
+	}
+	g.mu.Lock()
+	g.dialing[p]++
+	g.mu.Unlock()
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.dialing[p]--; g.dialing[p] == 0 {
+			delete(g.dialing, p)
+		}
+	}, nil
+}
+
+// forget drops the decision made for a closed connection.
+func (g *gate) forget(c inet.Conn) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if d, ok := g.decisions[c]; ok && d.counted {
+		g.inbound--
+	}
+	delete(g.decisions, c)
+}
+
+// admitInbound counts the inbound connection c against the limit, and
+// reports whether it fits.
+func (g *gate) admitInbound(c inet.Conn, d *gateDecision) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.maxInbound <= 0 {
+		return true
+	}
+	if g.inbound >= g.maxInbound {
+		g.rejected++
+		return false
+	}
+	// connections closed in the meantime were forgotten already.
+	if g.decisions[c] == d {
+		g.inbound++
+		d.counted = true
+	}
+	return true
+}
+
+// InboundStats returns the inbound connection accounting of a host with
+// an inbound connection limit.
+func (h *BasicHost) InboundStats() InboundStats {
+	g := h.gate
+	if g == nil {
+		return InboundStats{}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return InboundStats{Open: g.inbound, Limit: g.maxInbound, Rejected: g.rejected}
+}
+
+// gateConn reports whether the host's gater and inbound connection limit
+// allow c, closing c if they don't.
+func (h *BasicHost) gateConn(c inet.Conn) bool {
+	g := h.gate
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	d, ok := g.decisions[c]
+	if !ok {
+		d = &gateDecision{conn: c, opened: time.Now()}
+		// don't remember connections already closed, whose decision
+		// would never be forgotten.
+		if h.conns.alive(h.Network(), c) {
+			g.decisions[c] = d
+		}
+	}
+	dialing := g.dialing[c.RemotePeer()] > 0
+	g.mu.Unlock()
+
+	dir := DirInbound
+	if dialing && containsAddr(h.Peerstore().Addrs(c.RemotePeer()), c.RemoteMultiaddr()) {
+		dir = DirOutbound
+	}
+
+	d.once.Do(func() {
+		stage, reason := g.intercept(dir, c)
+		if stage == "" && dir == DirInbound && !g.admitInbound(c, d) {
+			stage = "MaxInboundConns"
+		}
+		d.allow = stage == ""
+		if d.allow {
+			for _, pc := range g.prune(d) {
+				h.pruneConn(pc)
+			}
+			return
+		}
+		log.Debugf("refused connection to %s at %s", c.RemotePeer(), stage)
+		h.closeConn(c, "refused at "+stage)
+		h.emit(events.Event{
+			Type:   events.ConnectionGated,
+			Peer:   c.RemotePeer().Pretty(),
+			Addr:   c.RemoteMultiaddr().String(),
+			Error:  stage,
+			Reason: int(reason),
+		})
+	})
+	return d.allow
+}
+
+// intercept runs the gater's hooks on c, and returns the one refusing it,
+// if any.
+func (g *gate) intercept(dir Direction, c inet.Conn) (string, DisconnectReason) {
+	if g.gater == nil {
+		return "", 0
+	}
+	if dir == DirInbound && !g.gater.InterceptAccept(c) {
+		return "InterceptAccept", 0
+	}
+	if !g.gater.InterceptSecured(dir, c.RemotePeer(), c) {
+		return "InterceptSecured", 0
+	}
+	if allow, reason := g.gater.InterceptUpgraded(c); !allow {
+		return "InterceptUpgraded", reason
+	}
+	return "", 0
+}
@@ -0,0 +1,50 @@
+package basichost
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// KeyBookStats describes the memory used by public keys.
+type KeyBookStats struct {
+	// Keys and Bytes count the stored keys and their size.
+	Keys  int
+	Bytes int
+
+	// Limit is the configured cap, in bytes.
+	Limit int
+
+	// Evictions counts the keys forgotten to stay under the cap.
+	Evictions uint64
+}
+
+// KeyBook is the public key store of a peerstore capping the memory spent
+// on keys, see HostOpts.KeyBook.
+type KeyBook interface {
+	Stats() KeyBookStats
+
+	// Pin exempts the key of p from eviction, or undoes it.
+	Pin(p peer.ID, pinned bool)
+}
+
+// KeyBookStats returns the public key accounting of the host, which is only
+// kept with HostOpts.KeyBook.
+func (h *BasicHost) KeyBookStats() KeyBookStats {
+	if h.keyBook == nil {
+		return KeyBookStats{}
+	}
+	return h.keyBook.Stats()
+}
+
+// PinKey exempts the public key of p from eviction, with HostOpts.KeyBook.
+func (h *BasicHost) PinKey(p peer.ID) {
+	if h.keyBook != nil {
+		h.keyBook.Pin(p, true)
+	}
+}
+
+// UnpinKey undoes PinKey.
+func (h *BasicHost) UnpinKey(p peer.ID) {
+	if h.keyBook != nil {
+		h.keyBook.Pin(p, false)
+	}
+}
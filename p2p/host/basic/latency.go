@@ -0,0 +1,33 @@
+package basichost
+
+import (
+	"sort"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// PeerLatency returns the smoothed latency to p, as recorded in the
+// peerstore by identify exchanges and pings, or zero if none was recorded
+// yet.
+func (h *BasicHost) PeerLatency(p peer.ID) time.Duration {
+	return h.Peerstore().LatencyEWMA(p)
+}
+
+// PeersByLatency returns the connected peers, fastest first. Peers whose
+// latency wasn't recorded yet come last.
+func (h *BasicHost) PeersByLatency() []peer.ID {
+	peers := h.Network().Peers()
+	latencies := make(map[peer.ID]time.Duration, len(peers))
+	for _, p := range peers {
+		latencies[p] = h.PeerLatency(p)
+	}
+	sort.SliceStable(peers, func(i, j int) bool {
+		li, lj := latencies[peers[i]], latencies[peers[j]]
+		if li == 0 || lj == 0 {
+			return lj == 0 && li != 0
+		}
+		return li < lj
+	})
+	return peers
+}
@@ -2,6 +2,8 @@ package basichost
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 
 	goprocess "github.com/jbenet/goprocess"
@@ -42,12 +44,35 @@ type natManager struct {
 
 	ready chan struct{}     // closed once the nat is ready to process port mappings
 	proc  goprocess.Process // natManager has a process + children. can be closed.
+
+	errmu       sync.Mutex
+	errs        map[string]*NATMappingError // by internal address
+	discoverErr error                       // set if no nat was found
+}
+
+// ErrNoNATDevice is the error of every listen address of a NAT manager that
+// found no NAT device to map them on.
+var ErrNoNATDevice = errors.New("no NAT device found")
+
+// discoverNATDevice finds the NAT device, tests stub it.
+var discoverNATDevice = inat.DiscoverNAT
+
+// NATMappingError describes a port mapping the NAT manager failed to
+// establish.
+type NATMappingError struct {
+	InternalAddr ma.Multiaddr
+	Err          error
+}
+
+func (e *NATMappingError) Error() string {
+	return fmt.Sprintf("failed to map %s: %s", e.InternalAddr, e.Err)
 }
 
 func newNatManager(net inet.Network) *natManager {
 	nmgr := &natManager{
 		net:   net,
 		ready: make(chan struct{}),
+		errs:  make(map[string]*NATMappingError),
 	}
 
 	nmgr.proc = goprocess.WithTeardown(func() error {
@@ -91,7 +116,7 @@ func (nmgr *natManager) discoverNAT() {
 		var nat *inat.NAT
 		go func() {
 			defer close(discoverdone)
-			nat = inat.DiscoverNAT()
+			nat = discoverNATDevice()
 		}()
 
 		// by this point -- after finding the NAT -- we may have already
@@ -101,6 +126,10 @@ func (nmgr *natManager) discoverNAT() {
 			return
 		case <-discoverdone:
 			if nat == nil { // no nat, or failed to get it.
+				log.Warning("no NAT device found, not mapping any ports")
+				nmgr.errmu.Lock()
+				nmgr.discoverErr = ErrNoNATDevice
+				nmgr.errmu.Unlock()
 				return
 			}
 		}
@@ -177,6 +206,7 @@ func addPortMapping(nmgr *natManager, intaddr ma.Multiaddr) {
 	if err != nil {
 		lm["outcome"] = "failure"
 		lm["error"] = err
+		nmgr.setMappingError(intaddr, err)
 		return
 	}
 
@@ -184,9 +214,11 @@ func addPortMapping(nmgr *natManager, intaddr ma.Multiaddr) {
 	if err != nil {
 		lm["outcome"] = "failure"
 		lm["error"] = err
+		nmgr.setMappingError(intaddr, err)
 		return
 	}
 
+	nmgr.setMappingError(intaddr, nil)
 	lm["outcome"] = "success"
 	lm["externalAddr"] = func() interface{} { return extaddr.String() }
 	log.Infof("established nat port mapping: %s <--> %s", intaddr, extaddr)
@@ -207,6 +239,67 @@ func rmPortMapping(nmgr *natManager, intaddr ma.Multiaddr) {
 			mapping.Close()
 		}
 	}
+	nmgr.setMappingError(intaddr, nil)
+}
+
+// setMappingError records the outcome of mapping intaddr, clearing any
+// previous error if err is nil.
+func (nmgr *natManager) setMappingError(intaddr ma.Multiaddr, err error) {
+	nmgr.errmu.Lock()
+	defer nmgr.errmu.Unlock()
+	if err == nil {
+		delete(nmgr.errs, intaddr.String())
+		return
+	}
+	log.Warningf("failed to establish nat port mapping for %s: %s", intaddr, err)
+	nmgr.errs[intaddr.String()] = &NATMappingError{InternalAddr: intaddr, Err: err}
+}
+
+// MappingErrors returns the errors of the port mappings that currently
+// failed. Without a NAT device, that's all of them.
+func (nmgr *natManager) MappingErrors() []*NATMappingError {
+	nmgr.errmu.Lock()
+	discoverErr := nmgr.discoverErr
+	nmgr.errmu.Unlock()
+	if discoverErr != nil {
+		var out []*NATMappingError
+		for _, a := range nmgr.net.ListenAddresses() {
+			out = append(out, &NATMappingError{InternalAddr: a, Err: discoverErr})
+		}
+		return out
+	}
+
+	nmgr.errmu.Lock()
+	defer nmgr.errmu.Unlock()
+	out := make([]*NATMappingError, 0, len(nmgr.errs))
+	for _, e := range nmgr.errs {
+		out = append(out, e)
+	}
+	return out
+}
+
+// NATMapping is a port mapping established by a NAT manager.
+type NATMapping struct {
+	InternalAddr ma.Multiaddr
+	ExternalAddr ma.Multiaddr
+}
+
+// Mappings returns the port mappings currently established, with the
+// external address of each listen address mapped.
+func (nmgr *natManager) Mappings() []NATMapping {
+	nat := nmgr.NAT()
+	if nat == nil {
+		return nil
+	}
+	var out []NATMapping
+	for _, m := range nat.Mappings() {
+		ext, err := m.ExternalAddr()
+		if err != nil {
+			continue
+		}
+		out = append(out, NATMapping{InternalAddr: m.InternalAddr(), ExternalAddr: ext})
+	}
+	return out
 }
 
 // nmgrNetNotifiee implements the network notification listening part
@@ -0,0 +1,61 @@
+package basichost
+
+import (
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// hostNotifiee turns network notifications into host events, and lets the
+// host know when its listen addresses change.
+type hostNotifiee BasicHost
+
+func (hn *hostNotifiee) host() *BasicHost {
+	return (*BasicHost)(hn)
+}
+
+func (hn *hostNotifiee) Connected(n inet.Network, c inet.Conn) {
+	hn.host().conns.connected(n, c)
+	hn.host().emit(events.Event{
+		Type: events.Connected,
+		Peer: c.RemotePeer().Pretty(),
+		Addr: c.RemoteMultiaddr().String(),
+	})
+}
+
+func (hn *hostNotifiee) Disconnected(n inet.Network, c inet.Conn) {
+	reason := hn.host().conns.disconnected(c)
+	hn.host().gate.forget(c)
+	hn.host().streamLimits.closed(c)
+	if ar := hn.host().autoRelay; ar != nil && ar.isRelay(c.RemotePeer()) {
+		ar.signal()
+	}
+	hn.host().emit(events.Event{
+		Type:  events.Disconnected,
+		Peer:  c.RemotePeer().Pretty(),
+		Addr:  c.RemoteMultiaddr().String(),
+		Error: reason,
+	})
+}
+
+func (hn *hostNotifiee) Listen(n inet.Network, a ma.Multiaddr) {
+	hn.host().signalAddrsChanged()
+	hn.host().emit(events.Event{
+		Type: events.Listen,
+		Addr: a.String(),
+	})
+}
+
+func (hn *hostNotifiee) ListenClose(n inet.Network, a ma.Multiaddr) {
+	hn.host().signalAddrsChanged()
+	hn.host().emit(events.Event{
+		Type: events.ListenClose,
+		Addr: a.String(),
+	})
+}
+
+func (hn *hostNotifiee) OpenedStream(inet.Network, inet.Stream) {}
+func (hn *hostNotifiee) ClosedStream(n inet.Network, s inet.Stream) {
+	hn.host().streamClosed(s, nil)
+}
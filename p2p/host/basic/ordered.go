@@ -0,0 +1,282 @@
+package basichost
+
+import (
+	"sync"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// orderedNetwork wraps an inet.Network so that the notifiees registered
+// through it see Connected and Disconnected notifications for any given peer
+// one at a time, in the order they actually happened.
+//
+// The network dispatches notifications concurrently, so a Disconnected for a
+// connection may arrive before its Connected, or a Connected for a new
+// connection before the Disconnected of the one it replaced. orderedNetwork
+// holds notifications back until everything that happened before them has
+// been delivered. Notifications for different peers are still delivered in
+// parallel.
+type orderedNetwork struct {
+	inet.Network
+
+	mu       sync.Mutex
+	notifees map[inet.Notifiee]struct{}
+	peers    map[peer.ID]*peerNotifs
+}
+
+type notif struct {
+	conn      inet.Conn
+	connected bool
+}
+
+type peerNotifs struct {
+	queue   []notif // ready to be delivered
+	held    []notif // waiting for connections closed earlier to be reported
+	open    map[inet.Conn]struct{}
+	early   map[inet.Conn]struct{} // disconnected before we saw them connect
+	running bool
+}
+
+func newOrderedNetwork(net inet.Network) *orderedNetwork {
+	on := &orderedNetwork{
+		Network:  net,
+		notifees: make(map[inet.Notifiee]struct{}),
+		peers:    make(map[peer.ID]*peerNotifs),
+	}
+	net.Notify((*orderedNotifiee)(on))
+	return on
+}
+
+// Notify registers n to receive ordered notifications.
+func (on *orderedNetwork) Notify(n inet.Notifiee) {
+	on.mu.Lock()
+	on.notifees[n] = struct{}{}
+	on.mu.Unlock()
+}
+
+// StopNotify unregisters n.
+func (on *orderedNetwork) StopNotify(n inet.Notifiee) {
+	on.mu.Lock()
+	delete(on.notifees, n)
+	on.mu.Unlock()
+}
+
+func (on *orderedNetwork) snapshot() []inet.Notifiee {
+	on.mu.Lock()
+	defer on.mu.Unlock()
+	out := make([]inet.Notifiee, 0, len(on.notifees))
+	for n := range on.notifees {
+		out = append(out, n)
+	}
+	return out
+}
+
+func (on *orderedNetwork) peerState(p peer.ID) *peerNotifs {
+	pn, ok := on.peers[p]
+	if !ok {
+		pn = &peerNotifs{
+			open:  make(map[inet.Conn]struct{}),
+			early: make(map[inet.Conn]struct{}),
+		}
+		on.peers[p] = pn
+	}
+	return pn
+}
+
+// hasStale reports whether a connection we reported as open is already gone
+// from the network, meaning its Disconnected is still on the way.
+func (on *orderedNetwork) hasStale(p peer.ID, pn *peerNotifs) bool {
+	if len(pn.open) == 0 {
+		return false
+	}
+	live := make(map[inet.Conn]struct{})
+	for _, c := range on.Network.ConnsToPeer(p) {
+		live[c] = struct{}{}
+	}
+	for c := range pn.open {
+		if _, ok := live[c]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (on *orderedNetwork) connected(c inet.Conn) {
+	p := c.RemotePeer()
+
+	on.mu.Lock()
+	defer on.mu.Unlock()
+
+	pn := on.peerState(p)
+	if len(pn.held) > 0 || on.hasStale(p, pn) {
+		pn.held = append(pn.held, notif{conn: c, connected: true})
+	} else {
+		pn.queue = append(pn.queue, notif{conn: c, connected: true})
+		pn.open[c] = struct{}{}
+	}
+
+	if _, ok := pn.early[c]; ok {
+		delete(pn.early, c)
+		on.disconnectedLocked(p, pn, c)
+		return
+	}
+	on.run(p, pn)
+}
+
+func (on *orderedNetwork) disconnected(c inet.Conn) {
+	p := c.RemotePeer()
+
+	on.mu.Lock()
+	defer on.mu.Unlock()
+
+	on.disconnectedLocked(p, on.peerState(p), c)
+}
+
+func (on *orderedNetwork) disconnectedLocked(p peer.ID, pn *peerNotifs, c inet.Conn) {
+	switch {
+	case isOpen(pn, c):
+		pn.queue = append(pn.queue, notif{conn: c})
+		delete(pn.open, c)
+		on.release(p, pn)
+	case isHeld(pn, c):
+		pn.held = append(pn.held, notif{conn: c})
+	default:
+		pn.early[c] = struct{}{}
+	}
+	on.run(p, pn)
+}
+
+// release moves held notifications to the queue, once all connections
+// closed before them have been reported.
+func (on *orderedNetwork) release(p peer.ID, pn *peerNotifs) {
+	for len(pn.held) > 0 && !on.hasStale(p, pn) {
+		n := pn.held[0]
+		pn.held = pn.held[1:]
+		pn.queue = append(pn.queue, n)
+		if n.connected {
+			pn.open[n.conn] = struct{}{}
+		} else {
+			delete(pn.open, n.conn)
+		}
+	}
+}
+
+// run starts delivering queued notifications for p, unless that's already
+// happening.
+func (on *orderedNetwork) run(p peer.ID, pn *peerNotifs) {
+	if pn.running || len(pn.queue) == 0 {
+		return
+	}
+	pn.running = true
+	go on.deliver(p, pn)
+}
+
+func (on *orderedNetwork) deliver(p peer.ID, pn *peerNotifs) {
+	for {
+		on.mu.Lock()
+		if len(pn.queue) == 0 {
+			pn.running = false
+			if len(pn.held) == 0 && len(pn.open) == 0 && len(pn.early) == 0 {
+				delete(on.peers, p)
+			}
+			on.mu.Unlock()
+			return
+		}
+		n := pn.queue[0]
+		pn.queue = pn.queue[1:]
+		on.mu.Unlock()
+
+		for _, nf := range on.snapshot() {
+			if n.connected {
+				nf.Connected(on, n.conn)
+			} else {
+				nf.Disconnected(on, n.conn)
+			}
+		}
+	}
+}
+
+func isOpen(pn *peerNotifs, c inet.Conn) bool {
+	_, ok := pn.open[c]
+	return ok
+}
+
+func isHeld(pn *peerNotifs, c inet.Conn) bool {
+	for _, n := range pn.held {
+		if n.conn == c && n.connected {
+			return true
+		}
+	}
+	return false
+}
+
+// orderedNotifiee receives the notifications of the wrapped network.
+type orderedNotifiee orderedNetwork
+
+func (nn *orderedNotifiee) net() *orderedNetwork {
+	return (*orderedNetwork)(nn)
+}
+
+func (nn *orderedNotifiee) Connected(_ inet.Network, c inet.Conn) {
+	nn.net().connected(c)
+}
+
+func (nn *orderedNotifiee) Disconnected(_ inet.Network, c inet.Conn) {
+	nn.net().disconnected(c)
+}
+
+func (nn *orderedNotifiee) Listen(_ inet.Network, a ma.Multiaddr) {
+	on := nn.net()
+	for _, n := range on.snapshot() {
+		n.Listen(on, a)
+	}
+}
+
+func (nn *orderedNotifiee) ListenClose(_ inet.Network, a ma.Multiaddr) {
+	on := nn.net()
+	for _, n := range on.snapshot() {
+		n.ListenClose(on, a)
+	}
+}
+
+func (nn *orderedNotifiee) OpenedStream(_ inet.Network, s inet.Stream) {
+	on := nn.net()
+	for _, n := range on.snapshot() {
+		n.OpenedStream(on, s)
+	}
+}
+
+func (nn *orderedNotifiee) ClosedStream(_ inet.Network, s inet.Stream) {
+	on := nn.net()
+	for _, n := range on.snapshot() {
+		n.ClosedStream(on, s)
+	}
+}
+
+// rawNetwork returns the network underneath net's wrappers: our
+// orderedNetwork, and those unwrapping themselves.
+func rawNetwork(net inet.Network) inet.Network {
+	for {
+		switch n := net.(type) {
+		case *orderedNetwork:
+			net = n.Network
+		case interface{ Unwrap() inet.Network }:
+			net = n.Unwrap()
+		default:
+			return net
+		}
+	}
+}
+
+// rawNetworkHost is a BasicHost whose Network is the one underneath the
+// wrappers of its own.
+type rawNetworkHost struct {
+	*BasicHost
+	net inet.Network
+}
+
+func (h *rawNetworkHost) Network() inet.Network {
+	return h.net
+}
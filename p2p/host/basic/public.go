@@ -0,0 +1,66 @@
+package basichost
+
+import (
+	"net"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var nonPublicNets = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, ipnet, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipnet
+	}
+	return nets
+}
+
+// IsPublicAddr reports whether a may be reachable from the public
+// internet: it isn't a loopback, link-local, private (RFC 1918) or unique
+// local IPv6 address. Relay and DNS addresses count as public.
+func IsPublicAddr(a ma.Multiaddr) bool {
+	if isCircuitAddr(a) {
+		return true
+	}
+	ip := leadingIP(a)
+	if ip == nil {
+		// DNS and other non-IP addresses.
+		return true
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range nonPublicNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// leadingIP returns the IP address a starts with, if any.
+func leadingIP(a ma.Multiaddr) net.IP {
+	first := ma.Split(a)[0]
+	switch first.Protocols()[0].Code {
+	case ma.P_IP4:
+		ip := net.ParseIP(strings.TrimPrefix(first.String(), "/ip4/"))
+		if ip == nil {
+			return nil
+		}
+		return ip.To4()
+	case ma.P_IP6:
+		return net.ParseIP(strings.TrimPrefix(first.String(), "/ip6/"))
+	}
+	return nil
+}
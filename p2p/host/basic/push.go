@@ -0,0 +1,43 @@
+package basichost
+
+import (
+	"time"
+
+	goprocess "github.com/jbenet/goprocess"
+)
+
+// IdentifyPushDelay is how long the host waits, after its stream handlers
+// or advertised addresses change, before pushing the change to its peers
+// with identify push. The changes made meanwhile, such as the handlers
+// registered at startup, are all sent in the one push.
+var IdentifyPushDelay = 100 * time.Millisecond
+
+// requestPush schedules an identify push.
+func (h *BasicHost) requestPush() {
+	select {
+	case h.pushRequests <- struct{}{}:
+	default:
+	}
+}
+
+// pushLoop sends the identify pushes requested, until the host is closed.
+func (h *BasicHost) pushLoop(p goprocess.Process) {
+	for {
+		select {
+		case <-h.pushRequests:
+		case <-p.Closing():
+			return
+		}
+		select {
+		case <-time.After(IdentifyPushDelay):
+		case <-p.Closing():
+			return
+		}
+		// the requests made while waiting are covered by this push.
+		select {
+		case <-h.pushRequests:
+		default:
+		}
+		h.ids.Push()
+	}
+}
@@ -0,0 +1,174 @@
+package basichost
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// RelayLimits caps the circuits a relay (a host with hop enabled) serves.
+// Circuits are counted from the relay streams peers open to the host, so
+// circuits the host is the destination of count too. Zero values mean no
+// limit.
+type RelayLimits struct {
+	// MaxCircuits and MaxCircuitsPerPeer cap the circuits open at once,
+	// in all and per peer. Circuits beyond them are refused.
+	MaxCircuits        int
+	MaxCircuitsPerPeer int
+
+	// BufferSize is the size of the buffer the data of each direction of
+	// a circuit is copied through.
+	BufferSize int
+
+	// MaxDuration is how long a circuit may stay open. Older circuits are
+	// reset.
+	MaxDuration time.Duration
+}
+
+// RelayStats counts the circuits of a host with RelayLimits.
+type RelayStats struct {
+	// Active is the number of circuits open.
+	Active int
+
+	// Rejected counts the circuits refused for being over the caps, and
+	// Expired the ones reset for being open longer than MaxDuration.
+	Rejected uint64
+	Expired  uint64
+}
+
+type relayLimiter struct {
+	limits RelayLimits
+
+	mu      sync.Mutex
+	active  int
+	perPeer map[peer.ID]int
+
+	// accessed atomically
+	rejected, expired uint64
+}
+
+func newRelayLimiter(limits RelayLimits) *relayLimiter {
+	return &relayLimiter{
+		limits:  limits,
+		perPeer: make(map[peer.ID]int),
+	}
+}
+
+func (rl *relayLimiter) admit(p peer.ID) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if (rl.limits.MaxCircuits > 0 && rl.active >= rl.limits.MaxCircuits) ||
+		(rl.limits.MaxCircuitsPerPeer > 0 && rl.perPeer[p] >= rl.limits.MaxCircuitsPerPeer) {
+		return false
+	}
+	rl.active++
+	rl.perPeer[p]++
+	return true
+}
+
+func (rl *relayLimiter) release(p peer.ID) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.active--
+	if rl.perPeer[p]--; rl.perPeer[p] == 0 {
+		delete(rl.perPeer, p)
+	}
+}
+
+// wrap applies the limits to the streams handled by handler.
+func (rl *relayLimiter) wrap(handler inet.StreamHandler) inet.StreamHandler {
+	return func(s inet.Stream) {
+		p := s.Conn().RemotePeer()
+		if !rl.admit(p) {
+			atomic.AddUint64(&rl.rejected, 1)
+			log.Debugf("refusing circuit from %s: too many circuits", p.Pretty())
+			s.Reset()
+			return
+		}
+
+		cs := &circuitStream{Stream: s, limiter: rl, peer: p}
+		if rl.limits.MaxDuration > 0 {
+			cs.timer = time.AfterFunc(rl.limits.MaxDuration, func() {
+				atomic.AddUint64(&rl.expired, 1)
+				cs.Reset()
+			})
+		}
+		handler(cs)
+	}
+}
+
+func (rl *relayLimiter) stats() RelayStats {
+	rl.mu.Lock()
+	active := rl.active
+	rl.mu.Unlock()
+	return RelayStats{
+		Active:   active,
+		Rejected: atomic.LoadUint64(&rl.rejected),
+		Expired:  atomic.LoadUint64(&rl.expired),
+	}
+}
+
+// RelayStats returns the circuit counters of the host, which are only
+// kept with HostOpts.RelayLimits.
+func (h *BasicHost) RelayStats() RelayStats {
+	if h.relayLimiter == nil {
+		return RelayStats{}
+	}
+	return h.relayLimiter.stats()
+}
+
+// circuitStream is a relay stream counted against the limits until it is
+// closed or reset. It implements io.WriterTo and io.ReaderFrom, so that
+// io.Copy, which relays the data, uses a buffer of the configured size.
+type circuitStream struct {
+	inet.Stream
+	limiter *relayLimiter
+	peer    peer.ID
+	timer   *time.Timer
+
+	releaseOnce sync.Once
+}
+
+func (s *circuitStream) release() {
+	s.releaseOnce.Do(func() {
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.limiter.release(s.peer)
+	})
+}
+
+func (s *circuitStream) Close() error {
+	s.release()
+	return s.Stream.Close()
+}
+
+func (s *circuitStream) Reset() error {
+	s.release()
+	return s.Stream.Reset()
+}
+
+func (s *circuitStream) buffer() []byte {
+	size := s.limiter.limits.BufferSize
+	if size <= 0 {
+		size = 32 << 10 // as io.Copy
+	}
+	return make([]byte, size)
+}
+
+// WriteTo and ReadFrom hide the methods of the underlying stream, so that
+// io.CopyBuffer doesn't recurse into them.
+type onlyReader struct{ io.Reader }
+type onlyWriter struct{ io.Writer }
+
+func (s *circuitStream) WriteTo(w io.Writer) (int64, error) {
+	return io.CopyBuffer(onlyWriter{w}, onlyReader{s.Stream}, s.buffer())
+}
+
+func (s *circuitStream) ReadFrom(r io.Reader) (int64, error) {
+	return io.CopyBuffer(onlyWriter{s.Stream}, onlyReader{r}, s.buffer())
+}
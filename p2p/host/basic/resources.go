@@ -0,0 +1,189 @@
+package basichost
+
+import (
+	"sync"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ResourceLimits caps the streams the host keeps open. Inbound streams
+// beyond a limit are reset before protocol negotiation, without affecting
+// their connection, and counted in ResourceStats. Outbound streams count
+// towards the limits but are never refused. A zero field means no limit.
+//
+// Streams count until they are closed or reset, or their connection is
+// closed.
+type ResourceLimits struct {
+	// MaxInboundStreamsPerConn caps the inbound streams of each
+	// connection.
+	MaxInboundStreamsPerConn int
+
+	// MaxStreamsPerPeer caps the streams to each peer, across its
+	// connections.
+	MaxStreamsPerPeer int
+
+	// MaxStreams caps the streams of the host.
+	MaxStreams int
+}
+
+// ResourceStats describes the streams of a host with ResourceLimits.
+type ResourceStats struct {
+	// Streams is the number of streams open.
+	Streams int
+
+	// RejectedPerConn, RejectedPerPeer and RejectedTotal count the inbound
+	// streams reset for exceeding MaxInboundStreamsPerConn,
+	// MaxStreamsPerPeer and MaxStreams.
+	RejectedPerConn uint64
+	RejectedPerPeer uint64
+	RejectedTotal   uint64
+}
+
+// StreamLimitGater is implemented by connection gaters wanting to know
+// about the inbound streams refused for exceeding the host's
+// ResourceLimits, to disconnect repeat offenders. limit is the name of the
+// exceeded ResourceLimits field.
+type StreamLimitGater interface {
+	InterceptStreamLimit(c inet.Conn, limit string) (allow bool)
+}
+
+type connStreams struct {
+	inbound, total int
+}
+
+// streamLimiter counts the host's streams against its ResourceLimits.
+type streamLimiter struct {
+	limits ResourceLimits
+
+	mu      sync.Mutex
+	conns   map[inet.Conn]*connStreams
+	perPeer map[peer.ID]int
+	total   int
+	stats   ResourceStats
+}
+
+func newStreamLimiter(limits ResourceLimits) *streamLimiter {
+	return &streamLimiter{
+		limits:  limits,
+		conns:   make(map[inet.Conn]*connStreams),
+		perPeer: make(map[peer.ID]int),
+	}
+}
+
+// open counts a new stream on c, unless it's an inbound one exceeding a
+// limit, in which case it returns the name of the limit. The returned
+// function uncounts the stream.
+func (l *streamLimiter) open(c inet.Conn, dir Direction) (func(), string) {
+	if l == nil {
+		return func() {}, ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p := c.RemotePeer()
+	cs, ok := l.conns[c]
+	if !ok {
+		cs = new(connStreams)
+	}
+	if dir == DirInbound {
+		switch {
+		case l.limits.MaxInboundStreamsPerConn > 0 && cs.inbound >= l.limits.MaxInboundStreamsPerConn:
+			l.stats.RejectedPerConn++
+			return nil, "MaxInboundStreamsPerConn"
+		case l.limits.MaxStreamsPerPeer > 0 && l.perPeer[p] >= l.limits.MaxStreamsPerPeer:
+			l.stats.RejectedPerPeer++
+			return nil, "MaxStreamsPerPeer"
+		case l.limits.MaxStreams > 0 && l.total >= l.limits.MaxStreams:
+			l.stats.RejectedTotal++
+			return nil, "MaxStreams"
+		}
+		cs.inbound++
+	}
+	l.conns[c] = cs
+	cs.total++
+	l.perPeer[p]++
+	l.total++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(c, cs, dir) })
+	}, ""
+}
+
+func (l *streamLimiter) release(c inet.Conn, cs *connStreams, dir Direction) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// the streams of closed connections were uncounted already.
+	if l.conns[c] != cs {
+		return
+	}
+	if dir == DirInbound {
+		cs.inbound--
+	}
+	cs.total--
+	l.uncount(c.RemotePeer(), 1)
+}
+
+func (l *streamLimiter) uncount(p peer.ID, n int) {
+	l.total -= n
+	l.perPeer[p] -= n
+	if l.perPeer[p] <= 0 {
+		delete(l.perPeer, p)
+	}
+}
+
+// closed uncounts the streams left on a closed connection.
+func (l *streamLimiter) closed(c inet.Conn) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if cs, ok := l.conns[c]; ok {
+		delete(l.conns, c)
+		l.uncount(c.RemotePeer(), cs.total)
+	}
+}
+
+// ResourceStats returns the stream accounting of a host with
+// ResourceLimits.
+func (h *BasicHost) ResourceStats() ResourceStats {
+	l := h.streamLimits
+	if l == nil {
+		return ResourceStats{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := l.stats
+	st.Streams = l.total
+	return st
+}
+
+// acceptStream counts the new inbound stream s against the host's
+// ResourceLimits. If a limit is exceeded, s is reset, the gater is told,
+// and acceptStream returns false.
+func (h *BasicHost) acceptStream(s inet.Stream) (func(), bool) {
+	release, limit := h.streamLimits.open(s.Conn(), DirInbound)
+	if limit == "" {
+		return release, true
+	}
+
+	c := s.Conn()
+	log.Debugf("refusing stream from %s: %s exceeded", c.RemotePeer(), limit)
+	s.Reset()
+	h.emit(events.Event{
+		Type:  events.StreamRefused,
+		Peer:  c.RemotePeer().Pretty(),
+		Addr:  c.RemoteMultiaddr().String(),
+		Error: limit,
+	})
+	if h.gate != nil {
+		if g, ok := h.gate.gater.(StreamLimitGater); ok && !g.InterceptStreamLimit(c, limit) {
+			h.closeConn(c, "exceeded "+limit)
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,69 @@
+package basichost
+
+import (
+	"context"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// PeerRouting finds the addresses of peers. routedhost.Routing
+// implementations satisfy it.
+type PeerRouting interface {
+	FindPeer(context.Context, peer.ID) (pstore.PeerInfo, error)
+}
+
+type peerRouting struct {
+	mu sync.Mutex
+	r  PeerRouting
+}
+
+// SetPeerRouting makes Connect and NewStream ask r for the addresses of
+// peers the peerstore has none for. Connect also asks it when dialing all
+// the known addresses failed, and retries with the new ones. The addresses
+// found are added to the peerstore with TempAddrTTL. A nil r disables the
+// lookups.
+func (h *BasicHost) SetPeerRouting(r PeerRouting) {
+	h.routing.mu.Lock()
+	h.routing.r = r
+	h.routing.mu.Unlock()
+}
+
+func (h *BasicHost) peerRouting() PeerRouting {
+	h.routing.mu.Lock()
+	defer h.routing.mu.Unlock()
+	return h.routing.r
+}
+
+// findPeerAddrs asks the peer routing for the addresses of p, within ctx,
+// adds them to the peerstore, and reports whether any of them was new.
+func (h *BasicHost) findPeerAddrs(ctx context.Context, p peer.ID) bool {
+	r := h.peerRouting()
+	if r == nil || ctx.Err() != nil {
+		return false
+	}
+	pi, err := r.FindPeer(ctx, p)
+	if err != nil {
+		log.Debugf("failed to find addresses of %s: %s", p.Pretty(), err)
+		return false
+	}
+	if pi.ID != p {
+		log.Debugf("routing returned %s when looking for %s", pi.ID.Pretty(), p.Pretty())
+		return false
+	}
+
+	known := make(map[string]bool)
+	for _, a := range h.Peerstore().Addrs(p) {
+		known[string(a.Bytes())] = true
+	}
+	found := false
+	for _, a := range pi.Addrs {
+		if !known[string(a.Bytes())] {
+			found = true
+			break
+		}
+	}
+	h.Peerstore().AddAddrs(p, pi.Addrs, pstore.TempAddrTTL)
+	return found
+}
@@ -0,0 +1,210 @@
+package basichost
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// Direction is the direction of a connection, relative to the host.
+type Direction int
+
+const (
+	// DirUnknown matches connections in either direction.
+	DirUnknown Direction = iota
+
+	// DirInbound is for connections the remote peer opened.
+	DirInbound
+
+	// DirOutbound is for connections the host dialed.
+	DirOutbound
+)
+
+// SampleOpts restricts the peers SamplePeers picks from. The zero value
+// allows every connected peer.
+type SampleOpts struct {
+	// Protocols the peer must support, according to the peerstore.
+	Protocols []string
+
+	// Direction, if set, requires a connection in that direction.
+	Direction Direction
+
+	// NoTransient requires a direct (non-relayed) connection.
+	NoTransient bool
+
+	// MinConnAge requires a connection open for at least that long.
+	MinConnAge time.Duration
+
+	// Exclude lists peers never to pick.
+	Exclude map[peer.ID]struct{}
+}
+
+// SamplePeers returns up to k peers picked uniformly at random among the
+// connected peers matching opts. A peer matches if one of its connections
+// satisfies all the connection constraints. It runs in time linear in the
+// number of connections, without sorting them.
+func SamplePeers(h *BasicHost, k int, opts SampleOpts) []peer.ID {
+	if k <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	out := make([]peer.ID, 0, k)
+	seen := 0
+	for _, p := range h.Network().Peers() {
+		if _, ok := opts.Exclude[p]; ok {
+			continue
+		}
+		if !h.eligiblePeer(p, now, opts) {
+			continue
+		}
+
+		// reservoir sampling: the i-th eligible peer replaces a random
+		// pick with probability k/i.
+		seen++
+		if len(out) < k {
+			out = append(out, p)
+		} else if j := rand.Intn(seen); j < k {
+			out[j] = p
+		}
+	}
+	return out
+}
+
+func (h *BasicHost) eligiblePeer(p peer.ID, now time.Time, opts SampleOpts) bool {
+	if len(opts.Protocols) > 0 {
+		supported, err := h.Peerstore().SupportsProtocols(p, opts.Protocols...)
+		if err != nil || len(supported) < len(opts.Protocols) {
+			return false
+		}
+	}
+
+	for _, c := range h.Network().ConnsToPeer(p) {
+		if opts.NoTransient && isCircuitAddr(c.RemoteMultiaddr()) {
+			continue
+		}
+		opened, dir := h.conns.info(c)
+		if opts.Direction != DirUnknown && dir != opts.Direction {
+			continue
+		}
+		if opts.MinConnAge > 0 && (opened.IsZero() || now.Sub(opened) < opts.MinConnAge) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+type connInfo struct {
+	opened time.Time
+	dir    Direction
+
+	// closeReason is why the host closed the connection, if it did.
+	closeReason string
+
+	// latency is the round trip of the identify exchange on the
+	// connection.
+	latency time.Duration
+}
+
+// connTracker remembers when the host's connections were opened, and in
+// which direction. Connections are inbound unless the host marks them as
+// dialed.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[inet.Conn]*connInfo
+}
+
+func (t *connTracker) entry(c inet.Conn) *connInfo {
+	if t.conns == nil {
+		t.conns = make(map[inet.Conn]*connInfo)
+	}
+	ci, ok := t.conns[c]
+	if !ok {
+		ci = &connInfo{opened: time.Now(), dir: DirInbound}
+		t.conns[c] = ci
+	}
+	return ci
+}
+
+func (t *connTracker) connected(n inet.Network, c inet.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.alive(n, c) {
+		t.entry(c)
+	}
+}
+
+// disconnected forgets c, and returns why the host closed it, if it did.
+func (t *connTracker) disconnected(c inet.Conn) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var reason string
+	if ci, ok := t.conns[c]; ok {
+		reason = ci.closeReason
+	}
+	delete(t.conns, c)
+	return reason
+}
+
+// measured records the latency measured on c.
+func (t *connTracker) measured(n inet.Network, c inet.Conn, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.alive(n, c) {
+		t.entry(c).latency = latency
+	}
+}
+
+// latency returns the latency measured on c, or zero.
+func (t *connTracker) latency(c inet.Conn) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ci, ok := t.conns[c]; ok {
+		return ci.latency
+	}
+	return 0
+}
+
+// closing records why the host is closing c.
+func (t *connTracker) closing(n inet.Network, c inet.Conn, reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.alive(n, c) {
+		t.entry(c).closeReason = reason
+	}
+}
+
+// dialed marks c as an outbound connection. The Connected notification for
+// c may not have been delivered yet.
+func (t *connTracker) dialed(n inet.Network, c inet.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.alive(n, c) {
+		t.entry(c).dir = DirOutbound
+	}
+}
+
+// alive reports whether c is still open, so that late notifications don't
+// resurrect connections already gone.
+func (t *connTracker) alive(n inet.Network, c inet.Conn) bool {
+	for _, cc := range n.ConnsToPeer(c.RemotePeer()) {
+		if cc == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *connTracker) info(c inet.Conn) (time.Time, Direction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ci, ok := t.conns[c]; ok {
+		return ci.opened, ci.dir
+	}
+	return time.Time{}, DirUnknown
+}
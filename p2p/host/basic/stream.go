@@ -0,0 +1,258 @@
+package basichost
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	events "github.com/libp2p/go-libp2p/p2p/host/events"
+
+	metrics "github.com/libp2p/go-libp2p-metrics"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+)
+
+// StreamStat describes the traffic on a stream.
+type StreamStat struct {
+	Protocol protocol.ID
+	Peer     peer.ID
+
+	// Opened is when the stream was opened, and Duration how long it has
+	// been open, or was open if it is done: closed both ways, or reset.
+	Opened   time.Time
+	Duration time.Duration
+
+	// BytesRead and BytesWritten count the bytes read from and written to
+	// the stream after protocol negotiation.
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// StatStream is implemented by all streams handed out by the BasicHost,
+// whether opened with NewStream or passed to a stream handler.
+type StatStream interface {
+	inet.Stream
+
+	// Stat returns the stream's traffic counters.
+	Stat() StreamStat
+}
+
+// StreamObserver is told about the streams of a host, both inbound and
+// outbound, once their protocol is negotiated. Callbacks are called
+// synchronously, OnRead and OnWrite on every read and write, so they must
+// be fast.
+type StreamObserver interface {
+	OnOpen(s inet.Stream, dir Direction)
+	OnRead(s inet.Stream, n int)
+	OnWrite(s inet.Stream, n int)
+
+	// OnClose is called once the stream is done, with its final traffic
+	// counters: once it's been both closed and read to EOF, or reset.
+	OnClose(s inet.Stream, st StreamStat)
+}
+
+// statStream counts the bytes moved over a stream, reports them to the
+// bandwidth reporter, and emits a StreamClosed event once the stream is
+// done. Close only closes the stream for writing, so that is once it has
+// also been read to EOF, or once it's reset.
+type statStream struct {
+	inet.Stream
+
+	// accessed atomically
+	read, written uint64
+
+	opened time.Time
+	host   *BasicHost
+
+	halvesMu sync.Mutex
+	halves   int
+
+	closeOnce sync.Once
+	closed    int64 // unix nanoseconds, accessed atomically
+
+	// release uncounts the stream from the host's resource limits.
+	release func()
+}
+
+// wrapStream wraps s, whose protocol must already have been set, so its
+// traffic is accounted for. release, if set, uncounts an inbound stream
+// from the host's resource limits once it's closed; outbound streams are
+// counted here.
+func (h *BasicHost) wrapStream(s inet.Stream, dir Direction, release func()) inet.Stream {
+	if release == nil {
+		release, _ = h.streamLimits.open(s.Conn(), dir)
+	}
+	ss := &statStream{
+		Stream:  s,
+		opened:  time.Now(),
+		host:    h,
+		release: release,
+	}
+	h.streams.add(s, ss)
+	if h.observer != nil {
+		h.observer.OnOpen(ss, dir)
+	}
+	return ss
+}
+
+// openStreams maps the network's streams to the statStreams wrapping them,
+// so those can be finished when the network reports a stream closed, even
+// if it wasn't through the wrapper: on a remote reset, or when the host
+// resets all streams on Close.
+type openStreams struct {
+	mu sync.Mutex
+	m  map[inet.Stream]*statStream
+}
+
+// streamClosed finishes the statStream wrapping the network's stream s, if
+// it hasn't been already.
+func (h *BasicHost) streamClosed(s inet.Stream, err error) {
+	if ss := h.streams.remove(s); ss != nil {
+		ss.finish(err)
+	}
+}
+
+// netStream returns the network's stream underneath s.
+func netStream(s inet.Stream) inet.Stream {
+	for {
+		w, ok := s.(*streamWrapper)
+		if !ok {
+			return s
+		}
+		s = w.Stream
+	}
+}
+
+func (o *openStreams) add(s inet.Stream, ss *statStream) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.m == nil {
+		o.m = make(map[inet.Stream]*statStream)
+	}
+	o.m[netStream(s)] = ss
+}
+
+func (o *openStreams) remove(s inet.Stream) *statStream {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s = netStream(s)
+	ss := o.m[s]
+	delete(o.m, s)
+	return ss
+}
+
+func (s *statStream) reporter() metrics.Reporter {
+	return s.host.bwc
+}
+
+// The halves of a stream, done once closed for writing, and read to EOF.
+const (
+	halfWrite = 1 << iota
+	halfRead
+)
+
+// closeHalf records that the given half of the stream is done, and
+// finishes the stream when both are.
+func (s *statStream) closeHalf(half int) {
+	s.halvesMu.Lock()
+	s.halves |= half
+	done := s.halves == halfWrite|halfRead
+	s.halvesMu.Unlock()
+	if done {
+		s.finish(nil)
+	}
+}
+
+func (s *statStream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	if n > 0 {
+		atomic.AddUint64(&s.read, uint64(n))
+		if bwc := s.reporter(); bwc != nil {
+			bwc.LogRecvMessageStream(int64(n), s.Protocol(), s.Conn().RemotePeer())
+			s.host.transportBW.counter(s.Conn()).LogRecvMessage(int64(n))
+		}
+		if obs := s.host.observer; obs != nil {
+			obs.OnRead(s, n)
+		}
+	}
+	if err == io.EOF {
+		s.closeHalf(halfRead)
+	}
+	return n, err
+}
+
+func (s *statStream) Write(b []byte) (int, error) {
+	n, err := s.Stream.Write(b)
+	if n > 0 {
+		atomic.AddUint64(&s.written, uint64(n))
+		if bwc := s.reporter(); bwc != nil {
+			bwc.LogSentMessageStream(int64(n), s.Protocol(), s.Conn().RemotePeer())
+			s.host.transportBW.counter(s.Conn()).LogSentMessage(int64(n))
+		}
+		if obs := s.host.observer; obs != nil {
+			obs.OnWrite(s, n)
+		}
+	}
+	return n, err
+}
+
+func (s *statStream) Close() error {
+	err := s.Stream.Close()
+	if err != nil {
+		s.finish(err)
+		return err
+	}
+	s.closeHalf(halfWrite)
+	return nil
+}
+
+func (s *statStream) Reset() error {
+	err := s.Stream.Reset()
+	s.finish(err)
+	return err
+}
+
+func (s *statStream) Stat() StreamStat {
+	end := time.Now()
+	if closed := atomic.LoadInt64(&s.closed); closed != 0 {
+		end = time.Unix(0, closed)
+	}
+	return StreamStat{
+		Protocol:     s.Protocol(),
+		Peer:         s.Conn().RemotePeer(),
+		Opened:       s.opened,
+		Duration:     end.Sub(s.opened),
+		BytesRead:    atomic.LoadUint64(&s.read),
+		BytesWritten: atomic.LoadUint64(&s.written),
+	}
+}
+
+// finish records the time the stream was done and emits the StreamClosed
+// event, the first time it's called.
+func (s *statStream) finish(err error) {
+	s.closeOnce.Do(func() {
+		atomic.StoreInt64(&s.closed, time.Now().UnixNano())
+		s.release()
+		s.host.streams.remove(s.Stream)
+
+		st := s.Stat()
+		ev := events.Event{
+			Type:         events.StreamClosed,
+			Peer:         st.Peer.Pretty(),
+			Addr:         s.Conn().RemoteMultiaddr().String(),
+			Protocol:     string(st.Protocol),
+			Took:         st.Duration,
+			BytesRead:    st.BytesRead,
+			BytesWritten: st.BytesWritten,
+		}
+		if err != nil {
+			ev.Error = err.Error()
+		}
+		s.host.emit(ev)
+		if obs := s.host.observer; obs != nil {
+			obs.OnClose(s, st)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package basichost
+
+import (
+	"os"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// isUnixAddr reports whether a is a unix domain socket address.
+func isUnixAddr(a ma.Multiaddr) bool {
+	protos := a.Protocols()
+	return len(protos) > 0 && protos[0].Name == "unix"
+}
+
+// unixSocketPath returns the path of the socket of a unix address.
+func unixSocketPath(a ma.Multiaddr) string {
+	return strings.TrimPrefix(a.String(), "/unix")
+}
+
+// removeUnixSockets removes the socket files of the given listen
+// addresses, once the listeners are closed. Files that aren't sockets are
+// left alone.
+func removeUnixSockets(addrs []ma.Multiaddr) {
+	for _, a := range addrs {
+		if !isUnixAddr(a) {
+			continue
+		}
+		path := unixSocketPath(a)
+		fi, err := os.Lstat(path)
+		if err != nil || fi.Mode()&os.ModeSocket == 0 {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			log.Warningf("failed to remove unix socket %s: %s", path, err)
+		}
+	}
+}
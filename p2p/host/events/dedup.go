@@ -0,0 +1,164 @@
+package events
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DefaultDedupWindow is the window Dedup coalesces failures over, if none is
+// given.
+var DefaultDedupWindow = time.Minute
+
+// FailureKey identifies a class of identical failures.
+type FailureKey struct {
+	Type      Type
+	Peer      string
+	AddrClass string
+	ErrClass  string
+}
+
+type failureWindow struct {
+	first      Event
+	suppressed uint64
+}
+
+// Dedup is a Sink that coalesces repeated identical failures, so a node that
+// keeps failing the same way (say, behind a captive portal) doesn't drown
+// the events that matter.
+//
+// Failures (events with an Error) with the same type, peer, address class
+// and error class are identical. The first one is passed on as is, with a
+// Count of 1. Identical failures in the following window are suppressed, and
+// when the window closes, a single summary event is emitted with the number
+// of suppressed failures as its Count. Summing up Count therefore gives the
+// exact number of failures. Other events are passed on untouched.
+type Dedup struct {
+	sink   Sink
+	window time.Duration
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[FailureKey]*failureWindow
+	totals  map[FailureKey]uint64
+}
+
+// NewDedup wraps sink, coalescing failures over window.
+func NewDedup(sink Sink, window time.Duration) *Dedup {
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+	return &Dedup{
+		sink:    sink,
+		window:  window,
+		pending: make(map[FailureKey]*failureWindow),
+		totals:  make(map[FailureKey]uint64),
+	}
+}
+
+// Emit implements Sink.
+func (d *Dedup) Emit(e Event) {
+	if e.Error == "" {
+		d.sink.Emit(e)
+		return
+	}
+
+	key := failureKeyOf(e)
+
+	d.mu.Lock()
+	d.totals[key]++
+	if fw, ok := d.pending[key]; ok {
+		fw.suppressed++
+		d.mu.Unlock()
+		return
+	}
+	if !d.closed {
+		d.pending[key] = &failureWindow{first: e}
+		time.AfterFunc(d.window, func() { d.closeWindow(key) })
+	}
+	d.mu.Unlock()
+
+	e.Count = 1
+	d.sink.Emit(e)
+}
+
+// Totals returns the exact number of failures seen so far, per class.
+func (d *Dedup) Totals() map[FailureKey]uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := make(map[FailureKey]uint64, len(d.totals))
+	for k, v := range d.totals {
+		out[k] = v
+	}
+	return out
+}
+
+// Close emits the summaries of all open windows, and closes the wrapped
+// sink if it is an io.Closer.
+func (d *Dedup) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	keys := make([]FailureKey, 0, len(d.pending))
+	for k := range d.pending {
+		keys = append(keys, k)
+	}
+	d.mu.Unlock()
+
+	for _, k := range keys {
+		d.closeWindow(k)
+	}
+
+	if c, ok := d.sink.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (d *Dedup) closeWindow(key FailureKey) {
+	d.mu.Lock()
+	fw, ok := d.pending[key]
+	delete(d.pending, key)
+	d.mu.Unlock()
+
+	if !ok || fw.suppressed == 0 {
+		return
+	}
+
+	summary := fw.first
+	summary.Time = time.Now()
+	summary.Count = fw.suppressed
+	d.sink.Emit(summary)
+}
+
+var digitsRe = regexp.MustCompile("[0-9]+")
+
+func failureKeyOf(e Event) FailureKey {
+	return FailureKey{
+		Type:      e.Type,
+		Peer:      e.Peer,
+		AddrClass: addrClass(e.Addr),
+		// errors often embed addresses and ports; ignore them.
+		ErrClass: digitsRe.ReplaceAllString(e.Error, "N"),
+	}
+}
+
+// addrClass reduces an address to its protocols, e.g. /ip4/tcp.
+func addrClass(s string) string {
+	if s == "" {
+		return ""
+	}
+	a, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return s
+	}
+	var parts []string
+	for _, p := range a.Protocols() {
+		parts = append(parts, p.Name)
+	}
+	return "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collectSink struct {
+	mu   sync.Mutex
+	evts []Event
+}
+
+func (c *collectSink) Emit(e Event) {
+	c.mu.Lock()
+	c.evts = append(c.evts, e)
+	c.mu.Unlock()
+}
+
+func (c *collectSink) events() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.evts...)
+}
+
+func TestDedupFailureStorm(t *testing.T) {
+	sink := &collectSink{}
+	d := NewDedup(sink, time.Millisecond*100)
+
+	timeout := errors.New("dial tcp4 10.0.0.1:4001: i/o timeout")
+	const storm = 1000
+	for i := 0; i < storm; i++ {
+		d.Emit(Event{
+			Type:  DialAttempt,
+			Peer:  "QmPeer",
+			Addr:  fmt.Sprintf("/ip4/10.0.0.1/tcp/%d", 4000+i%10),
+			Error: fmt.Sprintf("%s (attempt %d)", timeout, i),
+		})
+	}
+	d.Emit(Event{Type: Connected, Peer: "QmOther"})
+
+	// before the window closes, we should only see the first failure.
+	evts := sink.events()
+	if len(evts) != 2 {
+		t.Fatalf("expected 2 events before the window closed, got %d", len(evts))
+	}
+
+	time.Sleep(time.Millisecond * 300)
+
+	evts = sink.events()
+	if len(evts) != 3 {
+		t.Fatalf("expected 3 events after the window closed, got %d", len(evts))
+	}
+
+	var total uint64
+	for _, e := range evts {
+		if e.Type == DialAttempt {
+			total += e.Count
+		}
+	}
+	if total != storm {
+		t.Fatalf("expected event counts to add up to %d, got %d", storm, total)
+	}
+
+	for k, v := range d.Totals() {
+		if v != storm {
+			t.Fatalf("expected exact total of %d for %+v, got %d", storm, k, v)
+		}
+	}
+}
+
+func TestDedupCloseFlushes(t *testing.T) {
+	sink := &collectSink{}
+	d := NewDedup(sink, time.Hour)
+
+	for i := 0; i < 5; i++ {
+		d.Emit(Event{Type: DialAttempt, Peer: "QmPeer", Error: "no route to host"})
+	}
+	d.Emit(Event{Type: DialAttempt, Peer: "QmOther", Error: "no route to host"})
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	evts := sink.events()
+	if len(evts) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(evts))
+	}
+	if evts[2].Count != 4 {
+		t.Fatalf("expected the summary to count 4 suppressed failures, got %d", evts[2].Count)
+	}
+}
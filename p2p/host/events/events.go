@@ -0,0 +1,125 @@
+// Package events defines the structured connection events emitted by the
+// basic host, and sinks that record them.
+package events
+
+import (
+	"time"
+)
+
+// Type identifies the kind of an Event.
+type Type string
+
+const (
+	// DialAttempt is emitted once a dial to a peer finishes, successfully
+	// or not. Error is set if the dial failed.
+	DialAttempt Type = "DialAttempt"
+
+	// Identified is emitted once the identify handshake on a new
+	// connection has completed, inbound or outbound. Took is how long it
+	// took.
+	Identified Type = "Identified"
+
+	// Handshake is emitted as a new connection is upgraded: Stage is
+	// "secured" once the security handshake is done, then "muxed" once
+	// the stream muxer is negotiated, or Error is set if that failed. Took
+	// is how long the muxer negotiation took.
+	Handshake Type = "Handshake"
+
+	// Connected is emitted when a new connection to a peer is opened.
+	Connected Type = "Connected"
+
+	// Disconnected is emitted when a connection to a peer is closed. Error
+	// is the reason the host closed it, if it did: refused by its gater,
+	// pruned, over a stream limit, a protocol mismatch, or the host
+	// closing.
+	Disconnected Type = "Disconnected"
+
+	// Listen is emitted when the network starts listening on an address.
+	Listen Type = "Listen"
+
+	// ListenClose is emitted when the network stops listening on an address.
+	ListenClose Type = "ListenClose"
+
+	// StreamClosed is emitted when a stream is closed or reset. Took is
+	// how long the stream was open.
+	StreamClosed Type = "StreamClosed"
+
+	// ResolveFailed is emitted when a DNS address of a peer fails to
+	// resolve. Addr is the address, and Error the reason.
+	ResolveFailed Type = "ResolveFailed"
+
+	// ExternalAddrDemoted is emitted when an externally declared address
+	// fails validation too many times in a row, and stops being advertised.
+	ExternalAddrDemoted Type = "ExternalAddrDemoted"
+
+	// ExternalAddrRestored is emitted when a demoted external address is
+	// validated again.
+	ExternalAddrRestored Type = "ExternalAddrRestored"
+
+	// SlowTransfer is emitted when a peer is too slow negotiating a
+	// protocol on a stream it opened, and the stream is reset.
+	SlowTransfer Type = "SlowTransfer"
+
+	// AddrsUpdated is emitted when the set of addresses the host
+	// advertises changes. Addrs holds the new set.
+	AddrsUpdated Type = "AddrsUpdated"
+
+	// ReachabilityChanged is emitted when the host finds out, or is told,
+	// that it has become publicly reachable or not. Reachability holds the
+	// new state.
+	ReachabilityChanged Type = "ReachabilityChanged"
+
+	// ConnectionGated is emitted when the host's connection gater, or its
+	// inbound connection limit, refuses a connection, which is then
+	// closed. Error names the refusing hook, or MaxInboundConns, and
+	// Reason holds the gater's disconnect reason, if it gave one.
+	ConnectionGated Type = "ConnectionGated"
+
+	// ConnectionPruned is emitted when a connection exceeds the limit of
+	// connections to its peer. It is closed once its streams are done.
+	ConnectionPruned Type = "ConnectionPruned"
+
+	// StreamRefused is emitted when an inbound stream is reset for
+	// exceeding the host's resource limits. Error names the limit.
+	StreamRefused Type = "StreamRefused"
+
+	// ProtocolMismatch is emitted when a peer is disconnected for
+	// reporting, in identify, a protocol version the host doesn't talk to.
+	// Protocol holds the reported version.
+	ProtocolMismatch Type = "ProtocolMismatch"
+)
+
+// Event is a single structured record of something that happened to the
+// host's connections or addresses.
+type Event struct {
+	Time  time.Time     `json:"time"`
+	Type  Type          `json:"type"`
+	Peer  string        `json:"peer,omitempty"`
+	Addr  string        `json:"addr,omitempty"`
+	Error string        `json:"error,omitempty"`
+	Took  time.Duration `json:"took,omitempty"`
+
+	// Protocol and the byte counts are set on StreamClosed events.
+	// Protocol is also set on ProtocolMismatch events.
+	Protocol     string `json:"protocol,omitempty"`
+	BytesRead    uint64 `json:"bytesRead,omitempty"`
+	BytesWritten uint64 `json:"bytesWritten,omitempty"`
+
+	Addrs []string `json:"addrs,omitempty"`
+
+	Stage string `json:"stage,omitempty"`
+
+	Reachability string `json:"reachability,omitempty"`
+
+	Reason int `json:"reason,omitempty"`
+
+	// Count is set on events standing in for several identical failures,
+	// see Dedup.
+	Count uint64 `json:"count,omitempty"`
+}
+
+// Sink receives events. Implementations must not block the caller, as
+// events are emitted from the networking code paths.
+type Sink interface {
+	Emit(Event)
+}
@@ -0,0 +1,302 @@
+package events
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	logging "github.com/ipfs/go-log"
+)
+
+var log = logging.Logger("events")
+
+// LogQueueSize is the number of events that may be waiting to be written
+// before Log starts dropping them.
+var LogQueueSize = 1024
+
+// Log is a Sink that records events to a bounded, rotating on-disk log.
+//
+// Records are length-prefixed (4 byte big endian) JSON encoded events. The
+// log is made up of two segments, the file at the given path and an older
+// one with a ".1" suffix. Once the current segment grows past half of the
+// size budget, it replaces the older one and a new segment is started, so
+// the log never takes up more than (roughly) maxBytes on disk.
+//
+// Writes happen asynchronously. If the disk can't keep up, events are
+// dropped rather than blocking the caller; see Dropped.
+type Log struct {
+	path    string
+	segSize int64
+
+	f    *os.File
+	w    *bufio.Writer
+	size int64
+
+	mu     sync.RWMutex // guards closed, and sending on queue
+	closed bool
+	queue  chan Event
+	done   chan struct{}
+
+	dropped uint64
+}
+
+// NewLog opens (or creates) the event log at path, limited to maxBytes.
+func NewLog(path string, maxBytes int64) (*Log, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("event log size must be positive, got %d", maxBytes)
+	}
+
+	// drop a record left half written by a crash, so the ones we append
+	// after it can still be read back.
+	if n, err := completeLength(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	} else if err == nil {
+		if err := os.Truncate(path, n); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &Log{
+		path:    path,
+		segSize: maxBytes / 2,
+		queue:   make(chan Event, LogQueueSize),
+		done:    make(chan struct{}),
+	}
+	if err := l.open(os.O_APPEND); err != nil {
+		return nil, err
+	}
+	go l.writeLoop()
+	return l, nil
+}
+
+// Emit queues the event to be written. It never blocks.
+func (l *Log) Emit(e Event) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.closed {
+		atomic.AddUint64(&l.dropped, 1)
+		return
+	}
+
+	select {
+	case l.queue <- e:
+	default:
+		atomic.AddUint64(&l.dropped, 1)
+	}
+}
+
+// Dropped returns the number of events that were dropped because the
+// write queue was full, or the log was already closed.
+func (l *Log) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close flushes all queued events to disk and closes the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.mu.Unlock()
+
+	<-l.done
+
+	if l.f == nil {
+		return nil
+	}
+	if err := l.w.Flush(); err != nil {
+		l.f.Close()
+		return err
+	}
+	return l.f.Close()
+}
+
+func (l *Log) writeLoop() {
+	defer close(l.done)
+
+	for e := range l.queue {
+		if err := l.write(e); err != nil {
+			log.Warningf("failed to write event log %s: %s", l.path, err)
+			atomic.AddUint64(&l.dropped, 1)
+		}
+
+		// only flush once we've caught up, so bursts get batched.
+		if len(l.queue) == 0 {
+			if err := l.w.Flush(); err != nil {
+				log.Warningf("failed to flush event log %s: %s", l.path, err)
+			}
+		}
+	}
+}
+
+func (l *Log) write(e Event) error {
+	if l.f == nil {
+		// a previous rotation failed to reopen the segment, try again.
+		if err := l.open(os.O_APPEND); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	recsize := int64(len(b) + 4)
+	if l.size > 0 && l.size+recsize > l.segSize {
+		if err := l.rotate(); err != nil {
+			if l.f == nil {
+				return err
+			}
+			log.Warningf("failed to rotate event log %s: %s", l.path, err)
+		}
+	}
+
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(b)))
+	if _, err := l.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := l.w.Write(b); err != nil {
+		return err
+	}
+	l.size += recsize
+	return nil
+}
+
+// rotate replaces the older segment with the current one, and starts a new
+// current segment. If the rename fails, the log keeps appending to the
+// current segment and rotation is retried on the next write.
+func (l *Log) rotate() error {
+	ferr := l.w.Flush()
+	l.f.Close()
+	l.f = nil
+
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		if oerr := l.open(os.O_APPEND); oerr != nil {
+			log.Warningf("failed to reopen event log %s: %s", l.path, oerr)
+		}
+		return err
+	}
+	if err := l.open(os.O_TRUNC); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// open opens the current segment with the given extra flag and points the
+// writer at it, discarding anything still buffered.
+func (l *Log) open(flag int) error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	if l.w == nil {
+		l.w = bufio.NewWriter(f)
+	} else {
+		l.w.Reset(f)
+	}
+	l.size = fi.Size()
+	return nil
+}
+
+// completeLength returns the length of the prefix of the segment at path
+// that holds complete records.
+func completeLength(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n int64
+	r := bufio.NewReader(f)
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return n, nil
+			}
+			return 0, err
+		}
+
+		rlen := int64(binary.BigEndian.Uint32(hdr[:]))
+		skipped, err := io.CopyN(ioutil.Discard, r, rlen)
+		if err != nil {
+			if err == io.EOF && skipped < rlen {
+				return n, nil
+			}
+			return 0, err
+		}
+		n += 4 + rlen
+	}
+}
+
+// ReadLog reads back all events recorded in the event log at path, oldest
+// first. A truncated record at the end of a segment (for example, after a
+// crash) is ignored.
+func ReadLog(path string) ([]Event, error) {
+	var out []Event
+	for _, p := range []string{path + ".1", path} {
+		evts, err := readSegment(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		out = append(out, evts...)
+	}
+	return out, nil
+}
+
+func readSegment(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Event
+	r := bufio.NewReader(f)
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return out, nil
+			}
+			return nil, err
+		}
+
+		buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return out, nil
+			}
+			return nil, err
+		}
+
+		var e Event
+		if err := json.Unmarshal(buf, &e); err != nil {
+			return nil, fmt.Errorf("corrupt event log record in %s: %s", path, err)
+		}
+		out = append(out, e)
+	}
+}
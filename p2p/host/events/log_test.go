@@ -0,0 +1,201 @@
+package events
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLogRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+	maxBytes := int64(4096)
+
+	l, err := NewLog(path, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const count = 500
+	for i := 0; i < count; i++ {
+		l.Emit(Event{
+			Time: time.Now(),
+			Type: DialAttempt,
+			Peer: "QmPeer",
+			Addr: strconv.Itoa(i),
+		})
+		if i%50 == 0 {
+			// give the writer a chance, so we don't only test dropping.
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	for _, p := range []string{path, path + ".1"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += fi.Size()
+	}
+	if total > maxBytes {
+		t.Fatalf("event log takes up %d bytes, expected at most %d", total, maxBytes)
+	}
+
+	evts, err := ReadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) == 0 {
+		t.Fatal("expected to read back some events")
+	}
+	if uint64(len(evts))+l.Dropped() >= count {
+		t.Fatal("expected old events to have been rotated out")
+	}
+
+	last := -1
+	for _, e := range evts {
+		if e.Type != DialAttempt || e.Peer != "QmPeer" {
+			t.Fatalf("read back unexpected event: %+v", e)
+		}
+		i, err := strconv.Atoi(e.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i <= last {
+			t.Fatalf("events out of order: %d after %d", i, last)
+		}
+		last = i
+	}
+}
+
+func TestLogTruncatedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+	l, err := NewLog(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		l.Emit(Event{Type: Connected, Peer: fmt.Sprint(i)})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of writing a record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, '{'})
+	f.Close()
+
+	evts, err := ReadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(evts))
+	}
+
+	l.Emit(Event{Type: Connected})
+	if l.Dropped() != 1 {
+		t.Fatal("emitting to a closed log should count as dropped")
+	}
+}
+
+func TestLogReopenAfterTruncatedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+	l, err := NewLog(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Emit(Event{Type: Connected, Peer: "0"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, '{'})
+	f.Close()
+
+	l, err = NewLog(path, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Emit(Event{Type: Connected, Peer: "1"})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	evts, err := ReadLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) != 2 || evts[0].Peer != "0" || evts[1].Peer != "1" {
+		t.Fatalf("expected the events written before and after the crash, got %+v", evts)
+	}
+}
+
+func TestLogRotationFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "events.log")
+
+	// a non-empty directory in the way of the older segment makes the
+	// rename fail.
+	if err := os.MkdirAll(filepath.Join(path+".1", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := NewLog(path, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const count = 10
+	for i := 0; i < count; i++ {
+		l.Emit(Event{Type: DialAttempt, Peer: "QmPeer", Addr: strconv.Itoa(i)})
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	evts, err := readSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evts) != count {
+		t.Fatalf("expected the log to keep writing to the current segment, read back %d of %d events", len(evts), count)
+	}
+}
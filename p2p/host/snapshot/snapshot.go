@@ -0,0 +1,266 @@
+// Package snapshot saves the addresses and protocols a peerstore knows
+// about other peers, and loads them back, so that a restarted node can
+// reach its previous neighborhood without waiting for discovery.
+//
+// Keys are never saved, and neither is anything about the peers the
+// peerstore holds a private key for, such as the local one.
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+)
+
+var log = logging.Logger("snapshot")
+
+// Version is the version of the format written by ExportPeerstore.
+const Version = 1
+
+// maxRecordLen bounds the length of the peer records read by
+// ImportPeerstore.
+const maxRecordLen = 1 << 20
+
+// AddrTTL is the TTL ImportPeerstore gives the addresses it loads.
+var AddrTTL = pstore.RecentlyConnectedAddrTTL
+
+// ErrUnknownVersion is returned by ImportPeerstore for snapshots written
+// with a version of the format it doesn't know.
+var ErrUnknownVersion = errors.New("unknown peerstore snapshot version")
+
+// ExportPeerstore writes the addresses and protocols of the peers in ps to
+// w. The snapshot starts with the version byte, followed by a
+// length-prefixed record per peer, made of varints and length-prefixed
+// byte strings:
+//
+//	version (len id naddrs addr* nprotos proto*)*
+func ExportPeerstore(ps pstore.Peerstore, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteByte(Version)
+
+	var rec bytes.Buffer
+	for _, p := range ps.Peers() {
+		if ps.PrivKey(p) != nil {
+			continue
+		}
+		addrs := ps.Addrs(p)
+		protos, err := ps.GetProtocols(p)
+		if err != nil {
+			return err
+		}
+		if len(addrs) == 0 && len(protos) == 0 {
+			continue
+		}
+
+		rec.Reset()
+		putBytes(&rec, []byte(p))
+		putUvarint(&rec, uint64(len(addrs)))
+		for _, a := range addrs {
+			putBytes(&rec, a.Bytes())
+		}
+		putUvarint(&rec, uint64(len(protos)))
+		for _, proto := range protos {
+			putBytes(&rec, []byte(proto))
+		}
+
+		var b [binary.MaxVarintLen64]byte
+		bw.Write(b[:binary.PutUvarint(b[:], uint64(rec.Len()))])
+		if _, err := bw.Write(rec.Bytes()); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ImportPeerstore adds the peers of a snapshot written by ExportPeerstore
+// to ps, with AddrTTL. Records with an invalid peer ID, and addresses that
+// fail to parse, are skipped. A truncated snapshot fails, but the peers
+// read until then are kept.
+func ImportPeerstore(r io.Reader, ps pstore.Peerstore) error {
+	br := bufio.NewReader(r)
+	v, err := br.ReadByte()
+	if err != nil {
+		return err
+	}
+	if v != Version {
+		return ErrUnknownVersion
+	}
+
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n > maxRecordLen {
+			return fmt.Errorf("peer record of %d bytes too long in peerstore snapshot", n)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err := importRecord(b, ps); err != nil {
+			log.Debugf("skipping peer in snapshot: %s", err)
+		}
+	}
+}
+
+func importRecord(b []byte, ps pstore.Peerstore) error {
+	r := bytes.NewReader(b)
+	id, err := readBytes(r)
+	if err != nil {
+		return err
+	}
+	if _, err := mh.Cast(id); err != nil {
+		return fmt.Errorf("invalid peer ID: %s", err)
+	}
+	p := peer.ID(id)
+
+	naddrs, err := readCount(r)
+	if err != nil {
+		return err
+	}
+	addrs := make([]ma.Multiaddr, 0, naddrs)
+	for i := 0; i < naddrs; i++ {
+		ab, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		a, err := ma.NewMultiaddrBytes(ab)
+		if err != nil {
+			log.Debugf("skipping invalid address of %s in snapshot: %s", p.Pretty(), err)
+			continue
+		}
+		addrs = append(addrs, a)
+	}
+
+	nprotos, err := readCount(r)
+	if err != nil {
+		return err
+	}
+	protos := make([]string, 0, nprotos)
+	for i := 0; i < nprotos; i++ {
+		pb, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		protos = append(protos, string(pb))
+	}
+
+	// a peer holding a private key is ours, and better known than the
+	// snapshot knows it.
+	if ps.PrivKey(p) != nil {
+		return nil
+	}
+	ps.AddAddrs(p, addrs, AddrTTL)
+	if len(protos) > 0 {
+		return ps.AddProtocols(p, protos...)
+	}
+	return nil
+}
+
+// Save exports ps to the file at path, replacing it atomically.
+func Save(path string, ps pstore.Peerstore) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := ExportPeerstore(ps, f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load imports the file at path into ps. A missing file isn't an error.
+func Load(path string, ps pstore.Peerstore) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ImportPeerstore(f, ps)
+}
+
+// Loop saves ps to path every interval, and once more when closing is
+// closed.
+func Loop(path string, ps pstore.Peerstore, interval time.Duration, closing <-chan struct{}) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-closing:
+			save(path, ps)
+			return
+		}
+		save(path, ps)
+	}
+}
+
+func save(path string, ps pstore.Peerstore) {
+	if err := Save(path, ps); err != nil {
+		log.Warningf("failed to save peerstore snapshot to %s: %s", path, err)
+	}
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func putBytes(buf *bytes.Buffer, b []byte) {
+	putUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+// readCount reads a number of items, each taking at least a byte.
+func readCount(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,127 @@
+package snapshot
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	testutil "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestExportImport(t *testing.T) {
+	sk, _, err := crypto.GenerateKeyPair(crypto.Ed25519, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	self, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	ps := pstore.NewPeerstore()
+	ps.AddPrivKey(self, sk)
+	ps.AddAddr(self, ma.StringCast("/ip4/127.0.0.1/tcp/4001"), pstore.PermanentAddrTTL)
+	ps.AddAddr(other, a, pstore.PermanentAddrTTL)
+	ps.AddProtocols(other, "/test/1.0.0")
+
+	var buf bytes.Buffer
+	if err := ExportPeerstore(ps, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(self)) {
+		t.Fatal("expected the local peer to be left out of the snapshot")
+	}
+
+	loaded := pstore.NewPeerstore()
+	if err := ImportPeerstore(bytes.NewReader(buf.Bytes()), loaded); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := loaded.Addrs(other); len(addrs) != 1 || !addrs[0].Equal(a) {
+		t.Fatalf("expected %s, got %s", a, addrs)
+	}
+	if protos, _ := loaded.GetProtocols(other); len(protos) != 1 || protos[0] != "/test/1.0.0" {
+		t.Fatalf("expected the protocols to be imported, got %v", protos)
+	}
+
+	if err := ImportPeerstore(bytes.NewReader([]byte{Version + 1}), loaded); err != ErrUnknownVersion {
+		t.Fatalf("expected ErrUnknownVersion, got %v", err)
+	}
+	if err := ImportPeerstore(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), loaded); err == nil {
+		t.Fatal("expected a truncated snapshot to fail")
+	}
+}
+
+func TestImportSkipsInvalidAddrs(t *testing.T) {
+	p, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+
+	var rec bytes.Buffer
+	putBytes(&rec, []byte(p))
+	putUvarint(&rec, 2)
+	putBytes(&rec, []byte{0xff, 0xff})
+	putBytes(&rec, a.Bytes())
+	putUvarint(&rec, 0)
+
+	var bad bytes.Buffer
+	putBytes(&bad, []byte("not a peer ID"))
+	putUvarint(&bad, 0)
+	putUvarint(&bad, 0)
+
+	var buf bytes.Buffer
+	buf.WriteByte(Version)
+	putBytes(&buf, bad.Bytes())
+	putBytes(&buf, rec.Bytes())
+
+	ps := pstore.NewPeerstore()
+	if err := ImportPeerstore(&buf, ps); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := ps.Addrs(p); len(addrs) != 1 || !addrs[0].Equal(a) {
+		t.Fatalf("expected only the valid address to be imported, got %s", addrs)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+
+	ps := pstore.NewPeerstore()
+	if err := Load(path, ps); err != nil {
+		t.Fatalf("expected a missing snapshot to be ignored, got %s", err)
+	}
+
+	p, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.AddAddr(p, ma.StringCast("/ip4/1.2.3.4/tcp/4001"), pstore.PermanentAddrTTL)
+	if err := Save(path, ps); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := pstore.NewPeerstore()
+	if err := Load(path, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Addrs(p)) != 1 {
+		t.Fatal("expected the saved peer to be loaded")
+	}
+}
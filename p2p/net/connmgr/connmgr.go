@@ -0,0 +1,333 @@
+// Package connmgr provides a connection manager that keeps the number of
+// open connections between two watermarks. Connections are tracked per
+// peer, peers are valued by the tags protocols put on them, and when the
+// high watermark is exceeded the least valuable connections are closed
+// until only the low watermark is left.
+package connmgr
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	ifconnmgr "github.com/libp2p/go-libp2p-interface-connmgr"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var log = logging.Logger("connmgr")
+
+// DefaultGracePeriod is how long new connections are spared from trimming,
+// when no grace period is given.
+var DefaultGracePeriod = time.Minute
+
+// TrimInterval is how often the manager checks the connection count on its
+// own, besides the checks made whenever a connection is opened.
+var TrimInterval = time.Minute
+
+// SilencePeriod is the minimum time between two trims, so that a burst of
+// connections above the high watermark triggers a single trim.
+var SilencePeriod = 10 * time.Second
+
+// BasicConnMgr is a connection manager trimming connections down to a low
+// watermark whenever a high watermark is exceeded. Peers are trimmed in
+// order of increasing value, the sum of their tags, and among peers of the
+// same value, those with the fewest open streams go first. Connections
+// younger than the grace period and peers protected with Protect are never
+// trimmed.
+//
+// It implements ifconnmgr.ConnManager; register its Notifee with the
+// network to have it track connections (the basic host does it for the
+// connection manager it's given).
+type BasicConnMgr struct {
+	highWater   int
+	lowWater    int
+	gracePeriod time.Duration
+
+	mu        sync.Mutex
+	peers     map[peer.ID]*peerInfo
+	protected map[peer.ID]map[string]struct{}
+	connCount int
+	lastTrim  time.Time
+
+	trim   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ ifconnmgr.ConnManager = (*BasicConnMgr)(nil)
+
+type peerInfo struct {
+	tags      map[string]int
+	value     int
+	conns     map[inet.Conn]time.Time
+	firstSeen time.Time
+}
+
+// NewConnManager creates a connection manager keeping between low and high
+// connections open, and starts its trimming loop; Close stops it. A zero
+// low trims every unprotected connection past the grace period once there
+// are more than high. A zero grace uses DefaultGracePeriod.
+func NewConnManager(low, high int, grace time.Duration) *BasicConnMgr {
+	if grace == 0 {
+		grace = DefaultGracePeriod
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cm := &BasicConnMgr{
+		highWater:   high,
+		lowWater:    low,
+		gracePeriod: grace,
+		peers:       make(map[peer.ID]*peerInfo),
+		protected:   make(map[peer.ID]map[string]struct{}),
+		trim:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	go cm.background()
+	return cm
+}
+
+// Close stops the trimming loop. Connections are left open, and still
+// tracked, but no longer trimmed.
+func (cm *BasicConnMgr) Close() error {
+	cm.cancel()
+	<-cm.done
+	return nil
+}
+
+func (cm *BasicConnMgr) background() {
+	defer close(cm.done)
+
+	ticker := time.NewTicker(TrimInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-cm.trim:
+		case <-cm.ctx.Done():
+			return
+		}
+		cm.mu.Lock()
+		over := cm.connCount > cm.highWater
+		cm.mu.Unlock()
+		if over {
+			cm.TrimOpenConns(cm.ctx)
+		}
+	}
+}
+
+// TrimOpenConns closes the least valuable connections until only the low
+// watermark is left, unless the last trim was less than SilencePeriod ago.
+func (cm *BasicConnMgr) TrimOpenConns(ctx context.Context) {
+	for _, c := range cm.connsToClose() {
+		log.Debugf("closing connection to %s", c.RemotePeer())
+		c.Close()
+	}
+}
+
+func (cm *BasicConnMgr) connsToClose() []inet.Conn {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	now := time.Now()
+	if cm.connCount <= cm.lowWater || now.Sub(cm.lastTrim) < SilencePeriod {
+		return nil
+	}
+	cm.lastTrim = now
+
+	type candidate struct {
+		info    *peerInfo
+		streams int
+	}
+	var candidates []candidate
+	for p, info := range cm.peers {
+		if _, ok := cm.protected[p]; ok {
+			continue
+		}
+		streams := 0
+		for c := range info.conns {
+			streams += len(c.GetStreams())
+		}
+		candidates = append(candidates, candidate{info, streams})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].info.value != candidates[j].info.value {
+			return candidates[i].info.value < candidates[j].info.value
+		}
+		return candidates[i].streams < candidates[j].streams
+	})
+
+	excess := cm.connCount - cm.lowWater
+	var closed []inet.Conn
+	for _, cand := range candidates {
+		if excess <= 0 {
+			break
+		}
+		for c, opened := range cand.info.conns {
+			if now.Sub(opened) < cm.gracePeriod {
+				continue
+			}
+			closed = append(closed, c)
+			excess--
+		}
+	}
+	return closed
+}
+
+// TagPeer sets the tag of the given peer to val. The peer's value is the sum
+// of its tags.
+func (cm *BasicConnMgr) TagPeer(p peer.ID, tag string, val int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	info, ok := cm.peers[p]
+	if !ok {
+		log.Debugf("tried to tag connection to unknown peer %s", p)
+		return
+	}
+	info.value += val - info.tags[tag]
+	info.tags[tag] = val
+}
+
+// UntagPeer removes the tag from the given peer.
+func (cm *BasicConnMgr) UntagPeer(p peer.ID, tag string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	info, ok := cm.peers[p]
+	if !ok {
+		log.Debugf("tried to untag connection to unknown peer %s", p)
+		return
+	}
+	info.value -= info.tags[tag]
+	delete(info.tags, tag)
+}
+
+// GetTagInfo returns the tags and connections of the given peer, or nil if
+// it isn't connected.
+func (cm *BasicConnMgr) GetTagInfo(p peer.ID) *ifconnmgr.TagInfo {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	info, ok := cm.peers[p]
+	if !ok {
+		return nil
+	}
+	out := &ifconnmgr.TagInfo{
+		FirstSeen: info.firstSeen,
+		Value:     info.value,
+		Tags:      make(map[string]int, len(info.tags)),
+		Conns:     make(map[string]time.Time, len(info.conns)),
+	}
+	for t, v := range info.tags {
+		out.Tags[t] = v
+	}
+	for c, t := range info.conns {
+		out.Conns[c.RemoteMultiaddr().String()] = t
+	}
+	return out
+}
+
+// Protect keeps the connections to the given peer from being trimmed, until
+// every tag it was protected with is removed with Unprotect. Unlike tags,
+// protections are kept while the peer is disconnected.
+func (cm *BasicConnMgr) Protect(p peer.ID, tag string) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	tags, ok := cm.protected[p]
+	if !ok {
+		tags = make(map[string]struct{})
+		cm.protected[p] = tags
+	}
+	tags[tag] = struct{}{}
+}
+
+// Unprotect removes a protection set with Protect, and reports whether the
+// peer is still protected under other tags.
+func (cm *BasicConnMgr) Unprotect(p peer.ID, tag string) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	tags, ok := cm.protected[p]
+	if !ok {
+		return false
+	}
+	delete(tags, tag)
+	if len(tags) == 0 {
+		delete(cm.protected, p)
+		return false
+	}
+	return true
+}
+
+// Notifee returns the notifiee tracking connections for the manager.
+func (cm *BasicConnMgr) Notifee() inet.Notifiee {
+	return (*cmNotifee)(cm)
+}
+
+type cmNotifee BasicConnMgr
+
+func (nn *cmNotifee) cm() *BasicConnMgr {
+	return (*BasicConnMgr)(nn)
+}
+
+func (nn *cmNotifee) Connected(n inet.Network, c inet.Conn) {
+	cm := nn.cm()
+
+	cm.mu.Lock()
+	p := c.RemotePeer()
+	info, ok := cm.peers[p]
+	if !ok {
+		info = &peerInfo{
+			tags:      make(map[string]int),
+			conns:     make(map[inet.Conn]time.Time),
+			firstSeen: time.Now(),
+		}
+		cm.peers[p] = info
+	}
+	if _, ok := info.conns[c]; !ok {
+		info.conns[c] = time.Now()
+		cm.connCount++
+	}
+	over := cm.connCount > cm.highWater
+	cm.mu.Unlock()
+
+	if over {
+		select {
+		case cm.trim <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (nn *cmNotifee) Disconnected(n inet.Network, c inet.Conn) {
+	cm := nn.cm()
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	p := c.RemotePeer()
+	info, ok := cm.peers[p]
+	if !ok {
+		return
+	}
+	if _, ok := info.conns[c]; !ok {
+		return
+	}
+	delete(info.conns, c)
+	cm.connCount--
+	if len(info.conns) == 0 {
+		delete(cm.peers, p)
+	}
+}
+
+func (nn *cmNotifee) Listen(n inet.Network, addr ma.Multiaddr)      {}
+func (nn *cmNotifee) ListenClose(n inet.Network, addr ma.Multiaddr) {}
+func (nn *cmNotifee) OpenedStream(inet.Network, inet.Stream)        {}
+func (nn *cmNotifee) ClosedStream(inet.Network, inet.Stream)        {}
@@ -0,0 +1,128 @@
+package connmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	testutil "github.com/libp2p/go-testutil"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type tconn struct {
+	inet.Conn
+	peer    peer.ID
+	streams int
+	closed  bool
+}
+
+func (c *tconn) RemotePeer() peer.ID           { return c.peer }
+func (c *tconn) RemoteMultiaddr() ma.Multiaddr { return ma.StringCast("/ip4/1.2.3.4/tcp/4001") }
+func (c *tconn) GetStreams() []inet.Stream     { return make([]inet.Stream, c.streams) }
+func (c *tconn) Close() error                  { c.closed = true; return nil }
+
+func randConn(t *testing.T, streams int) *tconn {
+	p, err := testutil.RandPeerID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tconn{peer: p, streams: streams}
+}
+
+func TestTrimOpenConns(t *testing.T) {
+	cm := NewConnManager(2, 5, time.Nanosecond)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	valued := randConn(t, 0)
+	protected := randConn(t, 0)
+	busy := randConn(t, 3)
+	idle := []*tconn{randConn(t, 0), randConn(t, 0)}
+	for _, c := range append([]*tconn{valued, protected, busy}, idle...) {
+		not.Connected(nil, c)
+	}
+	cm.TagPeer(valued.peer, "test", 10)
+	cm.Protect(protected.peer, "test")
+	time.Sleep(time.Millisecond)
+
+	// at the high watermark, so trimming is up to us.
+	cm.TrimOpenConns(context.Background())
+
+	for _, c := range []*tconn{valued, protected} {
+		if c.closed {
+			t.Fatal("expected the valued and protected connections to be kept")
+		}
+	}
+	for _, c := range idle {
+		if !c.closed {
+			t.Fatal("expected the idle connections to be closed first")
+		}
+	}
+	// closing is up to the network; report it like the network would.
+	for _, c := range idle {
+		not.Disconnected(nil, c)
+	}
+	if busy.closed {
+		t.Fatal("expected the busy connection to be kept while at the low watermark")
+	}
+
+	if cm.Unprotect(protected.peer, "test") {
+		t.Fatal("expected the peer to be unprotected")
+	}
+	if ti := cm.GetTagInfo(valued.peer); ti == nil || ti.Value != 10 {
+		t.Fatalf("unexpected tag info: %+v", ti)
+	}
+	cm.UntagPeer(valued.peer, "test")
+	if ti := cm.GetTagInfo(valued.peer); ti.Value != 0 {
+		t.Fatalf("expected the tag removed, got %+v", ti)
+	}
+}
+
+func TestTrimToZero(t *testing.T) {
+	cm := NewConnManager(0, 1, time.Nanosecond)
+	defer cm.Close()
+
+	conns := []*tconn{randConn(t, 0), randConn(t, 0)}
+	for _, c := range conns {
+		cm.Notifee().Connected(nil, c)
+	}
+	time.Sleep(time.Millisecond)
+	cm.TrimOpenConns(context.Background())
+	for _, c := range conns {
+		if !c.closed {
+			t.Fatal("expected a zero low watermark to trim every connection")
+		}
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	cm := NewConnManager(1, 2, time.Hour)
+	defer cm.Close()
+
+	conns := []*tconn{randConn(t, 0), randConn(t, 0), randConn(t, 0)}
+	for _, c := range conns {
+		cm.Notifee().Connected(nil, c)
+	}
+	cm.TrimOpenConns(context.Background())
+	for _, c := range conns {
+		if c.closed {
+			t.Fatal("expected new connections to be spared")
+		}
+	}
+}
+
+func TestCloseStopsLoop(t *testing.T) {
+	cm := NewConnManager(1, 2, 0)
+	done := make(chan struct{})
+	go func() {
+		cm.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't stop the trimming loop")
+	}
+}
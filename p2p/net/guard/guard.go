@@ -0,0 +1,124 @@
+// Package guard protects short protocol exchanges, such as protocol
+// negotiation and identify, against peers keeping them alive by dribbling
+// bytes just fast enough to evade absolute timeouts.
+package guard
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// ErrSlowTransfer is returned by reads on a watched stream that was reset
+// for not making enough progress.
+var ErrSlowTransfer = errors.New("stream transfer too slow")
+
+// DefaultLimits are the limits the basic host and the identify service
+// apply by default: 16 bytes every 5 seconds, not enforced on loopback
+// connections.
+var DefaultLimits = Limits{MinBytes: 16, Window: 5 * time.Second}
+
+// Limits is a minimum throughput for reads: at least MinBytes in every
+// Window, counted from the first byte read. A zero MinBytes or Window
+// disables the limits.
+type Limits struct {
+	MinBytes int
+	Window   time.Duration
+
+	// Loopback enforces the limits on loopback connections too.
+	Loopback bool
+}
+
+func (l Limits) applies(s inet.Stream) bool {
+	if l.MinBytes <= 0 || l.Window <= 0 {
+		return false
+	}
+	return l.Loopback || !manet.IsIPLoopback(s.Conn().RemoteMultiaddr())
+}
+
+// Stream is a stream watched for read progress.
+type Stream struct {
+	inet.Stream
+
+	read    int64
+	tripped int32
+
+	started   chan struct{}
+	startOnce sync.Once
+
+	done chan struct{}
+	once sync.Once
+}
+
+// Watch returns s wrapped so that it is reset if, in any window, reads make
+// less progress than the limits require, until Done is called. onSlow, if
+// not nil, is called when that happens. The first window starts with the
+// first byte read: a stream opened ahead of its first use, and idle until
+// then, isn't reset.
+func Watch(s inet.Stream, l Limits, onSlow func()) *Stream {
+	gs := &Stream{
+		Stream:  s,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if l.applies(s) {
+		go gs.watch(l, onSlow)
+	}
+	return gs
+}
+
+func (s *Stream) watch(l Limits, onSlow func()) {
+	select {
+	case <-s.started:
+	case <-s.done:
+		return
+	}
+
+	ticker := time.NewTicker(l.Window)
+	defer ticker.Stop()
+
+	var last int64
+	for {
+		select {
+		case <-ticker.C:
+			cur := atomic.LoadInt64(&s.read)
+			if cur-last < int64(l.MinBytes) {
+				atomic.StoreInt32(&s.tripped, 1)
+				s.Stream.Reset()
+				if onSlow != nil {
+					onSlow()
+				}
+				return
+			}
+			last = cur
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	n, err := s.Stream.Read(b)
+	if n > 0 {
+		s.startOnce.Do(func() { close(s.started) })
+	}
+	atomic.AddInt64(&s.read, int64(n))
+	if err != nil && s.Tripped() {
+		err = ErrSlowTransfer
+	}
+	return n, err
+}
+
+// Done stops watching the stream.
+func (s *Stream) Done() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// Tripped reports whether the stream was reset for being too slow.
+func (s *Stream) Tripped() bool {
+	return atomic.LoadInt32(&s.tripped) == 1
+}
@@ -0,0 +1,272 @@
+// Package memory implements an in-process transport, for tests. Nodes
+// listen on and dial /memory/<id> addresses, and connections go through
+// the regular security and stream muxer upgrade, without OS sockets.
+package memory
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// P_MEMORY is the multiaddr protocol code of memory addresses.
+const P_MEMORY = 777
+
+// Protocol is the /memory multiaddr protocol. Its value is a 64 bit
+// identifier.
+var Protocol = ma.Protocol{
+	Code:       P_MEMORY,
+	Size:       64,
+	Name:       "memory",
+	VCode:      ma.CodeToVarint(P_MEMORY),
+	Transcoder: ma.NewTranscoderFromFunctions(memoryStB, memoryBtS),
+}
+
+func init() {
+	if err := ma.AddProtocol(Protocol); err != nil {
+		panic(err)
+	}
+}
+
+func memoryStB(s string) ([]byte, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, id)
+	return b, nil
+}
+
+func memoryBtS(b []byte) (string, error) {
+	if len(b) != 8 {
+		return "", fmt.Errorf("invalid memory address length %d", len(b))
+	}
+	return strconv.FormatUint(binary.BigEndian.Uint64(b), 10), nil
+}
+
+var (
+	// ErrAddrInUse is returned when listening on an identifier already
+	// listened on.
+	ErrAddrInUse = errors.New("memory address already in use")
+
+	// ErrConnRefused is returned when dialing an identifier nobody
+	// listens on.
+	ErrConnRefused = errors.New("connection refused")
+
+	errListenerClosed = errors.New("memory listener closed")
+)
+
+// registry holds the listeners of the process, by identifier. Listening
+// on identifier 0 picks a free one.
+var registry = struct {
+	sync.Mutex
+	listeners map[uint64]*listener
+	next      uint64
+}{listeners: make(map[uint64]*listener), next: 1 << 32}
+
+func addrID(a ma.Multiaddr) (uint64, error) {
+	s, err := a.ValueForProtocol(P_MEMORY)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+func memoryAddr(id uint64) ma.Multiaddr {
+	a, err := ma.NewMultiaddr(fmt.Sprintf("/memory/%d", id))
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Transport is the memory transport. All the transports of a process
+// share the same address space.
+type Transport struct {
+	// Latency delays the data sent over the connections dialed with the
+	// transport, in both directions, to simulate a network in tests.
+	Latency time.Duration
+}
+
+var _ transport.Transport = (*Transport)(nil)
+
+// NewTransport returns a memory transport.
+func NewTransport() *Transport {
+	return &Transport{}
+}
+
+// Matches reports whether a is a memory address.
+func (t *Transport) Matches(a ma.Multiaddr) bool {
+	protos := a.Protocols()
+	return len(protos) == 1 && protos[0].Code == P_MEMORY
+}
+
+func (t *Transport) Dialer(laddr ma.Multiaddr, opts ...transport.DialOpt) (transport.Dialer, error) {
+	return &dialer{t: t}, nil
+}
+
+func (t *Transport) Listen(laddr ma.Multiaddr) (transport.Listener, error) {
+	if !t.Matches(laddr) {
+		return nil, fmt.Errorf("not a memory address: %s", laddr)
+	}
+	id, err := addrID(laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	if id == 0 {
+		for registry.listeners[registry.next] != nil {
+			registry.next++
+		}
+		id = registry.next
+		registry.next++
+	}
+	if registry.listeners[id] != nil {
+		return nil, ErrAddrInUse
+	}
+	l := &listener{
+		t:      t,
+		id:     id,
+		addr:   memoryAddr(id),
+		conns:  make(chan *conn),
+		closed: make(chan struct{}),
+	}
+	registry.listeners[id] = l
+	return l, nil
+}
+
+type dialer struct {
+	t *Transport
+}
+
+func (d *dialer) Matches(a ma.Multiaddr) bool {
+	return d.t.Matches(a)
+}
+
+func (d *dialer) Dial(raddr ma.Multiaddr) (transport.Conn, error) {
+	return d.DialContext(context.Background(), raddr)
+}
+
+func (d *dialer) DialContext(ctx context.Context, raddr ma.Multiaddr) (transport.Conn, error) {
+	id, err := addrID(raddr)
+	if err != nil {
+		return nil, err
+	}
+	registry.Lock()
+	l := registry.listeners[id]
+	// the dialing end gets an identifier nobody listens on.
+	laddr := memoryAddr(registry.next)
+	registry.next++
+	registry.Unlock()
+	if l == nil {
+		return nil, ErrConnRefused
+	}
+
+	local, remote := newConnPair(d.t, laddr, l.addr)
+	select {
+	case l.conns <- remote:
+		return local, nil
+	case <-l.closed:
+		return nil, ErrConnRefused
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type listener struct {
+	t    *Transport
+	id   uint64
+	addr ma.Multiaddr
+
+	conns     chan *conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) Accept() (transport.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		registry.Lock()
+		delete(registry.listeners, l.id)
+		registry.Unlock()
+		close(l.closed)
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return netAddr{l.addr}
+}
+
+func (l *listener) Multiaddr() ma.Multiaddr {
+	return l.addr
+}
+
+// netAddr is a memory address as a net.Addr.
+type netAddr struct {
+	ma.Multiaddr
+}
+
+func (a netAddr) Network() string { return "memory" }
+
+// conn is one end of a memory connection.
+type conn struct {
+	t            *Transport
+	r, w         *pipe
+	laddr, raddr ma.Multiaddr
+}
+
+func newConnPair(t *Transport, a, b ma.Multiaddr) (*conn, *conn) {
+	ab, ba := newPipe(t.Latency), newPipe(t.Latency)
+	return &conn{t: t, r: ba, w: ab, laddr: a, raddr: b},
+		&conn{t: t, r: ab, w: ba, laddr: b, raddr: a}
+}
+
+func (c *conn) Read(b []byte) (int, error)  { return c.r.read(b) }
+func (c *conn) Write(b []byte) (int, error) { return c.w.write(b) }
+
+func (c *conn) Close() error {
+	c.w.closeWrite()
+	c.r.closeRead()
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr            { return netAddr{c.laddr} }
+func (c *conn) RemoteAddr() net.Addr           { return netAddr{c.raddr} }
+func (c *conn) LocalMultiaddr() ma.Multiaddr   { return c.laddr }
+func (c *conn) RemoteMultiaddr() ma.Multiaddr  { return c.raddr }
+func (c *conn) Transport() transport.Transport { return c.t }
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.r.setReadDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline is a no-op: writes never block.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.r.setReadDeadline(t)
+	return nil
+}
@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// timeoutError is returned by reads past their deadline.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// pipe is one direction of a connection. Unlike net.Pipe, writes are
+// buffered and never block, as both ends of a handshake commonly write
+// before reading. With a latency, written data becomes readable only once
+// the latency has elapsed.
+type pipe struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+
+	latency time.Duration
+	pending []chunk
+	wake    *time.Timer
+
+	// wclosed is set when the writing end is closed, rclosed when the
+	// reading end is.
+	wclosed bool
+	rclosed bool
+
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// chunk is written data in flight, readable at the given time.
+type chunk struct {
+	data []byte
+	at   time.Time
+}
+
+func newPipe(latency time.Duration) *pipe {
+	p := &pipe{latency: latency}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+func (p *pipe) read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.deliver(); p.buf.Len() == 0; p.deliver() {
+		switch {
+		case p.rclosed:
+			return 0, io.ErrClosedPipe
+		case p.wclosed && len(p.pending) == 0:
+			return 0, io.EOF
+		case !p.deadline.IsZero() && !time.Now().Before(p.deadline):
+			return 0, timeoutError{}
+		}
+		if len(p.pending) > 0 && p.wake == nil {
+			p.wake = time.AfterFunc(time.Until(p.pending[0].at), func() {
+				p.mu.Lock()
+				p.wake = nil
+				p.cond.Broadcast()
+				p.mu.Unlock()
+			})
+		}
+		p.cond.Wait()
+	}
+	return p.buf.Read(b)
+}
+
+// deliver moves the data in flight that has arrived to the read buffer.
+func (p *pipe) deliver() {
+	now := time.Now()
+	for len(p.pending) > 0 && !now.Before(p.pending[0].at) {
+		p.buf.Write(p.pending[0].data)
+		p.pending = p.pending[1:]
+	}
+}
+
+func (p *pipe) write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.wclosed || p.rclosed {
+		return 0, io.ErrClosedPipe
+	}
+	if p.latency > 0 {
+		data := append([]byte(nil), b...)
+		p.pending = append(p.pending, chunk{data: data, at: time.Now().Add(p.latency)})
+	} else {
+		p.buf.Write(b)
+	}
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *pipe) setReadDeadline(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.deadline = t
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if !t.IsZero() {
+		p.timer = time.AfterFunc(time.Until(t), func() {
+			p.mu.Lock()
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		})
+	}
+	p.cond.Broadcast()
+}
+
+func (p *pipe) closeWrite() {
+	p.mu.Lock()
+	p.wclosed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+func (p *pipe) closeRead() {
+	p.mu.Lock()
+	p.rclosed = true
+	p.buf.Reset()
+	p.pending = nil
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
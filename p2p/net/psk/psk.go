@@ -0,0 +1,192 @@
+// Package psk implements private networks: connections are encrypted with
+// a key pre-shared among the members of the network, so that nodes
+// without it can't even start a handshake with them. It is wire compatible
+// with go-libp2p-pnet.
+package psk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+
+	ipnet "github.com/libp2p/go-libp2p-interface-pnet"
+	transport "github.com/libp2p/go-libp2p-transport"
+	"golang.org/x/crypto/salsa20"
+	"golang.org/x/crypto/sha3"
+)
+
+// KeySize is the size of pre-shared keys, in bytes.
+const KeySize = 32
+
+const nonceSize = 24
+
+var (
+	// ErrKeySize is returned for keys that aren't KeySize bytes long.
+	ErrKeySize = fmt.Errorf("pre-shared key must be %d bytes", KeySize)
+
+	errShortNonce = errors.New("could not read the full nonce")
+)
+
+var (
+	pathPSKv1  = []byte("/key/swarm/psk/1.0.0/")
+	pathBin    = "/bin/"
+	pathBase16 = "/base16/"
+	pathBase64 = "/base64/"
+)
+
+// DecodeKey reads a pre-shared key in the key file format, a
+// "/key/swarm/psk/1.0.0/" line, an encoding line, "/base16/", "/base64/"
+// or "/bin/", then the key. Errors never include key material.
+func DecodeKey(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	header, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header, pathPSKv1) {
+		return nil, errors.New("malformed pre-shared key file: expected a /key/swarm/psk/1.0.0/ header")
+	}
+	enc, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	switch string(enc) {
+	case pathBin:
+		key = rest
+	case pathBase16:
+		key, err = hex.DecodeString(string(bytes.TrimSpace(rest)))
+	case pathBase64:
+		key, err = base64.StdEncoding.DecodeString(string(bytes.TrimSpace(rest)))
+	default:
+		return nil, fmt.Errorf("malformed pre-shared key file: unknown encoding %q", enc)
+	}
+	if err != nil {
+		return nil, errors.New("malformed pre-shared key file: invalid key encoding")
+	}
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	return key, nil
+}
+
+// Generate returns a new pre-shared key, read from r, which should be a
+// source of cryptographic randomness such as crypto/rand.Reader.
+func Generate(r io.Reader) ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey writes key to w in the key file format, base16 encoded.
+func EncodeKey(w io.Writer, key []byte) error {
+	if len(key) != KeySize {
+		return ErrKeySize
+	}
+	_, err := fmt.Fprintf(w, "%s\n%s\n%s\n", pathPSKv1, pathBase16, hex.EncodeToString(key))
+	return err
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, errors.New("malformed pre-shared key file: truncated header")
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+type protector struct {
+	psk         [KeySize]byte
+	fingerprint []byte
+}
+
+// NewProtector returns a protector for the private network keyed by key.
+func NewProtector(key []byte) (ipnet.Protector, error) {
+	if len(key) != KeySize {
+		return nil, ErrKeySize
+	}
+	p := &protector{}
+	copy(p.psk[:], key)
+	p.fingerprint = fingerprint(&p.psk)
+	return p, nil
+}
+
+func (p *protector) Protect(c transport.Conn) (transport.Conn, error) {
+	return &pskConn{Conn: c, psk: &p.psk}, nil
+}
+
+// Fingerprint identifies the network, without revealing its key.
+func (p *protector) Fingerprint() []byte {
+	return p.fingerprint
+}
+
+func fingerprint(psk *[KeySize]byte) []byte {
+	// the key is only ever hashed through the cipher.
+	enc := make([]byte, 64)
+	salsa20.XORKeyStream(enc, enc, []byte("finprint"), psk)
+	out := make([]byte, 16)
+	sha3.ShakeSum128(out, enc)
+	return out
+}
+
+// nonceReader is where the nonces of written data come from.
+var nonceReader io.Reader = rand.Reader
+
+// pskConn encrypts a connection with XSalsa20. Each side picks a random
+// nonce for the data it writes, and sends it first.
+type pskConn struct {
+	transport.Conn
+	psk *[KeySize]byte
+
+	readS  *xsalsa20
+	writeS *xsalsa20
+}
+
+func (c *pskConn) Read(out []byte) (int, error) {
+	if c.readS == nil {
+		var nonce [nonceSize]byte
+		if _, err := io.ReadFull(c.Conn, nonce[:]); err != nil {
+			return 0, errShortNonce
+		}
+		c.readS = newXSalsa20(c.psk, &nonce)
+	}
+
+	n, err := c.Conn.Read(out)
+	if n > 0 {
+		c.readS.XORKeyStream(out[:n], out[:n])
+	}
+	return n, err
+}
+
+func (c *pskConn) Write(in []byte) (int, error) {
+	if c.writeS == nil {
+		var nonce [nonceSize]byte
+		if _, err := io.ReadFull(nonceReader, nonce[:]); err != nil {
+			return 0, err
+		}
+		if _, err := c.Conn.Write(nonce[:]); err != nil {
+			return 0, err
+		}
+		c.writeS = newXSalsa20(c.psk, &nonce)
+	}
+
+	out := make([]byte, len(in))
+	c.writeS.XORKeyStream(out, in)
+	return c.Conn.Write(out)
+}
+
+var _ net.Conn = (*pskConn)(nil)
@@ -0,0 +1,164 @@
+package psk
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type testConn struct {
+	net.Conn
+}
+
+func (c *testConn) LocalMultiaddr() ma.Multiaddr   { return nil }
+func (c *testConn) RemoteMultiaddr() ma.Multiaddr  { return nil }
+func (c *testConn) Transport() transport.Transport { return nil }
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func protect(t *testing.T, key []byte, c net.Conn) transport.Conn {
+	p, err := NewProtector(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := p.Protect(&testConn{c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pc
+}
+
+func exchange(t *testing.T, k1, k2 []byte, msg []byte) []byte {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	a, b := protect(t, k1, c1), protect(t, k2, c2)
+
+	go func() {
+		// several writes, to cross key stream blocks unevenly.
+		for _, chunk := range [][]byte{msg[:3], msg[3:70], msg[70:]} {
+			a.Write(chunk)
+		}
+	}()
+	out := make([]byte, len(msg))
+	if _, err := io.ReadFull(b, out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestProtectRoundtrip(t *testing.T) {
+	msg := bytes.Repeat([]byte("private network "), 16)
+	if out := exchange(t, testKey(1), testKey(1), msg); !bytes.Equal(out, msg) {
+		t.Fatal("expected the message through with the same key")
+	}
+	if out := exchange(t, testKey(1), testKey(2), msg); bytes.Equal(out, msg) {
+		t.Fatal("expected the message garbled with another key")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	p1, _ := NewProtector(testKey(1))
+	p2, _ := NewProtector(testKey(1))
+	p3, _ := NewProtector(testKey(2))
+	if !bytes.Equal(p1.Fingerprint(), p2.Fingerprint()) {
+		t.Fatal("expected the same fingerprint for the same key")
+	}
+	if bytes.Equal(p1.Fingerprint(), p3.Fingerprint()) {
+		t.Fatal("expected different fingerprints for different keys")
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	key := testKey(7)
+	for _, enc := range []string{
+		"/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(key),
+		"/key/swarm/psk/1.0.0/\n/base64/\n" + base64.StdEncoding.EncodeToString(key) + "\n",
+		"/key/swarm/psk/1.0.0/\r\n/bin/\r\n" + string(key),
+	} {
+		out, err := DecodeKey(strings.NewReader(enc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, key) {
+			t.Fatalf("wrong key decoded from %q", enc)
+		}
+	}
+
+	secret := hex.EncodeToString(key[:16])
+	for _, enc := range []string{
+		"/key/swarm/psk/2.0.0/\n/base16/\n" + hex.EncodeToString(key),
+		"/key/swarm/psk/1.0.0/\n/base32/\n" + hex.EncodeToString(key),
+		"/key/swarm/psk/1.0.0/\n/base16/\n" + secret,
+		"/key/swarm/psk/1.0.0/\n/base16/\n" + secret + "zz",
+		"/key/swarm/psk/1.0.0/",
+	} {
+		_, err := DecodeKey(strings.NewReader(enc))
+		if err == nil {
+			t.Fatalf("expected %q to be rejected", enc)
+		}
+		if strings.Contains(err.Error(), secret) {
+			t.Fatalf("error leaks the key: %s", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeKey(&buf, key); err != nil {
+		t.Fatal(err)
+	}
+	out, err := DecodeKey(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, key) {
+		t.Fatal("expected an encoded key to decode to itself")
+	}
+
+	if _, err := NewProtector(key[:16]); err != ErrKeySize {
+		t.Fatalf("expected ErrKeySize, got %v", err)
+	}
+}
+
+// TestWireTranscript checks the bytes sent on the wire, for a fixed key and
+// nonce, against the go-libp2p-pnet format: the writer's 24 byte nonce,
+// then the data xored with the XSalsa20 key stream of the key and nonce.
+func TestWireTranscript(t *testing.T) {
+	key := make([]byte, KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := make([]byte, nonceSize)
+	for i := range nonce {
+		nonce[i] = byte(0x10 + i)
+	}
+	defer func(r io.Reader) { nonceReader = r }(nonceReader)
+	nonceReader = bytes.NewReader(nonce)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	pc := protect(t, key, c1)
+	go func() {
+		pc.Write([]byte("/multistream/"))
+		pc.Write([]byte("1.0.0\n"))
+	}()
+
+	want := "101112131415161718191a1b1c1d1e1f2021222324252627" +
+		"26b1aade0d3614f17858e75f24dff887274adb"
+	wire := make([]byte, len(want)/2)
+	if _, err := io.ReadFull(c2, wire); err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(wire) != want {
+		t.Fatalf("expected %s on the wire, got %x", want, wire)
+	}
+}
@@ -0,0 +1,50 @@
+package psk
+
+import (
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+// xsalsa20 is an XSalsa20 key stream, which, unlike salsa20.XORKeyStream,
+// may be consumed a little at a time.
+type xsalsa20 struct {
+	key     [32]byte
+	counter [16]byte // nonce, then little endian block counter
+	block   [64]byte
+	used    int // bytes of block already consumed
+}
+
+func newXSalsa20(key *[32]byte, nonce *[24]byte) *xsalsa20 {
+	s := &xsalsa20{used: 64}
+	var hNonce [16]byte
+	copy(hNonce[:], nonce[:16])
+	salsa.HSalsa20(&s.key, &hNonce, key, &salsa.Sigma)
+	copy(s.counter[:8], nonce[16:])
+	return s
+}
+
+// XORKeyStream xors src with the next len(src) bytes of the key stream
+// into dst, which may be src.
+func (s *xsalsa20) XORKeyStream(dst, src []byte) {
+	for len(src) > 0 {
+		if s.used == len(s.block) {
+			var zero [64]byte
+			salsa.XORKeyStream(s.block[:], zero[:], &s.counter, &s.key)
+			for i := 8; i < 16; i++ {
+				s.counter[i]++
+				if s.counter[i] != 0 {
+					break
+				}
+			}
+			s.used = 0
+		}
+		n := len(s.block) - s.used
+		if n > len(src) {
+			n = len(src)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ s.block[s.used+i]
+		}
+		s.used += n
+		dst, src = dst[n:], src[n:]
+	}
+}
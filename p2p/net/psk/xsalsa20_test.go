@@ -0,0 +1,69 @@
+package psk
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/salsa20"
+)
+
+var (
+	testXKey   = []byte("this is 32-byte key for xsalsa20")
+	testXNonce = []byte("24-byte nonce for xsalsa")
+)
+
+func newTestXSalsa20() *xsalsa20 {
+	var key [32]byte
+	var nonce [24]byte
+	copy(key[:], testXKey)
+	copy(nonce[:], testXNonce)
+	return newXSalsa20(&key, &nonce)
+}
+
+// the vectors of the NaCl XSalsa20 reference implementation.
+func TestXSalsa20KnownAnswer(t *testing.T) {
+	for _, tc := range []struct {
+		in  []byte
+		out string
+	}{
+		{[]byte("Hello world!"), "002d4513843fc240c401e541"},
+		{make([]byte, 64), "4848297feb1fb52fb66d81609bd547fabcbe7026edc8b5e5e449d088bfa69c08" +
+			"8f5d8da1d791267c2c195a7f8cae9c4b4050d08ce6d3a151ec265f3a58e47648"},
+	} {
+		out := make([]byte, len(tc.in))
+		newTestXSalsa20().XORKeyStream(out, tc.in)
+		if hex.EncodeToString(out) != tc.out {
+			t.Fatalf("expected %s, got %x", tc.out, out)
+		}
+	}
+}
+
+func TestXSalsa20CounterCarry(t *testing.T) {
+	// past block 255, the block counter carries into its second byte.
+	const size = 300 * 64
+	want := make([]byte, size)
+	var key [32]byte
+	copy(key[:], testXKey)
+	salsa20.XORKeyStream(want, want, testXNonce, &key)
+
+	got := make([]byte, size)
+	s := newTestXSalsa20()
+	for off, i := 0, 0; off < size; i++ {
+		// uneven chunks, crossing block boundaries at all offsets.
+		n := []int{7, 64, 1, 130, 33}[i%5]
+		if off+n > size {
+			n = size - off
+		}
+		s.XORKeyStream(got[off:off+n], got[off:off+n])
+		off += n
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("key stream differs from the one-shot XSalsa20 key stream")
+	}
+
+	carry := "2737107a66b9cbe09852e11577a54e566b52030b95aa4ade6f652bb6f44e84d4"
+	if h := hex.EncodeToString(got[255*64+48 : 256*64+16]); h != carry {
+		t.Fatalf("expected %s around the carry, got %s", carry, h)
+	}
+}
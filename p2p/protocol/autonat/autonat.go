@@ -0,0 +1,79 @@
+// Package autonat lets a node find out whether it is publicly reachable,
+// by asking its peers to dial it back.
+//
+// The messages are JSON encoded, and not compatible with the protobuf
+// based autonat protocol of other implementations, hence its own protocol
+// ID.
+package autonat
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	inet "github.com/libp2p/go-libp2p-net"
+)
+
+var log = logging.Logger("autonat")
+
+// ID is the protocol.ID of the dial back protocol.
+const ID = "/go-libp2p/autonat/1.0.0"
+
+// Dial back statuses.
+const (
+	StatusOK = "OK"
+	// StatusDialError means no address could be dialed back.
+	StatusDialError = "E_DIAL_ERROR"
+	// StatusDialRefused means the request was refused, because it had no
+	// address the service would dial, or because of its rate limit.
+	StatusDialRefused = "E_DIAL_REFUSED"
+)
+
+// streamTimeout bounds a whole exchange, dial back included.
+var streamTimeout = time.Minute
+
+// DialTimeout bounds each dial back.
+var DialTimeout = 15 * time.Second
+
+// MaxDialBackAddrs is how many addresses of a request a service dials back
+// at most, so that its dials, one after the other, fit in the exchange.
+var MaxDialBackAddrs = 3
+
+type dialRequest struct {
+	Addrs []string `json:"addrs"`
+}
+
+type dialResponse struct {
+	Status string `json:"status"`
+	Text   string `json:"text,omitempty"`
+	Addr   string `json:"addr,omitempty"`
+}
+
+var errNoResponse = errors.New("no dial back response")
+
+func writeMsg(s inet.Stream, msg interface{}) error {
+	return json.NewEncoder(s).Encode(msg)
+}
+
+func readMsg(s inet.Stream, msg interface{}) error {
+	// requests are small, don't read more than needed.
+	return json.NewDecoder(&limitedReader{r: s, n: 64 << 10}).Decode(msg)
+}
+
+type limitedReader struct {
+	r inet.Stream
+	n int
+}
+
+func (l *limitedReader) Read(b []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errors.New("message too large")
+	}
+	if len(b) > l.n {
+		b = b[:l.n]
+	}
+	n, err := l.r.Read(b)
+	l.n -= n
+	return n, err
+}
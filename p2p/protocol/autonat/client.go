@@ -0,0 +1,133 @@
+package autonat
+
+import (
+	"context"
+	"time"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// MaxPeersProbed is how many peers a probe asks at most.
+var MaxPeersProbed = 4
+
+// PrivateThreshold is how many peers must fail to dial us back for a probe
+// to conclude we aren't publicly reachable.
+var PrivateThreshold = 2
+
+// PublicThreshold is how many peers must dial us back for a probe to
+// conclude we are publicly reachable, so that a single peer can't settle
+// it.
+var PublicThreshold = 2
+
+// Client probes the reachability of a host.
+type Client struct {
+	h host.Host
+}
+
+// NewClient returns a client probing the reachability of h.
+func NewClient(h host.Host) *Client {
+	return &Client{h: h}
+}
+
+// Probe asks the connected peers running the dial back service to dial us
+// back at our addresses. ok is false when the probe was inconclusive,
+// for lack of peers answering, or else public tells whether
+// PublicThreshold peers reached us.
+func (c *Client) Probe(ctx context.Context) (public, ok bool) {
+	addrs := c.dialableAddrs()
+	if len(addrs) == 0 {
+		return false, false
+	}
+	return c.probe(ctx, addrs, PublicThreshold)
+}
+
+// DialBack is like Probe, for a single address of ours, such as one behind
+// a manual port forward: reached tells whether a peer dialed us back at a.
+// One peer is enough, as we already know a is ours.
+func (c *Client) DialBack(ctx context.Context, a ma.Multiaddr) (reached, ok bool) {
+	if isCircuitAddr(a) {
+		return false, false
+	}
+	return c.probe(ctx, []string{a.String()}, 1)
+}
+
+// probe concludes we are reached once publicThreshold peers dialed us back
+// at addrs, or that we aren't once PrivateThreshold peers failed to.
+func (c *Client) probe(ctx context.Context, addrs []string, publicThreshold int) (reached, ok bool) {
+	successes := 0
+	failures := 0
+	asked := 0
+	for _, p := range c.h.Network().Peers() {
+		if asked >= MaxPeersProbed {
+			break
+		}
+		if protos, err := c.h.Peerstore().SupportsProtocols(p, ID); err != nil || len(protos) == 0 {
+			continue
+		}
+		asked++
+
+		resp, err := c.dialBack(ctx, p, addrs)
+		if err != nil {
+			log.Debugf("dial back request to %s failed: %s", p.Pretty(), err)
+			continue
+		}
+		switch resp.Status {
+		case StatusOK:
+			if successes++; successes >= publicThreshold {
+				return true, true
+			}
+		case StatusDialError:
+			if failures++; failures >= PrivateThreshold {
+				return false, true
+			}
+		}
+	}
+	return false, false
+}
+
+// dialableAddrs are our addresses a peer can dial us back at. Relay
+// addresses are no proof of reachability.
+func (c *Client) dialableAddrs() []string {
+	var out []string
+	for _, a := range c.h.Addrs() {
+		if !isCircuitAddr(a) {
+			out = append(out, a.String())
+		}
+	}
+	return out
+}
+
+func (c *Client) dialBack(ctx context.Context, p peer.ID, addrs []string) (*dialResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, streamTimeout)
+	defer cancel()
+
+	s, err := c.h.NewStream(ctx, p, ID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(streamTimeout))
+
+	if err := writeMsg(s, &dialRequest{Addrs: addrs}); err != nil {
+		s.Reset()
+		return nil, err
+	}
+	var resp dialResponse
+	if err := readMsg(s, &resp); err != nil {
+		s.Reset()
+		return nil, errNoResponse
+	}
+	return &resp, nil
+}
+
+func isCircuitAddr(a ma.Multiaddr) bool {
+	for _, p := range a.Protocols() {
+		if p.Code == circuit.P_CIRCUIT {
+			return true
+		}
+	}
+	return false
+}
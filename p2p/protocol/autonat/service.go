@@ -0,0 +1,163 @@
+package autonat
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// RateLimit caps the dial back requests a service accepts per Interval, in
+// all and per peer. Requests beyond them are refused, so that the service
+// can't be used to make us dial others en masse.
+type RateLimit struct {
+	Global   int
+	PerPeer  int
+	Interval time.Duration
+}
+
+// DefaultRateLimit is the rate limit of services given none.
+var DefaultRateLimit = RateLimit{Global: 30, PerPeer: 3, Interval: time.Minute}
+
+// Service dials peers back at their addresses, for their clients to learn
+// whether they are reachable. It only dials addresses on the IP the peer
+// is connected from, over TCP, without going further than establishing
+// the TCP connection.
+type Service struct {
+	h     host.Host
+	limit RateLimit
+
+	mu      sync.Mutex
+	reset   time.Time
+	global  int
+	perPeer map[peer.ID]int
+}
+
+// NewService starts a dial back service on h.
+func NewService(h host.Host, limit RateLimit) *Service {
+	s := &Service{
+		h:       h,
+		limit:   limit,
+		perPeer: make(map[peer.ID]int),
+	}
+	h.SetStreamHandler(ID, s.handleStream)
+	return s
+}
+
+// Close stops the service.
+func (s *Service) Close() error {
+	s.h.RemoveStreamHandler(ID)
+	return nil
+}
+
+// allow counts a request from p, and reports whether it is within the
+// rate limit.
+func (s *Service) allow(p peer.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.After(s.reset) {
+		s.reset = now.Add(s.limit.Interval)
+		s.global = 0
+		s.perPeer = make(map[peer.ID]int)
+	}
+	if (s.limit.Global > 0 && s.global >= s.limit.Global) ||
+		(s.limit.PerPeer > 0 && s.perPeer[p] >= s.limit.PerPeer) {
+		return false
+	}
+	s.global++
+	s.perPeer[p]++
+	return true
+}
+
+func (s *Service) handleStream(st inet.Stream) {
+	defer st.Close()
+	st.SetDeadline(time.Now().Add(streamTimeout))
+
+	p := st.Conn().RemotePeer()
+	var req dialRequest
+	if err := readMsg(st, &req); err != nil {
+		st.Reset()
+		return
+	}
+
+	var resp *dialResponse
+	if !s.allow(p) {
+		resp = &dialResponse{Status: StatusDialRefused, Text: "rate limited"}
+	} else {
+		resp = s.dialBack(st.Conn().RemoteMultiaddr(), req.Addrs)
+	}
+	if err := writeMsg(st, resp); err != nil {
+		st.Reset()
+	}
+}
+
+func (s *Service) dialBack(from ma.Multiaddr, addrs []string) *dialResponse {
+	fromIP := ipOf(from)
+	if fromIP == nil {
+		return &dialResponse{Status: StatusDialRefused, Text: "no IP to dial back"}
+	}
+
+	tried := 0
+	for _, str := range addrs {
+		if tried >= MaxDialBackAddrs {
+			break
+		}
+		a, err := ma.NewMultiaddr(str)
+		if err != nil || !isTCPAddr(a) {
+			continue
+		}
+		if ip := ipOf(a); ip == nil || !ip.Equal(fromIP) {
+			continue
+		}
+
+		tried++
+		if dialTCP(a) {
+			return &dialResponse{Status: StatusOK, Addr: a.String()}
+		}
+	}
+	if tried == 0 {
+		return &dialResponse{Status: StatusDialRefused, Text: "no address to dial back"}
+	}
+	return &dialResponse{Status: StatusDialError, Text: "dial back failed"}
+}
+
+// isTCPAddr reports whether a is a plain /ip4 or /ip6 TCP address.
+func isTCPAddr(a ma.Multiaddr) bool {
+	protos := a.Protocols()
+	return len(protos) == 2 &&
+		(protos[0].Code == ma.P_IP4 || protos[0].Code == ma.P_IP6) &&
+		protos[1].Code == ma.P_TCP
+}
+
+// ipOf returns the IP of a, or nil if a has none.
+func ipOf(a ma.Multiaddr) net.IP {
+	_, hostport, err := manet.DialArgs(a)
+	if err != nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func dialTCP(a ma.Multiaddr) bool {
+	network, hostport, err := manet.DialArgs(a)
+	if err != nil {
+		return false
+	}
+	c, err := net.DialTimeout(network, hostport, DialTimeout)
+	if err != nil {
+		return false
+	}
+	c.Close()
+	return true
+}
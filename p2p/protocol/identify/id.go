@@ -4,7 +4,10 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	guard "github.com/libp2p/go-libp2p/p2p/net/guard"
 	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
 
 	semver "github.com/coreos/go-semver/semver"
@@ -27,12 +30,23 @@ var log = logging.Logger("net/identify")
 // ID is the protocol.ID of the Identify Service.
 const ID = "/ipfs/id/1.0.0"
 
+// IDPush is the protocol.ID of identify push, with which a peer sends an
+// identify message on its own when its information changes.
+const IDPush = "/ipfs/id/push/1.0.0"
+
 // LibP2PVersion holds the current protocol version for a client running this code
 // TODO(jbenet): fix the versioning mess.
 const LibP2PVersion = "ipfs/0.1.0"
 
 var ClientVersion = "go-libp2p/3.3.4"
 
+// ProtocolVersionKey and AgentVersionKey are the peerstore metadata keys
+// under which the protocol and agent versions peers report are stored.
+const (
+	ProtocolVersionKey = "ProtocolVersion"
+	AgentVersionKey    = "AgentVersion"
+)
+
 // IDService is a structure that implements ProtocolIdentify.
 // It is a trivial service that gives the other peer some
 // useful information about the local peer. A sort of hello.
@@ -45,6 +59,38 @@ type IDService struct {
 	Host host.Host
 
 	Reporter metrics.Reporter
+
+	// MinThroughput is the read progress identify exchanges must make;
+	// slower ones are aborted. NewIDService sets it to guard.DefaultLimits.
+	MinThroughput guard.Limits
+
+	// ConnectedAddrTTL and RecentlyConnectedAddrTTL are the TTLs of the
+	// addresses peers report, while we're connected to them and once we
+	// aren't anymore. If 0, pstore.ConnectedAddrTTL and
+	// pstore.RecentlyConnectedAddrTTL are used. Set them before the
+	// service identifies any connection.
+	ConnectedAddrTTL         time.Duration
+	RecentlyConnectedAddrTTL time.Duration
+
+	// UserAgent is the agent version sent to peers, in identify and
+	// identify push messages. If empty, ClientVersion is used.
+	UserAgent string
+
+	// ProtocolVersion is the protocol version sent to peers. If empty,
+	// LibP2PVersion is used, and peers reporting an incompatible version
+	// of it are disconnected. A custom version is only checked with
+	// RequireProtocolVersionMatch, which disconnects the peers reporting
+	// any other version. Disconnections are reported to
+	// OnProtocolMismatch, and counted in ProtocolMismatches.
+	ProtocolVersion             string
+	RequireProtocolVersionMatch bool
+	OnProtocolMismatch          func(c inet.Conn, protocolVersion, agentVersion string)
+
+	mismatches uint64
+
+	// timeout bounds identify exchanges, if positive. See SetTimeout.
+	timeout int64
+
 	// connections undergoing identification
 	// for wait purposes
 	currid map[inet.Conn]chan struct{}
@@ -61,19 +107,47 @@ type IDService struct {
 // attaching its stream handler to the given host.Host.
 func NewIDService(h host.Host) *IDService {
 	s := &IDService{
-		Host:   h,
-		currid: make(map[inet.Conn]chan struct{}),
+		Host:          h,
+		MinThroughput: guard.DefaultLimits,
+		currid:        make(map[inet.Conn]chan struct{}),
 	}
 	h.SetStreamHandler(ID, s.RequestHandler)
+	h.SetStreamHandler(IDPush, s.pushHandler)
 	h.Network().Notify((*netNotifiee)(s))
 	return s
 }
 
+func (ids *IDService) connectedAddrTTL() time.Duration {
+	if ids.ConnectedAddrTTL > 0 {
+		return ids.ConnectedAddrTTL
+	}
+	return pstore.ConnectedAddrTTL
+}
+
+func (ids *IDService) recentlyConnectedAddrTTL() time.Duration {
+	if ids.RecentlyConnectedAddrTTL > 0 {
+		return ids.RecentlyConnectedAddrTTL
+	}
+	return pstore.RecentlyConnectedAddrTTL
+}
+
+// SetObservedAddrTTL sets how long the addresses peers report observing us
+// at are remembered, pstore.OwnObservedAddrTTL by default.
+func (ids *IDService) SetObservedAddrTTL(ttl time.Duration) {
+	ids.observedAddrs.SetTTL(ttl)
+}
+
 // OwnObservedAddrs returns the addresses peers have reported we've dialed from
 func (ids *IDService) OwnObservedAddrs() []ma.Multiaddr {
 	return ids.observedAddrs.Addrs()
 }
 
+// OwnObservedAddrLastSeen returns when a peer last reported observing us at
+// addr, or the zero time if none did recently.
+func (ids *IDService) OwnObservedAddrLastSeen(addr ma.Multiaddr) time.Time {
+	return ids.observedAddrs.LastSeen(addr)
+}
+
 func (ids *IDService) IdentifyConn(c inet.Conn) {
 	ids.currmu.Lock()
 	if wait, found := ids.currid[c]; found {
@@ -96,6 +170,7 @@ func (ids *IDService) IdentifyConn(c inet.Conn) {
 		return
 	}
 	defer s.Close()
+	ids.setDeadline(s)
 
 	s.SetProtocol(ID)
 
@@ -103,13 +178,21 @@ func (ids *IDService) IdentifyConn(c inet.Conn) {
 		s = mstream.WrapStream(s, ids.Reporter)
 	}
 
+	gs := guard.Watch(s, ids.MinThroughput, func() {
+		log.Warningf("identify exchange with %s too slow, aborting", c.RemotePeer())
+	})
+	defer gs.Done()
+
 	// ok give the response to our handler.
-	if err := msmux.SelectProtoOrFail(ID, s); err != nil {
+	before := time.Now()
+	if err := msmux.SelectProtoOrFail(ID, gs); err != nil {
 		log.Event(context.TODO(), "IdentifyOpenFailed", c.RemotePeer(), logging.Metadata{"error": err})
 		return
 	}
+	// selecting the protocol takes a round trip.
+	ids.Host.Peerstore().RecordLatency(c.RemotePeer(), time.Since(before))
 
-	ids.ResponseHandler(s)
+	ids.ResponseHandler(gs)
 
 	ids.currmu.Lock()
 	_, found := ids.currid[c]
@@ -125,6 +208,7 @@ func (ids *IDService) IdentifyConn(c inet.Conn) {
 func (ids *IDService) RequestHandler(s inet.Stream) {
 	defer s.Close()
 	c := s.Conn()
+	ids.setDeadline(s)
 
 	if ids.Reporter != nil {
 		s = mstream.WrapStream(s, ids.Reporter)
@@ -155,6 +239,61 @@ func (ids *IDService) ResponseHandler(s inet.Stream) {
 		c.RemotePeer(), c.RemoteMultiaddr())
 }
 
+// Push sends our current information, such as our addresses and
+// protocols, to all the peers we are connected to that support identify
+// push. Peers identify told us don't support it are skipped.
+func (ids *IDService) Push() {
+	for _, p := range ids.Host.Network().Peers() {
+		if !ids.supportsPush(p) {
+			continue
+		}
+		go func(p peer.ID) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			s, err := ids.Host.NewStream(ctx, p, IDPush)
+			if err != nil {
+				log.Debugf("error opening push stream to %s: %s", p, err)
+				return
+			}
+			ids.RequestHandler(s)
+		}(p)
+	}
+}
+
+// supportsPush reports whether p may support identify push: it does, or
+// we don't know its protocols yet.
+func (ids *IDService) supportsPush(p peer.ID) bool {
+	ps := ids.Host.Peerstore()
+	if protos, err := ps.GetProtocols(p); err != nil || len(protos) == 0 {
+		return true
+	}
+	supported, err := ps.SupportsProtocols(p, IDPush)
+	return err != nil || len(supported) > 0
+}
+
+// pushHandler consumes a pushed identify message. The addresses it lists
+// replace the ones identify learned before; the ones added otherwise, such
+// as permanent ones, are kept.
+func (ids *IDService) pushHandler(s inet.Stream) {
+	defer s.Close()
+	c := s.Conn()
+	ids.setDeadline(s)
+
+	r := ggio.NewDelimitedReader(s, 2048)
+	mes := pb.Identify{}
+	if err := r.ReadMsg(&mes); err != nil {
+		log.Warning("error reading identify push message: ", err)
+		return
+	}
+	// expire the addresses identify added, which the message replaces,
+	// leaving the ones added by other means alone.
+	ids.Host.Peerstore().UpdateAddrs(c.RemotePeer(), ids.connectedAddrTTL(), pstore.TempAddrTTL)
+	ids.consumeMessage(&mes, c)
+
+	log.Debugf("%s received push from %s %s", IDPush,
+		c.RemotePeer(), c.RemoteMultiaddr())
+}
+
 func (ids *IDService) populateMessage(mes *pb.Identify, c inet.Conn) {
 
 	// set protocols this node is currently handling
@@ -189,8 +328,11 @@ func (ids *IDService) populateMessage(mes *pb.Identify, c inet.Conn) {
 	}
 
 	// set protocol versions
-	pv := LibP2PVersion
+	pv := ids.protocolVersion()
 	av := ClientVersion
+	if ids.UserAgent != "" {
+		av = ids.UserAgent
+	}
 	mes.ProtocolVersion = &pv
 	mes.AgentVersion = &av
 }
@@ -228,9 +370,9 @@ func (ids *IDService) consumeMessage(mes *pb.Identify, c inet.Conn) {
 	ids.addrMu.Lock()
 	switch ids.Host.Network().Connectedness(p) {
 	case inet.Connected:
-		ids.Host.Peerstore().AddAddrs(p, lmaddrs, pstore.ConnectedAddrTTL)
+		ids.Host.Peerstore().AddAddrs(p, lmaddrs, ids.connectedAddrTTL())
 	default:
-		ids.Host.Peerstore().AddAddrs(p, lmaddrs, pstore.RecentlyConnectedAddrTTL)
+		ids.Host.Peerstore().AddAddrs(p, lmaddrs, ids.recentlyConnectedAddrTTL())
 	}
 	ids.addrMu.Unlock()
 
@@ -240,18 +382,22 @@ func (ids *IDService) consumeMessage(mes *pb.Identify, c inet.Conn) {
 	pv := mes.GetProtocolVersion()
 	av := mes.GetAgentVersion()
 
+	ids.Host.Peerstore().Put(p, ProtocolVersionKey, pv)
+	ids.Host.Peerstore().Put(p, AgentVersionKey, av)
+
 	// version check. if we shouldn't talk, bail.
 	// TODO: at this point, we've already exchanged information.
 	// move this into a first handshake before the connection can open streams.
-	if !protocolVersionsAreCompatible(pv, LibP2PVersion) {
+	if !ids.protocolVersionAccepted(pv) {
 		logProtocolMismatchDisconnect(c, pv, av)
+		atomic.AddUint64(&ids.mismatches, 1)
+		if ids.OnProtocolMismatch != nil {
+			ids.OnProtocolMismatch(c, pv, av)
+		}
 		c.Close()
 		return
 	}
 
-	ids.Host.Peerstore().Put(p, "ProtocolVersion", pv)
-	ids.Host.Peerstore().Put(p, "AgentVersion", av)
-
 	// get the key from the other side. we may not have it (no-auth transport)
 	ids.consumeReceivedPubKey(c, mes.PublicKey)
 }
@@ -408,7 +554,7 @@ func (ids *IDService) consumeObservedAddress(observed []byte, c inet.Conn) {
 
 	// ok! we have the observed version of one of our ListenAddresses!
 	log.Debugf("added own observed listen addr: %s --> %s", c.LocalMultiaddr(), maddr)
-	ids.observedAddrs.Add(maddr, c.RemoteMultiaddr())
+	ids.observedAddrs.AddFrom(maddr, c.LocalMultiaddr(), c.RemoteMultiaddr())
 }
 
 func addrInAddrs(a ma.Multiaddr, as []ma.Multiaddr) bool {
@@ -445,6 +591,50 @@ func protocolVersionsAreCompatible(v1, v2 string) bool {
 	return v1s.Major == v2s.Major && v1s.Minor == v2s.Minor
 }
 
+// protocolVersion returns the protocol version we report to peers.
+func (ids *IDService) protocolVersion() string {
+	if ids.ProtocolVersion != "" {
+		return ids.ProtocolVersion
+	}
+	return LibP2PVersion
+}
+
+// protocolVersionAccepted reports whether we talk to peers reporting the
+// protocol version pv.
+func (ids *IDService) protocolVersionAccepted(pv string) bool {
+	switch {
+	case ids.RequireProtocolVersionMatch:
+		return pv == ids.protocolVersion()
+	case ids.ProtocolVersion != "":
+		return true
+	default:
+		return protocolVersionsAreCompatible(pv, LibP2PVersion)
+	}
+}
+
+// SetTimeout bounds the identify exchanges started from now on, including
+// the ones answering requests and pushes, to d. A zero or negative d
+// disables the timeout, which is the default.
+func (ids *IDService) SetTimeout(d time.Duration) {
+	atomic.StoreInt64(&ids.timeout, int64(d))
+}
+
+func (ids *IDService) setDeadline(s inet.Stream) {
+	d := time.Duration(atomic.LoadInt64(&ids.timeout))
+	if d <= 0 {
+		return
+	}
+	if err := s.SetDeadline(time.Now().Add(d)); err != nil {
+		log.Debugf("error setting identify stream deadline: %s", err)
+	}
+}
+
+// ProtocolMismatches returns the number of peers disconnected for
+// reporting a protocol version we don't talk to.
+func (ids *IDService) ProtocolMismatches() uint64 {
+	return atomic.LoadUint64(&ids.mismatches)
+}
+
 // netNotifiee defines methods to be used with the IpfsDHT
 type netNotifiee IDService
 
@@ -466,7 +656,7 @@ func (nn *netNotifiee) Disconnected(n inet.Network, v inet.Conn) {
 	if ids.Host.Network().Connectedness(v.RemotePeer()) != inet.Connected {
 		// Last disconnect.
 		ps := ids.Host.Peerstore()
-		ps.UpdateAddrs(v.RemotePeer(), pstore.ConnectedAddrTTL, pstore.RecentlyConnectedAddrTTL)
+		ps.UpdateAddrs(v.RemotePeer(), ids.connectedAddrTTL(), ids.recentlyConnectedAddrTTL())
 	}
 }
 
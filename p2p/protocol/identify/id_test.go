@@ -119,7 +119,7 @@ func testKnowsAddrs(t *testing.T, h host.Host, p peer.ID, expected []ma.Multiadd
 }
 
 func testHasProtocolVersions(t *testing.T, h host.Host, p peer.ID) {
-	v, err := h.Peerstore().Get(p, "ProtocolVersion")
+	v, err := h.Peerstore().Get(p, identify.ProtocolVersionKey)
 	if v == nil {
 		t.Error("no protocol version")
 		return
@@ -127,7 +127,7 @@ func testHasProtocolVersions(t *testing.T, h host.Host, p peer.ID) {
 	if v.(string) != identify.LibP2PVersion {
 		t.Error("protocol mismatch", err)
 	}
-	v, err = h.Peerstore().Get(p, "AgentVersion")
+	v, err = h.Peerstore().Get(p, identify.AgentVersionKey)
 	if v.(string) != identify.ClientVersion {
 		t.Error("agent version mismatch", err)
 	}
@@ -182,3 +182,43 @@ func TestProtoMatching(t *testing.T) {
 		t.Fatal("expected mismatch")
 	}
 }
+
+func TestIDPush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h1 := blhost.NewBlankHost(testutil.GenSwarmNetwork(t, ctx))
+	h2 := blhost.NewBlankHost(testutil.GenSwarmNetwork(t, ctx))
+	ids1 := identify.NewIDService(h1)
+	identify.NewIDService(h2)
+
+	if err := h1.Connect(ctx, h2.Peerstore().PeerInfo(h2.ID())); err != nil {
+		t.Fatal(err)
+	}
+	ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+
+	if err := h1.Network().Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0")); err != nil {
+		t.Fatal(err)
+	}
+	ids1.Push()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		known := h2.Peerstore().Addrs(h1.ID())
+		missing := false
+		for _, a := range h1.Addrs() {
+			found := false
+			for _, b := range known {
+				found = found || a.Equal(b)
+			}
+			missing = missing || !found
+		}
+		if !missing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pushed addresses not received: %s, knows %s", h1.Addrs(), known)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
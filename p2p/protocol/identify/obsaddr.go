@@ -16,7 +16,11 @@ const ActivationThresh = 4
 // - have been observed at least once recently (1h), because our position in the
 //   network, or network port mapppings, may have changed.
 type ObservedAddr struct {
-	Addr      ma.Multiaddr
+	Addr ma.Multiaddr
+
+	// Local is the listen address the observed address maps to, if known.
+	Local ma.Multiaddr
+
 	SeenBy    map[string]time.Time
 	LastSeen  time.Time
 	Activated bool
@@ -54,7 +58,28 @@ func (oas *ObservedAddrSet) Addrs() []ma.Multiaddr {
 		return nil
 	}
 
+	return oas.activeAddrs(nil)
+}
+
+// AddrsFor returns the activated addresses observed for the given local
+// listen address.
+func (oas *ObservedAddrSet) AddrsFor(local ma.Multiaddr) []ma.Multiaddr {
+	oas.Lock()
+	defer oas.Unlock()
+
+	// for zero-value.
+	if oas.addrs == nil {
+		return nil
+	}
+
+	return oas.activeAddrs(local)
+}
+
+// activeAddrs returns the activated addresses, observed for local if it's
+// not nil, expiring stale ones along the way.
+func (oas *ObservedAddrSet) activeAddrs(local ma.Multiaddr) []ma.Multiaddr {
 	now := time.Now()
+	seen := make(map[string]bool, len(oas.addrs))
 	addrs := make([]ma.Multiaddr, 0, len(oas.addrs))
 	for s, a := range oas.addrs {
 		// remove timed out addresses.
@@ -63,30 +88,72 @@ func (oas *ObservedAddrSet) Addrs() []ma.Multiaddr {
 			continue
 		}
 
+		if local != nil && (a.Local == nil || !a.Local.Equal(local)) {
+			continue
+		}
+
+		// the same address may have been observed for several listen
+		// addresses.
+		if seen[a.Addr.String()] {
+			continue
+		}
+
 		if a.Activated || a.TryActivate(oas.ttl) {
+			seen[a.Addr.String()] = true
 			addrs = append(addrs, a.Addr)
 		}
 	}
 	return addrs
 }
 
+// LastSeen returns when addr was last observed, for any local address, or
+// the zero time if it wasn't observed within the TTL.
+func (oas *ObservedAddrSet) LastSeen(addr ma.Multiaddr) time.Time {
+	oas.Lock()
+	defer oas.Unlock()
+
+	var last time.Time
+	for _, a := range oas.addrs {
+		if a.Addr.Equal(addr) && a.LastSeen.After(last) {
+			last = a.LastSeen
+		}
+	}
+	if time.Since(last) > oas.ttl {
+		return time.Time{}
+	}
+	return last
+}
+
 func (oas *ObservedAddrSet) Add(addr ma.Multiaddr, observer ma.Multiaddr) {
+	oas.AddFrom(addr, nil, observer)
+}
+
+// AddFrom records that observer saw us at addr, on a connection to our
+// local listen address local. Observations are tracked separately per local
+// address.
+func (oas *ObservedAddrSet) AddFrom(addr ma.Multiaddr, local ma.Multiaddr, observer ma.Multiaddr) {
 	oas.Lock()
 	defer oas.Unlock()
 
 	// for zero-value.
 	if oas.addrs == nil {
 		oas.addrs = make(map[string]*ObservedAddr)
-		oas.ttl = pstore.OwnObservedAddrTTL
+		if oas.ttl == 0 {
+			oas.ttl = pstore.OwnObservedAddrTTL
+		}
 	}
 
 	s := addr.String()
+	if local != nil {
+		s = local.String() + " " + s
+	}
 	oa, found := oas.addrs[s]
 
 	// first time seeing address.
 	if !found {
 		oa = &ObservedAddr{
 			Addr:   addr,
+			Local:  local,
 			SeenBy: make(map[string]time.Time),
 		}
 		oas.addrs[s] = oa
@@ -122,7 +189,7 @@ func (oas *ObservedAddrSet) TTL() time.Duration {
 	oas.Lock()
 	defer oas.Unlock()
 	// for zero-value.
-	if oas.addrs == nil {
+	if oas.ttl == 0 {
 		oas.ttl = pstore.OwnObservedAddrTTL
 	}
 	return oas.ttl
@@ -109,3 +109,64 @@ func TestObsAddrSet(t *testing.T) {
 		t.Error("addrs should have timed out")
 	}
 }
+
+func TestObsAddrSetPerLocal(t *testing.T) {
+	m := func(s string) ma.Multiaddr {
+		m, err := ma.NewMultiaddr(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	ext := m("/ip4/1.2.3.4/tcp/4001")
+	l1 := m("/ip4/10.0.0.1/tcp/4001")
+	l2 := m("/ip4/10.0.0.2/tcp/4001")
+
+	observers := []ma.Multiaddr{
+		m("/ip4/1.2.3.6/tcp/1236"),
+		m("/ip4/1.2.3.7/tcp/1237"),
+		m("/ip4/1.2.3.8/tcp/1237"),
+		m("/ip4/1.2.3.9/tcp/1237"),
+	}
+
+	oas := ObservedAddrSet{}
+
+	// observations for different listen addrs don't add up.
+	for i, o := range observers {
+		local := l1
+		if i%2 == 1 {
+			local = l2
+		}
+		oas.AddFrom(ext, local, o)
+	}
+	if len(oas.Addrs()) != 0 {
+		t.Fatal("addr should not be activated by split observations")
+	}
+
+	for _, o := range observers {
+		oas.AddFrom(ext, l1, o)
+	}
+	if addrs := oas.AddrsFor(l1); len(addrs) != 1 || !addrs[0].Equal(ext) {
+		t.Fatalf("expected %s for %s, got %s", ext, l1, addrs)
+	}
+	if addrs := oas.AddrsFor(l2); len(addrs) != 0 {
+		t.Fatalf("expected nothing for %s, got %s", l2, addrs)
+	}
+
+	for _, o := range observers {
+		oas.AddFrom(ext, l2, o)
+	}
+	if addrs := oas.Addrs(); len(addrs) != 1 {
+		t.Fatalf("expected a single address, got %s", addrs)
+	}
+}
+
+func TestObsAddrSetTTL(t *testing.T) {
+	oas := ObservedAddrSet{}
+	oas.SetTTL(time.Minute)
+	oas.AddFrom(ma.StringCast("/ip4/1.2.3.4/tcp/4001"), ma.StringCast("/ip4/10.0.0.1/tcp/4001"), ma.StringCast("/ip4/1.2.3.6/tcp/1236"))
+	if ttl := oas.TTL(); ttl != time.Minute {
+		t.Fatalf("expected the TTL set before the first observation to be kept, got %s", ttl)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"sync"
 	"time"
 
 	u "github.com/ipfs/go-ipfs-util"
@@ -22,17 +23,39 @@ const ID = "/ipfs/ping/1.0.0"
 
 const pingTimeout = time.Second * 60
 
+// MaxInboundPerPeer bounds the ping streams PingHandler serves at once for
+// each peer. Streams beyond it are reset.
+var MaxInboundPerPeer = 2
+
+// Result is the outcome of a ping: its round trip time, or the error that
+// ended the pings.
+type Result struct {
+	RTT   time.Duration
+	Error error
+}
+
 type PingService struct {
 	Host host.Host
+
+	mu      sync.Mutex
+	inbound map[peer.ID]int
 }
 
 func NewPingService(h host.Host) *PingService {
-	ps := &PingService{h}
+	ps := &PingService{Host: h}
 	h.SetStreamHandler(ID, ps.PingHandler)
 	return ps
 }
 
 func (p *PingService) PingHandler(s inet.Stream) {
+	remote := s.Conn().RemotePeer()
+	if !p.acquire(remote) {
+		log.Debugf("too many pings from %s", remote.Pretty())
+		s.Reset()
+		return
+	}
+	defer p.release(remote)
+
 	buf := make([]byte, PingSize)
 
 	errCh := make(chan error, 1)
@@ -76,39 +99,65 @@ func (p *PingService) PingHandler(s inet.Stream) {
 	}
 }
 
-func (ps *PingService) Ping(ctx context.Context, p peer.ID) (<-chan time.Duration, error) {
-	s, err := ps.Host.NewStream(ctx, p, ID)
-	if err != nil {
-		return nil, err
+func (p *PingService) acquire(remote peer.ID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inbound == nil {
+		p.inbound = make(map[peer.ID]int)
+	}
+	if p.inbound[remote] >= MaxInboundPerPeer {
+		return false
+	}
+	p.inbound[remote]++
+	return true
+}
+
+func (p *PingService) release(remote peer.ID) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inbound[remote]--; p.inbound[remote] <= 0 {
+		delete(p.inbound, remote)
 	}
+}
 
-	out := make(chan time.Duration)
+// Ping pings p until ctx is done, sending the result of each ping on the
+// returned channel, and records the round trip times in the peerstore. A
+// failure is sent as the last result, before the channel is closed.
+func (ps *PingService) Ping(ctx context.Context, p peer.ID) <-chan Result {
+	out := make(chan Result)
 	go func() {
 		defer close(out)
+		s, err := ps.Host.NewStream(ctx, p, ID)
+		if err != nil {
+			send(ctx, out, Result{Error: err})
+			return
+		}
 		defer s.Close()
-		for {
-			select {
-			case <-ctx.Done():
+		for ctx.Err() == nil {
+			t, err := ping(s)
+			if err != nil {
+				s.Reset()
+				log.Debugf("ping error: %s", err)
+				send(ctx, out, Result{Error: err})
 				return
-			default:
-				t, err := ping(s)
-				if err != nil {
-					s.Reset()
-					log.Debugf("ping error: %s", err)
-					return
-				}
+			}
 
-				ps.Host.Peerstore().RecordLatency(p, t)
-				select {
-				case out <- t:
-				case <-ctx.Done():
-					return
-				}
+			ps.Host.Peerstore().RecordLatency(p, t)
+			if !send(ctx, out, Result{RTT: t}) {
+				return
 			}
 		}
 	}()
+	return out
+}
 
-	return out, nil
+func send(ctx context.Context, out chan<- Result, r Result) bool {
+	select {
+	case out <- r:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func ping(s inet.Stream) (time.Duration, error) {
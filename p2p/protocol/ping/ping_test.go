@@ -1,4 +1,4 @@
-package ping
+package ping_test
 
 import (
 	"context"
@@ -9,6 +9,7 @@ import (
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ping "github.com/libp2p/go-libp2p/p2p/protocol/ping"
 )
 
 func TestPing(t *testing.T) {
@@ -26,28 +27,79 @@ func TestPing(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	ps1 := NewPingService(h1)
-	ps2 := NewPingService(h2)
+	ps1 := ping.NewPingService(h1)
+	ps2 := ping.NewPingService(h2)
 
 	testPing(t, ps1, h2.ID())
 	testPing(t, ps2, h1.ID())
+
+	if h1.Peerstore().LatencyEWMA(h2.ID()) == 0 {
+		t.Fatal("expected the pings to be recorded in the peerstore")
+	}
 }
 
-func testPing(t *testing.T, ps *PingService, p peer.ID) {
+func testPing(t *testing.T, ps *ping.PingService, p peer.ID) {
 	pctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	ts, err := ps.Ping(pctx, p)
-	if err != nil {
-		t.Fatal(err)
-	}
+	ts := ps.Ping(pctx, p)
 
 	for i := 0; i < 5; i++ {
 		select {
-		case took := <-ts:
-			t.Log("ping took: ", took)
+		case res := <-ts:
+			if res.Error != nil {
+				t.Fatal(res.Error)
+			}
+			t.Log("ping took: ", res.RTT)
 		case <-time.After(time.Second * 4):
 			t.Fatal("failed to receive ping")
 		}
 	}
 
 }
+
+func TestPingInboundLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h1 := bhost.New(netutil.GenSwarmNetwork(t, ctx))
+	h2 := bhost.New(netutil.GenSwarmNetwork(t, ctx))
+
+	err := h1.Connect(ctx, pstore.PeerInfo{
+		ID:    h2.ID(),
+		Addrs: h2.Addrs(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps1 := ping.NewPingService(h1)
+	ping.NewPingService(h2)
+
+	for i := 0; i < ping.MaxInboundPerPeer; i++ {
+		pctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		ts := ps1.Ping(pctx, h2.ID())
+		if res := <-ts; res.Error != nil {
+			t.Fatal(res.Error)
+		}
+	}
+
+	res, ok := <-ps1.Ping(ctx, h2.ID())
+	if !ok || res.Error == nil {
+		t.Fatal("expected a ping beyond the limit to fail")
+	}
+}
+
+func TestPingUnreachable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h1 := bhost.New(netutil.GenSwarmNetwork(t, ctx))
+	h2 := bhost.New(netutil.GenSwarmNetwork(t, ctx))
+	ps := ping.NewPingService(h1)
+
+	pctx, pcancel := context.WithTimeout(ctx, time.Second)
+	defer pcancel()
+	res, ok := <-ps.Ping(pctx, h2.ID())
+	if !ok || res.Error == nil {
+		t.Fatal("expected pinging a peer with no addresses to fail")
+	}
+}
@@ -0,0 +1,5 @@
+// Package transcripts checks our negotiation wire behavior against recorded
+// golden transcripts: stream protocol negotiation, and the upgrade of
+// plaintext connections, from security protocol to stream muxer. Run the
+// tests with -update to regenerate them.
+package transcripts
@@ -0,0 +1,241 @@
+package transcripts
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	msmux "github.com/multiformats/go-multistream"
+)
+
+var update = flag.Bool("update", false, "regenerate the golden transcripts")
+
+// A negotiation is run between a client and a server, each negotiating
+// over its end of a connection.
+type negotiation struct {
+	name   string
+	client func(rwc io.ReadWriteCloser) error
+	server func(rwc io.ReadWriteCloser) error
+}
+
+func selectProto(proto string) func(io.ReadWriteCloser) error {
+	return func(rwc io.ReadWriteCloser) error {
+		return msmux.SelectProtoOrFail(proto, rwc)
+	}
+}
+
+func selectOneOf(protos ...string) func(io.ReadWriteCloser) error {
+	return func(rwc io.ReadWriteCloser) error {
+		_, err := msmux.SelectOneOf(protos, rwc)
+		return err
+	}
+}
+
+func serve(protos ...string) func(io.ReadWriteCloser) error {
+	return func(rwc io.ReadWriteCloser) error {
+		_, _, err := newServer(protos).Negotiate(rwc)
+		return err
+	}
+}
+
+// The security protocols go-libp2p-conn negotiates, and the default muxers
+// set up by libp2p.New.
+const (
+	secioTag     = "/secio/1.0.0"
+	plaintextTag = "/plaintext/1.0.0"
+)
+
+var defaultMuxers = []string{"/yamux/1.0.0", "/mplex/6.3.0"}
+
+// upgradeClient and upgradeServer run the upgrade of a plaintext connection
+// as the swarm does: the dialer selects the security protocol among those
+// the listener offers, and then, over the secured connection, one of its
+// stream muxers in order of preference.
+func upgradeClient(muxers ...string) func(io.ReadWriteCloser) error {
+	return func(rwc io.ReadWriteCloser) error {
+		if err := msmux.SelectProtoOrFail(plaintextTag, rwc); err != nil {
+			return err
+		}
+		_, err := msmux.SelectOneOf(muxers, rwc)
+		return err
+	}
+}
+
+func upgradeServer(rwc io.ReadWriteCloser) error {
+	if err := serve(secioTag, plaintextTag)(rwc); err != nil {
+		return err
+	}
+	return serve(defaultMuxers...)(rwc)
+}
+
+var negotiations = []negotiation{
+	{"protocol", selectProto("/ipfs/id/1.0.0"), serve("/ipfs/id/1.0.0")},
+	{"protocol-fallback", selectOneOf("/unknown/1.0.0", "/test/1.0.0"), serve("/test/1.0.0")},
+	{"plaintext-yamux", upgradeClient(defaultMuxers...), upgradeServer},
+	{"plaintext-mplex", upgradeClient("/mplex/6.3.0"), upgradeServer},
+}
+
+// transcript holds the bytes sent in each direction.
+type transcript struct {
+	client []byte
+	server []byte
+}
+
+func newServer(protos []string) *msmux.MultistreamMuxer {
+	mux := msmux.NewMultistreamMuxer()
+	for _, p := range protos {
+		mux.AddHandler(p, nil)
+	}
+	return mux
+}
+
+// recorder records everything written to a conn.
+type recorder struct {
+	net.Conn
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	r.buf.Write(b)
+	r.mu.Unlock()
+	return r.Conn.Write(b)
+}
+
+func (r *recorder) bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]byte(nil), r.buf.Bytes()...)
+}
+
+// record runs n over a real connection.
+func record(t *testing.T, n negotiation) transcript {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	serverDone := make(chan error, 1)
+	var server *recorder
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			serverDone <- err
+			return
+		}
+		server = &recorder{Conn: c}
+		serverDone <- n.server(server)
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	client := &recorder{Conn: c}
+	if err := n.client(client); err != nil {
+		t.Fatalf("%s: client: %s", n.name, err)
+	}
+	if err := <-serverDone; err != nil {
+		t.Fatalf("%s: server: %s", n.name, err)
+	}
+	server.Close()
+
+	return transcript{client: client.bytes(), server: server.bytes()}
+}
+
+// replayConn feeds recorded bytes to one side of a negotiation, and records
+// what it writes.
+type replayConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *replayConn) Read(b []byte) (int, error)  { return c.Reader.Read(b) }
+func (c *replayConn) Write(b []byte) (int, error) { return c.Buffer.Write(b) }
+func (c *replayConn) Close() error                { return nil }
+
+func goldenPath(name string) string {
+	return filepath.Join("testdata", name+".golden")
+}
+
+func writeGolden(name string, tr transcript) error {
+	data := fmt.Sprintf("client: %s\nserver: %s\n", strconv.Quote(string(tr.client)), strconv.Quote(string(tr.server)))
+	return ioutil.WriteFile(goldenPath(name), []byte(data), 0644)
+}
+
+func readGolden(name string) (transcript, error) {
+	var tr transcript
+	data, err := ioutil.ReadFile(goldenPath(name))
+	if err != nil {
+		return tr, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ": ", 2)
+		if len(parts) != 2 {
+			return tr, fmt.Errorf("malformed golden line %q", s.Text())
+		}
+		val, err := strconv.Unquote(parts[1])
+		if err != nil {
+			return tr, fmt.Errorf("malformed golden line %q: %s", s.Text(), err)
+		}
+		switch parts[0] {
+		case "client":
+			tr.client = []byte(val)
+		case "server":
+			tr.server = []byte(val)
+		default:
+			return tr, fmt.Errorf("unknown golden direction %q", parts[0])
+		}
+	}
+	return tr, s.Err()
+}
+
+func TestTranscripts(t *testing.T) {
+	for _, n := range negotiations {
+		recorded := record(t, n)
+		if *update {
+			if err := writeGolden(n.name, recorded); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		golden, err := readGolden(n.name)
+		if err != nil {
+			t.Fatalf("%s: %s (run with -update to regenerate)", n.name, err)
+		}
+		if !bytes.Equal(recorded.client, golden.client) || !bytes.Equal(recorded.server, golden.server) {
+			t.Errorf("%s: wire behavior changed:\nclient %q, expected %q\nserver %q, expected %q",
+				n.name, recorded.client, golden.client, recorded.server, golden.server)
+		}
+
+		// the client answers the recorded server as it did.
+		c := &replayConn{Reader: bytes.NewReader(golden.server)}
+		if err := n.client(c); err != nil {
+			t.Errorf("%s: client replay: %s", n.name, err)
+		} else if !bytes.Equal(c.Buffer.Bytes(), golden.client) {
+			t.Errorf("%s: client replay sent %q, expected %q", n.name, c.Buffer.Bytes(), golden.client)
+		}
+
+		// and so does the server.
+		s := &replayConn{Reader: bytes.NewReader(golden.client)}
+		if err := n.server(s); err != nil {
+			t.Errorf("%s: server replay: %s", n.name, err)
+		} else if !bytes.Equal(s.Buffer.Bytes(), golden.server) {
+			t.Errorf("%s: server replay sent %q, expected %q", n.name, s.Buffer.Bytes(), golden.server)
+		}
+	}
+}
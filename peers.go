@@ -0,0 +1,115 @@
+package libp2p
+
+import (
+	"fmt"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ParsePeerAddrs parses a list of peer multiaddrs ending in a /p2p (or
+// /ipfs) component, such as "/ip4/1.2.3.4/tcp/4001/p2p/QmPeer", into
+// PeerInfos, merging the addresses of entries for the same peer.
+//
+// It is used by options that take lists of peers, and rejects lists that
+// are inconsistent: an address that doesn't name a valid peer ID, or two
+// entries naming different peers at the same transport address.
+func ParsePeerAddrs(addrs ...string) ([]pstore.PeerInfo, error) {
+	infos := make([]pstore.PeerInfo, 0, len(addrs))
+	for i, s := range addrs {
+		maddr, err := ma.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address #%d %q: %s", i, s, err)
+		}
+		if _, err := maddr.ValueForProtocol(ma.P_IPFS); err != nil {
+			return nil, fmt.Errorf("invalid peer address #%d %q: missing /p2p component", i, s)
+		}
+		pi, err := pstore.InfoFromP2pAddr(maddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address #%d %q: %s", i, s, err)
+		}
+		infos = append(infos, *pi)
+	}
+
+	if err := checkPeerInfos(infos, addrs); err != nil {
+		return nil, err
+	}
+	return mergePeerInfos(infos), nil
+}
+
+// checkPeerInfos verifies that peer IDs are valid, that addresses carrying
+// their own /p2p component agree with the peer ID they are listed under,
+// and that no transport address is claimed by two different peers.
+//
+// If origs is given, it holds the original string each PeerInfo was parsed
+// from, and is used to quote conflicting entries in errors.
+func checkPeerInfos(infos []pstore.PeerInfo, origs []string) error {
+	type owner struct {
+		id   peer.ID
+		orig string
+	}
+	owners := make(map[string]owner)
+
+	for i, pi := range infos {
+		if _, err := mh.Cast([]byte(pi.ID)); err != nil {
+			return fmt.Errorf("invalid peer ID %q: %s", pi.ID, err)
+		}
+
+		for _, addr := range pi.Addrs {
+			orig := addr.String()
+			if origs != nil {
+				orig = origs[i]
+			}
+
+			if _, err := addr.ValueForProtocol(ma.P_IPFS); err == nil {
+				embedded, err := pstore.InfoFromP2pAddr(addr)
+				if err != nil {
+					return fmt.Errorf("invalid peer address %q: %s", orig, err)
+				}
+				if embedded.ID != pi.ID {
+					return fmt.Errorf("peer address %q does not match peer ID %s", orig, pi.ID.Pretty())
+				}
+				if len(embedded.Addrs) == 0 {
+					continue
+				}
+				addr = embedded.Addrs[0]
+			} else if origs == nil {
+				orig += "/ipfs/" + pi.ID.Pretty()
+			}
+
+			key := addr.String()
+			if prev, ok := owners[key]; ok && prev.id != pi.ID {
+				return fmt.Errorf("conflicting peer IDs for the same address: %q and %q", prev.orig, orig)
+			}
+			owners[key] = owner{id: pi.ID, orig: orig}
+		}
+	}
+	return nil
+}
+
+// mergePeerInfos combines PeerInfos for the same peer, preserving the order
+// in which peers first appear.
+func mergePeerInfos(infos []pstore.PeerInfo) []pstore.PeerInfo {
+	var out []pstore.PeerInfo
+	index := make(map[peer.ID]int)
+	for _, pi := range infos {
+		i, ok := index[pi.ID]
+		if !ok {
+			index[pi.ID] = len(out)
+			out = append(out, pstore.PeerInfo{ID: pi.ID})
+			i = len(out) - 1
+		}
+	addrs:
+		for _, a := range pi.Addrs {
+			for _, b := range out[i].Addrs {
+				if a.Equal(b) {
+					continue addrs
+				}
+			}
+			out[i].Addrs = append(out[i].Addrs, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package libp2p
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testPeerA = "QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+	testPeerB = "QmSoLPppuBtQSGwKDZT2M73ULpjvfd3aZ6ha4oFGL1KrGM"
+)
+
+func TestParsePeerAddrs(t *testing.T) {
+	infos, err := ParsePeerAddrs(
+		"/ip4/1.2.3.4/tcp/4001/ipfs/"+testPeerA,
+		"/ip4/1.2.3.4/tcp/4001/ipfs/"+testPeerA, // duplicate, fine
+		"/ip6/::1/tcp/4001/ipfs/"+testPeerA,
+		"/ip4/5.6.7.8/tcp/4001/ipfs/"+testPeerB,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 peers, got %d", len(infos))
+	}
+	if infos[0].ID.Pretty() != testPeerA || len(infos[0].Addrs) != 2 {
+		t.Fatalf("unexpected first peer: %s %s", infos[0].ID.Pretty(), infos[0].Addrs)
+	}
+	if infos[1].ID.Pretty() != testPeerB || len(infos[1].Addrs) != 1 {
+		t.Fatalf("unexpected second peer: %s %s", infos[1].ID.Pretty(), infos[1].Addrs)
+	}
+}
+
+func TestParsePeerAddrsConflict(t *testing.T) {
+	a := "/ip4/1.2.3.4/tcp/4001/ipfs/" + testPeerA
+	b := "/ip4/1.2.3.4/tcp/4001/ipfs/" + testPeerB
+
+	_, err := ParsePeerAddrs(a, b)
+	if err == nil {
+		t.Fatal("expected conflicting peer IDs for the same address to be rejected")
+	}
+	if !strings.Contains(err.Error(), a) || !strings.Contains(err.Error(), b) {
+		t.Fatalf("expected error to quote both entries, got: %s", err)
+	}
+}
+
+func TestParsePeerAddrsMalformedID(t *testing.T) {
+	bad := "/ip4/1.2.3.4/tcp/4001/ipfs/QmNotAValidPeerID"
+	_, err := ParsePeerAddrs(bad)
+	if err == nil {
+		t.Fatal("expected a malformed peer ID to be rejected")
+	}
+	if !strings.Contains(err.Error(), bad) {
+		t.Fatalf("expected error to quote the malformed entry, got: %s", err)
+	}
+
+	if _, err := ParsePeerAddrs("/ip4/1.2.3.4/tcp/4001"); err == nil {
+		t.Fatal("expected an address without a peer ID to be rejected")
+	}
+}
+
+func TestParsePeerAddrsSharedIP(t *testing.T) {
+	// two peers behind the same proxy, on different ports.
+	_, err := ParsePeerAddrs(
+		"/ip4/1.2.3.4/tcp/4001/ipfs/"+testPeerA,
+		"/ip4/1.2.3.4/tcp/4002/ipfs/"+testPeerB,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoRelayPeerInfos(t *testing.T) {
+	a, err := ParsePeerAddrs("/ip4/1.2.3.4/tcp/4001/ipfs/" + testPeerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParsePeerAddrs("/ip4/1.2.3.4/tcp/4001/ipfs/" + testPeerB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := ParsePeerAddrs("/ip6/::1/tcp/4001/ipfs/" + testPeerA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := EnableAutoRelay(a...)(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAutoRelay(a2...)(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.AutoRelays) != 1 || len(cfg.AutoRelays[0].Addrs) != 2 {
+		t.Fatalf("expected the relay's addresses to be merged, got %v", cfg.AutoRelays)
+	}
+
+	cfg = Config{}
+	if err := EnableAutoRelay(a...)(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableAutoRelay(b...)(&cfg); err == nil {
+		t.Fatal("expected relays with conflicting peer IDs for the same address to be rejected")
+	}
+
+	cfg = Config{}
+	a[0].ID = "not a peer ID"
+	if err := EnableAutoRelay(a...)(&cfg); err == nil {
+		t.Fatal("expected a malformed relay peer ID to be rejected")
+	}
+}
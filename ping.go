@@ -0,0 +1,13 @@
+package libp2p
+
+// Ping makes the node answer pings, on the ping.ID protocol, or not, which
+// is the default. Disabled, the node doesn't register the protocol at all,
+// so it isn't advertised through identify. Either way, the node can ping
+// other peers through bhost.BasicHost.PingService, and the round trip
+// times are recorded in the peerstore.
+func Ping(enabled bool) Option {
+	return func(cfg *Config) error {
+		cfg.Ping = enabled
+		return nil
+	}
+}
@@ -0,0 +1,65 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	ping "github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+func TestPing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	h1 := makeLocalHost(ctx, t)
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t, Ping(true))
+	defer h2.Close()
+	h3 := makeLocalHost(ctx, t, Ping(true), Ping(false))
+	defer h3.Close()
+
+	connectHosts(ctx, t, h1, h2)
+	bh := h1.(*bhost.BasicHost)
+	pings := bh.PingService().Ping(ctx, h2.ID())
+	for i := 0; i < 3; i++ {
+		res := <-pings
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		if res.RTT <= 0 {
+			t.Fatalf("expected a round trip time, got %s", res.RTT)
+		}
+	}
+	if h1.Peerstore().LatencyEWMA(h2.ID()) == 0 {
+		t.Fatal("expected the pings to be recorded in the peerstore")
+	}
+
+	// the disabled node doesn't answer, nor advertise the protocol.
+	connectHosts(ctx, t, h1, h3)
+	if res := <-bh.PingService().Ping(ctx, h3.ID()); res.Error == nil {
+		t.Fatal("expected pinging a node with ping disabled to fail")
+	}
+	if hasProtocol(t, h1, h3.ID(), ping.ID) {
+		t.Fatal("expected the disabled node not to advertise ping")
+	}
+	if res := <-h2.(*bhost.BasicHost).PingService().Ping(ctx, h1.ID()); res.Error == nil {
+		t.Fatal("expected ping to be disabled by default")
+	}
+}
+
+func hasProtocol(t *testing.T, h host.Host, p peer.ID, proto string) bool {
+	protos, err := h.Peerstore().GetProtocols(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pr := range protos {
+		if pr == proto {
+			return true
+		}
+	}
+	return false
+}
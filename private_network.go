@@ -0,0 +1,73 @@
+package libp2p
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+
+	psk "github.com/libp2p/go-libp2p/p2p/net/psk"
+)
+
+// PrivateNetworkPSK restricts the node to the private network keyed by the
+// given pre-shared key, which must be 32 bytes long.
+func PrivateNetworkPSK(key []byte) Option {
+	return func(cfg *Config) error {
+		prot, err := psk.NewProtector(key)
+		if err != nil {
+			return fmt.Errorf("invalid private network key: %s", err)
+		}
+		return PrivateNetwork(prot)(cfg)
+	}
+}
+
+// PrivateNetworkPSKReader is like PrivateNetworkPSK, reading the key from r
+// in the key file format, with a /key/swarm/psk/1.0.0/ header.
+func PrivateNetworkPSKReader(r io.Reader) Option {
+	return func(cfg *Config) error {
+		key, err := psk.DecodeKey(r)
+		if err != nil {
+			return fmt.Errorf("invalid private network key: %s", err)
+		}
+		return PrivateNetworkPSK(key)(cfg)
+	}
+}
+
+// ForcePrivateNetwork makes New fail unless a private network is
+// configured, with PrivateNetwork or PrivateNetworkPSK, rather than
+// silently joining the public network, e.g. when the key file is missing.
+func ForcePrivateNetwork(cfg *Config) error {
+	cfg.ForcePrivateNetwork = true
+	return nil
+}
+
+// NewPSK generates a new private network key, for PrivateNetworkPSK.
+func NewPSK() ([]byte, error) {
+	return psk.Generate(rand.Reader)
+}
+
+// WritePSKFile writes a private network key to the file at path, in the key
+// file format read by ReadPSKFile and PrivateNetworkPSKReader. The file is
+// only readable by its owner, and must not exist yet.
+func WritePSKFile(path string, key []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err := psk.EncodeKey(f, key); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// ReadPSKFile reads a private network key from the key file at path.
+func ReadPSKFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return psk.DecodeKey(f)
+}
@@ -0,0 +1,108 @@
+package libp2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestPrivateNetworkPSK(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key := bytes.Repeat([]byte{1}, 32)
+	h1 := makeLocalHost(ctx, t, PrivateNetworkPSK(key))
+	defer h1.Close()
+	h2 := makeLocalHost(ctx, t, PrivateNetworkPSKReader(strings.NewReader(
+		"/key/swarm/psk/1.0.0/\n/base16/\n"+hex.EncodeToString(key))))
+	defer h2.Close()
+	connectHosts(ctx, t, h2, h1)
+
+	outsider := makeLocalHost(ctx, t, PrivateNetworkPSK(bytes.Repeat([]byte{2}, 32)))
+	defer outsider.Close()
+	dctx, dcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer dcancel()
+	err := outsider.Connect(dctx, pstore.PeerInfo{ID: h1.ID(), Addrs: h1.Addrs()})
+	if err == nil {
+		t.Fatal("expected a host with another key to be refused")
+	}
+}
+
+func TestPrivateNetworkPSKErrors(t *testing.T) {
+	ctx := context.Background()
+	secret := strings.Repeat("ab", 16)
+
+	for _, opts := range [][]Option{
+		{PrivateNetworkPSK([]byte(secret[:16]))},
+		{PrivateNetworkPSKReader(strings.NewReader("/key/swarm/psk/1.0.0/\n/base16/\n" + secret))},
+		{PrivateNetworkPSKReader(strings.NewReader("/key/swarm/psk/1.0.0/\n/base32/\n" + secret + secret))},
+	} {
+		_, err := New(ctx, opts...)
+		if err == nil {
+			t.Fatal("expected an invalid key to be rejected")
+		}
+		if strings.Contains(err.Error(), secret) || strings.Contains(err.Error(), secret[:16]) {
+			t.Fatalf("error leaks the key: %s", err)
+		}
+	}
+
+	key := bytes.Repeat([]byte{1}, 32)
+	if _, err := New(ctx, PrivateNetworkPSK(key), PrivateNetworkPSK(key)); err == nil {
+		t.Fatal("expected multiple private network options to be rejected")
+	}
+}
+
+func TestForcePrivateNetwork(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := New(ctx, ForcePrivateNetwork, Defaults)
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError without a private network, got %v", err)
+	}
+
+	h := makeLocalHost(ctx, t, ForcePrivateNetwork, PrivateNetworkPSK(bytes.Repeat([]byte{1}, 32)))
+	h.Close()
+}
+
+func TestPSKFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := NewPSK()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "swarm.key")
+	if err := WritePSKFile(path, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePSKFile(path, key); err == nil {
+		t.Fatal("expected an existing key file to be left alone")
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected the key file to be private, got %s", fi.Mode())
+	}
+
+	read, err := ReadPSKFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, key) {
+		t.Fatal("expected the key read back")
+	}
+}
@@ -0,0 +1,290 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// EnableRelay enables the circuit relay transport, so that the node can
+// dial and be dialed through relays. It also lets the node listen on
+// /p2p-circuit addresses: either the bare "/p2p-circuit", accepting relayed
+// connections through any relay we're connected to, or
+// "[<relay addr>]/ipfs/<relay>/p2p-circuit", which connects to that relay
+// when the node starts. Only the latter are advertised, once for each
+// address of the relay.
+//
+// Without options the node only uses relays, to dial and to be dialed;
+// circuit.OptHop makes it a relay for others too, and circuit.OptActive
+// lets it dial the destination of relayed connections on their behalf. The
+// option may be given more than once, but always with the same options.
+func EnableRelay(opts ...circuit.RelayOpt) Option {
+	return func(cfg *Config) error {
+		if cfg.DisableRelay {
+			return fmt.Errorf("cannot both enable and disable relay")
+		}
+		if cfg.Relay && !sameRelayOpts(cfg.RelayOpts, opts) {
+			return fmt.Errorf("cannot enable relay with conflicting options %v and %v", cfg.RelayOpts, opts)
+		}
+		cfg.Relay = true
+		cfg.RelayOpts = opts
+		return nil
+	}
+}
+
+// DisableRelay disables the circuit relay transport, even if the defaults
+// would enable it. It can't be combined with EnableRelay.
+func DisableRelay() Option {
+	return func(cfg *Config) error {
+		if cfg.Relay {
+			return fmt.Errorf("cannot both enable and disable relay")
+		}
+		cfg.Relay = false
+		cfg.RelayOpts = nil
+		cfg.DisableRelay = true
+		return nil
+	}
+}
+
+// RelayHop makes the node a relay, which other peers open circuits
+// through. It enables relay, like EnableRelay(circuit.OptHop).
+func RelayHop() Option {
+	return addRelayOpt(circuit.OptHop)
+}
+
+// RelayActive lets the node, as a relay, dial the destination of a circuit
+// it isn't connected to yet. It enables relay, like
+// EnableRelay(circuit.OptActive).
+func RelayActive() Option {
+	return addRelayOpt(circuit.OptActive)
+}
+
+func addRelayOpt(opt circuit.RelayOpt) Option {
+	return func(cfg *Config) error {
+		if cfg.DisableRelay {
+			return fmt.Errorf("cannot both enable and disable relay")
+		}
+		cfg.Relay = true
+		if !hasRelayOpt(cfg.RelayOpts, opt) {
+			cfg.RelayOpts = append(cfg.RelayOpts, opt)
+		}
+		return nil
+	}
+}
+
+// RelayLimits caps the circuits the node serves as a relay (see RelayHop):
+// maxCircuits open at once, maxCircuitsPerPeer of them opened by the same
+// peer, each relayed through buffers of bufferSize bytes and reset after
+// maxDuration. Zero means no limit, or the default buffer size. Circuits
+// refused or reset are counted in BasicHost.RelayStats.
+func RelayLimits(maxCircuits, maxCircuitsPerPeer, bufferSize int, maxDuration time.Duration) Option {
+	return func(cfg *Config) error {
+		if cfg.RelayLimits != nil {
+			return fmt.Errorf("cannot specify multiple relay limits")
+		}
+		if maxCircuits < 0 || maxCircuitsPerPeer < 0 || bufferSize < 0 || maxDuration < 0 {
+			return fmt.Errorf("relay limits can't be negative")
+		}
+		cfg.RelayLimits = &bhost.RelayLimits{
+			MaxCircuits:        maxCircuits,
+			MaxCircuitsPerPeer: maxCircuitsPerPeer,
+			BufferSize:         bufferSize,
+			MaxDuration:        maxDuration,
+		}
+		return nil
+	}
+}
+
+func hasRelayOpt(opts []circuit.RelayOpt, opt circuit.RelayOpt) bool {
+	for _, o := range opts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableAutoRelay keeps the node reachable through the given static relays
+// while it isn't publicly reachable: it connects to them and advertises
+// "<relay addr>/ipfs/<relay>/p2p-circuit" addresses, which it drops if the
+// relay disconnects. The node considers itself publicly reachable if it has
+// a public address, unless told otherwise with
+// BasicHost.SetReachability. The relays must have hop enabled, and the node
+// needs EnableRelay.
+func EnableAutoRelay(relays ...pstore.PeerInfo) Option {
+	return func(cfg *Config) error {
+		if len(relays) == 0 {
+			return fmt.Errorf("autorelay needs at least one relay")
+		}
+		for _, pi := range relays {
+			if pi.ID == "" {
+				return fmt.Errorf("autorelay relays must have a peer ID")
+			}
+		}
+		all := append(cfg.AutoRelays[:len(cfg.AutoRelays):len(cfg.AutoRelays)], relays...)
+		if err := checkPeerInfos(all, nil); err != nil {
+			return err
+		}
+		cfg.AutoRelays = mergePeerInfos(all)
+		return nil
+	}
+}
+
+// sameRelayOpts reports whether a and b hold the same options, in any
+// order.
+func sameRelayOpts(a, b []circuit.RelayOpt) bool {
+	set := func(opts []circuit.RelayOpt) map[circuit.RelayOpt]bool {
+		m := make(map[circuit.RelayOpt]bool)
+		for _, o := range opts {
+			m[o] = true
+		}
+		return m
+	}
+	sa, sb := set(a), set(b)
+	if len(sa) != len(sb) {
+		return false
+	}
+	for o := range sa {
+		if !sb[o] {
+			return false
+		}
+	}
+	return true
+}
+
+// circuitListenAddr is a /p2p-circuit listen address. relay is empty for
+// the bare form.
+type circuitListenAddr struct {
+	addr      ma.Multiaddr
+	relay     peer.ID
+	relayAddr ma.Multiaddr // may be nil
+}
+
+// splitCircuitListenAddrs separates the circuit listen addresses, which are
+// served by the relay transport rather than listened on by the swarm.
+func splitCircuitListenAddrs(addrs []ma.Multiaddr) ([]ma.Multiaddr, []circuitListenAddr, error) {
+	var plain []ma.Multiaddr
+	var circuits []circuitListenAddr
+	for _, a := range addrs {
+		if !isRelayAddr(a) {
+			plain = append(plain, a)
+			continue
+		}
+		c, err := parseCircuitListenAddr(a)
+		if err != nil {
+			return nil, nil, err
+		}
+		circuits = append(circuits, c)
+	}
+	return plain, circuits, nil
+}
+
+func parseCircuitListenAddr(a ma.Multiaddr) (circuitListenAddr, error) {
+	parts := ma.Split(a)
+	i := 0
+	for parts[i].Protocols()[0].Code != circuit.P_CIRCUIT {
+		i++
+	}
+	if i != len(parts)-1 {
+		return circuitListenAddr{}, fmt.Errorf("circuit listen address %s can't have a destination", a)
+	}
+
+	c := circuitListenAddr{addr: a}
+	if i == 0 {
+		return c, nil
+	}
+	if parts[i-1].Protocols()[0].Code != ma.P_IPFS {
+		return circuitListenAddr{}, fmt.Errorf("circuit listen address %s must name the relay's peer ID", a)
+	}
+	s, err := parts[i-1].ValueForProtocol(ma.P_IPFS)
+	if err != nil {
+		return circuitListenAddr{}, err
+	}
+	c.relay, err = peer.IDB58Decode(s)
+	if err != nil {
+		return circuitListenAddr{}, fmt.Errorf("circuit listen address %s: %s", a, err)
+	}
+	if i > 1 {
+		c.relayAddr = ma.Join(parts[:i-1]...)
+	}
+	return c, nil
+}
+
+// circuitRelays returns the relays named in circuit listen addresses.
+func circuitRelays(circuits []circuitListenAddr) []pstore.PeerInfo {
+	var relays []pstore.PeerInfo
+	for _, c := range circuits {
+		if c.relay == "" {
+			continue
+		}
+		pi := pstore.PeerInfo{ID: c.relay}
+		if c.relayAddr != nil {
+			pi.Addrs = []ma.Multiaddr{c.relayAddr}
+		}
+		relays = append(relays, pi)
+	}
+	return relays
+}
+
+// circuitAddrsFactory wraps factory, which may be nil, to advertise the
+// circuit listen addresses naming a relay, through each of the relay's
+// addresses in ps. The bare "/p2p-circuit" is only listened on: other
+// peers can't dial it without knowing a relay.
+func circuitAddrsFactory(factory bhost.AddrsFactory, circuits []circuitListenAddr, ps pstore.Peerstore) bhost.AddrsFactory {
+	return func(addrs []ma.Multiaddr) []ma.Multiaddr {
+		if factory != nil {
+			addrs = factory(addrs)
+		}
+		for _, c := range circuits {
+			if c.relay == "" {
+				continue
+			}
+			suffix, err := ma.NewMultiaddr("/ipfs/" + c.relay.Pretty() + "/p2p-circuit")
+			if err != nil {
+				continue
+			}
+			relayAddrs := ps.Addrs(c.relay)
+			if c.relayAddr != nil && !containsAddr(relayAddrs, c.relayAddr) {
+				relayAddrs = append(relayAddrs, c.relayAddr)
+			}
+			for _, ra := range relayAddrs {
+				if isRelayAddr(ra) {
+					continue
+				}
+				if a := ra.Encapsulate(suffix); !containsAddr(addrs, a) {
+					addrs = append(addrs, a)
+				}
+			}
+		}
+		return addrs
+	}
+}
+
+// connectRelays connects to the relays named in circuit listen addresses,
+// through which we accept relayed connections.
+func connectRelays(ctx context.Context, h *bhost.BasicHost, circuits []circuitListenAddr) error {
+	done := make(map[peer.ID]bool)
+	for _, c := range circuits {
+		if c.relay == "" || done[c.relay] {
+			continue
+		}
+		done[c.relay] = true
+
+		pi := pstore.PeerInfo{ID: c.relay}
+		if c.relayAddr != nil {
+			pi.Addrs = []ma.Multiaddr{c.relayAddr}
+		}
+		if err := h.Connect(ctx, pi); err != nil {
+			return fmt.Errorf("failed to connect to relay %s: %s", c.relay.Pretty(), err)
+		}
+		h.ConnManager().TagPeer(c.relay, "relay-listen", 100)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package libp2p
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+
+	circuit "github.com/libp2p/go-libp2p-circuit"
+	inet "github.com/libp2p/go-libp2p-net"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestCircuitListenAddrs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	relay := makeLocalHost(ctx, t, EnableRelay(circuit.OptHop))
+	defer relay.Close()
+
+	circuitAddr := relay.Addrs()[0].String() + "/ipfs/" + relay.ID().Pretty() + "/p2p-circuit"
+	listener, err := New(ctx,
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0", circuitAddr, "/p2p-circuit"),
+		EnableRelay(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	listener.SetStreamHandler("/test/relayed", func(s inet.Stream) {
+		s.Write([]byte("hello"))
+		s.Close()
+	})
+
+	// the relay address is advertised, and is the only one the dialer gets.
+	var advertised []ma.Multiaddr
+	for _, a := range listener.Addrs() {
+		if isRelayAddr(a) {
+			advertised = append(advertised, a)
+		}
+	}
+	if !containsAddr(advertised, ma.StringCast(circuitAddr)) {
+		t.Fatalf("expected %s in the listener's addresses, got %s", circuitAddr, listener.Addrs())
+	}
+	if containsAddr(advertised, ma.StringCast("/p2p-circuit")) {
+		t.Fatal("the bare /p2p-circuit listen address shouldn't be advertised")
+	}
+
+	dialer := makeLocalHost(ctx, t, EnableRelay())
+	defer dialer.Close()
+	err = dialer.Connect(ctx, pstore.PeerInfo{ID: listener.ID(), Addrs: advertised})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := dialer.NewStream(ctx, listener.ID(), "/test/relayed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected hello, got %q", b)
+	}
+	for _, c := range dialer.Network().ConnsToPeer(listener.ID()) {
+		if !isRelayAddr(c.RemoteMultiaddr()) {
+			t.Fatalf("expected a relayed connection, got one to %s", c.RemoteMultiaddr())
+		}
+	}
+}
+
+func TestCircuitListenAddrsExpandRelayAddrs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	relay := makeLocalHost(ctx, t, EnableRelay(circuit.OptHop))
+	defer relay.Close()
+
+	ps := pstore.NewPeerstore()
+	ps.AddAddrs(relay.ID(), relay.Addrs(), pstore.PermanentAddrTTL)
+	listener, err := New(ctx,
+		ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ipfs/"+relay.ID().Pretty()+"/p2p-circuit"),
+		Peerstore(ps),
+		EnableRelay(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var advertised []ma.Multiaddr
+	for _, a := range listener.Addrs() {
+		if isRelayAddr(a) {
+			advertised = append(advertised, a)
+		}
+	}
+	for _, ra := range relay.Addrs() {
+		a := ma.StringCast(ra.String() + "/ipfs/" + relay.ID().Pretty() + "/p2p-circuit")
+		if !containsAddr(advertised, a) {
+			t.Errorf("expected %s in the listener's addresses, got %s", a, advertised)
+		}
+	}
+	if len(advertised) != len(relay.Addrs()) {
+		t.Errorf("expected one circuit address per relay address, got %s", advertised)
+	}
+}
+
+func TestCircuitListenAddrsRequireRelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := New(ctx, ListenAddrStrings("/p2p-circuit"))
+	if err == nil {
+		t.Fatal("expected circuit listen address to fail without EnableRelay")
+	}
+
+	for _, a := range []string{
+		"/p2p-circuit/ipfs/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+		"/ip4/127.0.0.1/tcp/4001/p2p-circuit",
+	} {
+		if _, err := parseCircuitListenAddr(ma.StringCast(a)); err == nil {
+			t.Fatalf("expected %s to be rejected", a)
+		}
+	}
+}
+
+func TestEnableRelayTwice(t *testing.T) {
+	var cfg Config
+	if err := EnableRelay(circuit.OptHop, circuit.OptActive)(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableRelay(circuit.OptActive, circuit.OptHop)(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableRelay(circuit.OptHop)(&cfg); err == nil {
+		t.Fatal("expected conflicting relay options to be rejected")
+	}
+	if err := EnableRelay()(&cfg); err == nil {
+		t.Fatal("expected conflicting relay options to be rejected")
+	}
+}
+
+func TestDisableRelay(t *testing.T) {
+	for _, opts := range [][]Option{
+		{EnableRelay(), DisableRelay()},
+		{DisableRelay(), EnableRelay()},
+		{DisableRelay(), ListenAddrStrings("/p2p-circuit")},
+	} {
+		_, err := New(context.Background(), opts...)
+		if _, ok := err.(*ConfigError); !ok {
+			t.Fatalf("expected a *ConfigError, got %v", err)
+		}
+	}
+
+	var cfg Config
+	if err := DisableRelay()(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := Defaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Relay {
+		t.Fatal("expected relay to stay disabled")
+	}
+}
+
+func TestAutoRelay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	relay := makeLocalHost(ctx, t, EnableRelay(circuit.OptHop))
+	relayInfo := pstore.PeerInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+
+	// loopback addresses aren't public, so the node uses the relay.
+	h := makeLocalHost(ctx, t, EnableRelay(), EnableAutoRelay(relayInfo))
+	defer h.Close()
+	h.SetStreamHandler("/test/relayed", func(s inet.Stream) {
+		s.Write([]byte("hello"))
+		s.Close()
+	})
+
+	relayAddrs := func() []ma.Multiaddr {
+		var out []ma.Multiaddr
+		for _, a := range h.Addrs() {
+			if isRelayAddr(a) {
+				out = append(out, a)
+			}
+		}
+		return out
+	}
+	var advertised []ma.Multiaddr
+	for len(advertised) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("relay addresses never advertised")
+		case <-time.After(10 * time.Millisecond):
+		}
+		advertised = relayAddrs()
+	}
+
+	dialer := makeLocalHost(ctx, t, EnableRelay())
+	defer dialer.Close()
+	err := dialer.Connect(ctx, pstore.PeerInfo{ID: h.ID(), Addrs: advertised})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := dialer.NewStream(ctx, h.ID(), "/test/relayed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("expected hello, got %q", b)
+	}
+
+	// losing the relay removes its addresses.
+	relay.Close()
+	for len(relayAddrs()) > 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("relay addresses still advertised after the relay went away")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	_, err = New(ctx, EnableAutoRelay(relayInfo))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError without EnableRelay, got %v", err)
+	}
+}
+
+func TestRelayLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	relay := makeLocalHost(ctx, t, RelayHop(), RelayLimits(1, 0, 0, 500*time.Millisecond))
+	defer relay.Close()
+
+	circuitAddr := relay.Addrs()[0].String() + "/ipfs/" + relay.ID().Pretty() + "/p2p-circuit"
+	listener := makeLocalHost(ctx, t, ListenAddrStrings(circuitAddr), EnableRelay())
+	defer listener.Close()
+	listener.SetStreamHandler("/test/relayed", func(s inet.Stream) {
+		ioutil.ReadAll(s)
+	})
+	pi := pstore.PeerInfo{ID: listener.ID(), Addrs: []ma.Multiaddr{ma.StringCast(circuitAddr)}}
+
+	d1 := makeLocalHost(ctx, t, EnableRelay())
+	defer d1.Close()
+	if err := d1.Connect(ctx, pi); err != nil {
+		t.Fatal(err)
+	}
+	s, err := d1.NewStream(ctx, listener.ID(), "/test/relayed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the relay serves a single circuit at once.
+	d2 := makeLocalHost(ctx, t, EnableRelay())
+	defer d2.Close()
+	if err := d2.Connect(ctx, pi); err == nil {
+		t.Fatal("expected a circuit over the cap to be refused")
+	}
+
+	// and resets it after its maximum duration.
+	buf := make([]byte, 1)
+	for {
+		if _, err := s.Write(buf); err != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("circuit not reset after its maximum duration")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	st := relay.(*bhost.BasicHost).RelayStats()
+	if st.Rejected == 0 || st.Expired == 0 {
+		t.Fatalf("expected rejected and expired circuits to be counted, got %+v", st)
+	}
+
+	_, err = New(ctx, EnableRelay(), RelayLimits(1, 0, 0, 0))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError setting limits without hop, got %v", err)
+	}
+}
+
+func TestForceReachabilityPrivate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	relay := makeLocalHost(ctx, t, RelayHop())
+	defer relay.Close()
+
+	circuitAddr := relay.Addrs()[0].String() + "/ipfs/" + relay.ID().Pretty() + "/p2p-circuit"
+	h := makeLocalHost(ctx, t, ListenAddrStrings(circuitAddr), EnableRelay(), ForceReachabilityPrivate())
+	defer h.Close()
+
+	// the private loopback address gives way to the relay address.
+	for {
+		addrs := h.Addrs()
+		direct := false
+		for _, a := range addrs {
+			direct = direct || !isRelayAddr(a)
+		}
+		if !direct && containsAddr(addrs, ma.StringCast(circuitAddr)) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("expected only relay addresses, got %s", addrs)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// MultiaddrResolver sets the resolver used for /dns4, /dns6 and /dnsaddr
+// addresses, both for listen addresses and for the addresses of peers
+// being dialed. To query a specific DNS server, use a resolver whose
+// Backend is a net.Resolver with a custom Dial function. If omitted,
+// madns.DefaultResolver is used.
+func MultiaddrResolver(r *madns.Resolver) Option {
+	return func(cfg *Config) error {
+		if r == nil {
+			return fmt.Errorf("multiaddr resolver must not be nil")
+		}
+		cfg.MultiaddrResolver = r
+		return nil
+	}
+}
+
+// resolveListenAddrs replaces the DNS addresses among addrs with the
+// addresses they resolve to.
+func resolveListenAddrs(ctx context.Context, r *madns.Resolver, addrs []ma.Multiaddr) ([]ma.Multiaddr, error) {
+	out := make([]ma.Multiaddr, 0, len(addrs))
+	for _, a := range addrs {
+		if !madns.Matches(a) {
+			out = append(out, a)
+			continue
+		}
+		resolved, err := r.Resolve(ctx, a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve listen address %s: %s", a, err)
+		}
+		if len(resolved) == 0 {
+			return nil, fmt.Errorf("listen address %s resolved to no addresses", a)
+		}
+		out = append(out, resolved...)
+	}
+	return out, nil
+}
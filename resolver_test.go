@@ -0,0 +1,69 @@
+package libp2p
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+func TestMultiaddrResolver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resolver := &madns.Resolver{Backend: &madns.MockBackend{
+		IP: map[string][]net.IPAddr{
+			"node.test": []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}},
+		},
+	}}
+
+	h1, err := New(ctx, ListenAddrStrings("/dns4/node.test/tcp/0"), MultiaddrResolver(resolver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h1.Close()
+	h1.SetStreamHandler("/test/1.0.0", func(s inet.Stream) { s.Close() })
+
+	laddrs := h1.Network().ListenAddresses()
+	if len(laddrs) != 1 {
+		t.Fatalf("expected a single listen address, got %s", laddrs)
+	}
+	if ip, err := laddrs[0].ValueForProtocol(ma.P_IP4); err != nil || ip != "127.0.0.1" {
+		t.Fatalf("expected to listen on the resolved address, got %s", laddrs[0])
+	}
+	port, err := laddrs[0].ValueForProtocol(ma.P_TCP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2 := makeLocalHost(ctx, t, MultiaddrResolver(resolver))
+	defer h2.Close()
+
+	// an unresolvable address doesn't prevent dialing the other one.
+	h2.Peerstore().AddAddrs(h1.ID(), []ma.Multiaddr{
+		ma.StringCast("/dns4/missing.test/tcp/" + port),
+		ma.StringCast("/dns4/node.test/tcp/" + port),
+	}, pstore.PermanentAddrTTL)
+
+	s, err := h2.NewStream(ctx, h1.ID(), "/test/1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+}
+
+func TestMultiaddrResolverUnresolvableListenAddr(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resolver := &madns.Resolver{Backend: &madns.MockBackend{}}
+	_, err := New(ctx, ListenAddrStrings("/dns4/missing.test/tcp/0"), MultiaddrResolver(resolver))
+	if err == nil {
+		t.Fatal("expected an unresolvable listen address to be rejected")
+	}
+}
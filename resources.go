@@ -0,0 +1,51 @@
+package libp2p
+
+import (
+	"fmt"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+)
+
+// ResourceLimitOpts caps the streams the node keeps open. Zero fields
+// mean no limit.
+type ResourceLimitOpts struct {
+	// MaxInboundStreamsPerConn, MaxStreamsPerPeer and MaxStreams cap the
+	// inbound streams of each connection, the streams to each peer and
+	// all the streams of the node; see bhost.ResourceLimits.
+	MaxInboundStreamsPerConn int
+	MaxStreamsPerPeer        int
+	MaxStreams               int
+
+	// MaxStreamBuffer caps the bytes buffered for each stream, waiting to
+	// be read by its handler. It sets the yamux stream window, so it can't
+	// be below 256KiB, and can't be combined with the Muxer option or a
+	// YamuxConfig setting the window.
+	MaxStreamBuffer uint32
+}
+
+// ResourceLimits makes the node cap the streams it keeps open. Inbound
+// streams beyond a limit are reset before protocol negotiation, leaving
+// their connection open; the rejections are counted by
+// bhost.BasicHost.ResourceStats and emitted as events.StreamRefused
+// events. A connection gater implementing bhost.StreamLimitGater is told
+// about them, and can have the connection closed.
+func ResourceLimits(opts ResourceLimitOpts) Option {
+	return func(cfg *Config) error {
+		if cfg.ResourceLimits != nil {
+			return fmt.Errorf("cannot specify multiple resource limits")
+		}
+		if opts.MaxInboundStreamsPerConn < 0 || opts.MaxStreamsPerPeer < 0 || opts.MaxStreams < 0 {
+			return fmt.Errorf("resource limits must not be negative")
+		}
+		if opts.MaxStreamBuffer != 0 && opts.MaxStreamBuffer < yamuxMinStreamWindow {
+			return fmt.Errorf("stream buffer limit must be at least %d bytes, got %d", yamuxMinStreamWindow, opts.MaxStreamBuffer)
+		}
+		cfg.ResourceLimits = &bhost.ResourceLimits{
+			MaxInboundStreamsPerConn: opts.MaxInboundStreamsPerConn,
+			MaxStreamsPerPeer:        opts.MaxStreamsPerPeer,
+			MaxStreams:               opts.MaxStreams,
+		}
+		cfg.MaxStreamBuffer = opts.MaxStreamBuffer
+		return nil
+	}
+}
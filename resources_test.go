@@ -0,0 +1,42 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+)
+
+func TestResourceLimits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := ResourceLimits(ResourceLimitOpts{MaxStreamBuffer: 1024})(&Config{}); err == nil {
+		t.Fatal("expected a stream buffer below the yamux minimum to be rejected")
+	}
+	if err := ResourceLimits(ResourceLimitOpts{MaxStreams: -1})(&Config{}); err == nil {
+		t.Fatal("expected a negative limit to be rejected")
+	}
+
+	_, err := New(ctx, ResourceLimits(ResourceLimitOpts{MaxStreamBuffer: 1 << 20}), Muxer(DefaultMuxer()))
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError combining Muxer and a stream buffer limit, got %v", err)
+	}
+	_, err = New(ctx,
+		ResourceLimits(ResourceLimitOpts{MaxStreamBuffer: 1 << 20}),
+		YamuxConfig(YamuxOpts{MaxStreamWindowSize: 2 << 20}),
+	)
+	if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError combining a yamux window and a stream buffer limit, got %v", err)
+	}
+
+	h := makeLocalHost(ctx, t,
+		ResourceLimits(ResourceLimitOpts{MaxStreams: 10, MaxStreamBuffer: 1 << 20}),
+		YamuxConfig(YamuxOpts{AcceptBacklog: 64}),
+	)
+	defer h.Close()
+	if st := h.(*bhost.BasicHost).ResourceStats(); st != (bhost.ResourceStats{}) {
+		t.Fatalf("expected no streams accounted yet, got %+v", st)
+	}
+}
@@ -0,0 +1,60 @@
+package libp2p
+
+import (
+	"fmt"
+	"io"
+
+	host "github.com/libp2p/go-libp2p-host"
+	routed "github.com/libp2p/go-libp2p/p2p/host/routed"
+)
+
+// RoutingC constructs the peer routing of a node, given its host.
+type RoutingC func(host.Host) (routed.Routing, error)
+
+// Routing wraps the node in a routed host, whose Connect and NewStream ask
+// the peer routing for the addresses of peers missing from the peerstore.
+// The basic host underneath asks it too, so that its own dials, and the
+// ones of the services using it, fall back to the routing when the
+// peerstore knows no addresses for a peer, or when they all fail.
+//
+// The routing is constructed once the basic host is set up, before New
+// returns, and closed along with the node if it is an io.Closer. The node
+// New returns is then no longer a *bhost.BasicHost.
+func Routing(rt RoutingC) Option {
+	return func(cfg *Config) error {
+		if cfg.Routing != nil {
+			return fmt.Errorf("cannot specify multiple routing options")
+		}
+		cfg.Routing = rt
+		return nil
+	}
+}
+
+// DisableRoutingFallback keeps the node from asking the routing for the
+// addresses of the peers it dials, for callers which would rather fail
+// fast. The routing is still constructed and closed with the node, and New
+// returns the basic host, unwrapped.
+func DisableRoutingFallback() Option {
+	return func(cfg *Config) error {
+		cfg.DisableRoutingFallback = true
+		return nil
+	}
+}
+
+// routedHost is a routed host owning its routing.
+type routedHost struct {
+	*routed.RoutedHost
+	routing routed.Routing
+}
+
+func (h *routedHost) Close() error {
+	err := h.RoutedHost.Close()
+	closeRouting(h.routing)
+	return err
+}
+
+func closeRouting(r routed.Routing) {
+	if c, ok := r.(io.Closer); ok {
+		c.Close()
+	}
+}
@@ -0,0 +1,119 @@
+package libp2p
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	bhost "github.com/libp2p/go-libp2p/p2p/host/basic"
+	routed "github.com/libp2p/go-libp2p/p2p/host/routed"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+type mapRouting struct {
+	peers  map[peer.ID]pstore.PeerInfo
+	closed bool
+}
+
+func (r *mapRouting) FindPeer(ctx context.Context, p peer.ID) (pstore.PeerInfo, error) {
+	pi, ok := r.peers[p]
+	if !ok {
+		return pstore.PeerInfo{}, fmt.Errorf("peer %s not found", p)
+	}
+	return pi, nil
+}
+
+func (r *mapRouting) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestRouting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+
+	rt := &mapRouting{peers: map[peer.ID]pstore.PeerInfo{
+		other.ID(): {ID: other.ID(), Addrs: other.Addrs()},
+	}}
+	var constructedWith host.Host
+	h := makeLocalHost(ctx, t, Routing(func(h host.Host) (routed.Routing, error) {
+		constructedWith = h
+		return rt, nil
+	}))
+	if constructedWith == nil || constructedWith.ID() != h.ID() {
+		t.Fatal("expected the routing to be constructed with the host")
+	}
+
+	if err := h.Connect(ctx, pstore.PeerInfo{ID: other.ID()}); err != nil {
+		t.Fatal(err)
+	}
+	if h.Network().Connectedness(other.ID()) != inet.Connected {
+		t.Fatal("expected a connection to the peer found through routing")
+	}
+
+	h.Close()
+	if !rt.closed {
+		t.Fatal("expected the routing to be closed with the host")
+	}
+
+	_, err := New(ctx, Routing(func(host.Host) (routed.Routing, error) {
+		return nil, fmt.Errorf("no routing today")
+	}))
+	if err == nil {
+		t.Fatal("expected the routing constructor error to fail New")
+	}
+}
+
+func TestRoutingFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+
+	rt := &mapRouting{peers: map[peer.ID]pstore.PeerInfo{
+		other.ID(): {ID: other.ID(), Addrs: other.Addrs()},
+	}}
+	var bh host.Host
+	h := makeLocalHost(ctx, t, Routing(func(h host.Host) (routed.Routing, error) {
+		bh = h
+		return rt, nil
+	}))
+	defer h.Close()
+
+	// the basic host underneath falls back to the routing on its own, and
+	// retries when the addresses it knows fail.
+	stale := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	if err := bh.Connect(ctx, pstore.PeerInfo{ID: other.ID(), Addrs: []ma.Multiaddr{stale}}); err != nil {
+		t.Fatal(err)
+	}
+	if h.Network().Connectedness(other.ID()) != inet.Connected {
+		t.Fatal("expected a connection to the peer found through routing")
+	}
+
+	h2 := makeLocalHost(ctx, t, DisableRoutingFallback(),
+		Routing(func(host.Host) (routed.Routing, error) { return rt, nil }))
+	if _, ok := h2.(*bhost.BasicHost); !ok {
+		t.Fatalf("expected a basic host without the routing fallback, got %T", h2)
+	}
+	if err := h2.Connect(ctx, pstore.PeerInfo{ID: other.ID()}); err == nil {
+		t.Fatal("expected connecting without addresses to fail")
+	}
+	rt.closed = false
+	h2.Close()
+	if !rt.closed {
+		t.Fatal("expected the routing to be closed with the host")
+	}
+
+	if _, err := New(ctx, DisableRoutingFallback()); err == nil {
+		t.Fatal("expected DisableRoutingFallback without Routing to fail")
+	}
+}
@@ -0,0 +1,26 @@
+package libp2p
+
+import (
+	"fmt"
+	"time"
+)
+
+// PeerstoreSnapshot makes the node load the peers saved in the file at path
+// when it starts, and save the addresses and protocols of the peers it
+// knows there every interval, and when it is closed, so that it can reach
+// its previous neighborhood quickly after a restart. Keys are never saved.
+// See package snapshot for the format, and ExportPeerstore and
+// ImportPeerstore in it to manage snapshots by hand.
+func PeerstoreSnapshot(path string, interval time.Duration) Option {
+	return func(cfg *Config) error {
+		if path == "" {
+			return fmt.Errorf("peerstore snapshot needs a path")
+		}
+		if interval <= 0 {
+			return fmt.Errorf("peerstore snapshot interval must be positive, got %s", interval)
+		}
+		cfg.PeerstoreSnapshot = path
+		cfg.PeerstoreSnapshotInterval = interval
+		return nil
+	}
+}
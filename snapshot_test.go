@@ -0,0 +1,47 @@
+package libp2p
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestPeerstoreSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "libp2p-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+
+	if _, err := New(ctx, PeerstoreSnapshot(path, 0)); err == nil {
+		t.Fatal("expected a zero snapshot interval to be rejected")
+	}
+
+	other := makeLocalHost(ctx, t)
+	defer other.Close()
+
+	h := makeLocalHost(ctx, t, PeerstoreSnapshot(path, time.Hour))
+	if err := h.Connect(ctx, pstore.PeerInfo{ID: other.ID(), Addrs: other.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	// the snapshot is saved on close.
+	h.Close()
+
+	restarted := makeLocalHost(ctx, t, PeerstoreSnapshot(path, time.Hour))
+	defer restarted.Close()
+	if len(restarted.Peerstore().Addrs(other.ID())) == 0 {
+		t.Fatal("expected the addresses of the previous peer to be loaded")
+	}
+	if len(restarted.Peerstore().Addrs(h.ID())) != 0 {
+		t.Fatal("expected the previous local peer to be left out of the snapshot")
+	}
+}
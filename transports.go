@@ -0,0 +1,69 @@
+package libp2p
+
+import (
+	"fmt"
+
+	memory "github.com/libp2p/go-libp2p/p2p/net/memory"
+
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// transportProbes are representative addresses of the protocols transports
+// may handle. A transport claims the protocols whose probes it matches.
+var transportProbes = []string{
+	"/ip4/127.0.0.1/tcp/1",
+	"/ip6/::1/tcp/1",
+	"/ip4/127.0.0.1/tcp/1/ws",
+	"/ip4/127.0.0.1/tcp/1/wss",
+	"/ip4/127.0.0.1/udp/1/quic",
+	"/ip4/127.0.0.1/udp/1/utp",
+	"/ip4/127.0.0.1/udp/1/udt",
+	"/unix/tmp/libp2p.sock",
+	"/memory/1",
+}
+
+// MemoryTransport adds the in-process memory transport, for tests: nodes
+// of the same process can listen on and dial /memory/<id> addresses, with
+// the regular security and stream muxer negotiation but no OS sockets.
+// Listening on /memory/0 picks a free identifier.
+func MemoryTransport() Option {
+	return Transports(memory.NewTransport())
+}
+
+// transportClaims returns the probe addresses t matches.
+func transportClaims(t transport.Transport) []ma.Multiaddr {
+	var claims []ma.Multiaddr
+	for _, s := range transportProbes {
+		// not every version of go-multiaddr knows every protocol.
+		a, err := ma.NewMultiaddr(s)
+		if err != nil {
+			continue
+		}
+		if t.Matches(a) {
+			claims = append(claims, a)
+		}
+	}
+	return claims
+}
+
+// checkTransports makes sure every configured transport is used: the
+// swarm picks the first transport matching an address, trying its built-in
+// ones first, so a transport claiming an address also claimed by a
+// built-in transport or by another configured one would be silently
+// shadowed.
+func checkTransports(tpts []transport.Transport) error {
+	owner := make(map[string]int)
+	for i, t := range tpts {
+		for _, a := range transportClaims(t) {
+			if canListen(a) {
+				return fmt.Errorf("transport #%d (%T) handles %s, which the built-in transports already handle and can't be replaced", i, t, a)
+			}
+			if j, ok := owner[a.String()]; ok {
+				return fmt.Errorf("transports #%d (%T) and #%d (%T) both handle %s", j, tpts[j], i, t, a)
+			}
+			owner[a.String()] = i
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package libp2p
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	crypto "github.com/libp2p/go-libp2p-crypto"
+	transport "github.com/libp2p/go-libp2p-transport"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// protoTransport claims the addresses ending with the given protocol.
+type protoTransport struct {
+	transport.Transport
+	proto string
+}
+
+func (t protoTransport) Matches(a ma.Multiaddr) bool {
+	protos := a.Protocols()
+	if t.proto == "unix" {
+		return protos[0].Name == "unix"
+	}
+	return protos[len(protos)-1].Name == t.proto
+}
+
+func TestCheckTransports(t *testing.T) {
+	tcp := protoTransport{proto: "tcp"}
+	ws := protoTransport{proto: "ws"}
+	quic := protoTransport{proto: "quic"}
+	unix := protoTransport{proto: "unix"}
+
+	for _, c := range []struct {
+		tpts []transport.Transport
+		err  string
+	}{
+		{tpts: []transport.Transport{quic, unix}},
+		{tpts: []transport.Transport{tcp}, err: "built-in"},
+		{tpts: []transport.Transport{ws}, err: "built-in"},
+		{tpts: []transport.Transport{unix, quic, unix}, err: "#0"},
+	} {
+		err := checkTransports(c.tpts)
+		if c.err == "" {
+			if err != nil {
+				t.Fatalf("expected %v to be accepted: %s", c.tpts, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("expected %v to be rejected with %q, got %v", c.tpts, c.err, err)
+		}
+	}
+
+	if _, err := New(context.Background(), Transports(tcp), RandomIdentity(crypto.Ed25519, 0)); err == nil {
+		t.Fatal("expected New to reject a shadowed transport")
+	} else if _, ok := err.(*ConfigError); !ok {
+		t.Fatalf("expected a *ConfigError, got %T", err)
+	}
+}